@@ -0,0 +1,1428 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+// Package awscraft implements AWS CDK construct of the craft solution.
+// The construct is embeddable into any AWS CDK application. It deploys
+// AWS Batch compute, job definitions and event gateway into the given scope.
+package awscraft
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsapigateway"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsbatch"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsdynamodb"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsecrassets"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsecs"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsevents"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseventstargets"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslambdadestinations"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslambdaeventsources"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awss3"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsscheduler"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssecretsmanager"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssqs"
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+	"github.com/fogfish/scud"
+	"github.com/fogfish/swarm/broker/eventbridge"
+	"github.com/fogfish/tagver"
+)
+
+// Prefixes of job artifacts at the source code bucket
+const (
+	ARTIFACT_CONTEXTS = "craft/contexts/"
+	ARTIFACT_LOGS     = "craft/logs/"
+	ARTIFACT_OUTPUTS  = "craft/outputs/"
+)
+
+type CraftProps struct {
+	Version tagver.Version
+
+	// Name of the craft, named resources are derived from it (event bus,
+	// compute environment, job queue, job definitions, gateway function
+	// and schedule group). It has to be unique within account and region.
+	//
+	// Default: {stack name}-{construct id}
+	Name string
+
+	// AWS S3 Bucket Identity for keeping source code
+	SourceCodeBucket string
+
+	// Existing AWS S3 Bucket for keeping source code. The construct creates
+	// a new bucket named after SourceCodeBucket if it is not defined.
+	SourceCode awss3.IBucket
+
+	// Import existing AWS S3 Bucket instead of creating a new one.
+	// SourceCodeBucket is either name or ARN of the bucket.
+	//
+	// Default: false
+	ImportSourceCodeBucket *bool
+
+	// AWS KMS key of existing bucket, jobs and functions are granted to use
+	// it along with the bucket.
+	//
+	// Default: the bucket is not encrypted by customer managed key
+	SourceCodeKey awskms.IKey
+
+	// Expiration of job artifacts (contexts, logs and outputs) at the bucket.
+	//
+	// Default: 30 days
+	ArtifactsExpiration awscdk.Duration
+
+	// Existing AWS EventBridge bus to consume events from. The construct
+	// creates a new bus named after the stack if it is not defined.
+	EventBus awsevents.IEventBus
+
+	// Max number of CPUs allocated for the cluster
+	MaxvCpus *float64
+
+	// The number of vCPUs reserved for the container.
+	//
+	// Default: 1.0
+	Cpu *float64
+
+	// The memory reserved for the container in GBs.
+	// The memory have to be aligned with reserved CPUs
+	// (e.g. 4 vCPU requires 8GB, 8 vCPU requires 16GB)
+	//
+	// Default: 4 GB
+	Memory *float64
+
+	// Enable spot instances
+	Spot *bool
+
+	// AWS Accounts, craft jobs are allowed to deploy into.
+	//
+	// Default: the account of the stack
+	TrustedAccounts []string
+
+	// Name patterns of IAM Roles, craft jobs are allowed to assume
+	// at trusted accounts.
+	//
+	// Default: cdk-*, craft-*
+	TrustedRoles []string
+
+	// Name of organization-level IAM Role, bootstrap job assumes it
+	// at target account.
+	//
+	// Default: OrganizationAccountAccessRole
+	OrganizationRole string
+
+	// AWS Organization, bootstrap job is allowed to assume organization-level
+	// role at any member account of it. Otherwise, it is allowed only at
+	// trusted accounts.
+	OrganizationId string
+
+	// ARNs of IAM managed policies, AWS CloudFormation uses them to deploy
+	// stacks into accounts bootstrapped by the bootstrap job.
+	//
+	// Default: arn:aws:iam::aws:policy/AdministratorAccess
+	BootstrapExecutionPolicies []string
+
+	// The time window to approve changes requested by approval mode,
+	// the change set is deleted once approval expires.
+	//
+	// Default: 24 hours
+	ApprovalTimeout awscdk.Duration
+
+	// Schedule of drift detection across deployed tenant stacks, the schedule
+	// expression of Amazon EventBridge Scheduler (e.g. rate(1 day)).
+	//
+	// Default: drift detection is not scheduled
+	DriftDetection string
+
+	// Redeploy the recorded module and context of drifted stacks.
+	//
+	// Default: false
+	DriftRemediation *bool
+
+	// Schedule of reconciliation of tenants to their desired state, the schedule
+	// expression of Amazon EventBridge Scheduler (e.g. rate(15 minutes)).
+	//
+	// Default: reconciliation is not scheduled
+	Reconcile string
+
+	// Debounce window of successive deployments of tenant's module,
+	// deployments within the window are merged into the one. The window
+	// is closed by Amazon EventBridge Scheduler with minute precision.
+	//
+	// Default: deployments are not debounced
+	Debounce awscdk.Duration
+
+	// Mapping rules of business events into EventCraft, the key of JSON
+	// document at the source code bucket (e.g. craft/rules.json).
+	//
+	// Default: business events are not mapped
+	Rules string
+
+	// Sources of business events (e.g. billing), the mapping consumes.
+	//
+	// Default: any source except the craft
+	RulesSources []string
+
+	// Additional AWS EventBridge buses with business events, the mapping
+	// consumes events from the craft's bus and these buses.
+	RulesEventBuses []awsevents.IEventBus
+
+	// Retention of craft events archive, archived events are replayed to
+	// the gateway within the time range using EventArchiveReplay.
+	//
+	// Default: events are not archived
+	ArchiveRetention awscdk.Duration
+
+	// Enables AWS SQS queue as buffered and retryable path of EventCraft
+	// ingestion. Producers send EventCraft as message body, failed messages
+	// are redelivered and moved to the dead-letter queue eventually.
+	//
+	// Default: false
+	Inbox *bool
+
+	// Enables HTTP API (AWS API Gateway) to submit deployments and query
+	// their status, callers sign requests using AWS SigV4.
+	//
+	// Default: false
+	Api *bool
+
+	// Enables delivery of status and outputs of deployments to the reply-to
+	// bus of EventCraft at the producer's account. Delivery to the bus and
+	// submission of events to the craft's bus are restricted to member
+	// accounts of OrganizationId, or trusted accounts if it is not defined.
+	//
+	// Default: false
+	ReplyTo *bool
+
+	// Secrets of webhook subscribers, JSON object of callback's host to
+	// the secret. Enables delivery of deployment status to the callback
+	// of EventCraft, the payload is signed using HMAC-SHA256.
+	//
+	// Default: webhooks are not delivered
+	WebhookSecrets awssecretsmanager.ISecret
+
+	// Permissions boundary applied to all IAM Roles of the construct.
+	PermissionsBoundary awsiam.IManagedPolicy
+
+	// Existing AWS VPC to run craft jobs. The construct creates a new VPC
+	// if it is not defined.
+	Vpc awsec2.IVpc
+
+	// Subnets of existing VPC to run craft jobs.
+	//
+	// Default: private subnets of the VPC
+	VpcSubnets *awsec2.SubnetSelection
+
+	// Type of subnets, the construct creates for craft jobs:
+	//   - PUBLIC jobs are running with public IPs
+	//   - PRIVATE_WITH_EGRESS jobs are running behind NAT gateway
+	//   - PRIVATE_ISOLATED jobs are running without internet route,
+	//     it requires VPC endpoints
+	//
+	// Default: PUBLIC
+	SubnetType awsec2.SubnetType
+
+	// Create VPC endpoints for AWS services used by craft jobs
+	// (S3, STS, CloudFormation, ECR, CloudWatch Logs and EventBridge).
+	//
+	// Default: false
+	VpcEndpoints *bool
+}
+
+type Craft struct {
+	constructs.Construct
+
+	// AWS EventBridge bus, the craft consumes events from
+	Bus awsevents.IEventBus
+
+	// AWS S3 Bucket with source code of templates and job artifacts,
+	// the key is defined if the construct creates the bucket or the key
+	// of existing one is given.
+	SourceCode    awss3.IBucket
+	SourceCodeKey awskms.IKey
+
+	// AWS VPC and subnets where craft jobs are running
+	Vpc        awsec2.IVpc
+	VpcSubnets *awsec2.SubnetSelection
+
+	// AWS Batch compute environment and queue of craft jobs
+	Compute awsbatch.FargateComputeEnvironment
+	Queue   awsbatch.IJobQueue
+
+	// AWS IAM Roles assumed by craft jobs and AWS Batch to run them
+	Role          awsiam.IRole
+	BootstrapRole awsiam.IRole
+	ExecutionRole awsiam.IRole
+
+	// AWS IAM Role assumed by Amazon EventBridge Scheduler to emit
+	// delayed events to the bus
+	SchedulerRole awsiam.IRole
+
+	// Amazon EventBridge Scheduler group of delayed deployments
+	Schedules awsscheduler.CfnScheduleGroup
+
+	// Recurring schedules of drift detection and reconciliation, if enabled
+	DriftDetection awsscheduler.CfnSchedule
+	Reconcile      awsscheduler.CfnSchedule
+
+	// AWS Batch Job definitions of deployment and bootstrap jobs
+	JobDeploy    awsbatch.EcsJobDefinition
+	JobBootstrap awsbatch.EcsJobDefinition
+
+	// AWS DynamoDB table with history of deployments
+	Registry awsdynamodb.ITable
+
+	// AWS DynamoDB table with state of fleet rollouts
+	Fleet awsdynamodb.ITable
+
+	// AWS DynamoDB table with desired state of tenants
+	Desired awsdynamodb.ITable
+
+	// AWS DynamoDB table with lifecycle of tenants
+	Tenants awsdynamodb.ITable
+
+	// AWS DynamoDB table with debounced deployments
+	Debounce awsdynamodb.ITable
+
+	// AWS DynamoDB table with last applied sequence of tenant's deployments
+	Sequence awsdynamodb.ITable
+
+	// AWS DynamoDB table with events processed by the gateway
+	Ledger awsdynamodb.ITable
+
+	// AWS Lambda function consuming events
+	Gateway awslambda.IFunction
+
+	// AWS EventBridge archive of craft events, if enabled
+	Archive awsevents.Archive
+
+	// AWS SQS queue with events failed by the gateway
+	DeadLetter awssqs.IQueue
+
+	// AWS Lambda function replaying events of the dead-letter queue
+	Replay awslambda.IFunction
+
+	// AWS SQS queue of EventCraft and its dead-letter queue, if enabled
+	Inbox           awssqs.IQueue
+	InboxDeadLetter awssqs.IQueue
+
+	// AWS Lambda function consuming EventCraft from the inbox, if enabled
+	GatewayInbox awslambda.IFunction
+
+	// AWS API Gateway of HTTP API and its AWS Lambda function, if enabled
+	Api        awsapigateway.RestApi
+	GatewayApi awslambda.IFunction
+
+	// AWS Lambda function tracking status of deployments
+	Monitor awslambda.IFunction
+
+	// AWS Lambda function mapping business events into EventCraft, if enabled
+	Mapper awslambda.IFunction
+
+	// AWS Lambda function relaying status and outputs of deployments to
+	// the reply-to bus, if enabled
+	Relay awslambda.IFunction
+
+	// AWS Lambda function delivering status of deployments to webhooks and
+	// AWS DynamoDB table with delivery logs, if enabled
+	Webhook  awslambda.IFunction
+	Webhooks awsdynamodb.ITable
+
+	broker         *eventbridge.Broker
+	gatewayRule    awsevents.Rule
+	image          awsecs.ContainerImage
+	assignPublicIp bool
+}
+
+func New(scope constructs.Construct, id *string, props *CraftProps) *Craft {
+	if props.Spot == nil {
+		props.Spot = jsii.Bool(true)
+	}
+
+	if props.Cpu == nil {
+		props.Cpu = jsii.Number(1.0)
+	}
+
+	if props.Memory == nil {
+		props.Memory = jsii.Number(4.0)
+	}
+
+	if len(props.TrustedAccounts) == 0 {
+		props.TrustedAccounts = []string{*awscdk.Aws_ACCOUNT_ID()}
+	}
+
+	if len(props.TrustedRoles) == 0 {
+		props.TrustedRoles = []string{"cdk-*", "craft-*"}
+	}
+
+	if props.OrganizationRole == "" {
+		props.OrganizationRole = "OrganizationAccountAccessRole"
+	}
+
+	if len(props.BootstrapExecutionPolicies) == 0 {
+		props.BootstrapExecutionPolicies = []string{"arn:aws:iam::aws:policy/AdministratorAccess"}
+	}
+
+	if props.ApprovalTimeout == nil {
+		props.ApprovalTimeout = awscdk.Duration_Hours(jsii.Number(24))
+	}
+
+	if props.ArtifactsExpiration == nil {
+		props.ArtifactsExpiration = awscdk.Duration_Days(jsii.Number(30))
+	}
+
+	if props.SubnetType == "" {
+		props.SubnetType = awsec2.SubnetType_PUBLIC
+	}
+
+	if props.Name == "" {
+		props.Name = *awscdk.Stack_Of(scope).StackName() + "-" + *id
+	}
+
+	c := &Craft{Construct: constructs.NewConstruct(scope, id)}
+	c.createSourceCode(props)
+	c.createEventBus(props)
+	c.createProducers(props)
+
+	c.createNetworking(props)
+	c.createCompute(props)
+	c.createQueue(props)
+	c.createRole(props)
+	c.createImage(props)
+	c.createJobDeploy(props)
+	c.createJobBootstrap(props)
+	c.createRegistry(props)
+	c.createGateway(props)
+	c.createInbox(props)
+	c.createReplay(props)
+	c.createApi(props)
+	c.createMonitor(props)
+	c.createMapper(props)
+	c.createRelay(props)
+	c.createWebhook(props)
+	c.createDriftDetection(props)
+	c.createReconcile(props)
+
+	return c
+}
+
+func (c *Craft) createSourceCode(props *CraftProps) {
+	switch {
+	case props.SourceCode != nil && props.SourceCodeKey == nil:
+		c.SourceCode = props.SourceCode
+	case props.SourceCode != nil:
+		c.importSourceCodeBucket(props,
+			&awss3.BucketAttributes{BucketArn: props.SourceCode.BucketArn()},
+		)
+	case props.ImportSourceCodeBucket != nil && *props.ImportSourceCodeBucket && strings.HasPrefix(props.SourceCodeBucket, "arn:"):
+		c.importSourceCodeBucket(props,
+			&awss3.BucketAttributes{BucketArn: jsii.String(props.SourceCodeBucket)},
+		)
+	case props.ImportSourceCodeBucket != nil && *props.ImportSourceCodeBucket:
+		c.importSourceCodeBucket(props,
+			&awss3.BucketAttributes{BucketName: jsii.String(props.SourceCodeBucket)},
+		)
+	default:
+		c.createSourceCodeBucket(props)
+	}
+}
+
+// grants to the imported bucket are extended to its key, if it is given
+func (c *Craft) importSourceCodeBucket(props *CraftProps, attrs *awss3.BucketAttributes) {
+	attrs.EncryptionKey = props.SourceCodeKey
+
+	c.SourceCodeKey = props.SourceCodeKey
+	c.SourceCode = awss3.Bucket_FromBucketAttributes(c.Construct, jsii.String("Bucket"), attrs)
+}
+
+func (c *Craft) createSourceCodeBucket(props *CraftProps) {
+	c.SourceCodeKey = awskms.NewKey(c.Construct, jsii.String("Key"),
+		&awskms.KeyProps{
+			Description:       jsii.String("encryption of craft source code and artifacts"),
+			EnableKeyRotation: jsii.Bool(true),
+		},
+	)
+
+	rules := []*awss3.LifecycleRule{}
+	for _, prefix := range []string{ARTIFACT_CONTEXTS, ARTIFACT_LOGS, ARTIFACT_OUTPUTS} {
+		rules = append(rules,
+			&awss3.LifecycleRule{
+				Prefix:                      jsii.String(prefix),
+				Expiration:                  props.ArtifactsExpiration,
+				NoncurrentVersionExpiration: awscdk.Duration_Days(jsii.Number(1)),
+			},
+		)
+	}
+
+	c.SourceCode = awss3.NewBucket(c.Construct, jsii.String("Bucket"),
+		&awss3.BucketProps{
+			BucketName:        jsii.String(props.SourceCodeBucket),
+			Versioned:         jsii.Bool(true),
+			Encryption:        awss3.BucketEncryption_KMS,
+			EncryptionKey:     c.SourceCodeKey,
+			BucketKeyEnabled:  jsii.Bool(true),
+			BlockPublicAccess: awss3.BlockPublicAccess_BLOCK_ALL(),
+			EnforceSSL:        jsii.Bool(true),
+			LifecycleRules:    &rules,
+		},
+	)
+}
+
+func (c *Craft) createNetworking(props *CraftProps) {
+	switch {
+	case props.Vpc != nil:
+		c.Vpc = props.Vpc
+		c.VpcSubnets = props.VpcSubnets
+		if c.VpcSubnets == nil {
+			c.VpcSubnets = &awsec2.SubnetSelection{
+				SubnetType: awsec2.SubnetType_PRIVATE_WITH_EGRESS,
+			}
+		}
+		c.assignPublicIp = c.VpcSubnets.SubnetType == awsec2.SubnetType_PUBLIC
+	case props.SubnetType == awsec2.SubnetType_PUBLIC:
+		c.Vpc = c.newVpc(props.Name,
+			&awsec2.SubnetConfiguration{
+				Name:       jsii.String("public"),
+				SubnetType: awsec2.SubnetType_PUBLIC,
+			},
+		)
+		c.VpcSubnets = &awsec2.SubnetSelection{SubnetGroupName: jsii.String("public")}
+		c.assignPublicIp = true
+	case props.SubnetType == awsec2.SubnetType_PRIVATE_WITH_EGRESS:
+		c.Vpc = c.newVpc(props.Name,
+			&awsec2.SubnetConfiguration{
+				Name:       jsii.String("public"),
+				SubnetType: awsec2.SubnetType_PUBLIC,
+			},
+			&awsec2.SubnetConfiguration{
+				Name:       jsii.String("private"),
+				SubnetType: awsec2.SubnetType_PRIVATE_WITH_EGRESS,
+			},
+		)
+		c.VpcSubnets = &awsec2.SubnetSelection{SubnetGroupName: jsii.String("private")}
+	case props.SubnetType == awsec2.SubnetType_PRIVATE_ISOLATED:
+		c.Vpc = c.newVpc(props.Name,
+			&awsec2.SubnetConfiguration{
+				Name:       jsii.String("private"),
+				SubnetType: awsec2.SubnetType_PRIVATE_ISOLATED,
+			},
+		)
+		c.VpcSubnets = &awsec2.SubnetSelection{SubnetGroupName: jsii.String("private")}
+	default:
+		panic("unsupported subnet type " + string(props.SubnetType))
+	}
+
+	if props.VpcEndpoints != nil && *props.VpcEndpoints {
+		c.createVpcEndpoints()
+	}
+}
+
+func (c *Craft) newVpc(name string, subnets ...*awsec2.SubnetConfiguration) awsec2.IVpc {
+	var natGateways *float64
+	if len(subnets) == 1 {
+		natGateways = jsii.Number(0)
+	}
+
+	return awsec2.NewVpc(c.Construct, jsii.String("VPC"),
+		&awsec2.VpcProps{
+			VpcName:             jsii.String(name),
+			SubnetConfiguration: &subnets,
+			NatGateways:         natGateways,
+		},
+	)
+}
+
+func (c *Craft) createVpcEndpoints() {
+	awsec2.NewGatewayVpcEndpoint(c.Construct, jsii.String("EndpointS3"),
+		&awsec2.GatewayVpcEndpointProps{
+			Vpc:     c.Vpc,
+			Service: awsec2.GatewayVpcEndpointAwsService_S3(),
+			Subnets: &[]*awsec2.SubnetSelection{c.VpcSubnets},
+		},
+	)
+
+	for id, service := range map[string]awsec2.InterfaceVpcEndpointAwsService{
+		"EndpointSTS":            awsec2.InterfaceVpcEndpointAwsService_STS(),
+		"EndpointCloudFormation": awsec2.InterfaceVpcEndpointAwsService_CLOUDFORMATION(),
+		"EndpointECR":            awsec2.InterfaceVpcEndpointAwsService_ECR(),
+		"EndpointECRDocker":      awsec2.InterfaceVpcEndpointAwsService_ECR_DOCKER(),
+		"EndpointLogs":           awsec2.InterfaceVpcEndpointAwsService_CLOUDWATCH_LOGS(),
+		"EndpointEvents":         awsec2.InterfaceVpcEndpointAwsService_EVENTBRIDGE(),
+	} {
+		awsec2.NewInterfaceVpcEndpoint(c.Construct, jsii.String(id),
+			&awsec2.InterfaceVpcEndpointProps{
+				Vpc:               c.Vpc,
+				Service:           service,
+				Subnets:           c.VpcSubnets,
+				PrivateDnsEnabled: jsii.Bool(true),
+			},
+		)
+	}
+}
+
+func (c *Craft) createCompute(props *CraftProps) {
+	c.Compute = awsbatch.NewFargateComputeEnvironment(c.Construct, jsii.String("Compute"),
+		&awsbatch.FargateComputeEnvironmentProps{
+			ComputeEnvironmentName: jsii.String(props.Name),
+			Vpc:                    c.Vpc,
+			MaxvCpus:               props.MaxvCpus,
+			Spot:                   props.Spot,
+			VpcSubnets:             c.VpcSubnets,
+		},
+	)
+}
+
+func (c *Craft) createQueue(props *CraftProps) {
+	c.Queue = awsbatch.NewJobQueue(c.Construct, jsii.String("Queue"),
+		&awsbatch.JobQueueProps{
+			JobQueueName: jsii.String(props.Name),
+			ComputeEnvironments: &[]*awsbatch.OrderedComputeEnvironment{
+				{Order: jsii.Number(1.0), ComputeEnvironment: c.Compute},
+			},
+		},
+	)
+}
+
+func (c *Craft) createRole(props *CraftProps) {
+	if props.PermissionsBoundary != nil {
+		awsiam.PermissionsBoundary_Of(c.Construct).Apply(props.PermissionsBoundary)
+	}
+
+	// Execution role is used by AWS Batch (ECS agent) to pull image and
+	// to write logs, the construct grants these permissions on demand.
+	c.ExecutionRole = awsiam.NewRole(c.Construct, jsii.String("ExecutionRole"),
+		&awsiam.RoleProps{
+			AssumedBy: awsiam.NewServicePrincipal(jsii.String("ecs-tasks.amazonaws.com"), nil),
+		},
+	)
+
+	c.ExecutionRole.AddToPrincipalPolicy(
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Actions: jsii.Strings("logs:CreateLogStream", "logs:PutLogEvents"),
+			Resources: jsii.Strings(
+				"arn:aws:logs:" + *awscdk.Aws_REGION() + ":" + *awscdk.Aws_ACCOUNT_ID() + ":log-group:/aws/batch/job:*",
+			),
+		}),
+	)
+
+	// Job role is used by craft job, it only reads templates, writes artifacts
+	// and assumes roles at trusted accounts.
+	resources := []*string{}
+	for _, acc := range props.TrustedAccounts {
+		for _, role := range props.TrustedRoles {
+			resources = append(resources, jsii.String("arn:aws:iam::"+acc+":role/"+role))
+		}
+	}
+
+	c.Role = awsiam.NewRole(c.Construct, jsii.String("Role"),
+		&awsiam.RoleProps{
+			AssumedBy: awsiam.NewServicePrincipal(jsii.String("ecs-tasks.amazonaws.com"), nil),
+			InlinePolicies: &map[string]awsiam.PolicyDocument{
+				"assume": awsiam.NewPolicyDocument(&awsiam.PolicyDocumentProps{
+					Statements: &[]awsiam.PolicyStatement{
+						awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+							Actions:   jsii.Strings("sts:AssumeRole"),
+							Resources: &resources,
+						}),
+					},
+				}),
+			},
+		},
+	)
+
+	c.SourceCode.GrantRead(c.Role, nil)
+	c.SourceCode.GrantPut(c.Role, jsii.String("craft/*"))
+	c.Bus.GrantPutEventsTo(c.Role)
+
+	// Scheduler role emits delayed events (e.g. expiration of approvals),
+	// craft jobs manages schedules prefixed by craft-
+	c.SchedulerRole = awsiam.NewRole(c.Construct, jsii.String("SchedulerRole"),
+		&awsiam.RoleProps{
+			AssumedBy: awsiam.NewServicePrincipal(jsii.String("scheduler.amazonaws.com"), nil),
+		},
+	)
+	c.Bus.GrantPutEventsTo(c.SchedulerRole)
+
+	c.Role.AddToPrincipalPolicy(
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Actions: jsii.Strings("scheduler:CreateSchedule", "scheduler:DeleteSchedule"),
+			Resources: jsii.Strings(
+				"arn:aws:scheduler:" + *awscdk.Aws_REGION() + ":" + *awscdk.Aws_ACCOUNT_ID() + ":schedule/default/craft-*",
+			),
+		}),
+	)
+	c.SchedulerRole.GrantPassRole(c.Role)
+
+	c.Schedules = awsscheduler.NewCfnScheduleGroup(c.Construct, jsii.String("Schedules"),
+		&awsscheduler.CfnScheduleGroupProps{
+			Name: jsii.String(props.Name),
+		},
+	)
+}
+
+func (c *Craft) createImage(props *CraftProps) {
+	sourceCode := os.Getenv("GITHUB_WORKSPACE")
+	if sourceCode == "" {
+		sourceCode = filepath.Join(os.Getenv("GOPATH"), "src/github.com/fogfish/craft")
+	}
+	sourceCode = filepath.Join(sourceCode, "internal/cmd/job/deploy")
+
+	asset := awsecrassets.NewDockerImageAsset(c.Construct, jsii.String("Image"),
+		&awsecrassets.DockerImageAssetProps{
+			Directory: jsii.String(sourceCode),
+			Platform:  awsecrassets.Platform_LINUX_AMD64(),
+		},
+	)
+
+	c.image = awsecs.ContainerImage_FromDockerImageAsset(asset)
+}
+
+func (c *Craft) createJobDeploy(props *CraftProps) {
+	c.JobDeploy = c.newJob(props, "Builder", "job-deploy", c.Role, "/bin/run.sh", nil)
+}
+
+func (c *Craft) createJobBootstrap(props *CraftProps) {
+	// Bootstrap role is only used by bootstrap job, it assumes organization
+	// level role at target account.
+	statement := &awsiam.PolicyStatementProps{
+		Actions: jsii.Strings("sts:AssumeRole"),
+	}
+
+	if props.OrganizationId == "" {
+		resources := []*string{}
+		for _, acc := range props.TrustedAccounts {
+			resources = append(resources, jsii.String("arn:aws:iam::"+acc+":role/"+props.OrganizationRole))
+		}
+		statement.Resources = &resources
+	} else {
+		statement.Resources = jsii.Strings("arn:aws:iam::*:role/" + props.OrganizationRole)
+		statement.Conditions = &map[string]any{
+			"StringEquals": map[string]any{"aws:ResourceOrgID": props.OrganizationId},
+		}
+	}
+
+	c.BootstrapRole = awsiam.NewRole(c.Construct, jsii.String("BootstrapRole"),
+		&awsiam.RoleProps{
+			AssumedBy: awsiam.NewServicePrincipal(jsii.String("ecs-tasks.amazonaws.com"), nil),
+			InlinePolicies: &map[string]awsiam.PolicyDocument{
+				"assume": awsiam.NewPolicyDocument(&awsiam.PolicyDocumentProps{
+					Statements: &[]awsiam.PolicyStatement{
+						awsiam.NewPolicyStatement(statement),
+					},
+				}),
+			},
+		},
+	)
+
+	c.SourceCode.GrantPut(c.BootstrapRole, jsii.String("craft/*"))
+	c.Bus.GrantPutEventsTo(c.BootstrapRole)
+
+	// the role is defined by the construct only, it matches the policy
+	c.JobBootstrap = c.newJob(props, "Bootstrap", "job-bootstrap", c.BootstrapRole, "/bin/bootstrap.sh",
+		map[string]*string{
+			"CRAFT_ORGANIZATION_ROLE":  jsii.String(props.OrganizationRole),
+			"CRAFT_EXECUTION_POLICIES": jsii.String(strings.Join(props.BootstrapExecutionPolicies, ",")),
+		},
+	)
+}
+
+func (c *Craft) newJob(props *CraftProps, id, name string, role awsiam.IRole, script string, env map[string]*string) awsbatch.EcsJobDefinition {
+	if env == nil {
+		env = map[string]*string{}
+	}
+	env["CRAFT_EVENT_BUS"] = c.Bus.EventBusName()
+	env["CRAFT_EVENT_BUS_ARN"] = c.Bus.EventBusArn()
+	env["CRAFT_SCHEDULER_ROLE"] = c.SchedulerRole.RoleArn()
+	env["CRAFT_APPROVAL_TIMEOUT"] = jsii.String(fmt.Sprintf("%.0f", *props.ApprovalTimeout.ToSeconds(nil)))
+
+	container := awsbatch.NewEcsFargateContainerDefinition(c.Construct, jsii.String(id+"Container"),
+		&awsbatch.EcsFargateContainerDefinitionProps{
+			Cpu:                    props.Cpu,
+			Memory:                 awscdk.Size_Gibibytes(props.Memory),
+			Image:                  c.image,
+			Command:                jsii.Strings("sh", script),
+			AssignPublicIp:         jsii.Bool(c.assignPublicIp),
+			JobRole:                role,
+			ExecutionRole:          c.ExecutionRole,
+			FargateCpuArchitecture: awsecs.CpuArchitecture_X86_64(),
+			Environment:            &env,
+		},
+	)
+
+	return awsbatch.NewEcsJobDefinition(c.Construct, jsii.String(id),
+		&awsbatch.EcsJobDefinitionProps{
+			JobDefinitionName: jsii.String(props.Name + "-" + name),
+			Container:         container,
+		},
+	)
+}
+
+func (c *Craft) createEventBus(props *CraftProps) {
+	c.broker = eventbridge.NewBroker(c.Construct, jsii.String("Broker"), nil)
+	if props.EventBus != nil {
+		c.broker.Bus = props.EventBus
+	} else {
+		c.broker.NewEventBus(&awsevents.EventBusProps{EventBusName: jsii.String(props.Name)})
+	}
+	c.Bus = c.broker.Bus
+}
+
+// Producers at member accounts of the organization (or trusted accounts)
+// submit events to the bus, if it is owned by the construct.
+func (c *Craft) createProducers(props *CraftProps) {
+	if props.EventBus != nil {
+		return
+	}
+
+	// the craft's own account needs no policy
+	accounts := []string{}
+	for _, acc := range props.TrustedAccounts {
+		if acc != *awscdk.Aws_ACCOUNT_ID() {
+			accounts = append(accounts, acc)
+		}
+	}
+
+	if props.OrganizationId == "" && len(accounts) == 0 {
+		return
+	}
+
+	statement := map[string]any{
+		"Sid":      "CraftProducers",
+		"Effect":   "Allow",
+		"Action":   "events:PutEvents",
+		"Resource": c.Bus.EventBusArn(),
+	}
+	if props.OrganizationId != "" {
+		statement["Principal"] = "*"
+		statement["Condition"] = map[string]any{
+			"StringEquals": map[string]any{"aws:PrincipalOrgID": props.OrganizationId},
+		}
+	} else {
+		statement["Principal"] = map[string]any{"AWS": accounts}
+	}
+
+	awsevents.NewCfnEventBusPolicy(c.Construct, jsii.String("Producers"),
+		&awsevents.CfnEventBusPolicyProps{
+			EventBusName: c.Bus.EventBusName(),
+			StatementId:  jsii.String("CraftProducers"),
+			Statement:    statement,
+		},
+	)
+}
+
+func (c *Craft) createGateway(props *CraftProps) {
+	f := c.broker.NewSink(
+		&eventbridge.SinkProps{
+			Source: []string{*c.Bus.EventBusName()},
+			Categories: []string{
+				"EventCraft",
+				"EventCraftArray",
+				"EventComposite",
+				"EventBootstrap",
+				"EventApproval",
+				"EventRollback",
+				"EventRollout",
+				"EventRolloutControl",
+				"EventDeployment",
+				"EventDriftDetection",
+				"EventReconcile",
+				"EventScheduleCancel",
+				"EventScheduleList",
+				"EventDebounce",
+				"EventTenantProvision",
+				"EventTenantSuspend",
+				"EventTenantResume",
+				"EventTenantDeprovision",
+			},
+			Function: &scud.FunctionGoProps{
+				SourceCodeModule: "github.com/fogfish/craft",
+				SourceCodeLambda: "internal/cmd/lambda/gateway",
+				FunctionProps: &awslambda.FunctionProps{
+					FunctionName: jsii.String(props.Name),
+					Timeout:      awscdk.Duration_Seconds(jsii.Number(60.0)),
+					Environment:  c.gatewayEnvironment(props),
+				},
+			},
+		},
+	)
+
+	c.Gateway = f.Handler
+	c.gatewayRule = f.Rule
+	c.grantGateway(c.Gateway)
+
+	// events of the craft are archived to be replayed
+	if props.ArchiveRetention != nil {
+		c.Archive = awsevents.NewArchive(c.Construct, jsii.String("Archive"),
+			&awsevents.ArchiveProps{
+				SourceEventBus: c.Bus,
+				EventPattern: &awsevents.EventPattern{
+					Source: jsii.Strings(*c.Bus.EventBusName()),
+				},
+				Retention: props.ArchiveRetention,
+			},
+		)
+	}
+
+	// events failed by the gateway are kept with the failure reason
+	c.DeadLetter = awssqs.NewQueue(c.Construct, jsii.String("DeadLetter"),
+		&awssqs.QueueProps{
+			RetentionPeriod: awscdk.Duration_Days(jsii.Number(14)),
+		},
+	)
+
+	c.Gateway.ConfigureAsyncInvoke(
+		&awslambda.EventInvokeConfigOptions{
+			OnFailure:     awslambdadestinations.NewSqsDestination(c.DeadLetter),
+			RetryAttempts: jsii.Number(2),
+		},
+	)
+}
+
+// The replay re-emits events of dead-letter queues to the craft's bus
+// and replays the archive to the gateway, if enabled.
+func (c *Craft) createReplay(props *CraftProps) {
+	env := map[string]*string{
+		"CONFIG_VSN":         jsii.String(string(props.Version)),
+		"CONFIG_DEAD_LETTER": c.DeadLetter.QueueUrl(),
+		"CONFIG_EVENT_BUS":   c.Bus.EventBusName(),
+	}
+
+	// archived events are replayed to the gateway only
+	if c.Archive != nil {
+		env["CONFIG_ARCHIVE"] = c.Archive.ArchiveArn()
+		env["CONFIG_EVENT_BUS_ARN"] = c.Bus.EventBusArn()
+		env["CONFIG_GATEWAY_RULE"] = c.gatewayRule.RuleArn()
+	}
+
+	// EventCraft failed by the inbox are replayed to the craft's bus
+	if c.InboxDeadLetter != nil {
+		env["CONFIG_INBOX_DEAD_LETTER"] = c.InboxDeadLetter.QueueUrl()
+	}
+
+	f := c.broker.NewSink(
+		&eventbridge.SinkProps{
+			Source:     []string{*c.Bus.EventBusName()},
+			Categories: []string{"EventReplay", "EventArchiveReplay"},
+			Function: &scud.FunctionGoProps{
+				SourceCodeModule: "github.com/fogfish/craft",
+				SourceCodeLambda: "internal/cmd/lambda/replay",
+				FunctionProps: &awslambda.FunctionProps{
+					Timeout:     awscdk.Duration_Seconds(jsii.Number(300.0)),
+					Environment: &env,
+				},
+			},
+		},
+	)
+
+	c.Replay = f.Handler
+	c.DeadLetter.GrantConsumeMessages(c.Replay)
+	if c.InboxDeadLetter != nil {
+		c.InboxDeadLetter.GrantConsumeMessages(c.Replay)
+	}
+	c.Bus.GrantPutEventsTo(c.Replay)
+
+	if c.Archive != nil {
+		c.Replay.AddToRolePolicy(
+			awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+				Actions: jsii.Strings("events:StartReplay"),
+				Resources: jsii.Strings(
+					*c.Archive.ArchiveArn(),
+					"arn:aws:events:"+*awscdk.Aws_REGION()+":"+*awscdk.Aws_ACCOUNT_ID()+":replay/*",
+				),
+			}),
+		)
+	}
+}
+
+// The gateway serves HTTP API to submit deployments and query their
+// status, the access is authorized by AWS IAM (SigV4).
+func (c *Craft) createApi(props *CraftProps) {
+	if props.Api == nil || !*props.Api {
+		return
+	}
+
+	env := *c.gatewayEnvironment(props)
+	env["CONFIG_API"] = jsii.String("on")
+
+	c.GatewayApi = scud.NewFunction(c.Construct, jsii.String("GatewayApi"),
+		&scud.FunctionGoProps{
+			SourceCodeModule: "github.com/fogfish/craft",
+			SourceCodeLambda: "internal/cmd/lambda/gateway",
+			FunctionProps: &awslambda.FunctionProps{
+				Timeout:     awscdk.Duration_Seconds(jsii.Number(29.0)),
+				Environment: &env,
+			},
+		},
+	)
+	c.grantGateway(c.GatewayApi)
+
+	c.Api = awsapigateway.NewLambdaRestApi(c.Construct, jsii.String("Api"),
+		&awsapigateway.LambdaRestApiProps{
+			Handler: c.GatewayApi,
+			Proxy:   jsii.Bool(false),
+			DefaultMethodOptions: &awsapigateway.MethodOptions{
+				AuthorizationType: awsapigateway.AuthorizationType_IAM,
+			},
+		},
+	)
+
+	deployments := c.Api.Root().AddResource(jsii.String("deployments"), nil)
+	deployments.AddMethod(jsii.String("POST"), nil, nil)
+	deployments.AddResource(jsii.String("{uid}"), nil).AddMethod(jsii.String("GET"), nil, nil)
+}
+
+// The gateway consumes EventCraft from AWS SQS queue, messages failed
+// repeatedly are moved to the dead-letter queue.
+func (c *Craft) createInbox(props *CraftProps) {
+	if props.Inbox == nil || !*props.Inbox {
+		return
+	}
+
+	c.InboxDeadLetter = awssqs.NewQueue(c.Construct, jsii.String("InboxDeadLetter"),
+		&awssqs.QueueProps{
+			RetentionPeriod: awscdk.Duration_Days(jsii.Number(14)),
+		},
+	)
+
+	c.Inbox = awssqs.NewQueue(c.Construct, jsii.String("Inbox"),
+		&awssqs.QueueProps{
+			// AWS recommends 6x of the function timeout
+			VisibilityTimeout: awscdk.Duration_Seconds(jsii.Number(360.0)),
+			DeadLetterQueue: &awssqs.DeadLetterQueue{
+				Queue:           c.InboxDeadLetter,
+				MaxReceiveCount: jsii.Number(5),
+			},
+		},
+	)
+
+	env := *c.gatewayEnvironment(props)
+	env["CONFIG_INBOX"] = c.Inbox.QueueName()
+
+	c.GatewayInbox = scud.NewFunction(c.Construct, jsii.String("GatewayInbox"),
+		&scud.FunctionGoProps{
+			SourceCodeModule: "github.com/fogfish/craft",
+			SourceCodeLambda: "internal/cmd/lambda/gateway",
+			FunctionProps: &awslambda.FunctionProps{
+				Timeout:     awscdk.Duration_Seconds(jsii.Number(60.0)),
+				Environment: &env,
+			},
+		},
+	)
+	c.grantGateway(c.GatewayInbox)
+
+	c.GatewayInbox.AddEventSource(
+		awslambdaeventsources.NewSqsEventSource(c.Inbox,
+			&awslambdaeventsources.SqsEventSourceProps{
+				BatchSize:               jsii.Number(10),
+				ReportBatchItemFailures: jsii.Bool(true),
+			},
+		),
+	)
+}
+
+func (c *Craft) gatewayEnvironment(props *CraftProps) *map[string]*string {
+	window := "0"
+	if props.Debounce != nil {
+		window = fmt.Sprintf("%.0f", *props.Debounce.ToSeconds(nil))
+	}
+
+	// processed events are kept while they are replayable
+	ledger := awscdk.Duration_Days(jsii.Number(14))
+	if props.ArchiveRetention != nil {
+		ledger = props.ArchiveRetention
+	}
+
+	return &map[string]*string{
+		"CONFIG_VSN":                 jsii.String(string(props.Version)),
+		"CONFIG_S3":                  c.SourceCode.BucketName(),
+		"CONFIG_BATCH_QUEUE":         c.Queue.JobQueueName(),
+		"CONFIG_BATCH_JOB_CRAFT":     c.JobDeploy.JobDefinitionArn(),
+		"CONFIG_BATCH_JOB_BOOTSTRAP": c.JobBootstrap.JobDefinitionArn(),
+		"CONFIG_TRUSTED_ACCOUNTS":    jsii.String(strings.Join(props.TrustedAccounts, ",")),
+		"CONFIG_ORGANIZATION_ID":     jsii.String(props.OrganizationId),
+		"CONFIG_REGISTRY":            c.Registry.TableName(),
+		"CONFIG_FLEET":               c.Fleet.TableName(),
+		"CONFIG_DESIRED":             c.Desired.TableName(),
+		"CONFIG_TENANTS":             c.Tenants.TableName(),
+		"CONFIG_DEBOUNCE":            c.Debounce.TableName(),
+		"CONFIG_DEBOUNCE_WINDOW":     jsii.String(window),
+		"CONFIG_SEQUENCE":            c.Sequence.TableName(),
+		"CONFIG_LEDGER":              c.Ledger.TableName(),
+		"CONFIG_LEDGER_TTL":          jsii.String(fmt.Sprintf("%.0f", *ledger.ToSeconds(nil))),
+		"CONFIG_EVENT_BUS":           c.Bus.EventBusName(),
+		"CONFIG_EVENT_BUS_ARN":       c.Bus.EventBusArn(),
+		"CONFIG_SCHEDULE_GROUP":      c.Schedules.Ref(),
+		"CONFIG_SCHEDULER_ROLE":      c.SchedulerRole.RoleArn(),
+	}
+}
+
+func (c *Craft) grantGateway(f awslambda.IFunction) {
+	c.JobDeploy.GrantSubmitJob(f, c.Queue)
+	c.JobBootstrap.GrantSubmitJob(f, c.Queue)
+	c.Registry.GrantReadWriteData(f)
+	c.Fleet.GrantReadWriteData(f)
+	c.Desired.GrantReadWriteData(f)
+	c.Tenants.GrantReadWriteData(f)
+	c.Debounce.GrantReadWriteData(f)
+	c.Sequence.GrantReadWriteData(f)
+	c.Ledger.GrantReadWriteData(f)
+	c.Bus.GrantPutEventsTo(f)
+	c.SourceCode.GrantPut(f, jsii.String(ARTIFACT_CONTEXTS+"*"))
+
+	// delayed deployments are schedules of the group
+	f.AddToRolePolicy(
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Actions: jsii.Strings("scheduler:CreateSchedule", "scheduler:DeleteSchedule", "scheduler:GetSchedule"),
+			Resources: jsii.Strings(
+				"arn:aws:scheduler:" + *awscdk.Aws_REGION() + ":" + *awscdk.Aws_ACCOUNT_ID() + ":schedule/" + *c.Schedules.Ref() + "/*",
+			),
+		}),
+	)
+	f.AddToRolePolicy(
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Actions:   jsii.Strings("scheduler:ListSchedules"),
+			Resources: jsii.Strings("*"),
+		}),
+	)
+	c.SchedulerRole.GrantPassRole(f.GrantPrincipal())
+}
+
+// The mapper consumes business events from the craft's bus and additional
+// buses, the rules are loaded from the source code bucket.
+func (c *Craft) createMapper(props *CraftProps) {
+	if props.Rules == "" {
+		return
+	}
+
+	c.Mapper = scud.NewFunction(c.Construct, jsii.String("Mapper"),
+		&scud.FunctionGoProps{
+			SourceCodeModule: "github.com/fogfish/craft",
+			SourceCodeLambda: "internal/cmd/lambda/mapper",
+			FunctionProps: &awslambda.FunctionProps{
+				Timeout: awscdk.Duration_Seconds(jsii.Number(10.0)),
+				Environment: &map[string]*string{
+					"CONFIG_VSN":       jsii.String(string(props.Version)),
+					"CONFIG_S3":        c.SourceCode.BucketName(),
+					"CONFIG_RULES":     jsii.String(props.Rules),
+					"CONFIG_EVENT_BUS": c.Bus.EventBusName(),
+				},
+			},
+		},
+	)
+
+	c.SourceCode.GrantRead(c.Mapper, jsii.String(props.Rules))
+	c.Bus.GrantPutEventsTo(c.Mapper)
+
+	// events emitted by the craft are not mapped
+	pattern := &awsevents.EventPattern{
+		Source: awsevents.Match_AnythingBut(*c.Bus.EventBusName()),
+	}
+	if len(props.RulesSources) > 0 {
+		pattern.Source = jsii.Strings(props.RulesSources...)
+	}
+
+	// business events of any type are delivered as detail of BusinessEvent,
+	// the mapper evaluates rules against the envelope of the event.
+	input := awsevents.RuleTargetInput_FromObject(
+		map[string]any{
+			"id":          awsevents.EventField_FromPath(jsii.String("$.id")),
+			"detail-type": "BusinessEvent",
+			"detail":      awsevents.EventField_FromPath(jsii.String("$")),
+		},
+	)
+
+	buses := append([]awsevents.IEventBus{c.Bus}, props.RulesEventBuses...)
+	for i, bus := range buses {
+		awsevents.NewRule(c.Construct, jsii.String(fmt.Sprintf("MapperRule%d", i)),
+			&awsevents.RuleProps{
+				EventBus:     bus,
+				EventPattern: pattern,
+				Targets: &[]awsevents.IRuleTarget{
+					awseventstargets.NewLambdaFunction(c.Mapper,
+						&awseventstargets.LambdaFunctionProps{Event: input},
+					),
+				},
+			},
+		)
+	}
+}
+
+// The relay delivers status and outputs of deployments to the reply-to bus
+// at the producer's account, the access is restricted to the organization.
+func (c *Craft) createRelay(props *CraftProps) {
+	if props.ReplyTo == nil || !*props.ReplyTo {
+		return
+	}
+
+	f := c.broker.NewSink(
+		&eventbridge.SinkProps{
+			Source:     []string{*c.Bus.EventBusName()},
+			Categories: []string{"EventDeployment", "EventCraftDiff", "EventApprovalRequested"},
+			Pattern: map[string]interface{}{
+				"replyTo": []map[string]bool{{"exists": true}},
+			},
+			Function: &scud.FunctionGoProps{
+				SourceCodeModule: "github.com/fogfish/craft",
+				SourceCodeLambda: "internal/cmd/lambda/relay",
+				FunctionProps: &awslambda.FunctionProps{
+					Timeout: awscdk.Duration_Seconds(jsii.Number(10.0)),
+					Environment: &map[string]*string{
+						"CONFIG_VSN": jsii.String(string(props.Version)),
+					},
+				},
+			},
+		},
+	)
+
+	c.Relay = f.Handler
+
+	// buses of member accounts only
+	if props.OrganizationId != "" {
+		c.Relay.AddToRolePolicy(
+			awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+				Actions:   jsii.Strings("events:PutEvents"),
+				Resources: jsii.Strings("arn:aws:events:*:*:event-bus/*"),
+				Conditions: &map[string]any{
+					"StringEquals": map[string]any{"aws:ResourceOrgID": props.OrganizationId},
+				},
+			}),
+		)
+	} else {
+		buses := make([]*string, len(props.TrustedAccounts))
+		for i, account := range props.TrustedAccounts {
+			buses[i] = jsii.String("arn:aws:events:*:" + account + ":event-bus/*")
+		}
+
+		c.Relay.AddToRolePolicy(
+			awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+				Actions:   jsii.Strings("events:PutEvents"),
+				Resources: &buses,
+			}),
+		)
+	}
+}
+
+// The webhook delivers terminal status of deployments to their callbacks,
+// each delivery is logged with its attempts.
+func (c *Craft) createWebhook(props *CraftProps) {
+	if props.WebhookSecrets == nil {
+		return
+	}
+
+	c.Webhooks = awsdynamodb.NewTable(c.Construct, jsii.String("Webhooks"),
+		&awsdynamodb.TableProps{
+			PartitionKey:        &awsdynamodb.Attribute{Type: awsdynamodb.AttributeType_STRING, Name: jsii.String("uid")},
+			SortKey:             &awsdynamodb.Attribute{Type: awsdynamodb.AttributeType_STRING, Name: jsii.String("created")},
+			BillingMode:         awsdynamodb.BillingMode_PAY_PER_REQUEST,
+			PointInTimeRecovery: jsii.Bool(true),
+			RemovalPolicy:       awscdk.RemovalPolicy_RETAIN,
+		},
+	)
+
+	f := c.broker.NewSink(
+		&eventbridge.SinkProps{
+			Source:     []string{*c.Bus.EventBusName()},
+			Categories: []string{"EventDeployment"},
+			Pattern: map[string]interface{}{
+				"callback": []map[string]bool{{"exists": true}},
+				"status":   []string{"succeeded", "failed", "discarded"},
+			},
+			Function: &scud.FunctionGoProps{
+				SourceCodeModule: "github.com/fogfish/craft",
+				SourceCodeLambda: "internal/cmd/lambda/webhook",
+				FunctionProps: &awslambda.FunctionProps{
+					// covers attempts of delivery with exponential back-off
+					Timeout: awscdk.Duration_Seconds(jsii.Number(120.0)),
+					Environment: &map[string]*string{
+						"CONFIG_VSN":             jsii.String(string(props.Version)),
+						"CONFIG_WEBHOOKS":        c.Webhooks.TableName(),
+						"CONFIG_WEBHOOK_SECRETS": props.WebhookSecrets.SecretArn(),
+					},
+				},
+			},
+		},
+	)
+
+	c.Webhook = f.Handler
+	c.Webhooks.GrantWriteData(c.Webhook)
+	props.WebhookSecrets.GrantRead(c.Webhook, nil)
+}
+
+func (c *Craft) createDriftDetection(props *CraftProps) {
+	if props.DriftDetection == "" {
+		return
+	}
+
+	// each run of drift detection is identified by execution of the schedule
+	input := `{"uid":"drift-<aws.scheduler.execution-id>"}`
+	if props.DriftRemediation != nil && *props.DriftRemediation {
+		input = `{"uid":"drift-<aws.scheduler.execution-id>","remediate":true}`
+	}
+
+	c.DriftDetection = c.newSchedule("DriftDetection", props.DriftDetection, "EventDriftDetection", input)
+}
+
+func (c *Craft) createReconcile(props *CraftProps) {
+	if props.Reconcile == "" {
+		return
+	}
+
+	c.Reconcile = c.newSchedule("Reconcile", props.Reconcile, "EventReconcile",
+		`{"uid":"reconcile-<aws.scheduler.execution-id>"}`,
+	)
+}
+
+// recurring schedule, which emits the event to the bus
+func (c *Craft) newSchedule(id, expression, category, input string) awsscheduler.CfnSchedule {
+	return awsscheduler.NewCfnSchedule(c.Construct, jsii.String(id),
+		&awsscheduler.CfnScheduleProps{
+			GroupName:          c.Schedules.Ref(),
+			ScheduleExpression: jsii.String(expression),
+			FlexibleTimeWindow: &awsscheduler.CfnSchedule_FlexibleTimeWindowProperty{Mode: jsii.String("OFF")},
+			Target: &awsscheduler.CfnSchedule_TargetProperty{
+				Arn:     c.Bus.EventBusArn(),
+				RoleArn: c.SchedulerRole.RoleArn(),
+				Input:   jsii.String(input),
+				EventBridgeParameters: &awsscheduler.CfnSchedule_EventBridgeParametersProperty{
+					DetailType: jsii.String(category),
+					Source:     c.Bus.EventBusName(),
+				},
+			},
+		},
+	)
+}
+
+func (c *Craft) createRegistry(props *CraftProps) {
+	table := awsdynamodb.NewTable(c.Construct, jsii.String("Registry"),
+		&awsdynamodb.TableProps{
+			PartitionKey:        &awsdynamodb.Attribute{Type: awsdynamodb.AttributeType_STRING, Name: jsii.String("uid")},
+			BillingMode:         awsdynamodb.BillingMode_PAY_PER_REQUEST,
+			PointInTimeRecovery: jsii.Bool(true),
+			RemovalPolicy:       awscdk.RemovalPolicy_RETAIN,
+		},
+	)
+
+	// deployments of tenant, module and status ordered by time
+	for _, key := range []string{"tenant", "module", "status"} {
+		table.AddGlobalSecondaryIndex(
+			&awsdynamodb.GlobalSecondaryIndexProps{
+				IndexName:    jsii.String(key),
+				PartitionKey: &awsdynamodb.Attribute{Type: awsdynamodb.AttributeType_STRING, Name: jsii.String(key)},
+				SortKey:      &awsdynamodb.Attribute{Type: awsdynamodb.AttributeType_STRING, Name: jsii.String("created")},
+			},
+		)
+	}
+
+	c.Registry = table
+
+	c.Fleet = awsdynamodb.NewTable(c.Construct, jsii.String("Fleet"),
+		&awsdynamodb.TableProps{
+			PartitionKey:        &awsdynamodb.Attribute{Type: awsdynamodb.AttributeType_STRING, Name: jsii.String("uid")},
+			BillingMode:         awsdynamodb.BillingMode_PAY_PER_REQUEST,
+			PointInTimeRecovery: jsii.Bool(true),
+			RemovalPolicy:       awscdk.RemovalPolicy_RETAIN,
+		},
+	)
+
+	c.Desired = awsdynamodb.NewTable(c.Construct, jsii.String("Desired"),
+		&awsdynamodb.TableProps{
+			PartitionKey:        &awsdynamodb.Attribute{Type: awsdynamodb.AttributeType_STRING, Name: jsii.String("tenant")},
+			SortKey:             &awsdynamodb.Attribute{Type: awsdynamodb.AttributeType_STRING, Name: jsii.String("module")},
+			BillingMode:         awsdynamodb.BillingMode_PAY_PER_REQUEST,
+			PointInTimeRecovery: jsii.Bool(true),
+			RemovalPolicy:       awscdk.RemovalPolicy_RETAIN,
+		},
+	)
+
+	c.Tenants = awsdynamodb.NewTable(c.Construct, jsii.String("Tenants"),
+		&awsdynamodb.TableProps{
+			PartitionKey:        &awsdynamodb.Attribute{Type: awsdynamodb.AttributeType_STRING, Name: jsii.String("tenant")},
+			BillingMode:         awsdynamodb.BillingMode_PAY_PER_REQUEST,
+			PointInTimeRecovery: jsii.Bool(true),
+			RemovalPolicy:       awscdk.RemovalPolicy_RETAIN,
+		},
+	)
+
+	c.Debounce = awsdynamodb.NewTable(c.Construct, jsii.String("Debounce"),
+		&awsdynamodb.TableProps{
+			PartitionKey:        &awsdynamodb.Attribute{Type: awsdynamodb.AttributeType_STRING, Name: jsii.String("tenant")},
+			SortKey:             &awsdynamodb.Attribute{Type: awsdynamodb.AttributeType_STRING, Name: jsii.String("module")},
+			BillingMode:         awsdynamodb.BillingMode_PAY_PER_REQUEST,
+			PointInTimeRecovery: jsii.Bool(true),
+			RemovalPolicy:       awscdk.RemovalPolicy_RETAIN,
+		},
+	)
+
+	c.Sequence = awsdynamodb.NewTable(c.Construct, jsii.String("Sequence"),
+		&awsdynamodb.TableProps{
+			PartitionKey:        &awsdynamodb.Attribute{Type: awsdynamodb.AttributeType_STRING, Name: jsii.String("tenant")},
+			SortKey:             &awsdynamodb.Attribute{Type: awsdynamodb.AttributeType_STRING, Name: jsii.String("module")},
+			BillingMode:         awsdynamodb.BillingMode_PAY_PER_REQUEST,
+			PointInTimeRecovery: jsii.Bool(true),
+			RemovalPolicy:       awscdk.RemovalPolicy_RETAIN,
+		},
+	)
+
+	c.Ledger = awsdynamodb.NewTable(c.Construct, jsii.String("Ledger"),
+		&awsdynamodb.TableProps{
+			PartitionKey:        &awsdynamodb.Attribute{Type: awsdynamodb.AttributeType_STRING, Name: jsii.String("category")},
+			SortKey:             &awsdynamodb.Attribute{Type: awsdynamodb.AttributeType_STRING, Name: jsii.String("uid")},
+			TimeToLiveAttribute: jsii.String("expires"),
+			BillingMode:         awsdynamodb.BillingMode_PAY_PER_REQUEST,
+			RemovalPolicy:       awscdk.RemovalPolicy_RETAIN,
+		},
+	)
+}
+
+// The monitor consumes state changes of craft jobs from the default bus
+func (c *Craft) createMonitor(props *CraftProps) {
+	f := eventbridge.NewSink(c.Construct, jsii.String("Monitor"),
+		&eventbridge.SinkProps{
+			Source:     []string{"aws.batch"},
+			Categories: []string{"Batch Job State Change"},
+			Pattern: map[string]interface{}{
+				"jobQueue": []*string{c.Queue.JobQueueArn()},
+				"status":   []string{"SUCCEEDED", "FAILED"},
+			},
+			Function: &scud.FunctionGoProps{
+				SourceCodeModule: "github.com/fogfish/craft",
+				SourceCodeLambda: "internal/cmd/lambda/monitor",
+				FunctionProps: &awslambda.FunctionProps{
+					Timeout: awscdk.Duration_Seconds(jsii.Number(60.0)),
+					Environment: &map[string]*string{
+						"CONFIG_VSN":       jsii.String(string(props.Version)),
+						"CONFIG_S3":        c.SourceCode.BucketName(),
+						"CONFIG_REGISTRY":  c.Registry.TableName(),
+						"CONFIG_EVENT_BUS": c.Bus.EventBusName(),
+					},
+				},
+			},
+		},
+	)
+
+	c.Monitor = f.Handler
+	c.Registry.GrantWriteData(c.Monitor)
+	c.Bus.GrantPutEventsTo(c.Monitor)
+	c.SourceCode.GrantPut(c.Monitor, jsii.String(ARTIFACT_OUTPUTS+"*"))
+	c.SourceCode.GrantRead(c.Monitor, jsii.String(ARTIFACT_CONTEXTS+"*"))
+
+	// child jobs of array job are aggregated by the monitor,
+	// jobs of failed composite deployment are cancelled by the monitor
+	c.Monitor.AddToRolePolicy(
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Actions:   jsii.Strings("batch:ListJobs", "batch:TerminateJob"),
+			Resources: jsii.Strings("*"),
+		}),
+	)
+}
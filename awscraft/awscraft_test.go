@@ -0,0 +1,991 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package awscraft_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsevents"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awss3"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssecretsmanager"
+	"github.com/aws/jsii-runtime-go"
+	"github.com/fogfish/craft/awscraft"
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/tagver"
+)
+
+func TestAwsCraft(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"),
+		&awscdk.StackProps{
+			Env: &awscdk.Environment{
+				Region: jsii.String("us-east-1"),
+			},
+		},
+	)
+
+	awscraft.New(stack, jsii.String("Craft"),
+		&awscraft.CraftProps{
+			Version:          tagver.Version("test"),
+			SourceCodeBucket: "test",
+		},
+	)
+
+	require := map[*string]*float64{
+		jsii.String("AWS::EC2::VPC"):                         jsii.Number(1),
+		jsii.String("AWS::EC2::Subnet"):                      jsii.Number(2),
+		jsii.String("AWS::EC2::RouteTable"):                  jsii.Number(2),
+		jsii.String("AWS::EC2::SubnetRouteTableAssociation"): jsii.Number(2),
+		jsii.String("AWS::EC2::Route"):                       jsii.Number(2),
+		jsii.String("AWS::EC2::InternetGateway"):             jsii.Number(1),
+		jsii.String("AWS::EC2::InternetGateway"):             jsii.Number(1),
+		jsii.String("AWS::EC2::SecurityGroup"):               jsii.Number(1),
+		jsii.String("AWS::Batch::ComputeEnvironment"):        jsii.Number(1),
+		jsii.String("AWS::Batch::JobQueue"):                  jsii.Number(1),
+		jsii.String("AWS::Batch::JobDefinition"):             jsii.Number(2),
+		jsii.String("AWS::S3::Bucket"):                       jsii.Number(1),
+		jsii.String("AWS::S3::BucketPolicy"):                 jsii.Number(1),
+		jsii.String("AWS::KMS::Key"):                         jsii.Number(1),
+		jsii.String("AWS::IAM::Role"):                        jsii.Number(8),
+		jsii.String("AWS::Lambda::Function"):                 jsii.Number(4),
+		jsii.String("AWS::Lambda::EventInvokeConfig"):        jsii.Number(1),
+		jsii.String("AWS::SQS::Queue"):                       jsii.Number(1),
+		jsii.String("AWS::DynamoDB::Table"):                  jsii.Number(7),
+		jsii.String("AWS::Events::Rule"):                     jsii.Number(3),
+		jsii.String("Custom::LogRetention"):                  jsii.Number(3),
+		jsii.String("AWS::Scheduler::ScheduleGroup"):         jsii.Number(1),
+	}
+
+	template := assertions.Template_FromStack(stack, nil)
+	for key, val := range require {
+		template.ResourceCountIs(key, val)
+	}
+
+	template.HasResourceProperties(jsii.String("AWS::Lambda::EventInvokeConfig"),
+		map[string]any{
+			"MaximumRetryAttempts": 2,
+			"DestinationConfig": map[string]any{
+				"OnFailure": map[string]any{
+					"Destination": assertions.Match_AnyValue(),
+				},
+			},
+		},
+	)
+
+	template.HasResourceProperties(jsii.String("AWS::S3::Bucket"),
+		map[string]any{
+			"BucketName":              "test",
+			"VersioningConfiguration": map[string]any{"Status": "Enabled"},
+			"BucketEncryption": map[string]any{
+				"ServerSideEncryptionConfiguration": []any{
+					map[string]any{
+						"BucketKeyEnabled": true,
+						"ServerSideEncryptionByDefault": map[string]any{
+							"SSEAlgorithm": "aws:kms",
+						},
+					},
+				},
+			},
+			"PublicAccessBlockConfiguration": map[string]any{
+				"BlockPublicAcls":       true,
+				"BlockPublicPolicy":     true,
+				"IgnorePublicAcls":      true,
+				"RestrictPublicBuckets": true,
+			},
+			"LifecycleConfiguration": map[string]any{
+				"Rules": assertions.Match_ArrayWith(&[]any{
+					assertions.Match_ObjectLike(&map[string]any{
+						"Prefix":           awscraft.ARTIFACT_LOGS,
+						"ExpirationInDays": 30,
+						"Status":           "Enabled",
+					}),
+				}),
+			},
+		},
+	)
+}
+
+func TestAwsCraftEmbeddedTwice(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"), nil)
+
+	for _, id := range []string{"A", "B"} {
+		awscraft.New(stack, jsii.String(id),
+			&awscraft.CraftProps{
+				Version:          tagver.Version("test"),
+				SourceCodeBucket: "test-" + strings.ToLower(id),
+			},
+		)
+	}
+
+	template := assertions.Template_FromStack(stack, nil)
+
+	for _, name := range []string{"Test-A", "Test-B"} {
+		template.HasResourceProperties(jsii.String("AWS::Events::EventBus"),
+			map[string]any{"Name": name},
+		)
+		template.HasResourceProperties(jsii.String("AWS::Batch::JobQueue"),
+			map[string]any{"JobQueueName": name},
+		)
+		template.HasResourceProperties(jsii.String("AWS::Batch::JobDefinition"),
+			map[string]any{"JobDefinitionName": name + "-job-deploy"},
+		)
+		template.HasResourceProperties(jsii.String("AWS::Scheduler::ScheduleGroup"),
+			map[string]any{"Name": name},
+		)
+	}
+}
+
+func TestAwsCraftImportBucket(t *testing.T) {
+	for name, bucket := range map[string]string{
+		"Name": "test",
+		"Arn":  "arn:aws:s3:::test",
+	} {
+		t.Run(name, func(t *testing.T) {
+			app := awscdk.NewApp(nil)
+			stack := awscdk.NewStack(app, jsii.String("Test"),
+				&awscdk.StackProps{
+					Env: &awscdk.Environment{
+						Region: jsii.String("us-east-1"),
+					},
+				},
+			)
+
+			craft := awscraft.New(stack, jsii.String("Craft"),
+				&awscraft.CraftProps{
+					Version:                tagver.Version("test"),
+					SourceCodeBucket:       bucket,
+					ImportSourceCodeBucket: jsii.Bool(true),
+				},
+			)
+
+			it.Then(t).Should(
+				it.Equal(*craft.SourceCode.BucketName(), "test"),
+			)
+
+			template := assertions.Template_FromStack(stack, nil)
+			template.ResourceCountIs(jsii.String("AWS::S3::Bucket"), jsii.Number(0))
+			template.ResourceCountIs(jsii.String("AWS::KMS::Key"), jsii.Number(0))
+		})
+	}
+}
+
+func TestAwsCraftImportBucketWithKey(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"), nil)
+
+	awscraft.New(stack, jsii.String("Craft"),
+		&awscraft.CraftProps{
+			Version:                tagver.Version("test"),
+			SourceCodeBucket:       "test",
+			ImportSourceCodeBucket: jsii.Bool(true),
+			SourceCodeKey: awskms.Key_FromKeyArn(stack, jsii.String("Key"),
+				jsii.String("arn:aws:kms:eu-west-1:111111111111:key/test"),
+			),
+		},
+	)
+
+	template := assertions.Template_FromStack(stack, nil)
+	template.ResourceCountIs(jsii.String("AWS::KMS::Key"), jsii.Number(0))
+	template.HasResourceProperties(jsii.String("AWS::IAM::Policy"),
+		map[string]any{
+			"PolicyDocument": map[string]any{
+				"Statement": assertions.Match_ArrayWith(&[]any{
+					assertions.Match_ObjectLike(&map[string]any{
+						"Action":   assertions.Match_ArrayWith(&[]any{"kms:Decrypt"}),
+						"Resource": "arn:aws:kms:eu-west-1:111111111111:key/test",
+					}),
+				}),
+			},
+		},
+	)
+}
+
+func TestAwsCraftWithExistingResources(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"),
+		&awscdk.StackProps{
+			Env: &awscdk.Environment{
+				Region: jsii.String("us-east-1"),
+			},
+		},
+	)
+
+	bucket := awss3.Bucket_FromBucketName(stack, jsii.String("Bucket"), jsii.String("test"))
+	bus := awsevents.EventBus_FromEventBusName(stack, jsii.String("Bus"), jsii.String("test"))
+
+	craft := awscraft.New(stack, jsii.String("Craft"),
+		&awscraft.CraftProps{
+			Version:    tagver.Version("test"),
+			SourceCode: bucket,
+			EventBus:   bus,
+		},
+	)
+
+	it.Then(t).Should(
+		it.Equal(*craft.SourceCode.BucketName(), "test"),
+		it.Equal(*craft.Bus.EventBusName(), "test"),
+	)
+
+	require := map[*string]*float64{
+		jsii.String("AWS::S3::Bucket"):       jsii.Number(0),
+		jsii.String("AWS::Events::EventBus"): jsii.Number(0),
+		jsii.String("AWS::Events::Rule"):     jsii.Number(3),
+	}
+
+	template := assertions.Template_FromStack(stack, nil)
+	for key, val := range require {
+		template.ResourceCountIs(key, val)
+	}
+}
+
+func TestAwsCraftPrivateIsolated(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"),
+		&awscdk.StackProps{
+			Env: &awscdk.Environment{
+				Region: jsii.String("us-east-1"),
+			},
+		},
+	)
+
+	awscraft.New(stack, jsii.String("Craft"),
+		&awscraft.CraftProps{
+			Version:          tagver.Version("test"),
+			SourceCodeBucket: "test",
+			SubnetType:       awsec2.SubnetType_PRIVATE_ISOLATED,
+			VpcEndpoints:     jsii.Bool(true),
+		},
+	)
+
+	require := map[*string]*float64{
+		jsii.String("AWS::EC2::VPC"):             jsii.Number(1),
+		jsii.String("AWS::EC2::Subnet"):          jsii.Number(2),
+		jsii.String("AWS::EC2::InternetGateway"): jsii.Number(0),
+		jsii.String("AWS::EC2::NatGateway"):      jsii.Number(0),
+		jsii.String("AWS::EC2::VPCEndpoint"):     jsii.Number(7),
+	}
+
+	template := assertions.Template_FromStack(stack, nil)
+	for key, val := range require {
+		template.ResourceCountIs(key, val)
+	}
+
+	template.HasResourceProperties(jsii.String("AWS::Batch::JobDefinition"),
+		map[string]any{
+			"ContainerProperties": map[string]any{
+				"NetworkConfiguration": map[string]any{
+					"AssignPublicIp": "DISABLED",
+				},
+			},
+		},
+	)
+}
+
+func TestAwsCraftWithExistingVpc(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"),
+		&awscdk.StackProps{
+			Env: &awscdk.Environment{
+				Region: jsii.String("us-east-1"),
+			},
+		},
+	)
+
+	vpc := awsec2.NewVpc(stack, jsii.String("VPC"), nil)
+
+	craft := awscraft.New(stack, jsii.String("Craft"),
+		&awscraft.CraftProps{
+			Version:          tagver.Version("test"),
+			SourceCodeBucket: "test",
+			Vpc:              vpc,
+		},
+	)
+
+	it.Then(t).Should(
+		it.Equal(craft.Vpc, awsec2.IVpc(vpc)),
+	)
+
+	template := assertions.Template_FromStack(stack, nil)
+	template.ResourceCountIs(jsii.String("AWS::EC2::VPC"), jsii.Number(1))
+	template.HasResourceProperties(jsii.String("AWS::Batch::JobDefinition"),
+		map[string]any{
+			"ContainerProperties": map[string]any{
+				"NetworkConfiguration": map[string]any{
+					"AssignPublicIp": "DISABLED",
+				},
+			},
+		},
+	)
+}
+
+func TestAwsCraftRoles(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"),
+		&awscdk.StackProps{
+			Env: &awscdk.Environment{
+				Region: jsii.String("us-east-1"),
+			},
+		},
+	)
+
+	craft := awscraft.New(stack, jsii.String("Craft"),
+		&awscraft.CraftProps{
+			Version:          tagver.Version("test"),
+			SourceCodeBucket: "test",
+			TrustedAccounts:  []string{"111111111111", "222222222222"},
+			TrustedRoles:     []string{"cdk-hnb659fds-*"},
+		},
+	)
+
+	ecsTasks := map[string]any{
+		"Statement": []any{
+			map[string]any{
+				"Action":    "sts:AssumeRole",
+				"Effect":    "Allow",
+				"Principal": map[string]any{"Service": "ecs-tasks.amazonaws.com"},
+			},
+		},
+		"Version": "2012-10-17",
+	}
+
+	template := assertions.Template_FromStack(stack, nil)
+
+	// job role
+	template.HasResource(jsii.String("AWS::IAM::Role"),
+		map[string]any{
+			"Properties": assertions.Match_ObjectEquals(&map[string]any{
+				"AssumeRolePolicyDocument": ecsTasks,
+				"Policies": []any{
+					map[string]any{
+						"PolicyName": "assume",
+						"PolicyDocument": map[string]any{
+							"Statement": []any{
+								map[string]any{
+									"Action": "sts:AssumeRole",
+									"Effect": "Allow",
+									"Resource": []any{
+										"arn:aws:iam::111111111111:role/cdk-hnb659fds-*",
+										"arn:aws:iam::222222222222:role/cdk-hnb659fds-*",
+									},
+								},
+							},
+							"Version": "2012-10-17",
+						},
+					},
+				},
+			}),
+		},
+	)
+
+	// execution role
+	template.HasResource(jsii.String("AWS::IAM::Role"),
+		map[string]any{
+			"Properties": assertions.Match_ObjectEquals(&map[string]any{
+				"AssumeRolePolicyDocument": ecsTasks,
+			}),
+		},
+	)
+
+	template.HasResourceProperties(jsii.String("AWS::IAM::Policy"),
+		map[string]any{
+			"Roles": []any{
+				map[string]any{"Ref": stack.GetLogicalId(craft.ExecutionRole.Node().DefaultChild().(awscdk.CfnElement))},
+			},
+			"PolicyDocument": map[string]any{
+				"Statement": []any{
+					map[string]any{
+						"Action":   []any{"logs:CreateLogStream", "logs:PutLogEvents"},
+						"Effect":   "Allow",
+						"Resource": assertions.Match_AnyValue(),
+					},
+					map[string]any{
+						"Action":   []any{"ecr:BatchCheckLayerAvailability", "ecr:GetDownloadUrlForLayer", "ecr:BatchGetImage"},
+						"Effect":   "Allow",
+						"Resource": assertions.Match_AnyValue(),
+					},
+					map[string]any{
+						"Action":   "ecr:GetAuthorizationToken",
+						"Effect":   "Allow",
+						"Resource": "*",
+					},
+				},
+				"Version": "2012-10-17",
+			},
+		},
+	)
+
+	// no managed policies at job roles
+	roles := template.FindResources(jsii.String("AWS::IAM::Role"),
+		map[string]any{
+			"Properties": map[string]any{
+				"AssumeRolePolicyDocument": ecsTasks,
+				"ManagedPolicyArns":        assertions.Match_AnyValue(),
+			},
+		},
+	)
+	it.Then(t).Should(it.Equal(len(*roles), 0))
+}
+
+func TestAwsCraftBootstrapRole(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"),
+		&awscdk.StackProps{
+			Env: &awscdk.Environment{
+				Region: jsii.String("us-east-1"),
+			},
+		},
+	)
+
+	awscraft.New(stack, jsii.String("Craft"),
+		&awscraft.CraftProps{
+			Version:          tagver.Version("test"),
+			SourceCodeBucket: "test",
+			OrganizationId:   "o-test",
+		},
+	)
+
+	template := assertions.Template_FromStack(stack, nil)
+	template.HasResourceProperties(jsii.String("AWS::IAM::Role"),
+		map[string]any{
+			"Policies": []any{
+				map[string]any{
+					"PolicyName": "assume",
+					"PolicyDocument": map[string]any{
+						"Statement": []any{
+							map[string]any{
+								"Action":   "sts:AssumeRole",
+								"Effect":   "Allow",
+								"Resource": "arn:aws:iam::*:role/OrganizationAccountAccessRole",
+								"Condition": map[string]any{
+									"StringEquals": map[string]any{"aws:ResourceOrgID": "o-test"},
+								},
+							},
+						},
+						"Version": "2012-10-17",
+					},
+				},
+			},
+		},
+	)
+}
+
+func TestAwsCraftBootstrapJob(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"), nil)
+
+	awscraft.New(stack, jsii.String("Craft"),
+		&awscraft.CraftProps{
+			Version:                    tagver.Version("test"),
+			SourceCodeBucket:           "test",
+			OrganizationRole:           "CraftAdmin",
+			BootstrapExecutionPolicies: []string{"arn:aws:iam::aws:policy/PowerUserAccess"},
+		},
+	)
+
+	template := assertions.Template_FromStack(stack, nil)
+	template.HasResourceProperties(jsii.String("AWS::Batch::JobDefinition"),
+		map[string]any{
+			"ContainerProperties": assertions.Match_ObjectLike(&map[string]any{
+				"Environment": assertions.Match_ArrayWith(&[]any{
+					map[string]any{"Name": "CRAFT_EXECUTION_POLICIES", "Value": "arn:aws:iam::aws:policy/PowerUserAccess"},
+					map[string]any{"Name": "CRAFT_ORGANIZATION_ROLE", "Value": "CraftAdmin"},
+				}),
+			}),
+		},
+	)
+}
+
+func TestAwsCraftPermissionsBoundary(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"),
+		&awscdk.StackProps{
+			Env: &awscdk.Environment{
+				Region: jsii.String("us-east-1"),
+			},
+		},
+	)
+
+	boundary := awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("Boundary"),
+		jsii.String("arn:aws:iam::111111111111:policy/boundary"),
+	)
+
+	awscraft.New(stack, jsii.String("Craft"),
+		&awscraft.CraftProps{
+			Version:             tagver.Version("test"),
+			SourceCodeBucket:    "test",
+			PermissionsBoundary: boundary,
+		},
+	)
+
+	template := assertions.Template_FromStack(stack, nil)
+	roles := template.FindResources(jsii.String("AWS::IAM::Role"), nil)
+	bounded := template.FindResources(jsii.String("AWS::IAM::Role"),
+		map[string]any{
+			"Properties": map[string]any{
+				"PermissionsBoundary": "arn:aws:iam::111111111111:policy/boundary",
+			},
+		},
+	)
+	for id := range *roles {
+		if strings.HasPrefix(id, "Craft") {
+			_, has := (*bounded)[id]
+			it.Then(t).Should(it.True(has))
+		}
+	}
+}
+
+func TestAwsCraftRegistry(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"), nil)
+
+	awscraft.New(stack, jsii.String("Craft"),
+		&awscraft.CraftProps{
+			Version:          tagver.Version("test"),
+			SourceCodeBucket: "test",
+		},
+	)
+
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.HasResource(jsii.String("AWS::DynamoDB::Table"),
+		map[string]any{
+			"DeletionPolicy": "Retain",
+			"Properties": map[string]any{
+				"KeySchema": []any{
+					map[string]any{"AttributeName": "uid", "KeyType": "HASH"},
+				},
+				"GlobalSecondaryIndexes": []any{
+					assertions.Match_ObjectLike(&map[string]any{
+						"IndexName": "tenant",
+						"KeySchema": []any{
+							map[string]any{"AttributeName": "tenant", "KeyType": "HASH"},
+							map[string]any{"AttributeName": "created", "KeyType": "RANGE"},
+						},
+					}),
+					assertions.Match_ObjectLike(&map[string]any{
+						"IndexName": "module",
+						"KeySchema": []any{
+							map[string]any{"AttributeName": "module", "KeyType": "HASH"},
+							map[string]any{"AttributeName": "created", "KeyType": "RANGE"},
+						},
+					}),
+					assertions.Match_ObjectLike(&map[string]any{
+						"IndexName": "status",
+						"KeySchema": []any{
+							map[string]any{"AttributeName": "status", "KeyType": "HASH"},
+							map[string]any{"AttributeName": "created", "KeyType": "RANGE"},
+						},
+					}),
+				},
+				"PointInTimeRecoverySpecification": map[string]any{
+					"PointInTimeRecoveryEnabled": true,
+				},
+			},
+		},
+	)
+
+	template.HasResourceProperties(jsii.String("AWS::Events::Rule"),
+		map[string]any{
+			"EventPattern": map[string]any{
+				"source":      []any{"aws.batch"},
+				"detail-type": []any{"Batch Job State Change"},
+				"detail": map[string]any{
+					"status": []any{"SUCCEEDED", "FAILED"},
+				},
+			},
+		},
+	)
+
+	template.HasResourceProperties(jsii.String("AWS::Events::Rule"),
+		map[string]any{
+			"EventPattern": map[string]any{
+				"detail-type": assertions.Match_ArrayWith(&[]any{"EventComposite", "EventRollback", "EventRollout", "EventRolloutControl", "EventDeployment", "EventDriftDetection", "EventReconcile", "EventScheduleCancel", "EventScheduleList", "EventDebounce", "EventTenantProvision", "EventTenantSuspend", "EventTenantResume", "EventTenantDeprovision"}),
+			},
+		},
+	)
+}
+
+func TestAwsCraftSchedules(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"), nil)
+
+	awscraft.New(stack, jsii.String("Craft"),
+		&awscraft.CraftProps{
+			Version:          tagver.Version("test"),
+			SourceCodeBucket: "test",
+			DriftDetection:   "rate(1 day)",
+			DriftRemediation: jsii.Bool(true),
+			Reconcile:        "rate(15 minutes)",
+			Debounce:         awscdk.Duration_Minutes(jsii.Number(2)),
+		},
+	)
+
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.ResourceCountIs(jsii.String("AWS::Scheduler::Schedule"), jsii.Number(2))
+	template.HasResourceProperties(jsii.String("AWS::Scheduler::Schedule"),
+		map[string]any{
+			"ScheduleExpression": "rate(1 day)",
+			"Target": assertions.Match_ObjectLike(&map[string]any{
+				"Input": `{"uid":"drift-<aws.scheduler.execution-id>","remediate":true}`,
+				"EventBridgeParameters": map[string]any{
+					"DetailType": "EventDriftDetection",
+					"Source":     assertions.Match_AnyValue(),
+				},
+			}),
+		},
+	)
+
+	template.HasResourceProperties(jsii.String("AWS::Scheduler::Schedule"),
+		map[string]any{
+			"ScheduleExpression": "rate(15 minutes)",
+			"Target": assertions.Match_ObjectLike(&map[string]any{
+				"Input": `{"uid":"reconcile-<aws.scheduler.execution-id>"}`,
+			}),
+		},
+	)
+
+	template.HasResourceProperties(jsii.String("AWS::Lambda::Function"),
+		map[string]any{
+			"Environment": map[string]any{
+				"Variables": assertions.Match_ObjectLike(&map[string]any{
+					"CONFIG_DEBOUNCE_WINDOW": "120",
+				}),
+			},
+		},
+	)
+}
+
+func TestAwsCraftMapper(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"), nil)
+
+	awscraft.New(stack, jsii.String("Craft"),
+		&awscraft.CraftProps{
+			Version:          tagver.Version("test"),
+			SourceCodeBucket: "test",
+			Rules:            "craft/rules.json",
+			RulesSources:     []string{"billing"},
+			RulesEventBuses: []awsevents.IEventBus{
+				awsevents.EventBus_FromEventBusName(stack, jsii.String("Billing"), jsii.String("billing")),
+			},
+		},
+	)
+
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.ResourceCountIs(jsii.String("AWS::Lambda::Function"), jsii.Number(5))
+	template.HasResourceProperties(jsii.String("AWS::Lambda::Function"),
+		map[string]any{
+			"Environment": map[string]any{
+				"Variables": assertions.Match_ObjectLike(&map[string]any{
+					"CONFIG_RULES": "craft/rules.json",
+				}),
+			},
+		},
+	)
+
+	template.ResourceCountIs(jsii.String("AWS::Events::Rule"), jsii.Number(5))
+	template.HasResourceProperties(jsii.String("AWS::Events::Rule"),
+		map[string]any{
+			"EventBusName": "billing",
+			"EventPattern": map[string]any{
+				"source": []any{"billing"},
+			},
+			"Targets": []any{
+				assertions.Match_ObjectLike(&map[string]any{
+					"InputTransformer": map[string]any{
+						"InputPathsMap": map[string]any{
+							"id": "$.id",
+							"f1": "$",
+						},
+						"InputTemplate": `{"detail":<f1>,"detail-type":"BusinessEvent","id":<id>}`,
+					},
+				}),
+			},
+		},
+	)
+}
+
+func TestAwsCraftInbox(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"), nil)
+
+	awscraft.New(stack, jsii.String("Craft"),
+		&awscraft.CraftProps{
+			Version:          tagver.Version("test"),
+			SourceCodeBucket: "test",
+			Inbox:            jsii.Bool(true),
+		},
+	)
+
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.ResourceCountIs(jsii.String("AWS::Lambda::Function"), jsii.Number(5))
+	template.ResourceCountIs(jsii.String("AWS::SQS::Queue"), jsii.Number(3))
+	template.HasResourceProperties(jsii.String("AWS::SQS::Queue"),
+		map[string]any{
+			"RedrivePolicy": map[string]any{
+				"maxReceiveCount": 5,
+			},
+		},
+	)
+	template.HasResourceProperties(jsii.String("AWS::Lambda::EventSourceMapping"),
+		map[string]any{
+			"BatchSize":             10,
+			"FunctionResponseTypes": []any{"ReportBatchItemFailures"},
+		},
+	)
+	template.HasResourceProperties(jsii.String("AWS::Lambda::Function"),
+		map[string]any{
+			"Environment": map[string]any{
+				"Variables": assertions.Match_ObjectLike(&map[string]any{
+					"CONFIG_INBOX_DEAD_LETTER": assertions.Match_AnyValue(),
+				}),
+			},
+		},
+	)
+}
+
+func TestAwsCraftArchive(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"), nil)
+
+	awscraft.New(stack, jsii.String("Craft"),
+		&awscraft.CraftProps{
+			Version:          tagver.Version("test"),
+			SourceCodeBucket: "test",
+			ArchiveRetention: awscdk.Duration_Days(jsii.Number(90)),
+		},
+	)
+
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.ResourceCountIs(jsii.String("AWS::Events::Archive"), jsii.Number(1))
+	template.HasResourceProperties(jsii.String("AWS::Events::Archive"),
+		map[string]any{
+			"RetentionDays": 90,
+		},
+	)
+	template.HasResourceProperties(jsii.String("AWS::Lambda::Function"),
+		map[string]any{
+			"Environment": map[string]any{
+				"Variables": assertions.Match_ObjectLike(&map[string]any{
+					"CONFIG_ARCHIVE":      assertions.Match_AnyValue(),
+					"CONFIG_GATEWAY_RULE": assertions.Match_AnyValue(),
+				}),
+			},
+		},
+	)
+	template.HasResourceProperties(jsii.String("AWS::Lambda::Function"),
+		map[string]any{
+			"Environment": map[string]any{
+				"Variables": assertions.Match_ObjectLike(&map[string]any{
+					"CONFIG_LEDGER":     assertions.Match_AnyValue(),
+					"CONFIG_LEDGER_TTL": "7776000",
+				}),
+			},
+		},
+	)
+	template.HasResourceProperties(jsii.String("AWS::DynamoDB::Table"),
+		map[string]any{
+			"TimeToLiveSpecification": map[string]any{
+				"AttributeName": "expires",
+				"Enabled":       true,
+			},
+		},
+	)
+}
+
+func TestAwsCraftApi(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"), nil)
+
+	awscraft.New(stack, jsii.String("Craft"),
+		&awscraft.CraftProps{
+			Version:          tagver.Version("test"),
+			SourceCodeBucket: "test",
+			Api:              jsii.Bool(true),
+		},
+	)
+
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.ResourceCountIs(jsii.String("AWS::ApiGateway::RestApi"), jsii.Number(1))
+	template.ResourceCountIs(jsii.String("AWS::ApiGateway::Method"), jsii.Number(2))
+	template.HasResourceProperties(jsii.String("AWS::ApiGateway::Method"),
+		map[string]any{
+			"HttpMethod":        "POST",
+			"AuthorizationType": "AWS_IAM",
+		},
+	)
+	template.HasResourceProperties(jsii.String("AWS::ApiGateway::Method"),
+		map[string]any{
+			"HttpMethod":        "GET",
+			"AuthorizationType": "AWS_IAM",
+		},
+	)
+	template.HasResourceProperties(jsii.String("AWS::ApiGateway::Resource"),
+		map[string]any{
+			"PathPart": "{uid}",
+		},
+	)
+	template.HasResourceProperties(jsii.String("AWS::Lambda::Function"),
+		map[string]any{
+			"Environment": map[string]any{
+				"Variables": assertions.Match_ObjectLike(&map[string]any{
+					"CONFIG_API": "on",
+				}),
+			},
+		},
+	)
+}
+
+func TestAwsCraftWebhook(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"), nil)
+
+	awscraft.New(stack, jsii.String("Craft"),
+		&awscraft.CraftProps{
+			Version:          tagver.Version("test"),
+			SourceCodeBucket: "test",
+			WebhookSecrets: awssecretsmanager.Secret_FromSecretNameV2(stack,
+				jsii.String("Secrets"), jsii.String("craft/webhooks"),
+			),
+		},
+	)
+
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.ResourceCountIs(jsii.String("AWS::Lambda::Function"), jsii.Number(5))
+	template.ResourceCountIs(jsii.String("AWS::DynamoDB::Table"), jsii.Number(8))
+	template.HasResourceProperties(jsii.String("AWS::Events::Rule"),
+		map[string]any{
+			"EventPattern": assertions.Match_ObjectLike(&map[string]any{
+				"detail-type": []any{"EventDeployment"},
+				"detail": map[string]any{
+					"callback": []any{map[string]any{"exists": true}},
+					"status":   []any{"succeeded", "failed", "discarded"},
+				},
+			}),
+		},
+	)
+	template.HasResourceProperties(jsii.String("AWS::Lambda::Function"),
+		map[string]any{
+			"Environment": map[string]any{
+				"Variables": assertions.Match_ObjectLike(&map[string]any{
+					"CONFIG_WEBHOOKS":        assertions.Match_AnyValue(),
+					"CONFIG_WEBHOOK_SECRETS": assertions.Match_AnyValue(),
+				}),
+			},
+		},
+	)
+}
+
+func TestAwsCraftReplyTo(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("Test"), nil)
+
+	awscraft.New(stack, jsii.String("Craft"),
+		&awscraft.CraftProps{
+			Version:          tagver.Version("test"),
+			SourceCodeBucket: "test",
+			OrganizationId:   "o-test",
+			ReplyTo:          jsii.Bool(true),
+		},
+	)
+
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.ResourceCountIs(jsii.String("AWS::Lambda::Function"), jsii.Number(5))
+	template.HasResourceProperties(jsii.String("AWS::Events::Rule"),
+		map[string]any{
+			"EventPattern": assertions.Match_ObjectLike(&map[string]any{
+				"detail-type": []any{"EventDeployment", "EventCraftDiff", "EventApprovalRequested"},
+				"detail": map[string]any{
+					"replyTo": []any{map[string]any{"exists": true}},
+				},
+			}),
+		},
+	)
+	template.HasResourceProperties(jsii.String("AWS::IAM::Policy"),
+		map[string]any{
+			"PolicyDocument": map[string]any{
+				"Statement": assertions.Match_ArrayWith(&[]any{
+					map[string]any{
+						"Action":    "events:PutEvents",
+						"Effect":    "Allow",
+						"Resource":  "arn:aws:events:*:*:event-bus/*",
+						"Condition": map[string]any{"StringEquals": map[string]any{"aws:ResourceOrgID": "o-test"}},
+					},
+				}),
+			},
+		},
+	)
+
+	template.ResourceCountIs(jsii.String("AWS::Events::EventBusPolicy"), jsii.Number(1))
+	template.HasResourceProperties(jsii.String("AWS::Events::EventBusPolicy"),
+		map[string]any{
+			"Statement": assertions.Match_ObjectLike(&map[string]any{
+				"Principal": "*",
+				"Condition": map[string]any{"StringEquals": map[string]any{"aws:PrincipalOrgID": "o-test"}},
+			}),
+		},
+	)
+}
+
+func TestAwsCraftProducers(t *testing.T) {
+	t.Run("Trusted", func(t *testing.T) {
+		app := awscdk.NewApp(nil)
+		stack := awscdk.NewStack(app, jsii.String("Test"), nil)
+
+		awscraft.New(stack, jsii.String("Craft"),
+			&awscraft.CraftProps{
+				Version:          tagver.Version("test"),
+				SourceCodeBucket: "test",
+				TrustedAccounts:  []string{"111111111111"},
+			},
+		)
+
+		template := assertions.Template_FromStack(stack, nil)
+
+		template.ResourceCountIs(jsii.String("AWS::Events::EventBusPolicy"), jsii.Number(1))
+		template.HasResourceProperties(jsii.String("AWS::Events::EventBusPolicy"),
+			map[string]any{
+				"Statement": assertions.Match_ObjectLike(&map[string]any{
+					"Principal": map[string]any{"AWS": []any{"111111111111"}},
+				}),
+			},
+		)
+	})
+
+	t.Run("None", func(t *testing.T) {
+		app := awscdk.NewApp(nil)
+		stack := awscdk.NewStack(app, jsii.String("Test"), nil)
+
+		awscraft.New(stack, jsii.String("Craft"),
+			&awscraft.CraftProps{
+				Version:          tagver.Version("test"),
+				SourceCodeBucket: "test",
+				ReplyTo:          jsii.Bool(true),
+			},
+		)
+
+		template := assertions.Template_FromStack(stack, nil)
+
+		template.ResourceCountIs(jsii.String("AWS::Events::EventBusPolicy"), jsii.Number(0))
+	})
+}
@@ -9,12 +9,19 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsevents"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssecretsmanager"
 	"github.com/aws/jsii-runtime-go"
-	"github.com/fogfish/craft/internal/awscraft"
+	"github.com/fogfish/craft/awscraft"
 	"github.com/fogfish/tagver"
 )
 
@@ -23,21 +30,47 @@ func main() {
 
 	// craft-vX
 	vsn := FromContextVsn(app)
-	config := &awscdk.StackProps{
-		Env: &awscdk.Environment{
-			Account: jsii.String(os.Getenv("CDK_DEFAULT_ACCOUNT")),
-			Region:  jsii.String(os.Getenv("CDK_DEFAULT_REGION")),
+	stack := awscdk.NewStack(app,
+		jsii.String(vsn.Get("craft", "main").Tag("craft")),
+		&awscdk.StackProps{
+			Env: &awscdk.Environment{
+				Account: jsii.String(os.Getenv("CDK_DEFAULT_ACCOUNT")),
+				Region:  jsii.String(os.Getenv("CDK_DEFAULT_REGION")),
+			},
 		},
-	}
+	)
 
-	awscraft.New(app,
+	awscraft.New(stack, jsii.String("Craft"),
 		&awscraft.CraftProps{
-			StackProps:       config,
-			Version:          vsn.Get("craft", "main"),
-			SourceCodeBucket: FromContext(app, "source-code"),
-			Cpu:              FromContextFloat(app, "cpu"),
-			Memory:           FromContextFloat(app, "mem"),
-			Spot:             FromContextBool(app, "spot"),
+			Version:                    vsn.Get("craft", "main"),
+			Name:                       *stack.StackName(),
+			SourceCodeBucket:           FromContext(app, "source-code"),
+			ImportSourceCodeBucket:     FromContextBool(app, "source-code-import"),
+			SourceCodeKey:              FromContextKey(app, stack, "source-code-key"),
+			Cpu:                        FromContextFloat(app, "cpu"),
+			Memory:                     FromContextFloat(app, "mem"),
+			Spot:                       FromContextBool(app, "spot"),
+			Vpc:                        FromContextVpc(app, stack, "vpc"),
+			SubnetType:                 FromContextSubnetType(app, "subnets"),
+			VpcEndpoints:               FromContextBool(app, "vpc-endpoints"),
+			TrustedAccounts:            FromContextStrings(app, "trusted-accounts"),
+			TrustedRoles:               FromContextStrings(app, "trusted-roles"),
+			OrganizationId:             FromContext(app, "organization-id"),
+			OrganizationRole:           FromContext(app, "organization-role"),
+			BootstrapExecutionPolicies: FromContextStrings(app, "bootstrap-execution-policies"),
+			PermissionsBoundary:        FromContextPolicy(app, stack, "permissions-boundary"),
+			DriftDetection:             FromContext(app, "drift-detection"),
+			DriftRemediation:           FromContextBool(app, "drift-remediation"),
+			Reconcile:                  FromContext(app, "reconcile"),
+			Debounce:                   FromContextSeconds(app, "debounce"),
+			Rules:                      FromContext(app, "rules"),
+			RulesSources:               FromContextStrings(app, "rules-sources"),
+			RulesEventBuses:            FromContextEventBuses(app, stack, "rules-event-buses"),
+			Inbox:                      FromContextBool(app, "inbox"),
+			Api:                        FromContextBool(app, "api"),
+			ArchiveRetention:           FromContextDays(app, "archive"),
+			ReplyTo:                    FromContextBool(app, "reply-to"),
+			WebhookSecrets:             FromContextSecret(app, stack, "webhook-secrets"),
 		},
 	)
 
@@ -70,6 +103,24 @@ func FromContextFloat(app awscdk.App, key string) *float64 {
 	return jsii.Number(f)
 }
 
+func FromContextSeconds(app awscdk.App, key string) awscdk.Duration {
+	v := FromContextFloat(app, key)
+	if v == nil {
+		return nil
+	}
+
+	return awscdk.Duration_Seconds(v)
+}
+
+func FromContextDays(app awscdk.App, key string) awscdk.Duration {
+	v := FromContextFloat(app, key)
+	if v == nil {
+		return nil
+	}
+
+	return awscdk.Duration_Days(v)
+}
+
 func FromContextBool(app awscdk.App, key string) *bool {
 	switch FromContext(app, key) {
 	case "on":
@@ -81,6 +132,77 @@ func FromContextBool(app awscdk.App, key string) *bool {
 	}
 }
 
+func FromContextStrings(app awscdk.App, key string) []string {
+	v := FromContext(app, key)
+	if v == "" {
+		return nil
+	}
+
+	return strings.Split(v, ",")
+}
+
+func FromContextPolicy(app awscdk.App, stack awscdk.Stack, key string) awsiam.IManagedPolicy {
+	arn := FromContext(app, key)
+	if arn == "" {
+		return nil
+	}
+
+	return awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("PermissionsBoundary"), jsii.String(arn))
+}
+
+func FromContextKey(app awscdk.App, stack awscdk.Stack, key string) awskms.IKey {
+	arn := FromContext(app, key)
+	if arn == "" {
+		return nil
+	}
+
+	return awskms.Key_FromKeyArn(stack, jsii.String("SourceCodeKey"), jsii.String(arn))
+}
+
+func FromContextSecret(app awscdk.App, stack awscdk.Stack, key string) awssecretsmanager.ISecret {
+	name := FromContext(app, key)
+	if name == "" {
+		return nil
+	}
+
+	return awssecretsmanager.Secret_FromSecretNameV2(stack, jsii.String("WebhookSecrets"), jsii.String(name))
+}
+
+func FromContextVpc(app awscdk.App, stack awscdk.Stack, key string) awsec2.IVpc {
+	id := FromContext(app, key)
+	if id == "" {
+		return nil
+	}
+
+	return awsec2.Vpc_FromLookup(stack, jsii.String("VPC"),
+		&awsec2.VpcLookupOptions{VpcId: jsii.String(id)},
+	)
+}
+
+func FromContextSubnetType(app awscdk.App, key string) awsec2.SubnetType {
+	switch FromContext(app, key) {
+	case "public":
+		return awsec2.SubnetType_PUBLIC
+	case "private":
+		return awsec2.SubnetType_PRIVATE_WITH_EGRESS
+	case "isolated":
+		return awsec2.SubnetType_PRIVATE_ISOLATED
+	default:
+		return ""
+	}
+}
+
+func FromContextEventBuses(app awscdk.App, stack awscdk.Stack, key string) []awsevents.IEventBus {
+	seq := make([]awsevents.IEventBus, 0)
+	for i, name := range FromContextStrings(app, key) {
+		seq = append(seq,
+			awsevents.EventBus_FromEventBusName(stack, jsii.String(fmt.Sprintf("RulesEventBus%d", i)), jsii.String(name)),
+		)
+	}
+
+	return seq
+}
+
 func FromContextVsn(app awscdk.App) tagver.Versions {
 	return tagver.NewVersions(FromContext(app, "vsn"))
 }
@@ -0,0 +1,75 @@
+#!/bin/sh
+set -eu
+set -o pipefail
+
+##
+## Required ENV
+##   CRAFT_UID
+##     unique identity of the job, which has requested approval
+##     (e.g. 123-456-789)
+##
+##   CRAFT_BUCKET
+##     S3 bucket where job artifacts are stored
+##     (e.g. craft)
+##
+##   CRAFT_EVENT_BUS
+##     AWS EventBridge bus where job emits events
+##     (e.g. craft-main)
+##
+##   CRAFT_DECISION
+##     decision on requested approval: approve, reject or expire
+##
+## Artifacts
+##   s3://$CRAFT_BUCKET/craft/outputs/$CRAFT_UID.json
+##     changes of stacks, the job executes or deletes change sets listed here
+##
+##   s3://$CRAFT_BUCKET/craft/logs/$CRAFT_UID.approval.log
+##     output of the job
+##
+
+. /bin/craft.sh
+
+mkdir -p /tmp/$CRAFT_UID
+
+cd /tmp/$CRAFT_UID
+
+CRAFT_ARTIFACT=$CRAFT_UID.approval
+STATUS=failed
+
+report() {
+  jq -n \
+    --arg uid "$CRAFT_UID" \
+    --arg decision "$CRAFT_DECISION" \
+    --arg status "$STATUS" \
+    '{uid: $uid, decision: $decision, status: $status}' \
+    > craft.approval.json
+  craft_emit EventApprovalCompleted craft.approval.json
+  craft_artifacts
+}
+trap report EXIT
+
+CHANGESET=$(craft_changeset)
+
+aws s3 cp s3://$CRAFT_BUCKET/craft/outputs/$CRAFT_UID.json craft.changes.json
+
+if [ "$CRAFT_DECISION" != "expire" ]
+then
+  aws scheduler delete-schedule --name $CHANGESET || true
+fi
+
+case $CRAFT_DECISION in
+  approve)
+    craft_changes_execute craft.changes.json 2>&1 | tee craft.log
+    STATUS=executed
+    ;;
+
+  reject|expire)
+    craft_changes_delete craft.changes.json 2>&1 | tee craft.log
+    STATUS=discarded
+    ;;
+
+  *)
+    echo "unknown decision $CRAFT_DECISION"
+    exit 1
+    ;;
+esac
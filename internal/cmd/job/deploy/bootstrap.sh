@@ -0,0 +1,95 @@
+#!/bin/sh
+set -eu
+set -o pipefail
+
+##
+## Required ENV
+##   CRAFT_UID
+##     unique identity of the job, job artifacts are stored under it
+##     (e.g. 123-456-789)
+##
+##   CRAFT_BUCKET
+##     S3 bucket where job artifacts are stored
+##     (e.g. craft)
+##
+##   CRAFT_EVENT_BUS
+##     AWS EventBridge bus where job emits events
+##     (e.g. craft-main)
+##
+##   CRAFT_TARGET_ACCOUNT
+##     target account to bootstrap
+##     (e.g. 111111111111)
+##
+##   CRAFT_ORGANIZATION_ROLE
+##     organization-level role at target account, defined by job definition
+##     (e.g. OrganizationAccountAccessRole)
+##
+##   CRAFT_EXECUTION_POLICIES
+##     comma separated ARNs of managed policies used by AWS CloudFormation
+##     at target account, defined by job definition
+##     (e.g. arn:aws:iam::aws:policy/AdministratorAccess)
+##
+## Optional ENV
+##   CRAFT_TARGET_REGION
+##     target region to bootstrap, default is the region of the job
+##     (e.g. eu-west-1)
+##
+##   CRAFT_QUALIFIER
+##     AWS CDK bootstrap qualifier
+##     (default hnb659fds)
+##
+##   CRAFT_TRUST
+##     comma separated list of accounts trusted to deploy into target account,
+##     the account of craft is always trusted
+##     (e.g. 222222222222,333333333333)
+##
+## Artifacts
+##   s3://$CRAFT_BUCKET/craft/logs/$CRAFT_UID.log
+##     output of AWS CDK
+##
+##   s3://$CRAFT_BUCKET/craft/outputs/$CRAFT_UID.json
+##     status of bootstrapped account, the job emits it as EventBootstrapped
+##
+
+. /bin/craft.sh
+
+mkdir -p /tmp/$CRAFT_UID
+
+cd /tmp/$CRAFT_UID
+
+trap craft_artifacts EXIT
+
+REGION=${CRAFT_TARGET_REGION:-$AWS_REGION}
+QUALIFIER=${CRAFT_QUALIFIER:-hnb659fds}
+
+TRUST=$(aws sts get-caller-identity --query Account --output text)
+if [ -n "${CRAFT_TRUST:-}" ]
+then
+  TRUST="$TRUST,$CRAFT_TRUST"
+fi
+
+craft_assume arn:aws:iam::$CRAFT_TARGET_ACCOUNT:role/$CRAFT_ORGANIZATION_ROLE
+
+env $CREDENTIALS cdk bootstrap aws://$CRAFT_TARGET_ACCOUNT/$REGION \
+  --qualifier $QUALIFIER \
+  --trust $TRUST \
+  --trust-for-lookup $TRUST \
+  --cloudformation-execution-policies $CRAFT_EXECUTION_POLICIES \
+  2>&1 | tee craft.log
+
+VERSION=$(env $CREDENTIALS aws cloudformation describe-stacks \
+  --region $REGION \
+  --stack-name CDKToolkit \
+  --query "Stacks[0].Outputs[?OutputKey=='BootstrapVersion'].OutputValue" \
+  --output text)
+
+jq -n \
+  --arg uid "$CRAFT_UID" \
+  --arg account "$CRAFT_TARGET_ACCOUNT" \
+  --arg region "$REGION" \
+  --arg qualifier "$QUALIFIER" \
+  --arg version "$VERSION" \
+  '{uid: $uid, account: $account, region: $region, qualifier: $qualifier, version: $version}' \
+  > cdk.outputs.json
+
+craft_emit EventBootstrapped cdk.outputs.json
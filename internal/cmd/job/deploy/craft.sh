@@ -0,0 +1,315 @@
+#!/bin/sh
+##
+## Common functions of craft jobs
+##
+##   CRAFT_UID
+##     unique identity of the job, job artifacts are stored under it
+##
+##   CRAFT_BUCKET
+##     S3 bucket where application templates and job artifacts are stored
+##
+##   CRAFT_EVENT_BUS
+##     AWS EventBridge bus where job emits events
+##
+##   CRAFT_ARTIFACT
+##     optional key of job artifacts, default is CRAFT_UID
+##
+
+##
+## craft_assume ROLE_ARN
+##   assumes the role, credentials are exported to CREDENTIALS variable,
+##   use it as `env $CREDENTIALS cmd ...`
+craft_assume() {
+  set -- $(aws sts assume-role \
+    --role-arn $1 \
+    --role-session-name $(craft_session) \
+    --query '[Credentials.AccessKeyId,Credentials.SecretAccessKey,Credentials.SessionToken]' \
+    --output text)
+  CREDENTIALS="AWS_ACCESS_KEY_ID=$1 AWS_SECRET_ACCESS_KEY=$2 AWS_SESSION_TOKEN=$3"
+}
+
+##
+## craft_session
+##   name of assumed role session after the job, STS limits it to 64
+##   characters, long identity is truncated and suffixed by its hash
+craft_session() {
+  if [ ${#CRAFT_UID} -le 64 ]
+  then
+    echo $CRAFT_UID
+  else
+    echo $(echo $CRAFT_UID | cut -c1-47)-$(printf '%s' $CRAFT_UID | sha256sum | cut -c1-16)
+  fi
+}
+
+##
+## craft_changeset
+##   name of change set and its expiry schedule after the job, EventBridge
+##   Scheduler limits it to 64 characters, long identity is truncated and
+##   suffixed by its hash
+craft_changeset() {
+  NAME=craft-$(echo $CRAFT_UID | tr '_' '-')
+  if [ ${#NAME} -le 64 ]
+  then
+    echo $NAME
+  else
+    echo $(echo $NAME | cut -c1-47)-$(printf '%s' $CRAFT_UID | sha256sum | cut -c1-16)
+  fi
+}
+
+##
+## craft_artifacts
+##   uploads job artifacts (context, logs and outputs) to S3 bucket
+craft_artifacts() {
+  KEY=${CRAFT_ARTIFACT:-$CRAFT_UID}
+  if [ -f cdk.context.json ]
+  then
+    aws s3 cp cdk.context.json s3://$CRAFT_BUCKET/craft/contexts/$KEY.json || true
+  fi
+  if [ -f craft.log ]
+  then
+    aws s3 cp craft.log s3://$CRAFT_BUCKET/craft/logs/$KEY.log || true
+  fi
+  if [ -f cdk.outputs.json ]
+  then
+    aws s3 cp cdk.outputs.json s3://$CRAFT_BUCKET/craft/outputs/$KEY.json || true
+  fi
+}
+
+##
+## craft_emit CATEGORY FILE
+##   emits JSON object from the file as event of the category to craft bus,
+##   the event carries reply-to bus of the deployment (if defined)
+craft_emit() {
+  aws events put-events --entries "$(jq -n \
+    --arg bus "$CRAFT_EVENT_BUS" \
+    --arg category "$1" \
+    --arg detail "$(jq -c --arg replyTo "${CRAFT_REPLY_TO:-}" 'if $replyTo != "" then . + {replyTo: $replyTo} else . end' $2)" \
+    '[{Source: $bus, EventBusName: $bus, DetailType: $category, Detail: $detail}]')"
+}
+
+##
+## craft_stacks [lookup]
+##   lists stacks of synthesized application as "STACK ROLE_ARN REGION",
+##   the role is either craft-specific role at target account or
+##   AWS CDK bootstrap deploy role of the stack (lookup role if requested)
+craft_stacks() {
+  ACCOUNT=${CRAFT_TARGET_ACCOUNT:-$(aws sts get-caller-identity --query Account --output text)}
+  REGION=${CRAFT_TARGET_REGION:-$AWS_REGION}
+  PROPERTY=assumeRoleArn
+  if [ "${1:-}" = "lookup" ]
+  then
+    PROPERTY=lookupRole.arn
+  fi
+  jq -r --arg role "$PROPERTY" '.artifacts | to_entries[]
+    | select(.value.type == "aws:cloudformation:stack")
+    | "\(.value.properties.stackName // .key) \(.value.properties | getpath($role | split("."))) \(.value.environment)"' \
+    cdk.out/manifest.json \
+  | sed \
+    -e "s/\${AWS::Partition}/aws/g" \
+    -e "s/\${AWS::AccountId}/$ACCOUNT/g" \
+    -e "s/\${AWS::Region}/$REGION/g" \
+    -e "s|aws://[^/]*/unknown-region|aws://$ACCOUNT/$REGION|" \
+    -e "s|aws://[^/]*/||" \
+  | while read STACK ROLE REGION
+  do
+    if [ -n "${CRAFT_TARGET_ROLE:-}" ]
+    then
+      ROLE=arn:aws:iam::$CRAFT_TARGET_ACCOUNT:role/$CRAFT_TARGET_ROLE
+    fi
+    echo $STACK $ROLE $REGION
+  done
+}
+
+##
+## craft_changes CHANGESET
+##   describes the change set of each stack as structured JSON document
+##   with resources added, modified, replaced and removed, including
+##   IAM and security group changes. AWS CDK removes change sets without
+##   changes, the stack is described as unchanged. Other failures fail.
+craft_changes() {
+  craft_stacks | while read STACK ROLE REGION
+  do
+    craft_assume $ROLE
+
+    if ! env $CREDENTIALS aws cloudformation describe-change-set \
+      --region $REGION \
+      --stack-name $STACK \
+      --change-set-name $1 \
+      --output json 2> craft.changes.err
+    then
+      if ! grep -q ChangeSetNotFound craft.changes.err
+      then
+        cat craft.changes.err >&2
+        exit 1
+      fi
+      echo "{\"StackName\": \"$STACK\", \"Changes\": []}"
+    fi
+
+    echo "{\"Role\": \"$ROLE\", \"Region\": \"$REGION\"}"
+  done \
+  | jq -s --arg uid "$CRAFT_UID" --arg mod "$CRAFT_MODULE" '
+    def resource: {logicalId: .LogicalResourceId, type: .ResourceType, action: .Action, replacement: .Replacement};
+    def replaced: .Replacement == "True" or .Replacement == "Conditional";
+    {
+      uid: $uid,
+      "module": $mod,
+      stacks: [_nwise(2) | add | {
+        stack: .StackName,
+        role: .Role,
+        region: .Region,
+        changeSet: .ChangeSetId,
+        added:    [.Changes[].ResourceChange | select(.Action == "Add") | resource],
+        modified: [.Changes[].ResourceChange | select(.Action == "Modify" and (replaced | not)) | resource],
+        replaced: [.Changes[].ResourceChange | select(.Action == "Modify" and replaced) | resource],
+        removed:  [.Changes[].ResourceChange | select(.Action == "Remove") | resource],
+        iam:      [.Changes[].ResourceChange | select(.ResourceType | startswith("AWS::IAM::")) | resource],
+        securityGroups: [.Changes[].ResourceChange | select(.ResourceType | startswith("AWS::EC2::SecurityGroup")) | resource]
+      }]
+    }'
+}
+
+##
+## craft_changes_summary FILE
+##   summarizes structured changes as EventCraftDiff
+craft_changes_summary() {
+  jq --arg changes "s3://$CRAFT_BUCKET/craft/outputs/$CRAFT_UID.json" '{
+    uid: .uid,
+    "module": .module,
+    changes: $changes,
+    stacks: [.stacks[] | {
+      stack: .stack,
+      changeSet: .changeSet,
+      added: (.added | length),
+      modified: (.modified | length),
+      replaced: (.replaced | length),
+      removed: (.removed | length),
+      iam: (.iam | length),
+      securityGroups: (.securityGroups | length)
+    }]
+  }' $1
+}
+
+##
+## craft_changes_delete FILE
+##   deletes change sets of stacks listed at structured changes
+craft_changes_delete() {
+  jq -r '.stacks[] | select(.changeSet != null) | "\(.role) \(.region) \(.changeSet)"' $1 \
+  | while read ROLE REGION CHANGESET
+  do
+    craft_assume $ROLE
+
+    env $CREDENTIALS aws cloudformation delete-change-set \
+      --region $REGION \
+      --change-set-name $CHANGESET || true
+  done
+}
+
+##
+## craft_changes_execute FILE
+##   executes change sets of stacks listed at structured changes and
+##   waits until stacks are deployed
+craft_changes_execute() {
+  jq -r '.stacks[] | select(.changeSet != null) | "\(.stack) \(.role) \(.region) \(.changeSet)"' $1 \
+  | while read STACK ROLE REGION CHANGESET
+  do
+    craft_assume $ROLE
+
+    env $CREDENTIALS aws cloudformation execute-change-set \
+      --region $REGION \
+      --change-set-name $CHANGESET
+
+    STATUS=IN_PROGRESS
+    while echo $STATUS | grep -q IN_PROGRESS
+    do
+      sleep 10
+      STATUS=$(env $CREDENTIALS aws cloudformation describe-stacks \
+        --region $REGION \
+        --stack-name $STACK \
+        --query 'Stacks[0].StackStatus' \
+        --output text)
+      echo "$STACK $STATUS"
+    done
+
+    case $STATUS in
+      *ROLLBACK*|*FAILED*) return 1 ;;
+    esac
+  done
+}
+
+##
+## craft_drift
+##   detects drift of each stack using AWS CDK lookup role and describes
+##   drifted resources (modified, deleted) as structured JSON document
+craft_drift() {
+  craft_stacks lookup | while read STACK ROLE REGION
+  do
+    craft_assume $ROLE
+
+    if ! DETECTION=$(env $CREDENTIALS aws cloudformation detect-stack-drift \
+      --region $REGION \
+      --stack-name $STACK \
+      --query StackDriftDetectionId \
+      --output text)
+    then
+      echo "{\"stack\": \"$STACK\", \"status\": \"UNKNOWN\", \"resources\": []}"
+      continue
+    fi
+
+    STATUS=DETECTION_IN_PROGRESS
+    while [ "$STATUS" = "DETECTION_IN_PROGRESS" ]
+    do
+      sleep 5
+      STATUS=$(env $CREDENTIALS aws cloudformation describe-stack-drift-detection-status \
+        --region $REGION \
+        --stack-drift-detection-id $DETECTION \
+        --query DetectionStatus \
+        --output text)
+    done
+
+    DRIFT=$(env $CREDENTIALS aws cloudformation describe-stack-drift-detection-status \
+      --region $REGION \
+      --stack-drift-detection-id $DETECTION \
+      --query StackDriftStatus \
+      --output text)
+
+    env $CREDENTIALS aws cloudformation describe-stack-resource-drifts \
+      --region $REGION \
+      --stack-name $STACK \
+      --stack-resource-drift-status-filters MODIFIED DELETED \
+      --output json \
+    | jq --arg stack "$STACK" --arg status "$DRIFT" '{
+      stack: $stack,
+      status: $status,
+      resources: [.StackResourceDrifts[] | {
+        logicalId: .LogicalResourceId,
+        physicalId: .PhysicalResourceId,
+        type: .ResourceType,
+        status: .StackResourceDriftStatus,
+        differences: .PropertyDifferences
+      }]
+    }'
+  done \
+  | jq -s --arg uid "$CRAFT_UID" --arg mod "$CRAFT_MODULE" '{uid: $uid, "module": $mod, stacks: .}'
+}
+
+##
+## craft_drift_events FILE
+##   splits structured drift into EventDrift per stack, one JSON object per line
+craft_drift_events() {
+  jq -c \
+    --arg deployment "${CRAFT_DRIFT_DEPLOYMENT:-}" \
+    --arg tenant "${CRAFT_TENANT:-}" \
+    --arg version "${CRAFT_MODULE_VERSION:-}" \
+    --arg drifts "s3://$CRAFT_BUCKET/craft/outputs/$CRAFT_UID.json" '
+    .uid as $uid | .module as $mod | .stacks[] | {
+      uid: $uid,
+      deployment: $deployment,
+      tenant: $tenant,
+      "module": $mod,
+      version: $version,
+      stack: .stack,
+      status: .status,
+      resources: [.resources[] | del(.differences)],
+      drifts: $drifts
+    } | with_entries(select(.value != ""))' $1
+}
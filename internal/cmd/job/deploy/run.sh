@@ -1,8 +1,13 @@
 #!/bin/sh
 set -eu
+set -o pipefail
 
 ##
 ## Required ENV
+##   CRAFT_UID
+##     unique identity of the job, job artifacts are stored under it
+##     (e.g. 123-456-789)
+##
 ##   CRAFT_BUCKET
 ##     S3 bucket where application templates stores
 ##     (e.g. craft)
@@ -15,13 +20,232 @@ set -eu
 ##     context for AWS CDK application, inline JSON object
 ##     (e.g. {"acc": "xxx"})
 ##
+## Optional ENV
+##   CRAFT_MODE
+##     deploy the module (deploy), create change set without executing it
+##     and report changes (diff), request approval of changes (approval),
+##     detect drift of deployed stacks (drift) or destroy stacks of the
+##     module (destroy), default is deploy
+##
+##   CRAFT_DRIFT_DEPLOYMENT
+##     identity of deployment, which stacks are checked by drift mode
+##     (e.g. 123-456-789)
+##
+##   CRAFT_DRIFT_REMEDIATE
+##     mode of deployment (deploy, approval), which corrects drifted stacks
+##     using same module and context. Drifted stacks are not corrected if
+##     it is not defined.
+##     (e.g. deploy)
+##
+##   CRAFT_TARGET_ACCOUNT, CRAFT_TARGET_REGION
+##     target account and region to deploy the module into, exported to
+##     the application as CDK_DEFAULT_ACCOUNT and CDK_DEFAULT_REGION
+##     (e.g. 111111111111, eu-west-1)
+##
+##   CRAFT_TARGET_ROLE
+##     craft-specific role at target account, the job assumes it to deploy
+##     the module instead of AWS CDK bootstrap roles
+##     (e.g. craft-deploy)
+##
+##   CRAFT_MODULE_VERSION
+##     version of the module, the job builds the source code uploaded
+##     under s3://$CRAFT_BUCKET/$CRAFT_MODULE@$CRAFT_MODULE_VERSION
+##     (e.g. v1.2.3)
+##
+##   CRAFT_TENANT
+##     tenant of the deployment, used by the registry of deployments only
+##     (e.g. acme)
+##
+##   CRAFT_ARRAY
+##     S3 key of targets of array job, JSON object per line. The child job
+##     picks the target (tenant, context, account, region, role) at line
+##     AWS_BATCH_JOB_ARRAY_INDEX and runs as $CRAFT_UID-$AWS_BATCH_JOB_ARRAY_INDEX.
+##     CRAFT_CDK_CONTEXT is not required for array job. Targets of
+##     reconciliation and drift detection also define module and version
+##     (drift and remediate for drift detection), CRAFT_MODULE is not
+##     required for them.
+##     (e.g. craft/contexts/123-456-789.jsonl)
+##
+##   CRAFT_DEPENDS
+##     upstream modules of composite deployment, space separated name=uid.
+##     Outputs of upstream are referenced from CRAFT_CDK_CONTEXT as
+##     ${name.Output} or ${name.Stack.Output}
+##     (e.g. network=123-network data=123-data)
+##
+##   CRAFT_COMPOSITE, CRAFT_COMPOSITE_JOBS
+##     identity of composite deployment and space separated identity of
+##     its modules, the monitor cancels pending modules if this one fails
+##     (e.g. 123, 123-network 123-data 123-api)
+##
+##   CRAFT_COALESCED
+##     space separated identity of deployments coalesced into this one by
+##     debounce, used for tracing only
+##     (e.g. 123 456 789)
+##
+##   CRAFT_REPLY_TO
+##     ARN of event bus at the producer's account, events emitted by the job
+##     carry it, the craft delivers them to the bus
+##     (e.g. arn:aws:events:eu-west-1:111111111111:event-bus/app)
+##
+## Artifacts
+##   s3://$CRAFT_BUCKET/craft/contexts/$CRAFT_UID.json
+##     context of AWS CDK application
+##
+##   s3://$CRAFT_BUCKET/craft/logs/$CRAFT_UID.log
+##     output of AWS CDK
+##
+##   s3://$CRAFT_BUCKET/craft/outputs/$CRAFT_UID.json
+##     outputs of deployed stacks (deploy), changes of stacks (diff,
+##     approval) or drifted resources (drift), the job emits summary of
+##     changes as EventCraftDiff or EventApprovalRequested, drift of each
+##     stack as EventDrift
+##
+
+. /bin/craft.sh
+
+##
+## Child of array job picks its target
+if [ -n "${CRAFT_ARRAY:-}" ]
+then
+  INDEX=$AWS_BATCH_JOB_ARRAY_INDEX
+  TARGET=$(aws s3 cp s3://$CRAFT_BUCKET/$CRAFT_ARRAY - | sed -n "$((INDEX + 1))p")
+
+  CRAFT_UID=$CRAFT_UID-$INDEX
+  CRAFT_CDK_CONTEXT=$(echo "$TARGET" | jq -c .context)
+  CRAFT_TENANT=$(echo "$TARGET" | jq -r '.tenant // empty')
+  CRAFT_TARGET_ACCOUNT=$(echo "$TARGET" | jq -r '.account // empty')
+  CRAFT_TARGET_REGION=$(echo "$TARGET" | jq -r '.region // empty')
+  CRAFT_TARGET_ROLE=$(echo "$TARGET" | jq -r '.role // empty')
+
+  ## reconciliation and drift detection deploy any module
+  CRAFT_MODULE=$(echo "$TARGET" | jq -r --arg module "${CRAFT_MODULE:-}" '.module // $module')
+  CRAFT_MODULE_VERSION=$(echo "$TARGET" | jq -r --arg version "${CRAFT_MODULE_VERSION:-}" '.version // $version')
+  CRAFT_DRIFT_DEPLOYMENT=$(echo "$TARGET" | jq -r '.drift // empty')
+  CRAFT_DRIFT_REMEDIATE=$(echo "$TARGET" | jq -r '.remediate // empty')
+fi
+
+##
+## Module of composite deployment resolves references to outputs of upstream
+if [ -n "${CRAFT_DEPENDS:-}" ]
+then
+  REFS='{}'
+  for DEPENDS in $CRAFT_DEPENDS
+  do
+    NAME=${DEPENDS%%=*}
+    OUTPUTS=$(aws s3 cp s3://$CRAFT_BUCKET/craft/outputs/${DEPENDS#*=}.json -)
+    REFS=$(echo "$OUTPUTS" | jq -c --arg name "$NAME" --argjson refs "$REFS" \
+      '$refs + ([to_entries[] | .key as $stack | .value | to_entries[] |
+        {key: "\($name).\(.key)", value}, {key: "\($name).\($stack).\(.key)", value}] | from_entries)')
+  done
+
+  CRAFT_CDK_CONTEXT=$(echo "$CRAFT_CDK_CONTEXT" | jq -c --argjson refs "$REFS" \
+    'walk(if type == "string" then gsub("\\$\\{(?<ref>[^}]+)\\}"; .ref as $ref | $refs[$ref] // error("unresolved reference \($ref)")) else . end)')
+fi
 
 mkdir -p /go/src/$CRAFT_MODULE
 
 cd /go/src/$CRAFT_MODULE
 
-aws s3 cp s3://$CRAFT_BUCKET/$CRAFT_MODULE . --recursive
+SOURCE=$CRAFT_MODULE
+if [ -n "${CRAFT_MODULE_VERSION:-}" ]
+then
+  SOURCE=$CRAFT_MODULE@$CRAFT_MODULE_VERSION
+fi
+
+aws s3 cp s3://$CRAFT_BUCKET/$SOURCE . --recursive
 
 echo $CRAFT_CDK_CONTEXT > cdk.context.json
 
-cdk deploy
+trap craft_artifacts EXIT
+
+##
+## Target environment of the application
+APP=$(jq -r .app cdk.json)
+if [ -n "${CRAFT_TARGET_ACCOUNT:-}" ]
+then
+  APP="export CDK_DEFAULT_ACCOUNT=$CRAFT_TARGET_ACCOUNT; $APP"
+fi
+if [ -n "${CRAFT_TARGET_REGION:-}" ]
+then
+  APP="export CDK_DEFAULT_REGION=$CRAFT_TARGET_REGION; $APP"
+fi
+
+CREDENTIALS=""
+if [ -n "${CRAFT_TARGET_ROLE:-}" ]
+then
+  craft_assume arn:aws:iam::$CRAFT_TARGET_ACCOUNT:role/$CRAFT_TARGET_ROLE
+fi
+
+CHANGESET=$(craft_changeset)
+
+case ${CRAFT_MODE:-deploy} in
+  deploy)
+    env $CREDENTIALS cdk deploy --app "$APP" --outputs-file cdk.outputs.json 2>&1 | tee craft.log
+    ;;
+
+  diff)
+    env $CREDENTIALS cdk deploy --app "$APP" --method=prepare-change-set --change-set-name $CHANGESET 2>&1 | tee craft.log
+    craft_changes $CHANGESET > cdk.outputs.json
+    craft_changes_delete cdk.outputs.json
+    craft_changes_summary cdk.outputs.json > craft.summary.json
+    craft_emit EventCraftDiff craft.summary.json
+    ;;
+
+  approval)
+    env $CREDENTIALS cdk deploy --app "$APP" --method=prepare-change-set --change-set-name $CHANGESET 2>&1 | tee craft.log
+    craft_changes $CHANGESET > cdk.outputs.json
+
+    EXPIRES=$(date -u -d @$(( $(date +%s) + $CRAFT_APPROVAL_TIMEOUT )) +%Y-%m-%dT%H:%M:%S)
+    aws scheduler create-schedule \
+      --name $CHANGESET \
+      --schedule-expression "at($EXPIRES)" \
+      --flexible-time-window Mode=OFF \
+      --action-after-completion DELETE \
+      --target "$(jq -n \
+        --arg bus "$CRAFT_EVENT_BUS_ARN" \
+        --arg role "$CRAFT_SCHEDULER_ROLE" \
+        --arg source "$CRAFT_EVENT_BUS" \
+        --arg input "$(jq -nc --arg uid "$CRAFT_UID" '{uid: $uid, decision: "expire"}')" \
+        '{Arn: $bus, RoleArn: $role, Input: $input, EventBridgeParameters: {DetailType: "EventApproval", Source: $source}}')"
+
+    craft_changes_summary cdk.outputs.json | jq --arg expires "${EXPIRES}Z" '. + {expiresAt: $expires}' > craft.summary.json
+    craft_emit EventApprovalRequested craft.summary.json
+    ;;
+
+  drift)
+    env $CREDENTIALS cdk synth --app "$APP" --quiet 2>&1 | tee craft.log
+    craft_drift > cdk.outputs.json
+    craft_drift_events cdk.outputs.json | while read DRIFT
+    do
+      echo "$DRIFT" > craft.drift.json
+      craft_emit EventDrift craft.drift.json
+    done
+
+    if [ -n "${CRAFT_DRIFT_REMEDIATE:-}" ] && jq -e '.stacks | any(.status == "DRIFTED")' cdk.outputs.json > /dev/null
+    then
+      jq -n \
+        --arg uid "$CRAFT_UID-remediate" \
+        --arg mod "$CRAFT_MODULE" \
+        --arg version "${CRAFT_MODULE_VERSION:-}" \
+        --arg tenant "${CRAFT_TENANT:-}" \
+        --argjson context "$CRAFT_CDK_CONTEXT" \
+        --arg account "${CRAFT_TARGET_ACCOUNT:-}" \
+        --arg region "${CRAFT_TARGET_REGION:-}" \
+        --arg role "${CRAFT_TARGET_ROLE:-}" \
+        --arg mode "$CRAFT_DRIFT_REMEDIATE" \
+        '{uid: $uid, "module": $mod, version: $version, tenant: $tenant, context: $context,
+          account: $account, region: $region, role: $role, mode: $mode}
+        | with_entries(select(.value != ""))' > craft.remediate.json
+      craft_emit EventCraft craft.remediate.json
+    fi
+    ;;
+
+  destroy)
+    env $CREDENTIALS cdk destroy --app "$APP" --force 2>&1 | tee craft.log
+    ;;
+
+  *)
+    echo "unknown mode $CRAFT_MODE"
+    exit 1
+    ;;
+esac
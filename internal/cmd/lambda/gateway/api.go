@@ -0,0 +1,219 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+	"github.com/fogfish/craft/internal/scheduler"
+)
+
+// the internal error is logged, it is not replied to the caller
+var errInternal = errors.New("internal error")
+
+// Submitted deployment
+type Submitted struct {
+	UID string `json:"uid"`
+	Job string `json:"job,omitempty"`
+}
+
+// Deployment status replied to the caller, the context of deployment and
+// destinations of its status are not exposed.
+type Deployment struct {
+	UID     string `json:"uid"`
+	Tenant  string `json:"tenant,omitempty"`
+	Module  string `json:"module,omitempty"`
+	Version string `json:"version,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Job     string `json:"job,omitempty"`
+	Created string `json:"created,omitempty"`
+	Updated string `json:"updated,omitempty"`
+}
+
+// HTTP API of the gateway
+//
+//	POST /deployments submits EventCraft, uid is generated if not defined
+//	GET  /deployments/{uid} returns status of the deployment recorded by the registry
+func (s *Service) API() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /deployments", s.postDeployment)
+	mux.HandleFunc("GET /deployments/{uid}", s.getDeployment)
+	return mux
+}
+
+func (s *Service) postDeployment(w http.ResponseWriter, r *http.Request) {
+	var evt events.EventCraft
+	if err := json.NewDecoder(r.Body).Decode(&evt); err != nil {
+		reply(w, http.StatusBadRequest, failure(err))
+		return
+	}
+
+	if evt.UID == "" {
+		uid, err := newUID()
+		if err != nil {
+			slog.Error("failed to generate uid", "err", err)
+			reply(w, http.StatusInternalServerError, failure(errInternal))
+			return
+		}
+		evt.UID = uid
+	}
+
+	if err := s.scheduler.Validate(evt); err != nil {
+		reply(w, http.StatusBadRequest, failure(err))
+		return
+	}
+
+	job, err := s.scheduler.Submit(evt)
+	switch {
+	case errors.Is(err, scheduler.ErrInvalid):
+		reply(w, http.StatusBadRequest, failure(err))
+		return
+	case err != nil:
+		slog.Error("failed to submit deployment", "uid", evt.UID, "err", err)
+		reply(w, http.StatusInternalServerError, failure(errInternal))
+		return
+	}
+
+	reply(w, http.StatusAccepted, Submitted{UID: evt.UID, Job: job})
+}
+
+func (s *Service) getDeployment(w http.ResponseWriter, r *http.Request) {
+	d, err := s.scheduler.Deployment(r.PathValue("uid"))
+	switch {
+	case errors.Is(err, registry.ErrNotFound):
+		reply(w, http.StatusNotFound, failure(err))
+		return
+	case err != nil:
+		slog.Error("failed to read deployment", "uid", r.PathValue("uid"), "err", err)
+		reply(w, http.StatusInternalServerError, failure(errInternal))
+		return
+	}
+
+	reply(w, http.StatusOK,
+		Deployment{
+			UID:     d.UID,
+			Tenant:  d.Tenant,
+			Module:  d.Module,
+			Version: d.Version,
+			Status:  d.Status,
+			Job:     d.Job,
+			Created: d.Created,
+			Updated: d.Updated,
+		},
+	)
+}
+
+func reply(w http.ResponseWriter, status int, val any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(val); err != nil {
+		slog.Error("failed to encode reply", "err", err)
+	}
+}
+
+func failure(err error) map[string]string {
+	return map[string]string{"error": err.Error()}
+}
+
+func newUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// proxy of AWS API Gateway requests to the handler
+func proxy(h http.Handler) func(context.Context, lambdaevents.APIGatewayProxyRequest) (lambdaevents.APIGatewayProxyResponse, error) {
+	return func(ctx context.Context, req lambdaevents.APIGatewayProxyRequest) (lambdaevents.APIGatewayProxyResponse, error) {
+		query := url.Values{}
+		for key, val := range req.QueryStringParameters {
+			query.Set(key, val)
+		}
+		for key, vals := range req.MultiValueQueryStringParameters {
+			query[key] = vals
+		}
+
+		body := req.Body
+		if req.IsBase64Encoded {
+			b, err := base64.StdEncoding.DecodeString(req.Body)
+			if err != nil {
+				return lambdaevents.APIGatewayProxyResponse{}, err
+			}
+			body = string(b)
+		}
+
+		uri := url.URL{Path: req.Path, RawQuery: query.Encode()}
+		r, err := http.NewRequestWithContext(ctx, req.HTTPMethod, uri.String(), strings.NewReader(body))
+		if err != nil {
+			return lambdaevents.APIGatewayProxyResponse{}, err
+		}
+
+		for key, val := range req.Headers {
+			r.Header.Set(key, val)
+		}
+		for key, vals := range req.MultiValueHeaders {
+			r.Header[http.CanonicalHeaderKey(key)] = vals
+		}
+
+		w := &response{header: http.Header{}}
+		h.ServeHTTP(w, r)
+
+		headers := map[string]string{}
+		for key := range w.header {
+			headers[key] = w.header.Get(key)
+		}
+
+		return lambdaevents.APIGatewayProxyResponse{
+			StatusCode: w.status(),
+			Headers:    headers,
+			Body:       w.body.String(),
+		}, nil
+	}
+}
+
+// response of the handler, it is replied to AWS API Gateway
+type response struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (r *response) Header() http.Header { return r.header }
+
+func (r *response) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+
+func (r *response) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(b)
+}
+
+func (r *response) status() int {
+	if r.code == 0 {
+		return http.StatusOK
+	}
+	return r.code
+}
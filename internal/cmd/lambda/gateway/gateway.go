@@ -12,16 +12,31 @@ import (
 	"context"
 	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/batch"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	awsscheduler "github.com/aws/aws-sdk-go-v2/service/scheduler"
 
+	"github.com/fogfish/craft/internal/debounce"
+	"github.com/fogfish/craft/internal/desired"
 	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/fleet"
+	"github.com/fogfish/craft/internal/ledger"
+	"github.com/fogfish/craft/internal/registry"
 	"github.com/fogfish/craft/internal/scheduler"
+	"github.com/fogfish/craft/internal/sequence"
+	"github.com/fogfish/craft/internal/tenant"
 	_ "github.com/fogfish/logger/v3"
 	"github.com/fogfish/swarm"
 	"github.com/fogfish/swarm/broker/eventbridge"
 	"github.com/fogfish/swarm/dequeue"
+	"github.com/fogfish/swarm/enqueue"
 )
 
 func main() {
@@ -32,16 +47,135 @@ func main() {
 		panic(err)
 	}
 
+	opts := []scheduler.Option{
+		scheduler.WithAccounts(strings.Split(os.Getenv("CONFIG_TRUSTED_ACCOUNTS"), ",")...),
+		scheduler.WithJobBootstrap(os.Getenv("CONFIG_BATCH_JOB_BOOTSTRAP")),
+		scheduler.WithOrganization(os.Getenv("CONFIG_ORGANIZATION_ID")),
+		scheduler.WithBus(os.Getenv("CONFIG_EVENT_BUS_ARN")),
+		scheduler.WithStorage(s3.NewFromConfig(aws)),
+	}
+
+	// Registry of deployments
+	if table := os.Getenv("CONFIG_REGISTRY"); table != "" {
+		opts = append(opts,
+			scheduler.WithRegistry(registry.New(dynamodb.NewFromConfig(aws), table)),
+		)
+	}
+
+	// Rollouts of modules across tenants
+	if table := os.Getenv("CONFIG_FLEET"); table != "" {
+		opts = append(opts,
+			scheduler.WithFleet(fleet.NewStore(dynamodb.NewFromConfig(aws), table)),
+		)
+	}
+
+	// Desired state of tenants
+	if table := os.Getenv("CONFIG_DESIRED"); table != "" {
+		opts = append(opts,
+			scheduler.WithDesired(desired.NewStore(dynamodb.NewFromConfig(aws), table)),
+		)
+	}
+
+	// Lifecycle of tenants
+	if table := os.Getenv("CONFIG_TENANTS"); table != "" {
+		opts = append(opts,
+			scheduler.WithTenants(tenant.NewStore(dynamodb.NewFromConfig(aws), table)),
+		)
+	}
+
+	// Delayed deployments
+	if group := os.Getenv("CONFIG_SCHEDULE_GROUP"); group != "" {
+		opts = append(opts,
+			scheduler.WithTimer(
+				awsscheduler.NewFromConfig(aws),
+				group,
+				os.Getenv("CONFIG_EVENT_BUS_ARN"),
+				os.Getenv("CONFIG_SCHEDULER_ROLE"),
+			),
+		)
+	}
+
+	// Debounce of successive deployments of tenant's module
+	if table := os.Getenv("CONFIG_DEBOUNCE"); table != "" {
+		window, err := strconv.Atoi(os.Getenv("CONFIG_DEBOUNCE_WINDOW"))
+		if err != nil {
+			slog.Error("fatal failure of debounce config", "err", err)
+			panic(err)
+		}
+
+		if window > 0 {
+			opts = append(opts,
+				scheduler.WithDebounce(
+					debounce.NewStore(dynamodb.NewFromConfig(aws), table),
+					time.Duration(window)*time.Second,
+				),
+			)
+		}
+	}
+
+	if table := os.Getenv("CONFIG_SEQUENCE"); table != "" {
+		opts = append(opts,
+			scheduler.WithSequence(sequence.NewStore(dynamodb.NewFromConfig(aws), table)),
+		)
+	}
+
 	// AWS Batch Job Scheduler
 	scheduler := scheduler.New(
 		batch.NewFromConfig(aws),
 		os.Getenv("CONFIG_BATCH_QUEUE"),
 		os.Getenv("CONFIG_BATCH_JOB_CRAFT"),
 		os.Getenv("CONFIG_S3"),
+		opts...,
 	)
 
+	// Progress of rollouts, delayed deployments and transitions of tenants are emitted to the craft's bus
+	bus := os.Getenv("CONFIG_EVENT_BUS")
+	e, err := eventbridge.NewEnqueuer(bus,
+		eventbridge.WithConfig(
+			swarm.WithSource(bus),
+			swarm.WithLogStdErr(),
+		),
+	)
+	if err != nil {
+		slog.Error("fatal failure of eventbrige client", "err", err)
+		panic(err)
+	}
+
+	// Replayed events are processed once by uid
+	ledgers := []Option{}
+	if table := os.Getenv("CONFIG_LEDGER"); table != "" {
+		ttl, err := strconv.Atoi(os.Getenv("CONFIG_LEDGER_TTL"))
+		if err != nil {
+			slog.Error("fatal failure of ledger config", "err", err)
+			panic(err)
+		}
+
+		ledgers = append(ledgers,
+			WithLedger(ledger.NewStore(dynamodb.NewFromConfig(aws), table, time.Duration(ttl)*time.Second)),
+		)
+	}
+
 	// Run event consumption loop
-	service := New(scheduler)
+	service := New(scheduler,
+		enqueue.NewTyped[events.EventRolloutProgress](e),
+		enqueue.NewTyped[events.EventSchedules](e),
+		enqueue.NewTyped[events.EventTenantTransition](e),
+		ledgers...,
+	)
+
+	// The gateway serves HTTP API if it is deployed behind AWS API Gateway
+	if os.Getenv("CONFIG_API") != "" {
+		lambda.Start(proxy(service.API()))
+		return
+	}
+
+	// The gateway consumes EventCraft from AWS SQS if it is deployed as inbox
+	if os.Getenv("CONFIG_INBOX") != "" {
+		q := newInbox(swarm.WithLogStdErr())
+		go service.Run(dequeue.Typed[events.EventCraft](q))
+		q.Await()
+		return
+	}
 
 	q, err := eventbridge.NewDequeuer("default",
 		eventbridge.WithConfig(
@@ -54,6 +188,23 @@ func main() {
 	}
 
 	go service.Run(dequeue.Typed[events.EventCraft](q))
+	go service.RunArray(dequeue.Typed[events.EventCraftArray](q))
+	go service.RunComposite(dequeue.Typed[events.EventComposite](q))
+	go service.RunBootstrap(dequeue.Typed[events.EventBootstrap](q))
+	go service.RunApproval(dequeue.Typed[events.EventApproval](q))
+	go service.RunRollback(dequeue.Typed[events.EventRollback](q))
+	go service.RunRollout(dequeue.Typed[events.EventRollout](q))
+	go service.RunRolloutControl(dequeue.Typed[events.EventRolloutControl](q))
+	go service.RunDeployment(dequeue.Typed[events.EventDeployment](q))
+	go service.RunDriftDetection(dequeue.Typed[events.EventDriftDetection](q))
+	go service.RunReconcile(dequeue.Typed[events.EventReconcile](q))
+	go service.RunScheduleCancel(dequeue.Typed[events.EventScheduleCancel](q))
+	go service.RunScheduleList(dequeue.Typed[events.EventScheduleList](q))
+	go service.RunDebounce(dequeue.Typed[events.EventDebounce](q))
+	go service.RunTenantProvision(dequeue.Typed[events.EventTenantProvision](q))
+	go service.RunTenantSuspend(dequeue.Typed[events.EventTenantSuspend](q))
+	go service.RunTenantResume(dequeue.Typed[events.EventTenantResume](q))
+	go service.RunTenantDeprovision(dequeue.Typed[events.EventTenantDeprovision](q))
 
 	q.Await()
 }
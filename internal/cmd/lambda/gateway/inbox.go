@@ -0,0 +1,156 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/swarm"
+	"github.com/fogfish/swarm/kernel"
+)
+
+// inbox bridges AWS Lambda consuming AWS SQS to the swarm kernel, the kernel
+// decodes and routes messages. Producers send EventCraft as the message body.
+// The batch reports failed messages only, they are redelivered and moved to
+// dead-letter queue, other messages of the batch are deleted.
+type inbox struct {
+	sync.Mutex
+	timeToFlight time.Duration
+	ch           chan []swarm.Bag
+	session      chan struct{}
+	inflight     map[string]struct{}
+	failed       []string
+	start        func(handler any)
+}
+
+func newInbox(opts ...swarm.Option) *kernel.Dequeuer {
+	conf := swarm.NewConfig()
+	for _, opt := range opts {
+		opt(&conf)
+	}
+
+	bridge := newBridge(conf.TimeToFlight, func(handler any) { lambda.Start(handler) })
+
+	return kernel.NewDequeuer(bridge, conf)
+}
+
+func newBridge(timeToFlight time.Duration, start func(handler any)) *inbox {
+	return &inbox{
+		timeToFlight: timeToFlight,
+		ch:           make(chan []swarm.Bag),
+		session:      make(chan struct{}, 1),
+		start:        start,
+	}
+}
+
+func (s *inbox) Run() { s.start(s.run) }
+
+func (s *inbox) run(evt lambdaevents.SQSEvent) (lambdaevents.SQSEventResponse, error) {
+	s.Lock()
+	s.inflight = map[string]struct{}{}
+	s.failed = make([]string, 0)
+
+	// malformed message would stop routing of the batch by the kernel
+	bag := make([]swarm.Bag, 0, len(evt.Records))
+	for _, msg := range evt.Records {
+		var craft events.EventCraft
+		if err := json.Unmarshal([]byte(msg.Body), &craft); err != nil {
+			slog.Error("invalid event format", "id", msg.MessageId, "err", err)
+			s.failed = append(s.failed, msg.MessageId)
+			continue
+		}
+
+		s.inflight[msg.MessageId] = struct{}{}
+		bag = append(bag,
+			swarm.Bag{
+				Category: swarm.TypeOf[events.EventCraft](),
+				Digest:   msg.MessageId,
+				Object:   []byte(msg.Body),
+			},
+		)
+	}
+	s.Unlock()
+
+	if len(bag) != 0 {
+		s.ch <- bag
+
+		select {
+		case <-s.session:
+		case <-time.After(s.timeToFlight):
+			slog.Error("batch is timed out", "size", len(bag))
+		}
+	}
+
+	// messages, which are not acknowledged in time, are failed
+	s.Lock()
+	defer s.Unlock()
+
+	failures := make([]lambdaevents.SQSBatchItemFailure, 0, len(s.failed)+len(s.inflight))
+	for _, id := range s.failed {
+		failures = append(failures, lambdaevents.SQSBatchItemFailure{ItemIdentifier: id})
+	}
+	for id := range s.inflight {
+		failures = append(failures, lambdaevents.SQSBatchItemFailure{ItemIdentifier: id})
+	}
+	s.inflight = map[string]struct{}{}
+
+	return lambdaevents.SQSEventResponse{BatchItemFailures: failures}, nil
+}
+
+// Ask returns the batch of messages to the kernel
+func (s *inbox) Ask(ctx context.Context) ([]swarm.Bag, error) {
+	select {
+	case <-ctx.Done():
+		return nil, nil
+	case bag := <-s.ch:
+		return bag, nil
+	}
+}
+
+// Ack processed message, it is deleted from the queue
+func (s *inbox) Ack(ctx context.Context, digest string) error {
+	s.settle(digest, false)
+	return nil
+}
+
+// Err of message processing, the message is reported as failed
+func (s *inbox) Err(ctx context.Context, digest string, err error) error {
+	slog.Error("failed to consume message", "id", digest, "err", err)
+	s.settle(digest, true)
+	return nil
+}
+
+func (s *inbox) settle(digest string, failed bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, has := s.inflight[digest]; !has {
+		return
+	}
+
+	delete(s.inflight, digest)
+	if failed {
+		s.failed = append(s.failed, digest)
+	}
+
+	if len(s.inflight) == 0 {
+		select {
+		case s.session <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -9,30 +9,173 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 
 	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
 	"github.com/fogfish/swarm"
 )
 
 type Scheduler interface {
 	Schedule(evt events.EventCraft) error
+	Submit(evt events.EventCraft) (string, error)
+	Validate(evt events.EventCraft) error
+	Deployment(uid string) (*registry.Deployment, error)
+	ScheduleArray(evt events.EventCraftArray) error
+	ScheduleComposite(evt events.EventComposite) error
+	ScheduleBootstrap(evt events.EventBootstrap) error
+	ScheduleApproval(evt events.EventApproval) error
+	Rollback(evt events.EventRollback) error
+	Rollout(evt events.EventRollout) (*events.EventRolloutProgress, error)
+	RolloutControl(evt events.EventRolloutControl) (*events.EventRolloutProgress, error)
+	RolloutDeployment(evt events.EventDeployment) (*events.EventRolloutProgress, error)
+	DetectDrift(evt events.EventDriftDetection) error
+	Reconcile(evt events.EventReconcile) error
+	ScheduleCancel(evt events.EventScheduleCancel) error
+	ScheduleList(evt events.EventScheduleList) (*events.EventSchedules, error)
+	Debounce(evt events.EventDebounce) error
+	Provision(evt events.EventTenantProvision) ([]events.EventTenantTransition, error)
+	Suspend(evt events.EventTenantSuspend) ([]events.EventTenantTransition, error)
+	Resume(evt events.EventTenantResume) ([]events.EventTenantTransition, error)
+	Deprovision(evt events.EventTenantDeprovision) ([]events.EventTenantTransition, error)
+	TenantDeployment(evt events.EventDeployment) ([]events.EventTenantTransition, error)
+}
+
+type Emitter[T any] interface {
+	Enq(ctx context.Context, evt T, cat ...string) error
+}
+
+// Ledger of processed events, the event is identified by category and uid
+type Ledger interface {
+	Has(ctx context.Context, category, uid string) (bool, error)
+	Put(ctx context.Context, category, uid string) error
 }
 
 type Service struct {
 	scheduler Scheduler
+	rollouts  Emitter[events.EventRolloutProgress]
+	schedules Emitter[events.EventSchedules]
+	tenants   Emitter[events.EventTenantTransition]
+	ledger    Ledger
+}
+
+type Option func(*Service)
+
+// WithLedger makes processing of events idempotent by uid, the processed
+// event is skipped when it is delivered again (e.g. replay of the archive).
+// EventCraft is deduplicated by the registry.
+func WithLedger(ledger Ledger) Option {
+	return func(s *Service) {
+		s.ledger = ledger
+	}
 }
 
-func New(scheduler Scheduler) *Service {
-	return &Service{
+func New(
+	scheduler Scheduler,
+	rollouts Emitter[events.EventRolloutProgress],
+	schedules Emitter[events.EventSchedules],
+	tenants Emitter[events.EventTenantTransition],
+	opts ...Option,
+) *Service {
+	s := &Service{
 		scheduler: scheduler,
+		rollouts:  rollouts,
+		schedules: schedules,
+		tenants:   tenants,
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
 func (s *Service) Run(rcv <-chan swarm.Msg[events.EventCraft], ack chan<- swarm.Msg[events.EventCraft]) {
+	consume(rcv, ack, s.onEvtCraft)
+}
+
+func (s *Service) RunArray(rcv <-chan swarm.Msg[events.EventCraftArray], ack chan<- swarm.Msg[events.EventCraftArray]) {
+	consume(rcv, ack, once(s.ledger, func(evt events.EventCraftArray) string { return evt.UID }, s.onEvtCraftArray))
+}
+
+func (s *Service) RunComposite(rcv <-chan swarm.Msg[events.EventComposite], ack chan<- swarm.Msg[events.EventComposite]) {
+	consume(rcv, ack, once(s.ledger, func(evt events.EventComposite) string { return evt.UID }, s.onEvtComposite))
+}
+
+func (s *Service) RunBootstrap(rcv <-chan swarm.Msg[events.EventBootstrap], ack chan<- swarm.Msg[events.EventBootstrap]) {
+	consume(rcv, ack, once(s.ledger, func(evt events.EventBootstrap) string { return evt.UID }, s.onEvtBootstrap))
+}
+
+func (s *Service) RunApproval(rcv <-chan swarm.Msg[events.EventApproval], ack chan<- swarm.Msg[events.EventApproval]) {
+	consume(rcv, ack, once(s.ledger, func(evt events.EventApproval) string { return evt.UID + " " + evt.Decision }, s.onEvtApproval))
+}
+
+func (s *Service) RunRollback(rcv <-chan swarm.Msg[events.EventRollback], ack chan<- swarm.Msg[events.EventRollback]) {
+	consume(rcv, ack, once(s.ledger, func(evt events.EventRollback) string { return evt.UID }, s.onEvtRollback))
+}
+
+func (s *Service) RunRollout(rcv <-chan swarm.Msg[events.EventRollout], ack chan<- swarm.Msg[events.EventRollout]) {
+	consume(rcv, ack, once(s.ledger, func(evt events.EventRollout) string { return evt.UID }, s.onEvtRollout))
+}
+
+func (s *Service) RunRolloutControl(rcv <-chan swarm.Msg[events.EventRolloutControl], ack chan<- swarm.Msg[events.EventRolloutControl]) {
+	// actions share the uid of rollout, they are applied by its state
+	consume(rcv, ack, s.onEvtRolloutControl)
+}
+
+func (s *Service) RunDeployment(rcv <-chan swarm.Msg[events.EventDeployment], ack chan<- swarm.Msg[events.EventDeployment]) {
+	// status of the deployment is unique per job, the deployment is retried
+	// using same uid
+	consume(rcv, ack, once(s.ledger, func(evt events.EventDeployment) string {
+		if evt.Job == "" {
+			return ""
+		}
+		return evt.UID + " " + evt.Job + " " + evt.Status
+	}, s.onEvtDeployment))
+}
+
+func (s *Service) RunDriftDetection(rcv <-chan swarm.Msg[events.EventDriftDetection], ack chan<- swarm.Msg[events.EventDriftDetection]) {
+	consume(rcv, ack, once(s.ledger, func(evt events.EventDriftDetection) string { return evt.UID }, s.onEvtDriftDetection))
+}
+
+func (s *Service) RunReconcile(rcv <-chan swarm.Msg[events.EventReconcile], ack chan<- swarm.Msg[events.EventReconcile]) {
+	consume(rcv, ack, once(s.ledger, func(evt events.EventReconcile) string { return evt.UID }, s.onEvtReconcile))
+}
+
+func (s *Service) RunScheduleCancel(rcv <-chan swarm.Msg[events.EventScheduleCancel], ack chan<- swarm.Msg[events.EventScheduleCancel]) {
+	consume(rcv, ack, once(s.ledger, func(evt events.EventScheduleCancel) string { return evt.UID }, s.onEvtScheduleCancel))
+}
+
+func (s *Service) RunScheduleList(rcv <-chan swarm.Msg[events.EventScheduleList], ack chan<- swarm.Msg[events.EventScheduleList]) {
+	consume(rcv, ack, once(s.ledger, func(evt events.EventScheduleList) string { return evt.UID }, s.onEvtScheduleList))
+}
+
+func (s *Service) RunDebounce(rcv <-chan swarm.Msg[events.EventDebounce], ack chan<- swarm.Msg[events.EventDebounce]) {
+	consume(rcv, ack, once(s.ledger, func(evt events.EventDebounce) string { return evt.UID }, s.onEvtDebounce))
+}
+
+func (s *Service) RunTenantProvision(rcv <-chan swarm.Msg[events.EventTenantProvision], ack chan<- swarm.Msg[events.EventTenantProvision]) {
+	consume(rcv, ack, once(s.ledger, func(evt events.EventTenantProvision) string { return evt.UID }, s.onEvtTenantProvision))
+}
+
+func (s *Service) RunTenantSuspend(rcv <-chan swarm.Msg[events.EventTenantSuspend], ack chan<- swarm.Msg[events.EventTenantSuspend]) {
+	consume(rcv, ack, once(s.ledger, func(evt events.EventTenantSuspend) string { return evt.UID }, s.onEvtTenantSuspend))
+}
+
+func (s *Service) RunTenantResume(rcv <-chan swarm.Msg[events.EventTenantResume], ack chan<- swarm.Msg[events.EventTenantResume]) {
+	consume(rcv, ack, once(s.ledger, func(evt events.EventTenantResume) string { return evt.UID }, s.onEvtTenantResume))
+}
+
+func (s *Service) RunTenantDeprovision(rcv <-chan swarm.Msg[events.EventTenantDeprovision], ack chan<- swarm.Msg[events.EventTenantDeprovision]) {
+	consume(rcv, ack, once(s.ledger, func(evt events.EventTenantDeprovision) string { return evt.UID }, s.onEvtTenantDeprovision))
+}
+
+func consume[T any](rcv <-chan swarm.Msg[T], ack chan<- swarm.Msg[T], f func(T) error) {
 	for msg := range rcv {
-		if err := s.onEvtCraft(msg.Object); err != nil {
+		if err := f(msg.Object); err != nil {
 			ack <- msg.Fail(err)
 			continue
 		}
@@ -41,16 +184,342 @@ func (s *Service) Run(rcv <-chan swarm.Msg[events.EventCraft], ack chan<- swarm.
 	}
 }
 
+// once processes the event only once by its key, the key is recorded at the
+// ledger after the event is processed. The event without key is processed.
+func once[T any](ledger Ledger, key func(T) string, f func(T) error) func(T) error {
+	if ledger == nil {
+		return f
+	}
+
+	category := swarm.TypeOf[T]()
+	return func(evt T) error {
+		k := key(evt)
+		if k == "" {
+			return f(evt)
+		}
+
+		has, err := ledger.Has(context.Background(), category, k)
+		if err != nil {
+			slog.Error("failed to read ledger", "category", category, "key", k, "err", err)
+			return err
+		}
+
+		if has {
+			slog.Warn("event duplicate", "category", category, "key", k)
+			return nil
+		}
+
+		if err := f(evt); err != nil {
+			return err
+		}
+
+		// the event is processed, it is not failed if the record fails
+		if err := ledger.Put(context.Background(), category, k); err != nil {
+			slog.Error("failed to record event", "category", category, "key", k, "err", err)
+		}
+
+		return nil
+	}
+}
+
 func (s *Service) onEvtCraft(evt events.EventCraft) error {
-	if evt.UID == "" || evt.Module == "" || evt.Context == nil {
+	if err := s.scheduler.Validate(evt); err != nil {
+		slog.Error("invalid event format", "evt", evt, "err", err)
+		return err
+	}
+
+	if err := s.scheduler.Schedule(evt); err != nil {
+		slog.Error("failed to schedule event", "evt", evt, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) onEvtCraftArray(evt events.EventCraftArray) error {
+	if evt.UID == "" || evt.Module == "" || len(evt.Targets) == 0 {
+		slog.Error("invalid event format", "uid", evt.UID, "module", evt.Module)
+		return fmt.Errorf("invalid event format")
+	}
+
+	if err := s.scheduler.ScheduleArray(evt); err != nil {
+		slog.Error("failed to schedule event", "uid", evt.UID, "module", evt.Module, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) onEvtComposite(evt events.EventComposite) error {
+	if evt.UID == "" || len(evt.Modules) == 0 {
 		slog.Error("invalid event format", "evt", evt)
 		return fmt.Errorf("invalid event format")
 	}
 
-	if err := s.scheduler.Schedule(evt); err != nil {
+	if err := s.scheduler.ScheduleComposite(evt); err != nil {
+		slog.Error("failed to schedule event", "evt", evt, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) onEvtBootstrap(evt events.EventBootstrap) error {
+	if evt.UID == "" || evt.Account == "" {
+		slog.Error("invalid event format", "evt", evt)
+		return fmt.Errorf("invalid event format")
+	}
+
+	if err := s.scheduler.ScheduleBootstrap(evt); err != nil {
+		slog.Error("failed to schedule event", "evt", evt, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) onEvtApproval(evt events.EventApproval) error {
+	if evt.UID == "" || evt.Decision == "" {
+		slog.Error("invalid event format", "evt", evt)
+		return fmt.Errorf("invalid event format")
+	}
+
+	if err := s.scheduler.ScheduleApproval(evt); err != nil {
+		slog.Error("failed to schedule event", "evt", evt, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) onEvtRollback(evt events.EventRollback) error {
+	if evt.UID == "" || (evt.Tenant == "" && evt.Reverts == "") {
+		slog.Error("invalid event format", "evt", evt)
+		return fmt.Errorf("invalid event format")
+	}
+
+	if err := s.scheduler.Rollback(evt); err != nil {
+		slog.Error("failed to schedule event", "evt", evt, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) onEvtRollout(evt events.EventRollout) error {
+	if evt.UID == "" || evt.Module == "" || evt.Version == "" {
+		slog.Error("invalid event format", "evt", evt)
+		return fmt.Errorf("invalid event format")
+	}
+
+	progress, err := s.scheduler.Rollout(evt)
+	if err != nil {
 		slog.Error("failed to schedule event", "evt", evt, "err", err)
 		return err
 	}
 
+	return s.progress(progress)
+}
+
+func (s *Service) onEvtRolloutControl(evt events.EventRolloutControl) error {
+	if evt.UID == "" || evt.Action == "" {
+		slog.Error("invalid event format", "evt", evt)
+		return fmt.Errorf("invalid event format")
+	}
+
+	progress, err := s.scheduler.RolloutControl(evt)
+	if err != nil {
+		slog.Error("failed to schedule event", "evt", evt, "err", err)
+		return err
+	}
+
+	return s.progress(progress)
+}
+
+// deployments out of rollouts and tenant transitions are not relevant
+// for the gateway
+func (s *Service) onEvtDeployment(evt events.EventDeployment) error {
+	if evt.Rollout != "" {
+		progress, err := s.scheduler.RolloutDeployment(evt)
+		if err != nil {
+			slog.Error("failed to schedule event", "evt", evt, "err", err)
+			return err
+		}
+
+		if err := s.progress(progress); err != nil {
+			return err
+		}
+	}
+
+	if evt.Lifecycle != "" {
+		seq, err := s.scheduler.TenantDeployment(evt)
+		if err != nil {
+			slog.Error("failed to transit tenant", "evt", evt, "err", err)
+			return err
+		}
+
+		return s.transitions(seq)
+	}
+
+	return nil
+}
+
+func (s *Service) progress(evt *events.EventRolloutProgress) error {
+	if evt == nil {
+		return nil
+	}
+
+	if err := s.rollouts.Enq(context.Background(), *evt); err != nil {
+		slog.Error("failed to emit progress", "uid", evt.UID, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) onEvtDriftDetection(evt events.EventDriftDetection) error {
+	if evt.UID == "" {
+		slog.Error("invalid event format", "evt", evt)
+		return fmt.Errorf("invalid event format")
+	}
+
+	if err := s.scheduler.DetectDrift(evt); err != nil {
+		slog.Error("failed to schedule event", "evt", evt, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) onEvtReconcile(evt events.EventReconcile) error {
+	if evt.UID == "" {
+		slog.Error("invalid event format", "evt", evt)
+		return fmt.Errorf("invalid event format")
+	}
+
+	if err := s.scheduler.Reconcile(evt); err != nil {
+		slog.Error("failed to schedule event", "evt", evt, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) onEvtScheduleCancel(evt events.EventScheduleCancel) error {
+	if evt.UID == "" {
+		slog.Error("invalid event format", "evt", evt)
+		return fmt.Errorf("invalid event format")
+	}
+
+	if err := s.scheduler.ScheduleCancel(evt); err != nil {
+		slog.Error("failed to cancel schedule", "evt", evt, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) onEvtScheduleList(evt events.EventScheduleList) error {
+	if evt.UID == "" {
+		slog.Error("invalid event format", "evt", evt)
+		return fmt.Errorf("invalid event format")
+	}
+
+	schedules, err := s.scheduler.ScheduleList(evt)
+	if err != nil {
+		slog.Error("failed to list schedules", "evt", evt, "err", err)
+		return err
+	}
+
+	if err := s.schedules.Enq(context.Background(), *schedules); err != nil {
+		slog.Error("failed to emit schedules", "uid", evt.UID, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) onEvtDebounce(evt events.EventDebounce) error {
+	if evt.UID == "" || evt.Tenant == "" || evt.Module == "" {
+		slog.Error("invalid event format", "evt", evt)
+		return fmt.Errorf("invalid event format")
+	}
+
+	if err := s.scheduler.Debounce(evt); err != nil {
+		slog.Error("failed to schedule event", "evt", evt, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) onEvtTenantProvision(evt events.EventTenantProvision) error {
+	if evt.UID == "" || evt.Tenant == "" || evt.Module == "" || evt.Context == nil {
+		slog.Error("invalid event format", "evt", evt)
+		return fmt.Errorf("invalid event format")
+	}
+
+	seq, err := s.scheduler.Provision(evt)
+	if err != nil {
+		slog.Error("failed to transit tenant", "evt", evt, "err", err)
+		return err
+	}
+
+	return s.transitions(seq)
+}
+
+func (s *Service) onEvtTenantSuspend(evt events.EventTenantSuspend) error {
+	if evt.UID == "" || evt.Tenant == "" {
+		slog.Error("invalid event format", "evt", evt)
+		return fmt.Errorf("invalid event format")
+	}
+
+	seq, err := s.scheduler.Suspend(evt)
+	if err != nil {
+		slog.Error("failed to transit tenant", "evt", evt, "err", err)
+		return err
+	}
+
+	return s.transitions(seq)
+}
+
+func (s *Service) onEvtTenantResume(evt events.EventTenantResume) error {
+	if evt.UID == "" || evt.Tenant == "" {
+		slog.Error("invalid event format", "evt", evt)
+		return fmt.Errorf("invalid event format")
+	}
+
+	seq, err := s.scheduler.Resume(evt)
+	if err != nil {
+		slog.Error("failed to transit tenant", "evt", evt, "err", err)
+		return err
+	}
+
+	return s.transitions(seq)
+}
+
+func (s *Service) onEvtTenantDeprovision(evt events.EventTenantDeprovision) error {
+	if evt.UID == "" || evt.Tenant == "" {
+		slog.Error("invalid event format", "evt", evt)
+		return fmt.Errorf("invalid event format")
+	}
+
+	seq, err := s.scheduler.Deprovision(evt)
+	if err != nil {
+		slog.Error("failed to transit tenant", "evt", evt, "err", err)
+		return err
+	}
+
+	return s.transitions(seq)
+}
+
+func (s *Service) transitions(seq []events.EventTenantTransition) error {
+	for _, evt := range seq {
+		if err := s.tenants.Enq(context.Background(), evt); err != nil {
+			slog.Error("failed to emit transition", "tenant", evt.Tenant, "err", err)
+			return err
+		}
+	}
+
 	return nil
 }
@@ -10,16 +10,38 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	lambdaevents "github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/batch"
 	"github.com/aws/aws-sdk-go-v2/service/batch/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	awsscheduler "github.com/aws/aws-sdk-go-v2/service/scheduler"
+	schedtypes "github.com/aws/aws-sdk-go-v2/service/scheduler/types"
+	"github.com/fogfish/craft/internal/debounce"
+	"github.com/fogfish/craft/internal/desired"
 	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/fleet"
+	"github.com/fogfish/craft/internal/registry"
 	"github.com/fogfish/craft/internal/scheduler"
+	"github.com/fogfish/craft/internal/sequence"
+	"github.com/fogfish/craft/internal/tenant"
 	"github.com/fogfish/it/v2"
 	"github.com/fogfish/swarm"
+	"github.com/fogfish/swarm/dequeue"
+	"github.com/fogfish/swarm/kernel"
 )
 
 var (
@@ -35,8 +57,59 @@ var (
 		Context: []byte(`{"acc": "test"}`),
 	}
 
+	eventCrossAccount = events.EventCraft{
+		UID:     "123-456-789",
+		Module:  "github.com/fogfish/craft",
+		Context: []byte(`{"acc": "test"}`),
+		Account: "111111111111",
+		Region:  "eu-west-1",
+		Role:    "craft-deploy",
+	}
+
+	eventUntrustedAccount = events.EventCraft{
+		UID:     "123-456-789",
+		Module:  "github.com/fogfish/craft",
+		Context: []byte(`{"acc": "test"}`),
+		Account: "999999999999",
+	}
+
+	eventRoleWithoutAccount = events.EventCraft{
+		UID:     "123-456-789",
+		Module:  "github.com/fogfish/craft",
+		Context: []byte(`{"acc": "test"}`),
+		Role:    "craft-deploy",
+	}
+
+	eventDiff = events.EventCraft{
+		UID:     "123-456-789",
+		Module:  "github.com/fogfish/craft",
+		Context: []byte(`{"acc": "test"}`),
+		Mode:    events.MODE_DIFF,
+	}
+
+	eventReplyTo = events.EventCraft{
+		UID:     "123-456-789",
+		Module:  "github.com/fogfish/craft",
+		Context: []byte(`{"acc": "test"}`),
+		ReplyTo: "arn:aws:events:eu-west-1:111111111111:event-bus/app",
+	}
+
+	eventUnknownMode = events.EventCraft{
+		UID:     "123-456-789",
+		Module:  "github.com/fogfish/craft",
+		Context: []byte(`{"acc": "test"}`),
+		Mode:    "unknown",
+	}
+
 	eventUndefined = events.EventCraft{}
 
+	eventBootstrap = events.EventBootstrap{
+		UID:       "123-456-789",
+		Account:   "111111111111",
+		Qualifier: "test",
+		Trust:     []string{"222222222222", "333333333333"},
+	}
+
 	eventWrongType = events.EventCraft{
 		Context: []byte(`{"acc": "test"}`),
 	}
@@ -72,10 +145,110 @@ func TestSubmitJobFailed(t *testing.T) {
 	it.Then(t).ShouldNot(it.Nil(msg.Error))
 }
 
-func TestCorruptedEvents(t *testing.T) {
+func TestSubmitJobCrossAccount(t *testing.T) {
+	service := mockService(
+		types.KeyValuePair{Name: aws.String("CRAFT_TARGET_ACCOUNT"), Value: aws.String("111111111111")},
+		types.KeyValuePair{Name: aws.String("CRAFT_TARGET_REGION"), Value: aws.String("eu-west-1")},
+		types.KeyValuePair{Name: aws.String("CRAFT_TARGET_ROLE"), Value: aws.String("craft-deploy")},
+	)
+
+	rcv := make(chan swarm.Msg[events.EventCraft])
+	ack := make(chan swarm.Msg[events.EventCraft])
+	go service.Run(rcv, ack)
+
+	rcv <- swarm.Msg[events.EventCraft]{
+		Category: "test",
+		Object:   eventCrossAccount,
+	}
+	msg := <-ack
+	it.Then(t).Should(it.Nil(msg.Error))
+}
+
+func TestSubmitJobDiff(t *testing.T) {
+	service := mockService(
+		types.KeyValuePair{Name: aws.String("CRAFT_MODE"), Value: aws.String(events.MODE_DIFF)},
+	)
+
+	rcv := make(chan swarm.Msg[events.EventCraft])
+	ack := make(chan swarm.Msg[events.EventCraft])
+	go service.Run(rcv, ack)
+
+	rcv <- swarm.Msg[events.EventCraft]{
+		Category: "test",
+		Object:   eventDiff,
+	}
+	msg := <-ack
+	it.Then(t).Should(it.Nil(msg.Error))
+}
+
+func TestSubmitJobReplyTo(t *testing.T) {
+	for name, tt := range map[string]struct {
+		replyTo string
+		opts    []scheduler.Option
+	}{
+		"TrustedAccount": {
+			replyTo: "arn:aws:events:eu-west-1:111111111111:event-bus/app",
+		},
+		"ForeignAccount": {
+			replyTo: "arn:aws:events:eu-west-1:222222222222:event-bus/app",
+			opts:    []scheduler.Option{scheduler.WithOrganization("o-test")},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			service := mockServiceWith(
+				append(tt.opts, scheduler.WithBus("arn:aws:events:eu-west-1:111111111111:event-bus/craft")),
+				types.KeyValuePair{Name: aws.String("CRAFT_REPLY_TO"), Value: aws.String(tt.replyTo)},
+			)
+
+			evt := eventReplyTo
+			evt.ReplyTo = tt.replyTo
+
+			rcv := make(chan swarm.Msg[events.EventCraft])
+			ack := make(chan swarm.Msg[events.EventCraft])
+			go service.Run(rcv, ack)
+
+			rcv <- swarm.Msg[events.EventCraft]{
+				Category: "test",
+				Object:   evt,
+			}
+			msg := <-ack
+			it.Then(t).Should(it.Nil(msg.Error))
+		})
+	}
+}
+
+func TestSubmitJobReplyToInvalid(t *testing.T) {
+	for name, replyTo := range map[string]string{
+		"CraftBus":           "arn:aws:events:eu-west-1:111111111111:event-bus/craft",
+		"Malformed":          "arn:aws:sqs:eu-west-1:111111111111:app",
+		"UnreachableAccount": "arn:aws:events:eu-west-1:222222222222:event-bus/app",
+	} {
+		t.Run(name, func(t *testing.T) {
+			service := mockServiceWith(
+				[]scheduler.Option{scheduler.WithBus("arn:aws:events:eu-west-1:111111111111:event-bus/craft")},
+			)
+
+			evt := eventReplyTo
+			evt.ReplyTo = replyTo
+
+			rcv := make(chan swarm.Msg[events.EventCraft])
+			ack := make(chan swarm.Msg[events.EventCraft])
+			go service.Run(rcv, ack)
+
+			rcv <- swarm.Msg[events.EventCraft]{
+				Category: "test",
+				Object:   evt,
+			}
+			msg := <-ack
+			it.Then(t).ShouldNot(it.Nil(msg.Error))
+		})
+	}
+}
+
+func TestSubmitJobUntrusted(t *testing.T) {
 	for name, evt := range map[string]events.EventCraft{
-		"Undefined": eventUndefined,
-		"WrongType": eventWrongType,
+		"UntrustedAccount":   eventUntrustedAccount,
+		"RoleWithoutAccount": eventRoleWithoutAccount,
 	} {
 		t.Run(name, func(t *testing.T) {
 			service := mockService()
@@ -94,53 +267,2330 @@ func TestCorruptedEvents(t *testing.T) {
 	}
 }
 
-//------------------------------------------------------------------------------
+func TestSubmitJobInbox(t *testing.T) {
+	jobs := &mockJobs{}
+	service := New(
+		scheduler.New(jobs, "test-queue", "test-job", "test-s3",
+			scheduler.WithAccounts("111111111111"),
+		),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+
+	bridge := newBridge(time.Second, func(any) {})
+	q := kernel.NewDequeuer(bridge, swarm.NewConfig())
+	go service.Run(dequeue.Typed[events.EventCraft](q))
+	go q.Await()
+	defer q.Close()
+
+	// only failed messages of the batch are reported
+	val, err := bridge.run(lambdaevents.SQSEvent{
+		Records: []lambdaevents.SQSMessage{
+			{MessageId: "ok", Body: `{"uid":"123-456-789","module":"github.com/fogfish/craft","context":{"acc": "test"}}`},
+			{MessageId: "untrusted", Body: `{"uid":"123-456-789","module":"github.com/fogfish/craft","context":{"acc": "test"},"account":"999999999999"}`},
+			{MessageId: "malformed", Body: `not json`},
+		},
+	})
+
+	failed := make([]string, 0)
+	for _, f := range val.BatchItemFailures {
+		failed = append(failed, f.ItemIdentifier)
+	}
+	slices.Sort(failed)
+
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Seq(failed).Equal("malformed", "untrusted"),
+		it.Equal(len(jobs.seq), 1),
+		it.Equal(jobs.seq[0]["JOB_NAME"], "123-456-789"),
+	)
+}
+
+func TestHttpApi(t *testing.T) {
+	db := &mockRegistry{}
+	jobs := &mockJobs{}
+	service := New(
+		scheduler.New(jobs, "test-queue", "test-job", "test-s3",
+			scheduler.WithRegistry(db),
+			scheduler.WithAccounts("111111111111"),
+		),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+	api := httptest.NewServer(service.API())
+	defer api.Close()
+
+	for body, status := range map[string]int{
+		`{"uid": "a", "module": "github.com/fogfish/craft", "context": {}}`:                            http.StatusAccepted,
+		`{"uid": "a", "module": "github.com/fogfish/craft", "context": {}}` + " ":                      http.StatusAccepted,
+		`{"module": "github.com/fogfish/craft", "context": {}}`:                                        http.StatusAccepted,
+		`{"uid": "b", "module": "github.com/fogfish/craft"}`:                                           http.StatusBadRequest,
+		`{"uid": "b", "module": "github.com/fogfish/craft", "context": {}, "account": "999999999999"}`: http.StatusBadRequest,
+		`{"uid": "b", "module": "github.com/fogfish/craft", "context": {}, "mode": "unknown"}`:         http.StatusBadRequest,
+		`{"uid": "b", "module": "github.com/fogfish/craft", "context": {}, "callback": "ftp://x"}`:     http.StatusBadRequest,
+		`{"uid": "b", "module": "github.com/fogfish/craft", "context": {}, "replyTo": "app"}`:          http.StatusBadRequest,
+		`not json`: http.StatusBadRequest,
+	} {
+		t.Run(body, func(t *testing.T) {
+			resp, err := http.Post(api.URL+"/deployments", "application/json", strings.NewReader(body))
+			it.Then(t).Should(it.Nil(err))
+			defer resp.Body.Close()
+
+			var val Submitted
+			it.Then(t).Should(
+				it.Equal(resp.StatusCode, status),
+				it.Nil(json.NewDecoder(resp.Body).Decode(&val)),
+			)
+
+			if status == http.StatusAccepted {
+				it.Then(t).ShouldNot(
+					it.Equal(val.UID, ""),
+					it.Equal(val.Job, ""),
+				)
+			}
+		})
+	}
+
+	// redelivered deployment is submitted once
+	it.Then(t).Should(it.Equal(len(jobs.seq), 2))
+
+	resp, err := http.Get(api.URL + "/deployments/a")
+	it.Then(t).Should(it.Nil(err))
+	defer resp.Body.Close()
+
+	var d map[string]any
+	it.Then(t).Should(
+		it.Equal(resp.StatusCode, http.StatusOK),
+		it.Nil(json.NewDecoder(resp.Body).Decode(&d)),
+		it.Equal(d["uid"], any("a")),
+		it.Equal(d["module"], any("github.com/fogfish/craft")),
+		it.Equal(d["job"], any("a")),
+		it.Equal(d["status"], any(registry.STATUS_SCHEDULED)),
+	)
+
+	// context and destinations of status are not exposed
+	for _, key := range []string{"context", "callback", "replyTo", "account", "role"} {
+		_, has := d[key]
+		it.Then(t).ShouldNot(it.True(has))
+	}
+
+	resp, err = http.Get(api.URL + "/deployments/unknown")
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(resp.StatusCode, http.StatusNotFound),
+	)
+}
+
+func TestHttpApiProxy(t *testing.T) {
+	service := mockService()
+	handler := proxy(service.API())
+
+	resp, err := handler(context.Background(),
+		lambdaevents.APIGatewayProxyRequest{
+			HTTPMethod: http.MethodPost,
+			Path:       "/deployments",
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"uid": "123-456-789", "module": "github.com/fogfish/craft", "context": {"acc": "test"}}`,
+		},
+	)
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(resp.StatusCode, http.StatusAccepted),
+		it.Equal(resp.Headers["Content-Type"], "application/json"),
+		it.Equal(resp.Body, `{"uid":"123-456-789"}`+"\n"),
+	)
+}
+
+func TestHttpApiFailed(t *testing.T) {
+	service := New(
+		scheduler.New(mockJobsFailed{}, "test-queue", "test-job", "test-s3"),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+	handler := proxy(service.API())
+
+	// internal error is not replied to the caller
+	resp, err := handler(context.Background(),
+		lambdaevents.APIGatewayProxyRequest{
+			HTTPMethod: http.MethodPost,
+			Path:       "/deployments",
+			Body:       `{"uid": "a", "module": "github.com/fogfish/craft", "context": {}}`,
+		},
+	)
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(resp.StatusCode, http.StatusInternalServerError),
+		it.Equal(resp.Body, `{"error":"internal error"}`+"\n"),
+	)
+}
+
+func TestSubmitJobInvalid(t *testing.T) {
+	jobs := &mockJobs{}
+	service := New(
+		scheduler.New(jobs, "test-queue", "test-job", "test-s3"),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+
+	rcv := make(chan swarm.Msg[events.EventCraft])
+	ack := make(chan swarm.Msg[events.EventCraft])
+	go service.Run(rcv, ack)
+
+	// the bus accepts same events as HTTP API
+	for _, evt := range []events.EventCraft{
+		{UID: "a", Module: "m", Context: []byte(`{}`), Callback: "ftp://x"},
+		{UID: "a", Module: "m", Context: []byte(`{}`), ReplyTo: "app"},
+		{UID: "a", Module: "m", Context: []byte(`{}`), Schedule: "other"},
+	} {
+		rcv <- swarm.Msg[events.EventCraft]{Category: "test", Object: evt}
+		msg := <-ack
+		it.Then(t).Should(
+			it.True(errors.Is(msg.Error, scheduler.ErrInvalid)),
+		)
+	}
+
+	it.Then(t).Should(it.Equal(len(jobs.seq), 0))
+}
+
+func TestSubmitBootstrap(t *testing.T) {
+	for name, service := range map[string]*Service{
+		"TrustedAccount": mockBootstrap(scheduler.WithAccounts("111111111111")),
+		"Organization":   mockBootstrap(scheduler.WithOrganization("o-test")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			rcv := make(chan swarm.Msg[events.EventBootstrap])
+			ack := make(chan swarm.Msg[events.EventBootstrap])
+			go service.RunBootstrap(rcv, ack)
+
+			rcv <- swarm.Msg[events.EventBootstrap]{
+				Category: "test",
+				Object:   eventBootstrap,
+			}
+			msg := <-ack
+			it.Then(t).Should(it.Nil(msg.Error))
+		})
+	}
+}
+
+func TestSubmitBootstrapFailed(t *testing.T) {
+	for name, evt := range map[string]events.EventBootstrap{
+		"Undefined":        {},
+		"UntrustedAccount": {UID: "123-456-789", Account: "999999999999"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			service := mockBootstrap(scheduler.WithAccounts("111111111111"))
+
+			rcv := make(chan swarm.Msg[events.EventBootstrap])
+			ack := make(chan swarm.Msg[events.EventBootstrap])
+			go service.RunBootstrap(rcv, ack)
+
+			rcv <- swarm.Msg[events.EventBootstrap]{
+				Category: "test",
+				Object:   evt,
+			}
+			msg := <-ack
+			it.Then(t).ShouldNot(it.Nil(msg.Error))
+		})
+	}
+}
 
-func mockService() *Service {
+func TestSubmitApproval(t *testing.T) {
 	batch := &mock{
 		returnVal: &batch.SubmitJobOutput{},
 		expectVal: &batch.SubmitJobInput{
 			JobDefinition: aws.String("test-job"),
 			JobQueue:      aws.String("test-queue"),
 			ContainerOverrides: &types.ContainerOverrides{
+				Command: []string{"sh", "/bin/approval.sh"},
 				Environment: []types.KeyValuePair{
+					{Name: aws.String("CRAFT_UID"), Value: aws.String("123-456-789")},
 					{Name: aws.String("CRAFT_BUCKET"), Value: aws.String("test-s3")},
-					{Name: aws.String("CRAFT_MODULE"), Value: aws.String("github.com/fogfish/craft")},
-					{Name: aws.String("CRAFT_CDK_CONTEXT"), Value: aws.String(string(eventCraft.Context))},
+					{Name: aws.String("CRAFT_DECISION"), Value: aws.String(events.DECISION_APPROVE)},
 				},
 			},
 		},
 	}
+	db := &mockRegistry{
+		seq: []registry.Deployment{
+			{UID: "123-456-789", Mode: events.MODE_APPROVAL, Status: registry.STATUS_PENDING},
+			{UID: "deployed", Mode: events.MODE_APPROVAL, Status: registry.STATUS_SUCCEEDED},
+			{UID: "deploy", Status: registry.STATUS_PENDING},
+		},
+	}
+	service := New(scheduler.New(batch, "test-queue", "test-job", "test-s3", scheduler.WithRegistry(db)), &mockEmitter[events.EventRolloutProgress]{}, &mockEmitter[events.EventSchedules]{}, &mockEmitter[events.EventTenantTransition]{})
 
-	scheduler := scheduler.New(batch, "test-queue", "test-job", "test-s3")
+	for name, expect := range map[events.EventApproval]bool{
+		{UID: "123-456-789", Decision: events.DECISION_APPROVE}: true,
+		{UID: "123-456-789", Decision: "unknown"}:               false,
+		{UID: "123-456-789"}:                                    false,
+		{Decision: events.DECISION_APPROVE}:                     false,
+		{UID: "unknown", Decision: events.DECISION_APPROVE}:     false,
+		{UID: "deployed", Decision: events.DECISION_APPROVE}:    false,
+		{UID: "deployed", Decision: events.DECISION_EXPIRE}:     true,
+		{UID: "deploy", Decision: events.DECISION_APPROVE}:      false,
+	} {
+		t.Run(name.UID+"/"+name.Decision, func(t *testing.T) {
+			rcv := make(chan swarm.Msg[events.EventApproval])
+			ack := make(chan swarm.Msg[events.EventApproval])
+			go service.RunApproval(rcv, ack)
 
-	return New(scheduler)
+			rcv <- swarm.Msg[events.EventApproval]{
+				Category: "test",
+				Object:   name,
+			}
+			msg := <-ack
+			it.Then(t).Should(it.Equal(msg.Error == nil, expect))
+		})
+	}
 }
 
-type mock struct {
-	expectVal *batch.SubmitJobInput
-	returnVal *batch.SubmitJobOutput
+func TestSubmitApprovalOnce(t *testing.T) {
+	pending := func() *mockRegistry {
+		return &mockRegistry{
+			seq: []registry.Deployment{
+				{UID: "123-456-789", Mode: events.MODE_APPROVAL, Status: registry.STATUS_PENDING},
+			},
+		}
+	}
+
+	t.Run("Decided", func(t *testing.T) {
+		// the concurrent decision has taken the deployment
+		db := mockRegistryDecided{pending()}
+		service := New(scheduler.New(mockJobsFailed{}, "test-queue", "test-job", "test-s3", scheduler.WithRegistry(db)), &mockEmitter[events.EventRolloutProgress]{}, &mockEmitter[events.EventSchedules]{}, &mockEmitter[events.EventTenantTransition]{})
+
+		rcv := make(chan swarm.Msg[events.EventApproval])
+		ack := make(chan swarm.Msg[events.EventApproval])
+		go service.RunApproval(rcv, ack)
+
+		rcv <- swarm.Msg[events.EventApproval]{
+			Category: "test",
+			Object:   events.EventApproval{UID: "123-456-789", Decision: events.DECISION_APPROVE},
+		}
+		msg := <-ack
+		it.Then(t).Should(it.Nil(msg.Error))
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		db := pending()
+		service := New(scheduler.New(mockJobsFailed{}, "test-queue", "test-job", "test-s3", scheduler.WithRegistry(db)), &mockEmitter[events.EventRolloutProgress]{}, &mockEmitter[events.EventSchedules]{}, &mockEmitter[events.EventTenantTransition]{})
+
+		rcv := make(chan swarm.Msg[events.EventApproval])
+		ack := make(chan swarm.Msg[events.EventApproval])
+		go service.RunApproval(rcv, ack)
+
+		rcv <- swarm.Msg[events.EventApproval]{
+			Category: "test",
+			Object:   events.EventApproval{UID: "123-456-789", Decision: events.DECISION_APPROVE},
+		}
+		msg := <-ack
+		it.Then(t).Should(
+			it.Fail(func() error { return msg.Error }),
+			it.Equal(db.seq[0].Status, registry.STATUS_PENDING),
+		)
+	})
 }
 
-func (m *mock) SubmitJob(ctx context.Context, params *batch.SubmitJobInput, optFns ...func(*batch.Options)) (*batch.SubmitJobOutput, error) {
-	if aws.ToString(params.JobQueue) != aws.ToString(m.expectVal.JobQueue) {
-		return nil, fmt.Errorf("unexpected job queue")
+func TestSubmitJobRegistry(t *testing.T) {
+	db := &mockRegistry{}
+	service := mockServiceWith(
+		[]scheduler.Option{scheduler.WithRegistry(db)},
+		types.KeyValuePair{Name: aws.String("CRAFT_MODULE_VERSION"), Value: aws.String("v1.2.3")},
+		types.KeyValuePair{Name: aws.String("CRAFT_TENANT"), Value: aws.String("acme")},
+	)
+
+	rcv := make(chan swarm.Msg[events.EventCraft])
+	ack := make(chan swarm.Msg[events.EventCraft])
+	go service.Run(rcv, ack)
+
+	evt := eventCraft
+	evt.Version = "v1.2.3"
+	evt.Tenant = "acme"
+	rcv <- swarm.Msg[events.EventCraft]{
+		Category: "test",
+		Object:   evt,
 	}
+	msg := <-ack
+	it.Then(t).Should(
+		it.Nil(msg.Error),
+		it.Seq(db.seq).Equal(
+			registry.Deployment{
+				UID:     evt.UID,
+				Tenant:  "acme",
+				Module:  evt.Module,
+				Version: "v1.2.3",
+				Context: evt.Context,
+				Status:  registry.STATUS_SCHEDULED,
+			},
+		),
+	)
+}
 
-	if aws.ToString(params.JobDefinition) != aws.ToString(m.expectVal.JobDefinition) {
-		return nil, fmt.Errorf("unexpected job definition")
+func TestSubmitJobIdempotent(t *testing.T) {
+	for name, tt := range map[string]struct {
+		deployment registry.Deployment
+		jobs       int
+		conflict   bool
+	}{
+		"scheduled": {registry.Deployment{Status: registry.STATUS_SCHEDULED, Job: "job"}, 0, false},
+		"succeeded": {registry.Deployment{Status: registry.STATUS_SUCCEEDED, Job: "job"}, 0, false},
+		"parked":    {registry.Deployment{Status: registry.STATUS_PARKED}, 0, false},
+		"failed":    {registry.Deployment{Status: registry.STATUS_FAILED, Job: "job"}, 1, false},
+		"discarded": {registry.Deployment{Status: registry.STATUS_DISCARDED, Job: "job"}, 1, false},
+		"claimed":   {registry.Deployment{Status: registry.STATUS_SCHEDULED, Updated: time.Now().UTC().Format(time.RFC3339Nano)}, 0, true},
+		"expired":   {registry.Deployment{Status: registry.STATUS_SCHEDULED, Updated: "2024-01-01T00:00:00Z"}, 1, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			jobs := &mockJobs{}
+			deployment := tt.deployment
+			deployment.UID = eventCraft.UID
+			deployment.Module = eventCraft.Module
+			db := &mockRegistry{
+				seq: []registry.Deployment{deployment},
+			}
+			service := New(
+				scheduler.New(jobs, "test-queue", "test-job", "test-s3", scheduler.WithRegistry(db)),
+				&mockEmitter[events.EventRolloutProgress]{},
+				&mockEmitter[events.EventSchedules]{},
+				&mockEmitter[events.EventTenantTransition]{},
+			)
+
+			rcv := make(chan swarm.Msg[events.EventCraft])
+			ack := make(chan swarm.Msg[events.EventCraft])
+			go service.Run(rcv, ack)
+
+			rcv <- swarm.Msg[events.EventCraft]{Category: "test", Object: eventCraft}
+			msg := <-ack
+			it.Then(t).Should(
+				it.Equal(errors.Is(msg.Error, registry.ErrConflict), tt.conflict),
+				it.Equal(len(jobs.seq), tt.jobs),
+			)
+		})
 	}
+}
 
-	env := map[string]string{}
-	for _, e := range params.ContainerOverrides.Environment {
-		env[aws.ToString(e.Name)] = aws.ToString(e.Value)
+func TestSubmitJobReusedUID(t *testing.T) {
+	jobs := &mockJobs{}
+	db := &mockRegistry{
+		seq: []registry.Deployment{
+			{UID: "a", Tenant: "acme", Module: "m1", Version: "v1", Status: registry.STATUS_SUCCEEDED, Job: "job"},
+		},
 	}
-	for _, e := range m.expectVal.ContainerOverrides.Environment {
-		key := aws.ToString(e.Name)
-		if x, has := env[key]; !has || x != aws.ToString(e.Value) {
-			return nil, fmt.Errorf("unexpected environment override: %s, %s", key, x)
+	service := New(
+		scheduler.New(jobs, "test-queue", "test-job", "test-s3", scheduler.WithRegistry(db)),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+
+	rcv := make(chan swarm.Msg[events.EventCraft])
+	ack := make(chan swarm.Msg[events.EventCraft])
+	go service.Run(rcv, ack)
+
+	// redelivered deployment is accepted, other deployment reusing uid fails
+	for _, tt := range []struct {
+		evt   events.EventCraft
+		valid bool
+	}{
+		{events.EventCraft{UID: "a", Tenant: "acme", Module: "m1", Version: "v1", Context: []byte(`{}`)}, true},
+		{events.EventCraft{UID: "a", Tenant: "acme", Module: "m2", Version: "v1", Context: []byte(`{}`)}, false},
+		{events.EventCraft{UID: "a", Tenant: "beta", Module: "m1", Version: "v1", Context: []byte(`{}`)}, false},
+	} {
+		rcv <- swarm.Msg[events.EventCraft]{Category: "test", Object: tt.evt}
+		msg := <-ack
+		it.Then(t).Should(
+			it.Equal(msg.Error == nil, tt.valid),
+			it.Equal(errors.Is(msg.Error, scheduler.ErrInvalid), !tt.valid),
+		)
+	}
+
+	it.Then(t).Should(
+		it.Equal(len(jobs.seq), 0),
+		it.Equal(len(db.seq), 1),
+	)
+}
+
+func TestLedger(t *testing.T) {
+	ledger := &mockLedger{}
+	tenants := &mockTenants{}
+	service := New(
+		scheduler.New(&mockJobs{}, "test-queue", "test-job", "test-s3",
+			scheduler.WithRegistry(&mockRegistry{}),
+			scheduler.WithTenants(tenants),
+		),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+		WithLedger(ledger),
+	)
+
+	rcv := make(chan swarm.Msg[events.EventTenantSuspend])
+	ack := make(chan swarm.Msg[events.EventTenantSuspend])
+	go service.RunTenantSuspend(rcv, ack)
+
+	// the request fails, it is not recorded and processed again on retry
+	rcv <- swarm.Msg[events.EventTenantSuspend]{Category: "test", Object: events.EventTenantSuspend{UID: "s", Tenant: "acme"}}
+	msg := <-ack
+	it.Then(t).ShouldNot(it.Nil(msg.Error))
+
+	tenants.seq = map[string]tenant.Tenant{"acme": {ID: "acme", Status: events.TENANT_ACTIVE, Created: "2024-01-01T00:00:00Z"}}
+	rcv <- swarm.Msg[events.EventTenantSuspend]{Category: "test", Object: events.EventTenantSuspend{UID: "s", Tenant: "acme"}}
+	msg = <-ack
+	it.Then(t).Should(
+		it.Nil(msg.Error),
+		it.Equal(tenants.seq["acme"].Status, events.TENANT_SUSPENDED),
+	)
+
+	// replayed request is skipped, the tenant is not suspended again
+	tenants.seq = map[string]tenant.Tenant{"acme": {ID: "acme", Status: events.TENANT_ACTIVE, Created: "2024-01-01T00:00:00Z"}}
+	rcv <- swarm.Msg[events.EventTenantSuspend]{Category: "test", Object: events.EventTenantSuspend{UID: "s", Tenant: "acme"}}
+	msg = <-ack
+	it.Then(t).Should(
+		it.Nil(msg.Error),
+		it.Equal(tenants.seq["acme"].Status, events.TENANT_ACTIVE),
+		it.Seq(ledger.seq).Equal("EventTenantSuspend s"),
+	)
+}
+
+func TestRollback(t *testing.T) {
+	history := func() *mockRegistry {
+		return &mockRegistry{
+			seq: []registry.Deployment{
+				{UID: "a", Tenant: "acme", Module: "github.com/fogfish/craft", Version: "v1.0.0", Context: []byte(`{"acc": "test"}`), Status: registry.STATUS_SUCCEEDED},
+				{UID: "b", Tenant: "acme", Module: "github.com/fogfish/craft", Version: "v1.1.0", Context: []byte(`{"acc": "test"}`), Status: registry.STATUS_FAILED},
+				{UID: "c", Tenant: "acme", Module: "github.com/fogfish/craft", Version: "v1.2.0", Context: []byte(`{"acc": "test"}`), Status: registry.STATUS_SUCCEEDED},
+			},
 		}
 	}
 
-	return m.returnVal, nil
+	// rollback event -> identity of reverted deployment, if rollback is possible
+	for name, expect := range map[events.EventRollback]string{
+		{UID: "123-456-789", Tenant: "acme"}:  "c",
+		{UID: "123-456-789", Reverts: "c"}:    "c",
+		{UID: "123-456-789", Reverts: "b"}:    "b",
+		{UID: "123-456-789", Reverts: "a"}:    "",
+		{UID: "123-456-789", Reverts: "x"}:    "",
+		{UID: "123-456-789", Tenant: "other"}: "",
+		{UID: "123-456-789"}:                  "",
+	} {
+		t.Run(name.Tenant+name.Reverts, func(t *testing.T) {
+			db := history()
+			service := mockServiceWith(
+				[]scheduler.Option{scheduler.WithRegistry(db)},
+				types.KeyValuePair{Name: aws.String("CRAFT_MODULE_VERSION"), Value: aws.String("v1.0.0")},
+				types.KeyValuePair{Name: aws.String("CRAFT_TENANT"), Value: aws.String("acme")},
+			)
+
+			rcv := make(chan swarm.Msg[events.EventRollback])
+			ack := make(chan swarm.Msg[events.EventRollback])
+			go service.RunRollback(rcv, ack)
+
+			rcv <- swarm.Msg[events.EventRollback]{
+				Category: "test",
+				Object:   name,
+			}
+			msg := <-ack
+			it.Then(t).Should(it.Equal(msg.Error == nil, expect != ""))
+
+			if expect != "" {
+				it.Then(t).Should(
+					it.Equal(len(db.seq), 4),
+					it.Equal(db.seq[3].UID, "123-456-789"),
+					it.Equal(db.seq[3].Reverts, expect),
+				)
+			}
+		})
+	}
+}
+
+func TestRollout(t *testing.T) {
+	db := &mockRegistry{}
+	for _, tenant := range []string{"t1", "t2", "t3", "t4", "t5", "x1"} {
+		db.seq = append(db.seq,
+			registry.Deployment{UID: tenant, Tenant: tenant, Module: "github.com/fogfish/craft", Version: "v1.0.0", Context: []byte(`{"acc": "test"}`), Status: registry.STATUS_SUCCEEDED},
+		)
+	}
+
+	batch := &mock{
+		returnVal: &batch.SubmitJobOutput{},
+		expectVal: &batch.SubmitJobInput{
+			JobDefinition: aws.String("test-job"),
+			JobQueue:      aws.String("test-queue"),
+			ContainerOverrides: &types.ContainerOverrides{
+				Environment: []types.KeyValuePair{
+					{Name: aws.String("CRAFT_MODULE"), Value: aws.String("github.com/fogfish/craft")},
+					{Name: aws.String("CRAFT_MODULE_VERSION"), Value: aws.String("v2.0.0")},
+					{Name: aws.String("CRAFT_CDK_CONTEXT"), Value: aws.String(`{"acc": "test"}`)},
+				},
+			},
+		},
+	}
+	bus := &mockEmitter[events.EventRolloutProgress]{}
+	service := New(
+		scheduler.New(batch, "test-queue", "test-job", "test-s3",
+			scheduler.WithRegistry(db),
+			scheduler.WithFleet(&mockFleet{}),
+		),
+		bus,
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+
+	rollout := make(chan swarm.Msg[events.EventRollout])
+	rolloutAck := make(chan swarm.Msg[events.EventRollout])
+	go service.RunRollout(rollout, rolloutAck)
+
+	control := make(chan swarm.Msg[events.EventRolloutControl])
+	controlAck := make(chan swarm.Msg[events.EventRolloutControl])
+	go service.RunRolloutControl(control, controlAck)
+
+	deployment := make(chan swarm.Msg[events.EventDeployment])
+	deploymentAck := make(chan swarm.Msg[events.EventDeployment])
+	go service.RunDeployment(deployment, deploymentAck)
+
+	deployed := func(uid, status string) error {
+		deployment <- swarm.Msg[events.EventDeployment]{
+			Object: events.EventDeployment{UID: uid, Rollout: "r", Status: status},
+		}
+		return (<-deploymentAck).Error
+	}
+
+	controlled := func(action string) error {
+		control <- swarm.Msg[events.EventRolloutControl]{
+			Object: events.EventRolloutControl{UID: "r", Action: action},
+		}
+		return (<-controlAck).Error
+	}
+
+	progress := func(status string, wave, queued, running, succeeded, failed int) events.EventRolloutProgress {
+		return events.EventRolloutProgress{
+			UID: "r", Module: "github.com/fogfish/craft", Version: "v2.0.0",
+			Status: status, Wave: wave, Waves: 3,
+			Queued: queued, Running: running, Succeeded: succeeded, Failed: failed,
+		}
+	}
+
+	// waves of 1, 2 and 2 tenants, one deployment at time
+	rollout <- swarm.Msg[events.EventRollout]{
+		Object: events.EventRollout{
+			UID:         "r",
+			Module:      "github.com/fogfish/craft",
+			Version:     "v2.0.0",
+			Selector:    []string{"t*"},
+			Waves:       []int{1, 2},
+			Concurrency: 1,
+		},
+	}
+	it.Then(t).Should(it.Nil((<-rolloutAck).Error))
+
+	it.Then(t).Should(
+		it.Nil(deployed("r-0", registry.STATUS_SUCCEEDED)),
+		it.Nil(deployed("r-0", registry.STATUS_SUCCEEDED)),
+		it.Nil(deployed("r-1", registry.STATUS_FAILED)),
+		it.Nil(controlled(events.ACTION_RESUME)),
+		it.Nil(deployed("r-2", registry.STATUS_SUCCEEDED)),
+		it.Nil(controlled(events.ACTION_ABORT)),
+	).ShouldNot(
+		it.Nil(controlled(events.ACTION_RESUME)),
+	)
+
+	it.Then(t).Should(
+		it.Seq(bus.seq).Equal(
+			progress(events.ROLLOUT_RUNNING, 1, 4, 1, 0, 0),
+			progress(events.ROLLOUT_RUNNING, 2, 3, 1, 1, 0),
+			progress(events.ROLLOUT_HALTED, 2, 3, 0, 1, 1),
+			progress(events.ROLLOUT_RUNNING, 2, 2, 1, 1, 1),
+			progress(events.ROLLOUT_RUNNING, 3, 1, 1, 2, 1),
+			progress(events.ROLLOUT_ABORTED, 3, 1, 1, 2, 1),
+		),
+	)
+}
+
+func TestRolloutIdempotent(t *testing.T) {
+	db := &mockRegistry{
+		seq: []registry.Deployment{
+			{UID: "a", Tenant: "acme", Module: "github.com/fogfish/craft", Version: "v1.0.0", Context: []byte(`{"acc": "test"}`), Status: registry.STATUS_SUCCEEDED},
+		},
+	}
+	jobs := &mockJobs{}
+	service := New(
+		scheduler.New(jobs, "test-queue", "test-job", "test-s3",
+			scheduler.WithRegistry(db),
+			scheduler.WithFleet(&mockFleet{}),
+		),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+
+	rcv := make(chan swarm.Msg[events.EventRollout])
+	ack := make(chan swarm.Msg[events.EventRollout])
+	go service.RunRollout(rcv, ack)
+
+	evt := events.EventRollout{UID: "r", Module: "github.com/fogfish/craft", Version: "v2.0.0"}
+	for i := 0; i < 2; i++ {
+		rcv <- swarm.Msg[events.EventRollout]{Category: "test", Object: evt}
+		it.Then(t).Should(it.Nil((<-ack).Error))
+	}
+
+	evt.Version = "v3.0.0"
+	rcv <- swarm.Msg[events.EventRollout]{Category: "test", Object: evt}
+	it.Then(t).ShouldNot(it.Nil((<-ack).Error))
+
+	it.Then(t).Should(
+		it.Equal(len(jobs.seq), 1),
+		it.Equal(jobs.seq[0]["JOB_NAME"], "r-0"),
+	)
+}
+
+func TestRolloutFailed(t *testing.T) {
+	for name, evt := range map[string]events.EventRollout{
+		"Undefined":     {},
+		"NoVersion":     {UID: "r", Module: "github.com/fogfish/craft"},
+		"NoTenants":     {UID: "r", Module: "github.com/fogfish/craft", Version: "v2.0.0", Selector: []string{"none"}},
+		"UnknownModule": {UID: "r", Module: "github.com/fogfish/unknown", Version: "v2.0.0"},
+		"InvalidWaves":  {UID: "r", Module: "github.com/fogfish/craft", Version: "v2.0.0", Waves: []int{0}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := &mockRegistry{
+				seq: []registry.Deployment{
+					{UID: "a", Tenant: "acme", Module: "github.com/fogfish/craft", Status: registry.STATUS_SUCCEEDED},
+				},
+			}
+			service := mockServiceWith(
+				[]scheduler.Option{scheduler.WithRegistry(db), scheduler.WithFleet(&mockFleet{})},
+			)
+
+			rcv := make(chan swarm.Msg[events.EventRollout])
+			ack := make(chan swarm.Msg[events.EventRollout])
+			go service.RunRollout(rcv, ack)
+
+			rcv <- swarm.Msg[events.EventRollout]{
+				Category: "test",
+				Object:   evt,
+			}
+			msg := <-ack
+			it.Then(t).ShouldNot(it.Nil(msg.Error))
+		})
+	}
+}
+
+func TestSubmitArray(t *testing.T) {
+	batch := &mock{
+		returnVal: &batch.SubmitJobOutput{JobId: aws.String("job")},
+		expectVal: &batch.SubmitJobInput{
+			JobDefinition:   aws.String("test-job"),
+			JobQueue:        aws.String("test-queue"),
+			ArrayProperties: &types.ArrayProperties{Size: aws.Int32(2)},
+			ContainerOverrides: &types.ContainerOverrides{
+				Environment: []types.KeyValuePair{
+					{Name: aws.String("CRAFT_UID"), Value: aws.String("123-456-789")},
+					{Name: aws.String("CRAFT_MODULE"), Value: aws.String("github.com/fogfish/craft")},
+					{Name: aws.String("CRAFT_ARRAY"), Value: aws.String("craft/contexts/123-456-789.jsonl")},
+				},
+			},
+		},
+	}
+	storage := &mockStorage{}
+	db := &mockRegistry{}
+	service := New(
+		scheduler.New(batch, "test-queue", "test-job", "test-s3",
+			scheduler.WithAccounts("111111111111"),
+			scheduler.WithStorage(storage),
+			scheduler.WithRegistry(db),
+		),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+
+	rcv := make(chan swarm.Msg[events.EventCraftArray])
+	ack := make(chan swarm.Msg[events.EventCraftArray])
+	go service.RunArray(rcv, ack)
+
+	rcv <- swarm.Msg[events.EventCraftArray]{
+		Category: "test",
+		Object: events.EventCraftArray{
+			UID:    "123-456-789",
+			Module: "github.com/fogfish/craft",
+			Targets: []events.Target{
+				{Tenant: "a", Context: []byte(`{"acc":"a"}`)},
+				{Tenant: "b", Context: []byte(`{"acc":"b"}`), Account: "111111111111"},
+			},
+		},
+	}
+	msg := <-ack
+	it.Then(t).Should(
+		it.Nil(msg.Error),
+		it.Equal(storage.key, "craft/contexts/123-456-789.jsonl"),
+		it.Equal(storage.body,
+			`{"tenant":"a","context":{"acc":"a"}}`+"\n"+
+				`{"tenant":"b","context":{"acc":"b"},"account":"111111111111"}`+"\n",
+		),
+		it.Equal(len(db.seq), 3),
+		it.Equal(db.seq[0].UID, "123-456-789"),
+		it.Equal(db.seq[0].Job, "job"),
+		it.Equal(db.seq[1].UID, "123-456-789-0"),
+		it.Equal(db.seq[2].UID, "123-456-789-1"),
+		it.Equal(db.seq[2].Status, registry.STATUS_SCHEDULED),
+	)
+
+	// the array job is submitted once
+	rcv <- swarm.Msg[events.EventCraftArray]{
+		Category: "test",
+		Object: events.EventCraftArray{
+			UID:    "123-456-789",
+			Module: "github.com/fogfish/craft",
+			Targets: []events.Target{
+				{Tenant: "a", Context: []byte(`{"acc":"a"}`)},
+				{Tenant: "b", Context: []byte(`{"acc":"b"}`), Account: "111111111111"},
+			},
+		},
+	}
+	msg = <-ack
+	it.Then(t).Should(
+		it.Nil(msg.Error),
+		it.Equal(len(db.seq), 3),
+	)
+}
+
+func TestSubmitArrayFailed(t *testing.T) {
+	for name, evt := range map[string]events.EventCraftArray{
+		"Undefined": {},
+		"Single": {
+			UID: "123-456-789", Module: "github.com/fogfish/craft",
+			Targets: []events.Target{{Context: []byte(`{}`)}},
+		},
+		"NoContext": {
+			UID: "123-456-789", Module: "github.com/fogfish/craft",
+			Targets: []events.Target{{Context: []byte(`{}`)}, {}},
+		},
+		"UntrustedAccount": {
+			UID: "123-456-789", Module: "github.com/fogfish/craft",
+			Targets: []events.Target{{Context: []byte(`{}`)}, {Context: []byte(`{}`), Account: "999999999999"}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			service := mockServiceWith([]scheduler.Option{scheduler.WithStorage(&mockStorage{})})
+
+			rcv := make(chan swarm.Msg[events.EventCraftArray])
+			ack := make(chan swarm.Msg[events.EventCraftArray])
+			go service.RunArray(rcv, ack)
+
+			rcv <- swarm.Msg[events.EventCraftArray]{
+				Category: "test",
+				Object:   evt,
+			}
+			msg := <-ack
+			it.Then(t).ShouldNot(it.Nil(msg.Error))
+		})
+	}
+}
+
+func TestSubmitComposite(t *testing.T) {
+	batch := &mockGraph{}
+	service := New(
+		scheduler.New(batch, "test-queue", "test-job", "test-s3"),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+
+	rcv := make(chan swarm.Msg[events.EventComposite])
+	ack := make(chan swarm.Msg[events.EventComposite])
+	go service.RunComposite(rcv, ack)
+
+	rcv <- swarm.Msg[events.EventComposite]{
+		Category: "test",
+		Object: events.EventComposite{
+			UID:    "123",
+			Tenant: "acme",
+			Modules: []events.Component{
+				{Name: "api", Module: "github.com/fogfish/api", Context: []byte(`{"vpc":"${network.VpcId}"}`), DependsOn: []string{"network", "data"}},
+				{Name: "data", Module: "github.com/fogfish/data", Context: []byte(`{}`), DependsOn: []string{"network"}},
+				{Name: "network", Module: "github.com/fogfish/network", Context: []byte(`{}`)},
+			},
+		},
+	}
+	msg := <-ack
+	it.Then(t).Should(
+		it.Nil(msg.Error),
+		it.Seq(batch.seq).Equal(
+			"123-network[] ",
+			"123-data[123-network] network=123-network",
+			"123-api[123-network 123-data] network=123-network data=123-data",
+		),
+	)
+}
+
+func TestSubmitCompositeDesired(t *testing.T) {
+	batch := &mockGraph{}
+	state := &mockDesired{
+		seq: map[string]desired.State{
+			"acme github.com/fogfish/api": {Tenant: "acme", Module: "github.com/fogfish/api", Version: "v1", Context: []byte(`{}`)},
+		},
+	}
+	service := New(
+		scheduler.New(batch, "test-queue", "test-job", "test-s3", scheduler.WithDesired(state)),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+
+	rcv := make(chan swarm.Msg[events.EventComposite])
+	ack := make(chan swarm.Msg[events.EventComposite])
+	go service.RunComposite(rcv, ack)
+
+	rcv <- swarm.Msg[events.EventComposite]{
+		Category: "test",
+		Object: events.EventComposite{
+			UID:    "123",
+			Tenant: "acme",
+			Modules: []events.Component{
+				{Name: "api", Module: "github.com/fogfish/api", Context: []byte(`{"vpc":"${network.VpcId}"}`), DependsOn: []string{"network"}},
+				{Name: "network", Module: "github.com/fogfish/network", Context: []byte(`{}`)},
+			},
+		},
+	}
+	msg := <-ack
+
+	// unresolved context is not declared as desired state
+	_, hasApi := state.seq["acme github.com/fogfish/api"]
+	_, hasNetwork := state.seq["acme github.com/fogfish/network"]
+	it.Then(t).Should(
+		it.Nil(msg.Error),
+		it.Equal(len(batch.seq), 2),
+		it.Equal(hasApi, false),
+		it.Equal(hasNetwork, true),
+	)
+}
+
+func TestSubmitCompositeRetry(t *testing.T) {
+	batch := &mockGraph{fail: "123-api"}
+	service := New(
+		scheduler.New(batch, "test-queue", "test-job", "test-s3",
+			scheduler.WithRegistry(&mockRegistry{}),
+		),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+
+	rcv := make(chan swarm.Msg[events.EventComposite])
+	ack := make(chan swarm.Msg[events.EventComposite])
+	go service.RunComposite(rcv, ack)
+
+	evt := events.EventComposite{
+		UID:    "123",
+		Tenant: "acme",
+		Modules: []events.Component{
+			{Name: "api", Module: "github.com/fogfish/api", Context: []byte(`{}`), DependsOn: []string{"network"}},
+			{Name: "network", Module: "github.com/fogfish/network", Context: []byte(`{}`)},
+		},
+	}
+
+	rcv <- swarm.Msg[events.EventComposite]{Category: "test", Object: evt}
+	it.Then(t).ShouldNot(it.Nil((<-ack).Error))
+
+	rcv <- swarm.Msg[events.EventComposite]{Category: "test", Object: evt}
+	it.Then(t).Should(
+		it.Nil((<-ack).Error),
+		it.Seq(batch.seq).Equal(
+			"123-network[] ",
+			"123-api[123-network] network=123-network",
+		),
+	)
+}
+
+func TestSubmitCompositeFailed(t *testing.T) {
+	for name, modules := range map[string][]events.Component{
+		"Undefined": {},
+		"InvalidName": {
+			{Name: "a b", Module: "a", Context: []byte(`{}`)},
+		},
+		"Duplicated": {
+			{Name: "a", Module: "a", Context: []byte(`{}`)},
+			{Name: "a", Module: "a", Context: []byte(`{}`)},
+		},
+		"UnknownDependency": {
+			{Name: "a", Module: "a", Context: []byte(`{}`), DependsOn: []string{"b"}},
+		},
+		"Cycle": {
+			{Name: "a", Module: "a", Context: []byte(`{}`), DependsOn: []string{"b"}},
+			{Name: "b", Module: "b", Context: []byte(`{}`), DependsOn: []string{"a"}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			batch := &mockGraph{}
+			service := New(
+				scheduler.New(batch, "test-queue", "test-job", "test-s3"),
+				&mockEmitter[events.EventRolloutProgress]{},
+				&mockEmitter[events.EventSchedules]{},
+				&mockEmitter[events.EventTenantTransition]{},
+			)
+
+			rcv := make(chan swarm.Msg[events.EventComposite])
+			ack := make(chan swarm.Msg[events.EventComposite])
+			go service.RunComposite(rcv, ack)
+
+			rcv <- swarm.Msg[events.EventComposite]{
+				Category: "test",
+				Object:   events.EventComposite{UID: "123", Modules: modules},
+			}
+			msg := <-ack
+			it.Then(t).Should(
+				it.Fail(func() error { return msg.Error }),
+				it.Seq(batch.seq).BeEmpty(),
+			)
+		})
+	}
+}
+
+func TestSubmitJobDelayed(t *testing.T) {
+	for _, tt := range []struct {
+		evt  events.EventCraft
+		expr string
+	}{
+		{
+			evt:  events.EventCraft{UID: "a", Module: "m", NotBefore: "2100-01-01T04:00:00+02:00"},
+			expr: "at(2100-01-01T02:00:00) UTC",
+		},
+		{
+			evt:  events.EventCraft{UID: "b", Module: "m", Cron: "0 2 ? * SUN *"},
+			expr: "cron(0 2 ? * SUN *) UTC",
+		},
+		{
+			evt:  events.EventCraft{UID: "c", Module: "m", Cron: "0 2 ? * SUN *", Timezone: "Europe/Helsinki"},
+			expr: "cron(0 2 ? * SUN *) Europe/Helsinki",
+		},
+	} {
+		evt := tt.evt
+		timer := &mockTimer{}
+		service := mockServiceWith([]scheduler.Option{
+			scheduler.WithTimer(timer, "test-group", "arn:aws:events:eu-west-1:000000000000:event-bus/test-bus", "test-role"),
+		})
+
+		rcv := make(chan swarm.Msg[events.EventCraft])
+		ack := make(chan swarm.Msg[events.EventCraft])
+		go service.Run(rcv, ack)
+
+		evt.Context = []byte(`{}`)
+		rcv <- swarm.Msg[events.EventCraft]{Category: "test", Object: evt}
+		msg := <-ack
+
+		schedule := timer.schedules[scheduler.SCHEDULE_DELAY+evt.UID]
+		it.Then(t).Should(
+			it.Nil(msg.Error),
+			it.Equal(aws.ToString(schedule.ScheduleExpression)+" "+aws.ToString(schedule.ScheduleExpressionTimezone), tt.expr),
+			it.Equal(aws.ToString(schedule.Target.EventBridgeParameters.Source), "test-bus"),
+			it.String(aws.ToString(schedule.Target.Input)).Contain(`"schedule":"`+scheduler.SCHEDULE_DELAY+evt.UID+`"`),
+		)
+	}
+}
+
+func TestSubmitJobDelayedFailed(t *testing.T) {
+	for _, evt := range []events.EventCraft{
+		{UID: "a", Module: "m", NotBefore: "tomorrow"},
+		{UID: "b", Module: "m", NotBefore: "2100-01-01T00:00:00Z", Cron: "0 2 ? * SUN *"},
+		{UID: "c", Module: "m", Cron: "0 2 ? * SUN *", Account: "999999999999"},
+		{UID: "d", Module: "m", Cron: "0 2 ? * SUN *", Mode: "unknown"},
+	} {
+		timer := &mockTimer{}
+		service := mockServiceWith([]scheduler.Option{
+			scheduler.WithTimer(timer, "test-group", "arn:aws:events:eu-west-1:000000000000:event-bus/test-bus", "test-role"),
+		})
+
+		rcv := make(chan swarm.Msg[events.EventCraft])
+		ack := make(chan swarm.Msg[events.EventCraft])
+		go service.Run(rcv, ack)
+
+		evt.Context = []byte(`{}`)
+		rcv <- swarm.Msg[events.EventCraft]{Category: "test", Object: evt}
+		msg := <-ack
+
+		it.Then(t).ShouldNot(
+			it.Nil(msg.Error),
+		).Should(
+			it.Equal(len(timer.schedules), 0),
+		)
+	}
+}
+
+func TestSubmitJobDelayedEmitted(t *testing.T) {
+	timer := &mockTimer{schedules: map[string]*awsscheduler.CreateScheduleInput{scheduler.SCHEDULE_DELAY + eventCraft.UID: {}}}
+	service := mockServiceWith([]scheduler.Option{
+		scheduler.WithTimer(timer, "test-group", "arn:aws:events:eu-west-1:000000000000:event-bus/test-bus", "test-role"),
+	})
+
+	rcv := make(chan swarm.Msg[events.EventCraft])
+	ack := make(chan swarm.Msg[events.EventCraft])
+	go service.Run(rcv, ack)
+
+	evt := eventCraft
+	evt.Cron = "0 2 ? * SUN *"
+	evt.Schedule = scheduler.SCHEDULE_DELAY + eventCraft.UID
+	rcv <- swarm.Msg[events.EventCraft]{Category: "test", Object: evt}
+	msg := <-ack
+
+	it.Then(t).Should(
+		it.Nil(msg.Error),
+		it.Equal(len(timer.schedules), 0),
+	)
+}
+
+func TestSubmitJobDelayedForeign(t *testing.T) {
+	timer := &mockTimer{schedules: map[string]*awsscheduler.CreateScheduleInput{"other": {}}}
+	service := mockServiceWith([]scheduler.Option{
+		scheduler.WithTimer(timer, "test-group", "arn:aws:events:eu-west-1:000000000000:event-bus/test-bus", "test-role"),
+	})
+
+	rcv := make(chan swarm.Msg[events.EventCraft])
+	ack := make(chan swarm.Msg[events.EventCraft])
+	go service.Run(rcv, ack)
+
+	evt := eventCraft
+	evt.Schedule = "other"
+	rcv <- swarm.Msg[events.EventCraft]{Category: "test", Object: evt}
+	msg := <-ack
+
+	it.Then(t).ShouldNot(
+		it.Nil(msg.Error),
+	).Should(
+		it.Equal(len(timer.schedules), 1),
+	)
+}
+
+func TestSubmitJobDelayedLongUID(t *testing.T) {
+	timer := &mockTimer{}
+	service := mockServiceWith([]scheduler.Option{
+		scheduler.WithTimer(timer, "test-group", "arn:aws:events:eu-west-1:000000000000:event-bus/test-bus", "test-role"),
+	})
+
+	rcv := make(chan swarm.Msg[events.EventCraft])
+	ack := make(chan swarm.Msg[events.EventCraft])
+	go service.Run(rcv, ack)
+
+	evt := events.EventCraft{UID: strings.Repeat("a", 100), Module: "m", Cron: "0 2 ? * SUN *", Context: []byte(`{}`)}
+	rcv <- swarm.Msg[events.EventCraft]{Category: "test", Object: evt}
+	msg := <-ack
+	it.Then(t).Should(
+		it.Nil(msg.Error),
+		it.Equal(len(timer.schedules), 1),
+	)
+
+	var delayed events.EventCraft
+	for name, schedule := range timer.schedules {
+		it.Then(t).Should(
+			it.Less(len(name), 65),
+			it.Nil(json.Unmarshal([]byte(aws.ToString(schedule.Target.Input)), &delayed)),
+			it.Equal(delayed.Schedule, name),
+		)
+	}
+
+	cancel := make(chan swarm.Msg[events.EventScheduleCancel])
+	cancelAck := make(chan swarm.Msg[events.EventScheduleCancel])
+	go service.RunScheduleCancel(cancel, cancelAck)
+
+	cancel <- swarm.Msg[events.EventScheduleCancel]{Category: "test", Object: events.EventScheduleCancel{UID: evt.UID}}
+	cmsg := <-cancelAck
+	it.Then(t).Should(
+		it.Nil(cmsg.Error),
+		it.Equal(len(timer.schedules), 0),
+	)
+}
+
+func TestDebounceFailed(t *testing.T) {
+	for name, tt := range map[string]struct {
+		jobs     scheduler.JobQueue
+		registry scheduler.Registry
+		restored bool
+	}{
+		"Submit": {mockJobsFailed{}, &mockRegistry{}, true},
+		"Attach": {&mockJobs{}, mockRegistryAttachFailed{&mockRegistry{}}, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			buffers := &mockDebounce{}
+			service := New(
+				scheduler.New(tt.jobs, "test-queue", "test-job", "test-s3",
+					scheduler.WithRegistry(tt.registry),
+					scheduler.WithTimer(&mockTimer{}, "test-group", "arn:aws:events:eu-west-1:000000000000:event-bus/test-bus", "test-role"),
+					scheduler.WithDebounce(buffers, time.Minute),
+				),
+				&mockEmitter[events.EventRolloutProgress]{},
+				&mockEmitter[events.EventSchedules]{},
+				&mockEmitter[events.EventTenantTransition]{},
+			)
+
+			craft := make(chan swarm.Msg[events.EventCraft])
+			craftAck := make(chan swarm.Msg[events.EventCraft])
+			go service.Run(craft, craftAck)
+
+			craft <- swarm.Msg[events.EventCraft]{Category: "test", Object: events.EventCraft{UID: "a", Tenant: "acme", Module: "m1", Context: []byte(`{}`)}}
+			msg := <-craftAck
+			it.Then(t).Should(it.Nil(msg.Error))
+
+			closer := make(chan swarm.Msg[events.EventDebounce])
+			closerAck := make(chan swarm.Msg[events.EventDebounce])
+			go service.RunDebounce(closer, closerAck)
+
+			closer <- swarm.Msg[events.EventDebounce]{Category: "test", Object: events.EventDebounce{UID: "a", Tenant: "acme", Module: "m1"}}
+			req := <-closerAck
+
+			// the buffer is restored only if the job is not submitted
+			_, restored := buffers.seq["acme m1"]
+			it.Then(t).ShouldNot(
+				it.Nil(req.Error),
+			).Should(
+				it.Equal(restored, tt.restored),
+			)
+		})
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	timer := &mockTimer{}
+	schedules := &mockEmitter[events.EventSchedules]{}
+	service := New(
+		scheduler.New(&mock{}, "test-queue", "test-job", "test-s3",
+			scheduler.WithTimer(timer, "test-group", "arn:aws:events:eu-west-1:000000000000:event-bus/test-bus", "test-role"),
+		),
+		&mockEmitter[events.EventRolloutProgress]{},
+		schedules,
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+
+	craft := make(chan swarm.Msg[events.EventCraft])
+	craftAck := make(chan swarm.Msg[events.EventCraft])
+	go service.Run(craft, craftAck)
+
+	list := make(chan swarm.Msg[events.EventScheduleList])
+	listAck := make(chan swarm.Msg[events.EventScheduleList])
+	go service.RunScheduleList(list, listAck)
+
+	cancel := make(chan swarm.Msg[events.EventScheduleCancel])
+	cancelAck := make(chan swarm.Msg[events.EventScheduleCancel])
+	go service.RunScheduleCancel(cancel, cancelAck)
+
+	for _, evt := range []events.EventCraft{
+		{UID: "a", Module: "m", Tenant: "acme", Context: []byte(`{}`), Cron: "0 2 ? * SUN *"},
+		{UID: "b", Module: "m", Tenant: "acme", Context: []byte(`{}`), Cron: "0 3 ? * SUN *"},
+		{UID: "c", Module: "m", Tenant: "other", Context: []byte(`{}`), Cron: "0 4 ? * SUN *"},
+	} {
+		craft <- swarm.Msg[events.EventCraft]{Category: "test", Object: evt}
+		msg := <-craftAck
+		it.Then(t).Should(it.Nil(msg.Error))
+	}
+
+	cancel <- swarm.Msg[events.EventScheduleCancel]{Category: "test", Object: events.EventScheduleCancel{UID: "a"}}
+	msg := <-cancelAck
+	it.Then(t).Should(it.Nil(msg.Error))
+
+	list <- swarm.Msg[events.EventScheduleList]{Category: "test", Object: events.EventScheduleList{UID: "req", Tenant: "acme"}}
+	req := <-listAck
+	it.Then(t).Should(
+		it.Nil(req.Error),
+		it.Equal(len(schedules.seq), 1),
+		it.Equal(schedules.seq[0].UID, "req"),
+		it.Equal(len(schedules.seq[0].Schedules), 1),
+		it.Equal(schedules.seq[0].Schedules[0].UID, "b"),
+		it.Equal(schedules.seq[0].Schedules[0].Cron, "0 3 ? * SUN *"),
+	)
+}
+
+func TestDetectDrift(t *testing.T) {
+	deployed := func() *mockRegistry {
+		return &mockRegistry{
+			seq: []registry.Deployment{
+				{UID: "a", Tenant: "acme", Module: "m1", Version: "v1.0.0", Context: []byte(`{}`), Status: registry.STATUS_SUCCEEDED},
+				{UID: "b", Tenant: "acme", Module: "m1", Version: "v1.1.0", Context: []byte(`{}`), Status: registry.STATUS_FAILED},
+				{UID: "c", Tenant: "acme", Module: "m2", Version: "v1.0.0", Context: []byte(`{}`), Status: registry.STATUS_SUCCEEDED, Mode: events.MODE_APPROVAL},
+				{UID: "d", Tenant: "other", Module: "m1", Version: "v1.1.0", Context: []byte(`{}`), Status: registry.STATUS_SUCCEEDED},
+			},
+		}
+	}
+
+	// drift detection -> checked deployment and remediation per target of array job
+	for name, expect := range map[events.EventDriftDetection][]string{
+		{UID: "drift"}: {"m1 a ", "m2 c ", "m1 d "},
+		{UID: "drift", Tenant: "acme", Remediate: true}: {"m1 a deploy", "m2 c approval"},
+	} {
+		t.Run(name.Tenant, func(t *testing.T) {
+			db := deployed()
+			jobs := &mockJobs{}
+			storage := &mockStorage{}
+			service := New(
+				scheduler.New(jobs, "test-queue", "test-job", "test-s3",
+					scheduler.WithRegistry(db),
+					scheduler.WithStorage(storage),
+				),
+				&mockEmitter[events.EventRolloutProgress]{},
+				&mockEmitter[events.EventSchedules]{},
+				&mockEmitter[events.EventTenantTransition]{},
+			)
+
+			rcv := make(chan swarm.Msg[events.EventDriftDetection])
+			ack := make(chan swarm.Msg[events.EventDriftDetection])
+			go service.RunDriftDetection(rcv, ack)
+
+			rcv <- swarm.Msg[events.EventDriftDetection]{Category: "test", Object: name}
+			msg := <-ack
+
+			seq := make([]string, 0)
+			for _, line := range strings.Split(strings.TrimSpace(storage.body), "\n") {
+				var target struct {
+					Module    string `json:"module"`
+					Drift     string `json:"drift"`
+					Remediate string `json:"remediate"`
+				}
+				it.Then(t).Should(it.Nil(json.Unmarshal([]byte(line), &target)))
+				seq = append(seq, target.Module+" "+target.Drift+" "+target.Remediate)
+			}
+
+			it.Then(t).Should(
+				it.Nil(msg.Error),
+				it.Equal(len(jobs.seq), 1),
+				it.Equal(jobs.seq[0]["JOB_NAME"], "drift"),
+				it.Equal(jobs.seq[0]["ARRAY_SIZE"], strconv.Itoa(len(expect))),
+				it.Equal(jobs.seq[0]["CRAFT_MODE"], events.MODE_DRIFT),
+				it.Equal(jobs.seq[0]["CRAFT_ARRAY"], "craft/contexts/drift.jsonl"),
+				it.Seq(seq).Equal(expect...),
+				it.Equal(len(db.seq), 4),
+			)
+		})
+	}
+}
+
+func TestDetectDriftSingle(t *testing.T) {
+	// drift detection -> submitted jobs
+	for name, expect := range map[events.EventDriftDetection][]string{
+		{UID: "drift", Tenant: "other", Remediate: true}: {"drift-0 d deploy"},
+		{UID: "drift", Tenant: "unknown"}:                {},
+	} {
+		t.Run(name.Tenant, func(t *testing.T) {
+			db := &mockRegistry{
+				seq: []registry.Deployment{
+					{UID: "a", Tenant: "acme", Module: "m1", Version: "v1.0.0", Context: []byte(`{}`), Status: registry.STATUS_SUCCEEDED},
+					{UID: "d", Tenant: "other", Module: "m1", Version: "v1.1.0", Context: []byte(`{}`), Status: registry.STATUS_SUCCEEDED},
+				},
+			}
+			jobs := &mockJobs{}
+			service := New(
+				scheduler.New(jobs, "test-queue", "test-job", "test-s3", scheduler.WithRegistry(db)),
+				&mockEmitter[events.EventRolloutProgress]{},
+				&mockEmitter[events.EventSchedules]{},
+				&mockEmitter[events.EventTenantTransition]{},
+			)
+
+			rcv := make(chan swarm.Msg[events.EventDriftDetection])
+			ack := make(chan swarm.Msg[events.EventDriftDetection])
+			go service.RunDriftDetection(rcv, ack)
+
+			rcv <- swarm.Msg[events.EventDriftDetection]{Category: "test", Object: name}
+			msg := <-ack
+
+			seq := make([]string, 0)
+			for _, env := range jobs.seq {
+				it.Then(t).Should(it.Equal(env["CRAFT_MODE"], events.MODE_DRIFT))
+				seq = append(seq, env["JOB_NAME"]+" "+env["CRAFT_DRIFT_DEPLOYMENT"]+" "+env["CRAFT_DRIFT_REMEDIATE"])
+			}
+
+			it.Then(t).Should(
+				it.Nil(msg.Error),
+				it.Seq(seq).Equal(expect...),
+			)
+		})
+	}
+}
+
+func TestSubmitJobDrift(t *testing.T) {
+	jobs := &mockJobs{}
+	service := New(
+		scheduler.New(jobs, "test-queue", "test-job", "test-s3"),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+
+	rcv := make(chan swarm.Msg[events.EventCraft])
+	ack := make(chan swarm.Msg[events.EventCraft])
+	go service.Run(rcv, ack)
+
+	evt := eventCraft
+	evt.Mode = events.MODE_DRIFT
+	rcv <- swarm.Msg[events.EventCraft]{Category: "test", Object: evt}
+	msg := <-ack
+
+	it.Then(t).ShouldNot(
+		it.Nil(msg.Error),
+	).Should(
+		it.Equal(len(jobs.seq), 0),
+	)
+}
+
+func TestReconcile(t *testing.T) {
+	later := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	state := &mockDesired{
+		seq: map[string]desired.State{
+			"acme m1":  {Tenant: "acme", Module: "m1", Version: "v2", Context: []byte(`{"a": 1, "b": 2}`)},
+			"beta m1":  {Tenant: "beta", Module: "m1", Version: "v1", Context: []byte(`{}`), Attempts: 1, Retry: later},
+			"delta m1": {Tenant: "delta", Module: "m1", Version: "v1", Context: []byte(`{}`), Attempts: 2},
+			"gamma m1": {Tenant: "gamma", Module: "m1", Version: "v2", Context: []byte(`{}`)},
+			"zeta m1":  {Tenant: "zeta", Module: "m1", Version: "v1", Context: []byte(`{"a": 1}`)},
+		},
+	}
+	db := &mockRegistry{
+		seq: []registry.Deployment{
+			{UID: "a", Tenant: "acme", Module: "m1", Version: "v1", Context: []byte(`{"a": 1}`), Status: registry.STATUS_SUCCEEDED},
+			{UID: "b", Tenant: "acme", Module: "m1", Version: "v2", Context: []byte(`{"a": 1, "b": 2}`), Status: registry.STATUS_FAILED},
+			{UID: "c", Tenant: "beta", Module: "m1", Version: "v1", Context: []byte(`{}`), Status: registry.STATUS_FAILED},
+			{UID: "d", Tenant: "delta", Module: "m1", Version: "v1", Context: []byte(`{}`), Status: registry.STATUS_SUCCEEDED},
+			{UID: "e", Tenant: "gamma", Module: "m1", Version: "v2", Context: []byte(`{}`), Status: registry.STATUS_SCHEDULED},
+			{UID: "f", Tenant: "zeta", Module: "m1", Version: "v1", Context: []byte(`{"a":1}`), Status: registry.STATUS_SUCCEEDED},
+		},
+	}
+	jobs := &mockJobs{}
+	service := New(
+		scheduler.New(jobs, "test-queue", "test-job", "test-s3",
+			scheduler.WithRegistry(db),
+			scheduler.WithDesired(state),
+		),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+
+	rcv := make(chan swarm.Msg[events.EventReconcile])
+	ack := make(chan swarm.Msg[events.EventReconcile])
+	go service.RunReconcile(rcv, ack)
+
+	rcv <- swarm.Msg[events.EventReconcile]{Category: "test", Object: events.EventReconcile{UID: "reconcile"}}
+	msg := <-ack
+
+	it.Then(t).Should(
+		it.Nil(msg.Error),
+		it.Equal(len(jobs.seq), 1),
+		it.Equal(jobs.seq[0]["JOB_NAME"], "reconcile-0"),
+		it.Equal(jobs.seq[0]["CRAFT_TENANT"], "acme"),
+		it.Equal(jobs.seq[0]["CRAFT_MODULE_VERSION"], "v2"),
+		it.Equal(state.seq["acme m1"].Attempts, 1),
+		it.Equal(state.seq["beta m1"].Attempts, 1),
+		it.Equal(state.seq["delta m1"].Attempts, 0),
+		it.Equal(len(db.seq), 7),
+	)
+
+	// deployment of tenant declares its desired state
+	craft := make(chan swarm.Msg[events.EventCraft])
+	craftAck := make(chan swarm.Msg[events.EventCraft])
+	go service.Run(craft, craftAck)
+
+	craft <- swarm.Msg[events.EventCraft]{
+		Category: "test",
+		Object:   events.EventCraft{UID: "g", Tenant: "acme", Module: "m1", Version: "v3", Context: []byte(`{}`)},
+	}
+	req := <-craftAck
+
+	it.Then(t).Should(
+		it.Nil(req.Error),
+		it.Equal(state.seq["acme m1"].Version, "v3"),
+		it.Equal(state.seq["acme m1"].Attempts, 0),
+	)
+}
+
+func TestTenantSuspendFailed(t *testing.T) {
+	db := &mockRegistry{
+		seq: []registry.Deployment{
+			{UID: "p", Tenant: "acme", Module: "m1", Version: "v1", Context: []byte(`{}`), Status: registry.STATUS_SUCCEEDED},
+		},
+	}
+	jobs := &mockJobs{}
+	tenants := &mockTenants{
+		seq: map[string]tenant.Tenant{
+			"acme": {ID: "acme", Status: events.TENANT_ACTIVE, Transitions: make([]tenant.Transition, tenant.TRANSITIONS_MAX), Created: "2024-01-01T00:00:00Z"},
+		},
+	}
+	service := New(
+		scheduler.New(jobs, "test-queue", "test-job", "test-s3",
+			scheduler.WithRegistry(db),
+			scheduler.WithTenants(tenants),
+		),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+
+	suspend := make(chan swarm.Msg[events.EventTenantSuspend])
+	suspendAck := make(chan swarm.Msg[events.EventTenantSuspend])
+	go service.RunTenantSuspend(suspend, suspendAck)
+
+	deployment := make(chan swarm.Msg[events.EventDeployment])
+	deploymentAck := make(chan swarm.Msg[events.EventDeployment])
+	go service.RunDeployment(deployment, deploymentAck)
+
+	suspend <- swarm.Msg[events.EventTenantSuspend]{Category: "test", Object: events.EventTenantSuspend{UID: "s", Tenant: "acme"}}
+	sus := <-suspendAck
+
+	deployment <- swarm.Msg[events.EventDeployment]{
+		Category: "test",
+		Object:   events.EventDeployment{UID: "s-0", Tenant: "acme", Module: "m1", Status: registry.STATUS_FAILED, Reason: "stack failed", Lifecycle: events.TRANSITION_SUSPEND},
+	}
+	dep := <-deploymentAck
+
+	// failed suspension keeps the tenant active, history is capped
+	it.Then(t).Should(
+		it.Nil(sus.Error),
+		it.Nil(dep.Error),
+		it.Equal(len(jobs.seq), 1),
+		it.Equal(tenants.seq["acme"].Status, events.TENANT_ACTIVE),
+		it.Equal(len(tenants.seq["acme"].Pending), 0),
+		it.Equal(len(tenants.seq["acme"].Transitions), tenant.TRANSITIONS_MAX),
+		it.Equal(tenants.seq["acme"].Transitions[tenant.TRANSITIONS_MAX-1].Reason, "stack failed"),
+	)
+}
+
+func TestReconcileArray(t *testing.T) {
+	state := &mockDesired{
+		seq: map[string]desired.State{
+			"acme m1": {Tenant: "acme", Module: "m1", Version: "v2", Context: []byte(`{}`)},
+			"acme m2": {Tenant: "acme", Module: "m2", Version: "v1", Context: []byte(`{}`), Mode: events.MODE_DEPLOY},
+			"beta m1": {Tenant: "beta", Module: "m1", Version: "v2", Context: []byte(`{}`), Mode: events.MODE_APPROVAL},
+		},
+	}
+	db := &mockRegistry{}
+	jobs := &mockJobs{}
+	storage := &mockStorage{}
+	service := New(
+		scheduler.New(jobs, "test-queue", "test-job", "test-s3",
+			scheduler.WithRegistry(db),
+			scheduler.WithDesired(state),
+			scheduler.WithStorage(storage),
+		),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+
+	rcv := make(chan swarm.Msg[events.EventReconcile])
+	ack := make(chan swarm.Msg[events.EventReconcile])
+	go service.RunReconcile(rcv, ack)
+
+	rcv <- swarm.Msg[events.EventReconcile]{Category: "test", Object: events.EventReconcile{UID: "reconcile"}}
+	msg := <-ack
+
+	// tenants of deploy mode are reconciled by array job, single tenant of
+	// approval mode by standalone job
+	it.Then(t).Should(
+		it.Nil(msg.Error),
+		it.Equal(len(jobs.seq), 2),
+		it.Equal(jobs.seq[0]["JOB_NAME"], "reconcile"),
+		it.Equal(jobs.seq[0]["ARRAY_SIZE"], "2"),
+		it.Equal(jobs.seq[0]["CRAFT_ARRAY"], "craft/contexts/reconcile.jsonl"),
+		it.Equal(jobs.seq[1]["JOB_NAME"], "reconcile-approval-0"),
+		it.Equal(jobs.seq[1]["CRAFT_MODE"], events.MODE_APPROVAL),
+		it.Equal(jobs.seq[1]["CRAFT_TENANT"], "beta"),
+		it.Equal(storage.body,
+			`{"tenant":"acme","context":{},"module":"m1","version":"v2"}`+"\n"+
+				`{"tenant":"acme","context":{},"module":"m2","version":"v1"}`+"\n",
+		),
+		it.Equal(len(db.seq), 3),
+		it.Equal(db.seq[0].UID, "reconcile-0"),
+		it.Equal(db.seq[1].UID, "reconcile-1"),
+		it.Equal(db.seq[1].Status, registry.STATUS_SCHEDULED),
+		it.Equal(state.seq["acme m1"].Attempts, 1),
+		it.Equal(state.seq["beta m1"].Attempts, 1),
+	)
+}
+
+func TestTenantLifecycle(t *testing.T) {
+	db := &mockRegistry{}
+	jobs := &mockJobs{}
+	state := &mockDesired{}
+	tenants := &mockTenants{}
+	transitions := &mockEmitter[events.EventTenantTransition]{}
+	service := New(
+		scheduler.New(jobs, "test-queue", "test-job", "test-s3",
+			scheduler.WithRegistry(db),
+			scheduler.WithDesired(state),
+			scheduler.WithTenants(tenants),
+		),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		transitions,
+	)
+
+	deployment := make(chan swarm.Msg[events.EventDeployment])
+	deploymentAck := make(chan swarm.Msg[events.EventDeployment])
+	go service.RunDeployment(deployment, deploymentAck)
+
+	// completes deployments of the last submitted jobs
+	complete := func(status string) {
+		for _, env := range jobs.seq {
+			d, _ := db.Get(context.Background(), env["JOB_NAME"])
+			if d.Status != registry.STATUS_SCHEDULED {
+				continue
+			}
+			d.Status = status
+			db.seq = append(db.seq, *d)
+			deployment <- swarm.Msg[events.EventDeployment]{
+				Category: "test",
+				Object:   events.EventDeployment{UID: d.UID, Tenant: d.Tenant, Module: d.Module, Status: status, Lifecycle: d.Lifecycle},
+			}
+			<-deploymentAck
+		}
+	}
+
+	provision := make(chan swarm.Msg[events.EventTenantProvision])
+	provisionAck := make(chan swarm.Msg[events.EventTenantProvision])
+	go service.RunTenantProvision(provision, provisionAck)
+
+	provision <- swarm.Msg[events.EventTenantProvision]{
+		Category: "test",
+		Object:   events.EventTenantProvision{UID: "p", Tenant: "acme", Module: "m1", Version: "v1", Context: []byte(`{"a":1}`)},
+	}
+	msg := <-provisionAck
+	complete(registry.STATUS_SUCCEEDED)
+
+	it.Then(t).Should(
+		it.Nil(msg.Error),
+		it.Equal(len(jobs.seq), 1),
+		it.Equal(jobs.seq[0]["JOB_NAME"], "p"),
+		it.Equal(tenants.seq["acme"].Status, events.TENANT_ACTIVE),
+		it.Equal(len(transitions.seq), 2),
+		it.Equal(transitions.seq[1].To, events.TENANT_ACTIVE),
+	)
+
+	suspend := make(chan swarm.Msg[events.EventTenantSuspend])
+	suspendAck := make(chan swarm.Msg[events.EventTenantSuspend])
+	go service.RunTenantSuspend(suspend, suspendAck)
+
+	suspend <- swarm.Msg[events.EventTenantSuspend]{Category: "test", Object: events.EventTenantSuspend{UID: "s", Tenant: "acme"}}
+	sus := <-suspendAck
+
+	it.Then(t).Should(
+		it.Nil(sus.Error),
+		it.Equal(len(jobs.seq), 2),
+		it.Equal(jobs.seq[1]["JOB_NAME"], "s-0"),
+		it.Equal(jobs.seq[1]["CRAFT_CDK_CONTEXT"], `{"a":1,"suspended":true}`),
+		it.Equal(tenants.seq["acme"].Status, events.TENANT_ACTIVE),
+	)
+
+	// transition is not allowed while deployments are pending
+	suspend <- swarm.Msg[events.EventTenantSuspend]{Category: "test", Object: events.EventTenantSuspend{UID: "s", Tenant: "acme"}}
+	sus = <-suspendAck
+	it.Then(t).ShouldNot(it.Nil(sus.Error))
+
+	// the tenant is suspended once deployments are succeeded
+	complete(registry.STATUS_SUCCEEDED)
+	it.Then(t).Should(
+		it.Equal(tenants.seq["acme"].Status, events.TENANT_SUSPENDED),
+	)
+
+	deprovision := make(chan swarm.Msg[events.EventTenantDeprovision])
+	deprovisionAck := make(chan swarm.Msg[events.EventTenantDeprovision])
+	go service.RunTenantDeprovision(deprovision, deprovisionAck)
+
+	deprovision <- swarm.Msg[events.EventTenantDeprovision]{Category: "test", Object: events.EventTenantDeprovision{UID: "d", Tenant: "acme"}}
+	dep := <-deprovisionAck
+
+	it.Then(t).Should(
+		it.Nil(dep.Error),
+		it.Equal(len(jobs.seq), 3),
+		it.Equal(jobs.seq[2]["JOB_NAME"], "d-0"),
+		it.Equal(jobs.seq[2]["CRAFT_MODE"], events.MODE_DESTROY),
+		it.Equal(tenants.seq["acme"].Status, events.TENANT_DEPROVISIONING),
+		it.Equal(len(state.seq), 0),
+	)
+
+	// failed deployment fails the transition, the tenant stays deprovisioning
+	complete(registry.STATUS_FAILED)
+
+	it.Then(t).Should(
+		it.Equal(tenants.seq["acme"].Status, events.TENANT_DEPROVISIONING),
+		it.Equal(transitions.seq[len(transitions.seq)-1].UID, "d-0"),
+	)
+
+	deprovision <- swarm.Msg[events.EventTenantDeprovision]{Category: "test", Object: events.EventTenantDeprovision{UID: "e", Tenant: "acme"}}
+	dep = <-deprovisionAck
+	complete(registry.STATUS_SUCCEEDED)
+
+	it.Then(t).Should(
+		it.Nil(dep.Error),
+		it.Equal(len(jobs.seq), 4),
+		it.Equal(tenants.seq["acme"].Status, events.TENANT_DELETED),
+		it.Equal(len(tenants.seq["acme"].Transitions), 8),
+	)
+
+	// stacks are not destroyed out of deprovisioning
+	craft := make(chan swarm.Msg[events.EventCraft])
+	craftAck := make(chan swarm.Msg[events.EventCraft])
+	go service.Run(craft, craftAck)
+
+	craft <- swarm.Msg[events.EventCraft]{
+		Category: "test",
+		Object:   events.EventCraft{UID: "x", Tenant: "acme", Module: "m1", Context: []byte(`{}`), Mode: events.MODE_DESTROY},
+	}
+	req := <-craftAck
+	it.Then(t).ShouldNot(it.Nil(req.Error))
+}
+
+func TestDebounce(t *testing.T) {
+	db := &mockRegistry{}
+	jobs := &mockJobs{}
+	timer := &mockTimer{}
+	buffers := &mockDebounce{}
+	schedules := &mockEmitter[events.EventSchedules]{}
+	service := New(
+		scheduler.New(jobs, "test-queue", "test-job", "test-s3",
+			scheduler.WithRegistry(db),
+			scheduler.WithTimer(timer, "test-group", "arn:aws:events:eu-west-1:000000000000:event-bus/test-bus", "test-role"),
+			scheduler.WithDebounce(buffers, time.Minute),
+		),
+		&mockEmitter[events.EventRolloutProgress]{},
+		schedules,
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+
+	craft := make(chan swarm.Msg[events.EventCraft])
+	craftAck := make(chan swarm.Msg[events.EventCraft])
+	go service.Run(craft, craftAck)
+
+	for _, evt := range []events.EventCraft{
+		{UID: "a", Tenant: "acme", Module: "m1", Version: "v1", Context: []byte(`{"x":1,"y":{"a":1}}`)},
+		{UID: "b", Tenant: "acme", Module: "m1", Context: []byte(`{"y":{"b":2}}`)},
+		{UID: "c", Tenant: "acme", Module: "m1", Version: "v2", Context: []byte(`{"x":null,"z":3}`)},
+		{UID: "b", Tenant: "acme", Module: "m1", Context: []byte(`{"y":{"b":2}}`)},
+	} {
+		craft <- swarm.Msg[events.EventCraft]{Category: "test", Object: evt}
+		msg := <-craftAck
+		it.Then(t).Should(it.Nil(msg.Error))
+	}
+
+	it.Then(t).Should(
+		it.Equal(len(jobs.seq), 0),
+		it.Equal(len(timer.schedules), 1),
+		it.Equal(aws.ToString(timer.schedules[scheduler.SCHEDULE_DEBOUNCE+"a"].Target.EventBridgeParameters.DetailType), "EventDebounce"),
+	)
+
+	// debounce window is not a delayed deployment
+	list := make(chan swarm.Msg[events.EventScheduleList])
+	listAck := make(chan swarm.Msg[events.EventScheduleList])
+	go service.RunScheduleList(list, listAck)
+
+	list <- swarm.Msg[events.EventScheduleList]{Category: "test", Object: events.EventScheduleList{UID: "list"}}
+	<-listAck
+	it.Then(t).Should(it.Equal(len(schedules.seq[0].Schedules), 0))
+
+	closer := make(chan swarm.Msg[events.EventDebounce])
+	closerAck := make(chan swarm.Msg[events.EventDebounce])
+	go service.RunDebounce(closer, closerAck)
+
+	for i := 0; i < 2; i++ {
+		closer <- swarm.Msg[events.EventDebounce]{Category: "test", Object: events.EventDebounce{UID: "a", Tenant: "acme", Module: "m1"}}
+		msg := <-closerAck
+		it.Then(t).Should(it.Nil(msg.Error))
+	}
+
+	it.Then(t).Should(
+		it.Equal(len(jobs.seq), 1),
+		it.Equal(jobs.seq[0]["JOB_NAME"], "a"),
+		it.Equal(jobs.seq[0]["CRAFT_MODULE_VERSION"], "v2"),
+		it.Equal(jobs.seq[0]["CRAFT_CDK_CONTEXT"], `{"y":{"a":1,"b":2},"z":3}`),
+		it.Equal(jobs.seq[0]["CRAFT_COALESCED"], "a b c"),
+		it.Seq(db.seq[0].Coalesced).Equal("a", "b", "c"),
+	)
+}
+
+func TestSequence(t *testing.T) {
+	db := &mockRegistry{}
+	jobs := &mockJobs{}
+	service := New(
+		scheduler.New(jobs, "test-queue", "test-job", "test-s3",
+			scheduler.WithRegistry(db),
+			scheduler.WithSequence(&mockSequence{}),
+		),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+
+	craft := make(chan swarm.Msg[events.EventCraft])
+	craftAck := make(chan swarm.Msg[events.EventCraft])
+	go service.Run(craft, craftAck)
+
+	for _, evt := range []events.EventCraft{
+		{UID: "b", Tenant: "acme", Module: "m1", Version: "v2", Context: []byte(`{}`), Seq: 2},
+		{UID: "a", Tenant: "acme", Module: "m1", Version: "v1", Context: []byte(`{}`), Seq: 1},
+		{UID: "b", Tenant: "acme", Module: "m1", Version: "v2", Context: []byte(`{}`), Seq: 2},
+		{UID: "c", Tenant: "acme", Module: "m2", Version: "v1", Context: []byte(`{}`), Seq: 1},
+		{UID: "d", Tenant: "acme", Module: "m1", Version: "v3", Context: []byte(`{}`)},
+	} {
+		craft <- swarm.Msg[events.EventCraft]{Category: "test", Object: evt}
+		msg := <-craftAck
+		it.Then(t).Should(it.Nil(msg.Error))
+	}
+
+	// redelivered deployment is accepted but not submitted again
+	it.Then(t).Should(
+		it.Equal(len(jobs.seq), 3),
+		it.Equal(jobs.seq[0]["JOB_NAME"], "b"),
+		it.Equal(jobs.seq[1]["JOB_NAME"], "c"),
+		it.Equal(jobs.seq[2]["JOB_NAME"], "d"),
+		it.Equal(db.seq[1].UID, "a"),
+		it.Equal(db.seq[1].Status, registry.STATUS_PARKED),
+		it.Equal(db.seq[1].Seq, 1),
+	)
+
+	tenants, _ := db.Tenants(context.Background(), "m1")
+	it.Then(t).Should(
+		it.Equal(len(tenants), 1),
+		it.Equal(tenants[0].UID, "d"),
+	)
+}
+
+func TestSequenceRetry(t *testing.T) {
+	// the deployment "a" is claimed but its job is not submitted before the
+	// lease is expired, the sequence is advanced by newer deployment since then
+	db := &mockRegistry{seq: []registry.Deployment{
+		{UID: "a", Tenant: "acme", Module: "m1", Status: registry.STATUS_SCHEDULED, Seq: 1},
+	}}
+	jobs := &mockJobs{}
+	service := New(
+		scheduler.New(jobs, "test-queue", "test-job", "test-s3",
+			scheduler.WithRegistry(db),
+			scheduler.WithSequence(&mockSequence{seq: map[string]int64{"acme m1": 2}}),
+		),
+		&mockEmitter[events.EventRolloutProgress]{},
+		&mockEmitter[events.EventSchedules]{},
+		&mockEmitter[events.EventTenantTransition]{},
+	)
+
+	craft := make(chan swarm.Msg[events.EventCraft])
+	craftAck := make(chan swarm.Msg[events.EventCraft])
+	go service.Run(craft, craftAck)
+
+	// the retry is not parked, it is submitted
+	craft <- swarm.Msg[events.EventCraft]{Category: "test", Object: events.EventCraft{UID: "a", Tenant: "acme", Module: "m1", Context: []byte(`{}`), Seq: 1}}
+	msg := <-craftAck
+	it.Then(t).Should(
+		it.Nil(msg.Error),
+		it.Equal(len(jobs.seq), 1),
+		it.Equal(jobs.seq[0]["JOB_NAME"], "a"),
+		it.Equal(db.seq[len(db.seq)-1].Status, registry.STATUS_SCHEDULED),
+	)
+}
+
+func TestCorruptedEvents(t *testing.T) {
+	for name, evt := range map[string]events.EventCraft{
+		"Undefined":   eventUndefined,
+		"WrongType":   eventWrongType,
+		"UnknownMode": eventUnknownMode,
+	} {
+		t.Run(name, func(t *testing.T) {
+			service := mockService()
+
+			rcv := make(chan swarm.Msg[events.EventCraft])
+			ack := make(chan swarm.Msg[events.EventCraft])
+			go service.Run(rcv, ack)
+
+			rcv <- swarm.Msg[events.EventCraft]{
+				Category: "test",
+				Object:   evt,
+			}
+			msg := <-ack
+			it.Then(t).ShouldNot(it.Nil(msg.Error))
+		})
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func mockService(env ...types.KeyValuePair) *Service {
+	return mockServiceWith(nil, env...)
+}
+
+func mockServiceWith(opts []scheduler.Option, env ...types.KeyValuePair) *Service {
+	batch := &mock{
+		returnVal: &batch.SubmitJobOutput{},
+		expectVal: &batch.SubmitJobInput{
+			JobDefinition: aws.String("test-job"),
+			JobQueue:      aws.String("test-queue"),
+			ContainerOverrides: &types.ContainerOverrides{
+				Environment: append([]types.KeyValuePair{
+					{Name: aws.String("CRAFT_UID"), Value: aws.String(eventCraft.UID)},
+					{Name: aws.String("CRAFT_BUCKET"), Value: aws.String("test-s3")},
+					{Name: aws.String("CRAFT_MODULE"), Value: aws.String("github.com/fogfish/craft")},
+					{Name: aws.String("CRAFT_CDK_CONTEXT"), Value: aws.String(string(eventCraft.Context))},
+				}, env...),
+			},
+		},
+	}
+
+	scheduler := scheduler.New(batch, "test-queue", "test-job", "test-s3",
+		append(opts, scheduler.WithAccounts("111111111111"))...,
+	)
+
+	return New(scheduler, &mockEmitter[events.EventRolloutProgress]{}, &mockEmitter[events.EventSchedules]{}, &mockEmitter[events.EventTenantTransition]{})
+}
+
+func mockBootstrap(opts ...scheduler.Option) *Service {
+	batch := &mock{
+		returnVal: &batch.SubmitJobOutput{},
+		expectVal: &batch.SubmitJobInput{
+			JobDefinition: aws.String("test-bootstrap"),
+			JobQueue:      aws.String("test-queue"),
+			ContainerOverrides: &types.ContainerOverrides{
+				Environment: []types.KeyValuePair{
+					{Name: aws.String("CRAFT_UID"), Value: aws.String(eventBootstrap.UID)},
+					{Name: aws.String("CRAFT_BUCKET"), Value: aws.String("test-s3")},
+					{Name: aws.String("CRAFT_TARGET_ACCOUNT"), Value: aws.String(eventBootstrap.Account)},
+					{Name: aws.String("CRAFT_QUALIFIER"), Value: aws.String(eventBootstrap.Qualifier)},
+					{Name: aws.String("CRAFT_TRUST"), Value: aws.String("222222222222,333333333333")},
+				},
+			},
+		},
+	}
+
+	scheduler := scheduler.New(batch, "test-queue", "test-job", "test-s3",
+		append(opts, scheduler.WithJobBootstrap("test-bootstrap"))...,
+	)
+
+	return New(scheduler, &mockEmitter[events.EventRolloutProgress]{}, &mockEmitter[events.EventSchedules]{}, &mockEmitter[events.EventTenantTransition]{})
+}
+
+type mock struct {
+	expectVal *batch.SubmitJobInput
+	returnVal *batch.SubmitJobOutput
+}
+
+func (m *mock) SubmitJob(ctx context.Context, params *batch.SubmitJobInput, optFns ...func(*batch.Options)) (*batch.SubmitJobOutput, error) {
+	if aws.ToString(params.JobQueue) != aws.ToString(m.expectVal.JobQueue) {
+		return nil, fmt.Errorf("unexpected job queue")
+	}
+
+	if aws.ToString(params.JobDefinition) != aws.ToString(m.expectVal.JobDefinition) {
+		return nil, fmt.Errorf("unexpected job definition")
+	}
+
+	if m.expectVal.ArrayProperties != nil && (params.ArrayProperties == nil ||
+		aws.ToInt32(params.ArrayProperties.Size) != aws.ToInt32(m.expectVal.ArrayProperties.Size)) {
+		return nil, fmt.Errorf("unexpected array properties")
+	}
+
+	if m.expectVal.ContainerOverrides.Command != nil &&
+		strings.Join(params.ContainerOverrides.Command, " ") != strings.Join(m.expectVal.ContainerOverrides.Command, " ") {
+		return nil, fmt.Errorf("unexpected command override: %v", params.ContainerOverrides.Command)
+	}
+
+	env := map[string]string{}
+	for _, e := range params.ContainerOverrides.Environment {
+		env[aws.ToString(e.Name)] = aws.ToString(e.Value)
+	}
+	for _, e := range m.expectVal.ContainerOverrides.Environment {
+		key := aws.ToString(e.Name)
+		if x, has := env[key]; !has || x != aws.ToString(e.Value) {
+			return nil, fmt.Errorf("unexpected environment override: %s, %s", key, x)
+		}
+	}
+
+	return m.returnVal, nil
+}
+
+// in-memory registry, deployments are appended in chronological order
+type mockRegistry struct {
+	seq []registry.Deployment
+}
+
+func (m *mockRegistry) Put(ctx context.Context, d registry.Deployment) error {
+	m.seq = append(m.seq, d)
+	return nil
+}
+
+func (m *mockRegistry) Claim(ctx context.Context, d registry.Deployment) error {
+	if x, err := m.Get(ctx, d.UID); err == nil {
+		switch {
+		case x.Status == registry.STATUS_FAILED || x.Status == registry.STATUS_DISCARDED:
+		case x.Status == registry.STATUS_SCHEDULED && x.Job == "" &&
+			x.Updated < time.Now().UTC().Add(-registry.CLAIM_LEASE).Format(time.RFC3339Nano):
+		default:
+			return registry.ErrConflict
+		}
+	}
+	m.seq = append(m.seq, d)
+	return nil
+}
+
+func (m *mockRegistry) Attach(ctx context.Context, uid, job string) error {
+	for i := len(m.seq) - 1; i >= 0; i-- {
+		if m.seq[i].UID == uid {
+			m.seq[i].Job = job
+			return nil
+		}
+	}
+	return registry.ErrNotFound
+}
+
+func (m *mockRegistry) Transit(ctx context.Context, uid, from, to string) error {
+	for i := len(m.seq) - 1; i >= 0; i-- {
+		if m.seq[i].UID == uid {
+			if m.seq[i].Status != from {
+				return registry.ErrConflict
+			}
+			m.seq[i].Status = to
+			return nil
+		}
+	}
+	return registry.ErrConflict
+}
+
+func (m *mockRegistry) Get(ctx context.Context, uid string) (*registry.Deployment, error) {
+	for i := len(m.seq) - 1; i >= 0; i-- {
+		if m.seq[i].UID == uid {
+			d := m.seq[i]
+			return &d, nil
+		}
+	}
+	return nil, registry.ErrNotFound
+}
+
+func (m *mockRegistry) Tenants(ctx context.Context, module string) ([]registry.Deployment, error) {
+	seq := make([]registry.Deployment, 0)
+	has := map[string]struct{}{}
+	for i := len(m.seq) - 1; i >= 0; i-- {
+		if _, exists := has[m.seq[i].Tenant]; !exists && m.seq[i].Module == module && m.seq[i].Status != registry.STATUS_PARKED {
+			has[m.seq[i].Tenant] = struct{}{}
+			seq = append([]registry.Deployment{m.seq[i]}, seq...)
+		}
+	}
+	return seq, nil
+}
+
+func (m *mockRegistry) History(ctx context.Context, tenant string) ([]registry.Deployment, error) {
+	seq := make([]registry.Deployment, 0)
+	for i := len(m.seq) - 1; i >= 0; i-- {
+		if m.seq[i].Tenant == tenant {
+			seq = append(seq, m.seq[i])
+		}
+	}
+	return seq, nil
+}
+
+func (m *mockRegistry) Deployed(ctx context.Context, tenant string) ([]registry.Deployment, error) {
+	seq := make([]registry.Deployment, 0)
+	has := map[string]int{}
+	for _, d := range m.seq {
+		if d.Status != registry.STATUS_SUCCEEDED || d.Tenant == "" || (tenant != "" && d.Tenant != tenant) {
+			continue
+		}
+		if i, exists := has[d.Tenant+d.Module]; exists {
+			seq[i] = d
+			continue
+		}
+		has[d.Tenant+d.Module] = len(seq)
+		seq = append(seq, d)
+	}
+	return seq, nil
+}
+
+// in-memory store of rollouts, it keeps copies as the real storage does
+type mockFleet struct {
+	seq map[string]fleet.Rollout
+}
+
+func (m *mockFleet) Create(ctx context.Context, r *fleet.Rollout) error {
+	if m.seq == nil {
+		m.seq = map[string]fleet.Rollout{}
+	}
+	if _, has := m.seq[r.UID]; has {
+		return fleet.ErrConflict
+	}
+	return m.Update(ctx, r)
+}
+
+func (m *mockFleet) Update(ctx context.Context, r *fleet.Rollout) error {
+	c := *r
+	c.Targets = append([]fleet.Target{}, r.Targets...)
+	m.seq[r.UID] = c
+	return nil
+}
+
+func (m *mockFleet) Get(ctx context.Context, uid string) (*fleet.Rollout, error) {
+	r, has := m.seq[uid]
+	if !has {
+		return nil, fleet.ErrNotFound
+	}
+	r.Targets = append([]fleet.Target{}, r.Targets...)
+	return &r, nil
+}
+
+type mockEmitter[T any] struct {
+	seq []T
+}
+
+func (m *mockEmitter[T]) Enq(ctx context.Context, evt T, cat ...string) error {
+	m.seq = append(m.seq, evt)
+	return nil
+}
+
+type mockStorage struct {
+	key  string
+	body string
+}
+
+func (m *mockStorage) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	m.key = aws.ToString(params.Key)
+	m.body = string(body)
+	return &s3.PutObjectOutput{}, nil
+}
+
+// records graph of submitted jobs as "name[depends on] references"
+// mock of job queue, it records job dependencies. Submit of the job named
+// by fail is failed once.
+type mockGraph struct {
+	seq  []string
+	fail string
+}
+
+func (m *mockGraph) SubmitJob(ctx context.Context, params *batch.SubmitJobInput, optFns ...func(*batch.Options)) (*batch.SubmitJobOutput, error) {
+	if m.fail != "" && m.fail == aws.ToString(params.JobName) {
+		m.fail = ""
+		return nil, fmt.Errorf("job queue is not available")
+	}
+
+	deps := make([]string, 0)
+	for _, dep := range params.DependsOn {
+		deps = append(deps, aws.ToString(dep.JobId))
+	}
+
+	refs := ""
+	for _, e := range params.ContainerOverrides.Environment {
+		if aws.ToString(e.Name) == "CRAFT_DEPENDS" {
+			refs = aws.ToString(e.Value)
+		}
+	}
+
+	m.seq = append(m.seq,
+		fmt.Sprintf("%s[%s] %s", aws.ToString(params.JobName), strings.Join(deps, " "), refs),
+	)
+
+	return &batch.SubmitJobOutput{JobId: params.JobName}, nil
+}
+
+// in-memory store of desired states
+type mockDesired struct {
+	seq map[string]desired.State
+}
+
+func (m *mockDesired) Put(ctx context.Context, state *desired.State) error {
+	if m.seq == nil {
+		m.seq = map[string]desired.State{}
+	}
+	state.Reset()
+	m.seq[state.Tenant+" "+state.Module] = *state
+	return nil
+}
+
+func (m *mockDesired) Update(ctx context.Context, state *desired.State) error {
+	m.seq[state.Tenant+" "+state.Module] = *state
+	return nil
+}
+
+func (m *mockDesired) Delete(ctx context.Context, tenant, module string) error {
+	delete(m.seq, tenant+" "+module)
+	return nil
+}
+
+func (m *mockDesired) List(ctx context.Context) ([]desired.State, error) {
+	seq := make([]desired.State, 0)
+	for _, state := range m.seq {
+		seq = append(seq, state)
+	}
+	sort.Slice(seq, func(i, j int) bool {
+		return seq[i].Tenant < seq[j].Tenant || (seq[i].Tenant == seq[j].Tenant && seq[i].Module < seq[j].Module)
+	})
+	return seq, nil
+}
+
+// in-memory store of tenants, it keeps copies as the real storage does
+type mockTenants struct {
+	seq map[string]tenant.Tenant
+}
+
+func (m *mockTenants) Create(ctx context.Context, t *tenant.Tenant) error {
+	if m.seq == nil {
+		m.seq = map[string]tenant.Tenant{}
+	}
+	if _, has := m.seq[t.ID]; has {
+		return tenant.ErrConflict
+	}
+	t.Created = time.Now().UTC().Format(time.RFC3339Nano)
+	return m.Update(ctx, t)
+}
+
+func (m *mockTenants) Update(ctx context.Context, t *tenant.Tenant) error {
+	c := *t
+	c.Pending = append([]string{}, t.Pending...)
+	c.Transitions = append([]tenant.Transition{}, t.Transitions...)
+	m.seq[t.ID] = c
+	return nil
+}
+
+func (m *mockTenants) Get(ctx context.Context, id string) (*tenant.Tenant, error) {
+	t, has := m.seq[id]
+	if !has {
+		return nil, tenant.ErrNotFound
+	}
+	t.Pending = append([]string{}, t.Pending...)
+	t.Transitions = append([]tenant.Transition{}, t.Transitions...)
+	return &t, nil
+}
+
+// in-memory store of debounce buffers, it keeps copies as the real storage does
+type mockDebounce struct {
+	seq map[string]debounce.Buffer
+}
+
+func (m *mockDebounce) Append(ctx context.Context, b *debounce.Buffer) (*debounce.Buffer, error) {
+	if m.seq == nil {
+		m.seq = map[string]debounce.Buffer{}
+	}
+
+	stored, has := m.seq[b.Tenant+" "+b.Module]
+	if !has {
+		stored = *b
+	} else if err := stored.Merge(b); err != nil {
+		return nil, err
+	}
+
+	stored.UIDs = append([]string{}, stored.UIDs...)
+	m.seq[b.Tenant+" "+b.Module] = stored
+	return &stored, nil
+}
+
+func (m *mockDebounce) Take(ctx context.Context, tenant, module string) (*debounce.Buffer, error) {
+	b, has := m.seq[tenant+" "+module]
+	if !has {
+		return nil, debounce.ErrNotFound
+	}
+	delete(m.seq, tenant+" "+module)
+	return &b, nil
+}
+
+// registry fails to record jobs of submitted deployments
+type mockRegistryAttachFailed struct {
+	*mockRegistry
+}
+
+func (m mockRegistryAttachFailed) Attach(ctx context.Context, uid, job string) error {
+	return fmt.Errorf("failed to attach %s", job)
+}
+
+// registry, whose deployments are decided by concurrent events
+type mockRegistryDecided struct {
+	*mockRegistry
+}
+
+func (m mockRegistryDecided) Transit(ctx context.Context, uid, from, to string) error {
+	return registry.ErrConflict
+}
+
+type mockJobsFailed struct{}
+
+func (mockJobsFailed) SubmitJob(ctx context.Context, params *batch.SubmitJobInput, optFns ...func(*batch.Options)) (*batch.SubmitJobOutput, error) {
+	return nil, fmt.Errorf("failed to submit %s", aws.ToString(params.JobName))
+}
+
+type mockLedger struct {
+	seq []string
+}
+
+func (m *mockLedger) Has(ctx context.Context, category, uid string) (bool, error) {
+	return slices.Contains(m.seq, category+" "+uid), nil
+}
+
+func (m *mockLedger) Put(ctx context.Context, category, uid string) error {
+	m.seq = append(m.seq, category+" "+uid)
+	return nil
+}
+
+type mockSequence struct {
+	seq map[string]int64
+}
+
+func (m *mockSequence) Advance(ctx context.Context, tenant, module string, seq int64) error {
+	if m.seq == nil {
+		m.seq = map[string]int64{}
+	}
+
+	if last, has := m.seq[tenant+" "+module]; has && last > seq {
+		return sequence.ErrStale
+	}
+	m.seq[tenant+" "+module] = seq
+	return nil
+}
+
+// records environment of submitted jobs
+type mockJobs struct {
+	seq []map[string]string
+}
+
+func (m *mockJobs) SubmitJob(ctx context.Context, params *batch.SubmitJobInput, optFns ...func(*batch.Options)) (*batch.SubmitJobOutput, error) {
+	env := map[string]string{"JOB_NAME": aws.ToString(params.JobName)}
+	if params.ArrayProperties != nil {
+		env["ARRAY_SIZE"] = strconv.Itoa(int(aws.ToInt32(params.ArrayProperties.Size)))
+	}
+	for _, e := range params.ContainerOverrides.Environment {
+		env[aws.ToString(e.Name)] = aws.ToString(e.Value)
+	}
+	m.seq = append(m.seq, env)
+
+	return &batch.SubmitJobOutput{JobId: params.JobName}, nil
+}
+
+type mockTimer struct {
+	schedules map[string]*awsscheduler.CreateScheduleInput
+}
+
+func (m *mockTimer) CreateSchedule(ctx context.Context, params *awsscheduler.CreateScheduleInput, optFns ...func(*awsscheduler.Options)) (*awsscheduler.CreateScheduleOutput, error) {
+	if aws.ToString(params.GroupName) != "test-group" || aws.ToString(params.Target.RoleArn) != "test-role" {
+		return nil, fmt.Errorf("unexpected schedule")
+	}
+
+	if m.schedules == nil {
+		m.schedules = map[string]*awsscheduler.CreateScheduleInput{}
+	}
+	m.schedules[aws.ToString(params.Name)] = params
+	return &awsscheduler.CreateScheduleOutput{}, nil
+}
+
+func (m *mockTimer) DeleteSchedule(ctx context.Context, params *awsscheduler.DeleteScheduleInput, optFns ...func(*awsscheduler.Options)) (*awsscheduler.DeleteScheduleOutput, error) {
+	if _, has := m.schedules[aws.ToString(params.Name)]; !has {
+		return nil, &schedtypes.ResourceNotFoundException{}
+	}
+
+	delete(m.schedules, aws.ToString(params.Name))
+	return &awsscheduler.DeleteScheduleOutput{}, nil
+}
+
+func (m *mockTimer) GetSchedule(ctx context.Context, params *awsscheduler.GetScheduleInput, optFns ...func(*awsscheduler.Options)) (*awsscheduler.GetScheduleOutput, error) {
+	schedule, has := m.schedules[aws.ToString(params.Name)]
+	if !has {
+		return nil, &schedtypes.ResourceNotFoundException{}
+	}
+
+	return &awsscheduler.GetScheduleOutput{Name: schedule.Name, Target: schedule.Target}, nil
+}
+
+func (m *mockTimer) ListSchedules(ctx context.Context, params *awsscheduler.ListSchedulesInput, optFns ...func(*awsscheduler.Options)) (*awsscheduler.ListSchedulesOutput, error) {
+	seq := make([]schedtypes.ScheduleSummary, 0)
+	for name := range m.schedules {
+		seq = append(seq, schedtypes.ScheduleSummary{Name: aws.String(name)})
+	}
+	return &awsscheduler.ListSchedulesOutput{Schedules: seq}, nil
 }
@@ -0,0 +1,122 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/mapping"
+	_ "github.com/fogfish/logger/v3"
+	"github.com/fogfish/swarm"
+	"github.com/fogfish/swarm/broker/eventbridge"
+	"github.com/fogfish/swarm/dequeue"
+	"github.com/fogfish/swarm/enqueue"
+)
+
+func main() {
+	// Offline evaluation of rules, the event is read from stdin
+	//   go run ./internal/cmd/lambda/mapper -rules rules.json < event.json
+	file := flag.String("rules", "", "evaluate rules of the file against the event at stdin")
+	flag.Parse()
+
+	if *file != "" {
+		if err := offline(*file, os.Stdin, os.Stdout); err != nil {
+			slog.Error("failed to apply rules", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	aws, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		slog.Error("fatal failure of aws client", "err", err)
+		panic(err)
+	}
+
+	// Rules are loaded from S3 at cold start
+	rules, err := mapping.Load(context.Background(),
+		s3.NewFromConfig(aws),
+		os.Getenv("CONFIG_S3"),
+		os.Getenv("CONFIG_RULES"),
+	)
+	if err != nil {
+		slog.Error("fatal failure of rules", "err", err)
+		panic(err)
+	}
+
+	// Mapped events are emitted to the craft's bus
+	bus := os.Getenv("CONFIG_EVENT_BUS")
+	e, err := eventbridge.NewEnqueuer(bus,
+		eventbridge.WithConfig(
+			swarm.WithSource(bus),
+			swarm.WithLogStdErr(),
+		),
+	)
+	if err != nil {
+		slog.Error("fatal failure of eventbrige client", "err", err)
+		panic(err)
+	}
+
+	// Run event consumption loop
+	q, err := eventbridge.NewDequeuer(bus,
+		eventbridge.WithConfig(
+			swarm.WithLogStdErr(),
+		),
+	)
+	if err != nil {
+		slog.Error("fatal failure of eventbrige client", "err", err)
+		panic(err)
+	}
+
+	service := New(rules, enqueue.NewTyped[events.EventCraft](e))
+	go service.Run(dequeue.Bytes(q, mapping.CATEGORY))
+
+	q.Await()
+}
+
+func offline(file string, r io.Reader, w io.Writer) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	rules, err := mapping.Parse(b)
+	if err != nil {
+		return err
+	}
+
+	evt, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	seq, err := rules.Apply(evt)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	for _, craft := range seq {
+		if err := enc.Encode(craft); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
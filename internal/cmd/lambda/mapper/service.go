@@ -0,0 +1,88 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/mapping"
+	"github.com/fogfish/swarm"
+)
+
+type Emitter[T any] interface {
+	Enq(ctx context.Context, evt T, cat ...string) error
+}
+
+type Service struct {
+	rules  *mapping.Rules
+	crafts Emitter[events.EventCraft]
+
+	// identity of events emitted by the instance, the retry of business
+	// event does not emit them again.
+	emitted map[string]struct{}
+}
+
+// Number of emitted events remembered by the instance
+const EMITTED_MAX = 10000
+
+func New(rules *mapping.Rules, crafts Emitter[events.EventCraft]) *Service {
+	return &Service{
+		rules:   rules,
+		crafts:  crafts,
+		emitted: map[string]struct{}{},
+	}
+}
+
+func (s *Service) Run(rcv <-chan swarm.Msg[[]byte], ack chan<- swarm.Msg[[]byte]) {
+	for msg := range rcv {
+		if err := s.Handle(context.Background(), msg.Object); err != nil {
+			ack <- msg.Fail(err)
+			continue
+		}
+
+		ack <- msg
+	}
+}
+
+// Handle business event as delivered by AWS EventBridge, it emits
+// EventCraft of each matching rule. Failed rules are logged only,
+// the retry of the event does not fix them. The retry emits only events,
+// which have failed, the gateway discards duplicates of other instances.
+func (s *Service) Handle(ctx context.Context, evt json.RawMessage) error {
+	seq, err := s.rules.Apply(evt)
+	if err != nil {
+		slog.Error("failed to apply rules", "evt", evt, "err", err)
+	}
+
+	errs := make([]error, 0)
+	for _, craft := range seq {
+		if _, has := s.emitted[craft.UID]; has {
+			continue
+		}
+
+		if err := s.crafts.Enq(ctx, craft); err != nil {
+			slog.Error("failed to emit event", "uid", craft.UID, "err", err)
+			errs = append(errs, err)
+			continue
+		}
+
+		if len(s.emitted) >= EMITTED_MAX {
+			clear(s.emitted)
+		}
+		s.emitted[craft.UID] = struct{}{}
+
+		slog.Info("event mapped", "uid", craft.UID, "module", craft.Module, "tenant", craft.Tenant)
+	}
+
+	return errors.Join(errs...)
+}
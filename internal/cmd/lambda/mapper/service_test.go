@@ -0,0 +1,151 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/mapping"
+	"github.com/fogfish/it/v2"
+)
+
+const rules = `{
+  "rules": [
+    {
+      "name": "subscription",
+      "source": "billing",
+      "detailType": "SubscriptionCreated",
+      "when": "detail.plan != 'free'",
+      "module": "github.com/acme/app",
+      "uid": "join('-', ['sub', id])",
+      "tenant": "detail.customerId",
+      "version": "'v1.2.3'",
+      "context": "{plan: detail.plan, seats: detail.seats}"
+    },
+    {
+      "name": "plan",
+      "source": "billing",
+      "detailType": "PlanChanged",
+      "module": "github.com/acme/app",
+      "tenant": "detail.customerId",
+      "context": "detail.plan"
+    }
+  ]
+}`
+
+func TestMapping(t *testing.T) {
+	for evt, expect := range map[string][]events.EventCraft{
+		`{"id": "1", "source": "billing", "detail-type": "SubscriptionCreated", "detail": {"customerId": "acme", "plan": "pro", "seats": 10}}`: {
+			{UID: "sub-1", Module: "github.com/acme/app", Tenant: "acme", Version: "v1.2.3", Context: []byte(`{"plan":"pro","seats":10}`)},
+		},
+		`{"id": "2", "source": "billing", "detail-type": "SubscriptionCreated", "detail": {"customerId": "acme", "plan": "free"}}`: {},
+		`{"id": "3", "source": "identity", "detail-type": "SubscriptionCreated", "detail": {"customerId": "acme", "plan": "pro"}}`: {},
+		`{"id": "4", "source": "billing", "detail-type": "PlanChanged", "detail": {"customerId": "acme"}}`: {
+			{UID: "4-plan", Module: "github.com/acme/app", Tenant: "acme", Context: []byte(`{}`)},
+		},
+	} {
+		t.Run(evt, func(t *testing.T) {
+			r, err := mapping.Parse([]byte(rules))
+			it.Then(t).Should(it.Nil(err))
+
+			bus := &mockEmitter{}
+			err = New(r, bus).Handle(context.Background(), []byte(evt))
+
+			it.Then(t).Should(
+				it.Nil(err),
+				it.Equal(len(bus.seq), len(expect)),
+			)
+
+			for i, craft := range expect {
+				it.Then(t).Should(
+					it.Equal(bus.seq[i].UID, craft.UID),
+					it.Equal(bus.seq[i].Module, craft.Module),
+					it.Equal(bus.seq[i].Tenant, craft.Tenant),
+					it.Equal(bus.seq[i].Version, craft.Version),
+					it.Equal(string(bus.seq[i].Context), string(craft.Context)),
+				)
+			}
+		})
+	}
+}
+
+func TestMappingFailed(t *testing.T) {
+	r, err := mapping.Parse([]byte(rules))
+	it.Then(t).Should(it.Nil(err))
+
+	// context is not an object, the rule is skipped
+	bus := &mockEmitter{}
+	err = New(r, bus).Handle(context.Background(),
+		[]byte(`{"id": "1", "source": "billing", "detail-type": "PlanChanged", "detail": {"customerId": "acme", "plan": "pro"}}`),
+	)
+
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(len(bus.seq), 0),
+	)
+}
+
+func TestMappingRetry(t *testing.T) {
+	r, err := mapping.Parse([]byte(`{
+		"rules": [
+			{"name": "a", "module": "github.com/acme/a", "tenant": "detail.customerId"},
+			{"name": "b", "module": "github.com/acme/b", "tenant": "detail.customerId"}
+		]
+	}`))
+	it.Then(t).Should(it.Nil(err))
+
+	evt := []byte(`{"id": "1", "source": "billing", "detail-type": "PlanChanged", "detail": {"customerId": "acme"}}`)
+
+	// the first emit of 1-b is failed, the retry emits it only
+	bus := &mockEmitter{fail: map[string]int{"1-b": 1}}
+	service := New(r, bus)
+
+	err = service.Handle(context.Background(), evt)
+	it.Then(t).ShouldNot(it.Nil(err))
+
+	err = service.Handle(context.Background(), evt)
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(len(bus.seq), 2),
+		it.Equal(bus.seq[0].UID, "1-a"),
+		it.Equal(bus.seq[1].UID, "1-b"),
+	)
+}
+
+func TestMappingInvalidRules(t *testing.T) {
+	for _, rules := range []string{
+		`{"rules": [{"name": "x"}]}`,
+		`{"rules": [{"name": "x", "module": "m", "tenant": "detail.["}]}`,
+		`{"rules": {}}`,
+	} {
+		_, err := mapping.Parse([]byte(rules))
+		it.Then(t).ShouldNot(it.Nil(err))
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// emitter of crafts, emit of the event is failed given number of times
+type mockEmitter struct {
+	seq  []events.EventCraft
+	fail map[string]int
+}
+
+func (m *mockEmitter) Enq(ctx context.Context, evt events.EventCraft, cat ...string) error {
+	if m.fail[evt.UID] > 0 {
+		m.fail[evt.UID]--
+		return fmt.Errorf("failed to emit %s", evt.UID)
+	}
+
+	m.seq = append(m.seq, evt)
+	return nil
+}
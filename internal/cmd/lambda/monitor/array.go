@@ -0,0 +1,188 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+	"github.com/aws/aws-sdk-go-v2/service/batch/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+)
+
+// Aggregated results of the array job stored at the bucket
+type ArrayResults struct {
+	events.EventCraftArrayCompleted
+	Targets []events.TargetStatus `json:"targets"`
+}
+
+// aggregates status of child jobs under the identity of array job, the status
+// of each child job is recorded by its own state change.
+func (s *Service) onArrayJob(evt JobStateChange) error {
+	ctx := context.Background()
+
+	children, err := s.children(ctx, evt.JobId)
+	if err != nil {
+		slog.Error("failed to list child jobs", "uid", evt.JobName, "job", evt.JobId, "err", err)
+		return err
+	}
+
+	tenants, err := s.tenants(ctx, evt.env("CRAFT_ARRAY"))
+	if err != nil {
+		slog.Error("failed to read targets", "uid", evt.JobName, "job", evt.JobId, "err", err)
+		return err
+	}
+
+	results := ArrayResults{
+		EventCraftArrayCompleted: events.EventCraftArrayCompleted{
+			UID:     evt.JobName,
+			Module:  evt.env("CRAFT_MODULE"),
+			Version: evt.env("CRAFT_MODULE_VERSION"),
+			Size:    evt.ArrayProperties.Size,
+			Results: fmt.Sprintf("s3://%s/craft/outputs/%s.json", s.bucket, evt.JobName),
+		},
+		Targets: make([]events.TargetStatus, 0, len(children)),
+	}
+
+	for _, job := range children {
+		index := int(aws.ToInt32(job.ArrayProperties.Index))
+		target := events.TargetStatus{
+			Index:  index,
+			UID:    fmt.Sprintf("%s-%d", evt.JobName, index),
+			Status: registry.STATUS_SUCCEEDED,
+			Reason: aws.ToString(job.StatusReason),
+		}
+
+		if index >= 0 && index < len(tenants) {
+			target.Tenant = tenants[index]
+		}
+
+		if job.Status == types.JobStatusFailed {
+			target.Status = registry.STATUS_FAILED
+			results.Failed++
+		} else {
+			results.Succeeded++
+		}
+
+		results.Targets = append(results.Targets, target)
+	}
+
+	sort.Slice(results.Targets, func(i, j int) bool {
+		return results.Targets[i].Index < results.Targets[j].Index
+	})
+
+	body, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.storage.PutObject(ctx,
+		&s3.PutObjectInput{
+			Bucket:      aws.String(s.bucket),
+			Key:         aws.String(fmt.Sprintf("craft/outputs/%s.json", evt.JobName)),
+			Body:        bytes.NewReader(body),
+			ContentType: aws.String("application/json"),
+		},
+	)
+	if err != nil {
+		slog.Error("failed to store results", "uid", evt.JobName, "err", err)
+		return err
+	}
+
+	slog.Info("array job completed", "uid", evt.JobName, "job", evt.JobId,
+		"size", results.Size, "succeeded", results.Succeeded, "failed", results.Failed,
+	)
+
+	status := registry.STATUS_SUCCEEDED
+	if results.Failed > 0 {
+		status = registry.STATUS_FAILED
+	}
+
+	_, err = s.registry.UpdateStatus(ctx, evt.JobName, status, "")
+	if err != nil && !errors.Is(err, registry.ErrNotFound) {
+		slog.Error("failed to update status", "uid", evt.JobName, "job", evt.JobId, "err", err)
+		return err
+	}
+
+	if err := s.arrays.Enq(ctx, results.EventCraftArrayCompleted); err != nil {
+		slog.Error("failed to emit results", "uid", evt.JobName, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+// reads tenants of array job's targets, in the order of targets
+func (s *Service) tenants(ctx context.Context, key string) ([]string, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	val, err := s.storage.GetObject(ctx,
+		&s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer val.Body.Close()
+
+	seq := make([]string, 0)
+	codec := json.NewDecoder(val.Body)
+	for codec.More() {
+		var target events.Target
+		if err := codec.Decode(&target); err != nil {
+			return nil, err
+		}
+		seq = append(seq, target.Tenant)
+	}
+
+	return seq, nil
+}
+
+// lists completed child jobs of the array job
+func (s *Service) children(ctx context.Context, job string) ([]types.JobSummary, error) {
+	seq := make([]types.JobSummary, 0)
+
+	for _, status := range []types.JobStatus{types.JobStatusSucceeded, types.JobStatusFailed} {
+		var token *string
+		for {
+			val, err := s.queue.ListJobs(ctx,
+				&batch.ListJobsInput{
+					ArrayJobId: aws.String(job),
+					JobStatus:  status,
+					NextToken:  token,
+				},
+			)
+			if err != nil {
+				return nil, err
+			}
+
+			seq = append(seq, val.JobSummaryList...)
+
+			if val.NextToken == nil {
+				break
+			}
+			token = val.NextToken
+		}
+	}
+
+	return seq, nil
+}
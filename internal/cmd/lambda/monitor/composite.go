@@ -0,0 +1,75 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+	"github.com/aws/aws-sdk-go-v2/service/batch/types"
+)
+
+// cancels the rest of composite deployment once any of its modules fails
+func (s *Service) cancelComposite(ctx context.Context, evt JobStateChange) error {
+	composite := evt.env("CRAFT_COMPOSITE")
+
+	members := map[string]struct{}{}
+	for _, uid := range strings.Fields(evt.env("CRAFT_COMPOSITE_JOBS")) {
+		members[uid] = struct{}{}
+	}
+
+	var token *string
+	for {
+		val, err := s.queue.ListJobs(ctx,
+			&batch.ListJobsInput{
+				JobQueue: aws.String(evt.JobQueue),
+				Filters: []types.KeyValuesPair{
+					{Name: aws.String("JOB_NAME"), Values: []string{composite + "-*"}},
+				},
+				NextToken: token,
+			},
+		)
+		if err != nil {
+			slog.Error("failed to list composite jobs", "uid", composite, "err", err)
+			return err
+		}
+
+		for _, job := range val.JobSummaryList {
+			if _, has := members[aws.ToString(job.JobName)]; !has {
+				continue
+			}
+
+			if job.Status == types.JobStatusSucceeded || job.Status == types.JobStatusFailed {
+				continue
+			}
+
+			_, err := s.queue.TerminateJob(ctx,
+				&batch.TerminateJobInput{
+					JobId:  job.JobId,
+					Reason: aws.String(fmt.Sprintf("module %s has failed", evt.JobName)),
+				},
+			)
+			if err != nil {
+				slog.Error("failed to cancel composite job", "uid", job.JobName, "job", job.JobId, "err", err)
+				return err
+			}
+
+			slog.Info("composite job cancelled", "uid", job.JobName, "job", job.JobId, "cause", evt.JobName)
+		}
+
+		if val.NextToken == nil {
+			return nil
+		}
+		token = val.NextToken
+	}
+}
@@ -0,0 +1,79 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+	_ "github.com/fogfish/logger/v3"
+	"github.com/fogfish/swarm"
+	"github.com/fogfish/swarm/broker/eventbridge"
+	"github.com/fogfish/swarm/dequeue"
+	"github.com/fogfish/swarm/enqueue"
+)
+
+func main() {
+	aws, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		slog.Error("fatal failure of aws client", "err", err)
+		panic(err)
+	}
+
+	// Registry of deployments
+	registry := registry.New(
+		dynamodb.NewFromConfig(aws),
+		os.Getenv("CONFIG_REGISTRY"),
+	)
+
+	// Status of deployments is emitted to the craft's bus
+	bus := os.Getenv("CONFIG_EVENT_BUS")
+	e, err := eventbridge.NewEnqueuer(bus,
+		eventbridge.WithConfig(
+			swarm.WithSource(bus),
+			swarm.WithLogStdErr(),
+		),
+	)
+	if err != nil {
+		slog.Error("fatal failure of eventbrige client", "err", err)
+		panic(err)
+	}
+
+	// Run event consumption loop
+	service := New(
+		registry,
+		batch.NewFromConfig(aws),
+		s3.NewFromConfig(aws),
+		os.Getenv("CONFIG_S3"),
+		enqueue.NewTyped[events.EventDeployment](e),
+		enqueue.NewTyped[events.EventCraftArrayCompleted](e),
+	)
+
+	q, err := eventbridge.NewDequeuer("default",
+		eventbridge.WithConfig(
+			swarm.WithLogStdErr(),
+		),
+	)
+	if err != nil {
+		slog.Error("fatal failure of eventbrige client", "err", err)
+		panic(err)
+	}
+
+	go service.Run(dequeue.Typed[JobStateChange](q, CATEGORY_JOB_STATE_CHANGE))
+
+	q.Await()
+}
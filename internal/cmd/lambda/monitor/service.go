@@ -0,0 +1,215 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+	"github.com/fogfish/swarm"
+)
+
+// Detail type of events emitted by AWS Batch
+const CATEGORY_JOB_STATE_CHANGE = "Batch Job State Change"
+
+// Status of AWS Batch job
+const (
+	JOB_SUCCEEDED = "SUCCEEDED"
+	JOB_FAILED    = "FAILED"
+)
+
+// The subset of "Batch Job State Change" event used by the monitor
+// See https://docs.aws.amazon.com/batch/latest/userguide/batch_job_events.html
+type JobStateChange struct {
+	JobName      string `json:"jobName"`
+	JobId        string `json:"jobId"`
+	JobQueue     string `json:"jobQueue"`
+	Status       string `json:"status"`
+	StatusReason string `json:"statusReason,omitempty"`
+
+	// Size is defined for the parent of array job, index for its child
+	ArrayProperties struct {
+		Size  int  `json:"size,omitempty"`
+		Index *int `json:"index,omitempty"`
+	} `json:"arrayProperties"`
+
+	Container struct {
+		Environment []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"environment,omitempty"`
+	} `json:"container"`
+}
+
+func (evt JobStateChange) env(key string) string {
+	for _, kv := range evt.Container.Environment {
+		if kv.Name == key {
+			return kv.Value
+		}
+	}
+	return ""
+}
+
+type Registry interface {
+	UpdateStatus(ctx context.Context, uid, status, reason string) (*registry.Deployment, error)
+}
+
+type JobQueue interface {
+	ListJobs(ctx context.Context, params *batch.ListJobsInput, optFns ...func(*batch.Options)) (*batch.ListJobsOutput, error)
+	TerminateJob(ctx context.Context, params *batch.TerminateJobInput, optFns ...func(*batch.Options)) (*batch.TerminateJobOutput, error)
+}
+
+type Storage interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+type Emitter[T any] interface {
+	Enq(ctx context.Context, evt T, cat ...string) error
+}
+
+type Service struct {
+	registry    Registry
+	queue       JobQueue
+	storage     Storage
+	bucket      string
+	deployments Emitter[events.EventDeployment]
+	arrays      Emitter[events.EventCraftArrayCompleted]
+}
+
+func New(
+	registry Registry,
+	queue JobQueue,
+	storage Storage,
+	bucket string,
+	deployments Emitter[events.EventDeployment],
+	arrays Emitter[events.EventCraftArrayCompleted],
+) *Service {
+	return &Service{
+		registry:    registry,
+		queue:       queue,
+		storage:     storage,
+		bucket:      bucket,
+		deployments: deployments,
+		arrays:      arrays,
+	}
+}
+
+func (s *Service) Run(rcv <-chan swarm.Msg[JobStateChange], ack chan<- swarm.Msg[JobStateChange]) {
+	for msg := range rcv {
+		if err := s.onJobStateChange(msg.Object); err != nil {
+			ack <- msg.Fail(err)
+			continue
+		}
+
+		ack <- msg
+	}
+}
+
+func (s *Service) onJobStateChange(evt JobStateChange) error {
+	if evt.ArrayProperties.Size > 0 {
+		return s.onArrayJob(evt)
+	}
+
+	status := statusOf(evt)
+	if status == "" {
+		return nil
+	}
+
+	// child job of array job deploys the target identified by its index
+	uid := evt.JobName
+	if evt.ArrayProperties.Index != nil {
+		uid = fmt.Sprintf("%s-%d", evt.JobName, *evt.ArrayProperties.Index)
+	}
+
+	ctx := context.Background()
+
+	if _, err := s.update(ctx, uid, evt.JobId, status, evt.StatusReason); err != nil {
+		return err
+	}
+
+	if evt.Status == JOB_FAILED && evt.env("CRAFT_COMPOSITE") != "" {
+		return s.cancelComposite(ctx, evt)
+	}
+
+	return nil
+}
+
+// updates status of the deployment, deployments unknown to the registry are
+// ignored (e.g. bootstrap or diff).
+func (s *Service) update(ctx context.Context, uid, job, status, reason string) (*registry.Deployment, error) {
+	d, err := s.registry.UpdateStatus(ctx, uid, status, reason)
+	switch {
+	case errors.Is(err, registry.ErrNotFound):
+		return nil, nil
+	case err != nil:
+		slog.Error("failed to update status", "uid", uid, "job", job, "err", err)
+		return nil, err
+	}
+
+	slog.Info("deployment status", "uid", uid, "job", job, "status", status)
+
+	err = s.deployments.Enq(ctx,
+		events.EventDeployment{
+			UID:       d.UID,
+			Tenant:    d.Tenant,
+			Module:    d.Module,
+			Version:   d.Version,
+			Status:    d.Status,
+			Reason:    d.Reason,
+			Job:       d.Job,
+			Rollout:   d.Rollout,
+			Lifecycle: d.Lifecycle,
+			Coalesced: d.Coalesced,
+			Callback:  d.Callback,
+			ReplyTo:   d.ReplyTo,
+		},
+	)
+	if err != nil {
+		slog.Error("failed to emit status", "uid", uid, "err", err)
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// maps status of the job to status of the deployment
+func statusOf(evt JobStateChange) string {
+	switch evt.Status {
+	case JOB_FAILED:
+		return registry.STATUS_FAILED
+	case JOB_SUCCEEDED:
+	default:
+		return ""
+	}
+
+	// approval job completes the deployment, which has requested approval
+	if decision := evt.env("CRAFT_DECISION"); decision != "" {
+		if decision == events.DECISION_APPROVE {
+			return registry.STATUS_SUCCEEDED
+		}
+		return registry.STATUS_DISCARDED
+	}
+
+	switch evt.env("CRAFT_MODE") {
+	case events.MODE_DIFF, events.MODE_DRIFT:
+		return ""
+	case events.MODE_APPROVAL:
+		return registry.STATUS_PENDING
+	default:
+		return registry.STATUS_SUCCEEDED
+	}
+}
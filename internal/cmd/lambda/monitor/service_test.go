@@ -0,0 +1,338 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+	"github.com/aws/aws-sdk-go-v2/service/batch/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/swarm"
+)
+
+func TestJobStateChange(t *testing.T) {
+	for name, expect := range map[string]string{
+		`{"jobName": "123-456-789", "status": "SUCCEEDED"}`:                                                                                 registry.STATUS_SUCCEEDED,
+		`{"jobName": "123-456-789", "status": "FAILED", "statusReason": "Essential container in task exited"}`:                              registry.STATUS_FAILED,
+		`{"jobName": "123-456-789", "status": "SUCCEEDED", "container": {"environment": [{"name": "CRAFT_MODE", "value": "approval"}]}}`:    registry.STATUS_PENDING,
+		`{"jobName": "123-456-789", "status": "SUCCEEDED", "container": {"environment": [{"name": "CRAFT_MODE", "value": "diff"}]}}`:        "",
+		`{"jobName": "123-456-789", "status": "SUCCEEDED", "container": {"environment": [{"name": "CRAFT_MODE", "value": "drift"}]}}`:       "",
+		`{"jobName": "123-456-789", "status": "SUCCEEDED", "container": {"environment": [{"name": "CRAFT_DECISION", "value": "approve"}]}}`: registry.STATUS_SUCCEEDED,
+		`{"jobName": "123-456-789", "status": "SUCCEEDED", "container": {"environment": [{"name": "CRAFT_DECISION", "value": "reject"}]}}`:  registry.STATUS_DISCARDED,
+		`{"jobName": "123-456-789", "status": "RUNNING"}`:                                                                                   "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			var evt JobStateChange
+			it.Then(t).Should(it.Nil(json.Unmarshal([]byte(name), &evt)))
+
+			db := &mock{status: map[string]string{"123-456-789": registry.STATUS_SCHEDULED}}
+			bus := &mockEmitter[events.EventDeployment]{}
+			service := New(db, &mockQueue{}, &mockStorage{}, "test-s3", bus, &mockEmitter[events.EventCraftArrayCompleted]{})
+
+			rcv := make(chan swarm.Msg[JobStateChange])
+			ack := make(chan swarm.Msg[JobStateChange])
+			go service.Run(rcv, ack)
+
+			rcv <- swarm.Msg[JobStateChange]{
+				Category: CATEGORY_JOB_STATE_CHANGE,
+				Object:   evt,
+			}
+			msg := <-ack
+
+			if expect == "" {
+				expect = registry.STATUS_SCHEDULED
+			}
+			it.Then(t).Should(
+				it.Nil(msg.Error),
+				it.Equal(db.status["123-456-789"], expect),
+			)
+
+			if expect != registry.STATUS_SCHEDULED {
+				it.Then(t).Should(
+					it.Seq(bus.seq).Equal(events.EventDeployment{UID: "123-456-789", Status: expect}),
+				)
+			}
+		})
+	}
+}
+
+func TestJobStateChangeUnknown(t *testing.T) {
+	db := &mock{status: map[string]string{}}
+	bus := &mockEmitter[events.EventDeployment]{}
+	service := New(db, &mockQueue{}, &mockStorage{}, "test-s3", bus, &mockEmitter[events.EventCraftArrayCompleted]{})
+
+	rcv := make(chan swarm.Msg[JobStateChange])
+	ack := make(chan swarm.Msg[JobStateChange])
+	go service.Run(rcv, ack)
+
+	rcv <- swarm.Msg[JobStateChange]{
+		Category: CATEGORY_JOB_STATE_CHANGE,
+		Object:   JobStateChange{JobName: "bootstrap", Status: JOB_SUCCEEDED},
+	}
+	msg := <-ack
+	it.Then(t).Should(
+		it.Nil(msg.Error),
+		it.Seq(bus.seq).BeEmpty(),
+	)
+}
+
+func TestJobStateChangeFailed(t *testing.T) {
+	service := New(&mock{err: fmt.Errorf("unavailable")}, &mockQueue{}, &mockStorage{}, "test-s3",
+		&mockEmitter[events.EventDeployment]{},
+		&mockEmitter[events.EventCraftArrayCompleted]{},
+	)
+
+	rcv := make(chan swarm.Msg[JobStateChange])
+	ack := make(chan swarm.Msg[JobStateChange])
+	go service.Run(rcv, ack)
+
+	rcv <- swarm.Msg[JobStateChange]{
+		Category: CATEGORY_JOB_STATE_CHANGE,
+		Object:   JobStateChange{JobName: "123-456-789", Status: JOB_SUCCEEDED},
+	}
+	msg := <-ack
+	it.Then(t).ShouldNot(it.Nil(msg.Error))
+}
+
+func TestArrayJob(t *testing.T) {
+	db := &mock{status: map[string]string{
+		"123-456-789":   registry.STATUS_SCHEDULED,
+		"123-456-789-0": registry.STATUS_SCHEDULED,
+		"123-456-789-1": registry.STATUS_SCHEDULED,
+		"123-456-789-2": registry.STATUS_SCHEDULED,
+	}}
+	queue := &mockQueue{
+		jobs: []types.JobSummary{
+			{JobId: aws.String("job:2"), Status: types.JobStatusSucceeded, ArrayProperties: &types.ArrayPropertiesSummary{Index: aws.Int32(2)}},
+			{JobId: aws.String("job:0"), Status: types.JobStatusSucceeded, ArrayProperties: &types.ArrayPropertiesSummary{Index: aws.Int32(0)}},
+			{JobId: aws.String("job:1"), Status: types.JobStatusFailed, StatusReason: aws.String("exit 1"), ArrayProperties: &types.ArrayPropertiesSummary{Index: aws.Int32(1)}},
+		},
+	}
+	storage := &mockStorage{
+		objects: map[string]string{
+			"craft/contexts/123-456-789.jsonl": `{"tenant":"a","context":{}}
+{"tenant":"b","context":{}}
+{"tenant":"c","context":{}}
+`,
+		},
+	}
+	deployments := &mockEmitter[events.EventDeployment]{}
+	arrays := &mockEmitter[events.EventCraftArrayCompleted]{}
+	service := New(db, queue, storage, "test-s3", deployments, arrays)
+
+	rcv := make(chan swarm.Msg[JobStateChange])
+	ack := make(chan swarm.Msg[JobStateChange])
+	go service.Run(rcv, ack)
+
+	var evt JobStateChange
+	it.Then(t).Should(it.Nil(json.Unmarshal([]byte(`{
+		"jobName": "123-456-789",
+		"jobId": "job",
+		"status": "FAILED",
+		"arrayProperties": {"size": 3},
+		"container": {"environment": [
+			{"name": "CRAFT_MODULE", "value": "github.com/fogfish/craft"},
+			{"name": "CRAFT_ARRAY", "value": "craft/contexts/123-456-789.jsonl"}
+		]}
+	}`), &evt)))
+
+	rcv <- swarm.Msg[JobStateChange]{
+		Category: CATEGORY_JOB_STATE_CHANGE,
+		Object:   evt,
+	}
+	msg := <-ack
+
+	var results ArrayResults
+	it.Then(t).Should(
+		it.Nil(msg.Error),
+		it.Equal(db.status["123-456-789"], registry.STATUS_FAILED),
+		it.Equal(db.status["123-456-789-0"], registry.STATUS_SCHEDULED),
+		it.Equal(db.status["123-456-789-1"], registry.STATUS_SCHEDULED),
+		it.Equal(db.status["123-456-789-2"], registry.STATUS_SCHEDULED),
+		it.Equal(len(deployments.seq), 0),
+		it.Equal(storage.key, "craft/outputs/123-456-789.json"),
+		it.Nil(json.Unmarshal(storage.body, &results)),
+		it.Seq(arrays.seq).Equal(
+			events.EventCraftArrayCompleted{
+				UID:       "123-456-789",
+				Module:    "github.com/fogfish/craft",
+				Size:      3,
+				Succeeded: 2,
+				Failed:    1,
+				Results:   "s3://test-s3/craft/outputs/123-456-789.json",
+			},
+		),
+	).Should(
+		it.Seq(results.Targets).Equal(
+			events.TargetStatus{Index: 0, UID: "123-456-789-0", Tenant: "a", Status: registry.STATUS_SUCCEEDED},
+			events.TargetStatus{Index: 1, UID: "123-456-789-1", Tenant: "b", Status: registry.STATUS_FAILED, Reason: "exit 1"},
+			events.TargetStatus{Index: 2, UID: "123-456-789-2", Tenant: "c", Status: registry.STATUS_SUCCEEDED},
+		),
+	)
+}
+
+func TestArrayChildJob(t *testing.T) {
+	db := &mock{status: map[string]string{
+		"123-456-789":   registry.STATUS_SCHEDULED,
+		"123-456-789-0": registry.STATUS_SCHEDULED,
+	}}
+	deployments := &mockEmitter[events.EventDeployment]{}
+	service := New(db, &mockQueue{}, &mockStorage{}, "test-s3",
+		deployments,
+		&mockEmitter[events.EventCraftArrayCompleted]{},
+	)
+
+	rcv := make(chan swarm.Msg[JobStateChange])
+	ack := make(chan swarm.Msg[JobStateChange])
+	go service.Run(rcv, ack)
+
+	var evt JobStateChange
+	it.Then(t).Should(it.Nil(json.Unmarshal([]byte(`{"jobName": "123-456-789", "jobId": "job:0", "status": "SUCCEEDED", "arrayProperties": {"index": 0}}`), &evt)))
+
+	rcv <- swarm.Msg[JobStateChange]{
+		Category: CATEGORY_JOB_STATE_CHANGE,
+		Object:   evt,
+	}
+	msg := <-ack
+	it.Then(t).Should(
+		it.Nil(msg.Error),
+		it.Equal(db.status["123-456-789"], registry.STATUS_SCHEDULED),
+		it.Equal(db.status["123-456-789-0"], registry.STATUS_SUCCEEDED),
+		it.Equal(len(deployments.seq), 1),
+		it.Equal(deployments.seq[0].UID, "123-456-789-0"),
+	)
+}
+
+func TestCompositeFailed(t *testing.T) {
+	db := &mock{status: map[string]string{"123-data": registry.STATUS_SCHEDULED}}
+	queue := &mockQueue{
+		jobs: []types.JobSummary{
+			{JobId: aws.String("network"), JobName: aws.String("123-network"), Status: types.JobStatusSucceeded},
+			{JobId: aws.String("data"), JobName: aws.String("123-data"), Status: types.JobStatusFailed},
+			{JobId: aws.String("api"), JobName: aws.String("123-api"), Status: types.JobStatusPending},
+			{JobId: aws.String("web"), JobName: aws.String("123-web"), Status: types.JobStatusRunning},
+			{JobId: aws.String("other"), JobName: aws.String("123-other"), Status: types.JobStatusRunning},
+		},
+	}
+	service := New(db, queue, &mockStorage{}, "test-s3",
+		&mockEmitter[events.EventDeployment]{},
+		&mockEmitter[events.EventCraftArrayCompleted]{},
+	)
+
+	rcv := make(chan swarm.Msg[JobStateChange])
+	ack := make(chan swarm.Msg[JobStateChange])
+	go service.Run(rcv, ack)
+
+	var evt JobStateChange
+	it.Then(t).Should(it.Nil(json.Unmarshal([]byte(`{
+		"jobName": "123-data",
+		"jobId": "data",
+		"status": "FAILED",
+		"container": {"environment": [
+			{"name": "CRAFT_COMPOSITE", "value": "123"},
+			{"name": "CRAFT_COMPOSITE_JOBS", "value": "123-network 123-data 123-api 123-web"}
+		]}
+	}`), &evt)))
+
+	rcv <- swarm.Msg[JobStateChange]{
+		Category: CATEGORY_JOB_STATE_CHANGE,
+		Object:   evt,
+	}
+	msg := <-ack
+	it.Then(t).Should(
+		it.Nil(msg.Error),
+		it.Equal(db.status["123-data"], registry.STATUS_FAILED),
+		it.Seq(queue.terminated).Equal("api", "web"),
+	)
+}
+
+//------------------------------------------------------------------------------
+
+type mock struct {
+	status map[string]string
+	err    error
+}
+
+func (m *mock) UpdateStatus(ctx context.Context, uid, status, reason string) (*registry.Deployment, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	if _, has := m.status[uid]; !has {
+		return nil, registry.ErrNotFound
+	}
+
+	m.status[uid] = status
+	return &registry.Deployment{UID: uid, Status: status}, nil
+}
+
+type mockEmitter[T any] struct {
+	seq []T
+}
+
+func (m *mockEmitter[T]) Enq(ctx context.Context, evt T, cat ...string) error {
+	m.seq = append(m.seq, evt)
+	return nil
+}
+
+type mockQueue struct {
+	jobs       []types.JobSummary
+	terminated []string
+}
+
+func (m *mockQueue) ListJobs(ctx context.Context, params *batch.ListJobsInput, optFns ...func(*batch.Options)) (*batch.ListJobsOutput, error) {
+	seq := make([]types.JobSummary, 0)
+	for _, job := range m.jobs {
+		if len(params.Filters) != 0 || job.Status == params.JobStatus {
+			seq = append(seq, job)
+		}
+	}
+	return &batch.ListJobsOutput{JobSummaryList: seq}, nil
+}
+
+func (m *mockQueue) TerminateJob(ctx context.Context, params *batch.TerminateJobInput, optFns ...func(*batch.Options)) (*batch.TerminateJobOutput, error) {
+	m.terminated = append(m.terminated, aws.ToString(params.JobId))
+	return &batch.TerminateJobOutput{}, nil
+}
+
+type mockStorage struct {
+	objects map[string]string
+	key     string
+	body    []byte
+}
+
+func (m *mockStorage) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	obj, has := m.objects[aws.ToString(params.Key)]
+	if !has {
+		return nil, fmt.Errorf("not found")
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(obj))}, nil
+}
+
+func (m *mockStorage) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	m.key = aws.ToString(params.Key)
+	m.body = body
+	return &s3.PutObjectOutput{}, nil
+}
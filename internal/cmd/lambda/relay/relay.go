@@ -0,0 +1,34 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	_ "github.com/fogfish/logger/v3"
+)
+
+func main() {
+	aws, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		slog.Error("fatal failure of aws client", "err", err)
+		panic(err)
+	}
+
+	// Status and outputs of deployments are relayed to the reply-to bus,
+	// the access is restricted by the policy of the function
+	service := New(eventbridge.NewFromConfig(aws))
+
+	lambda.Start(service.Handle)
+}
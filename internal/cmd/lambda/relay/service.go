@@ -0,0 +1,97 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+)
+
+type Bus interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+type Service struct {
+	bus Bus
+}
+
+// New service relays events of the craft's bus to the reply-to bus,
+// which is defined by the event.
+func New(bus Bus) *Service {
+	return &Service{bus: bus}
+}
+
+// Handle relays the event (source, category and detail) to the reply-to bus.
+// The reply-to is stripped from the relayed detail, the relay never matches
+// its own output. Failed relay is retried by asynchronous invocation.
+func (s *Service) Handle(ctx context.Context, evt lambdaevents.EventBridgeEvent) error {
+	var detail struct {
+		UID     string `json:"uid"`
+		ReplyTo string `json:"replyTo"`
+	}
+	if err := json.Unmarshal(evt.Detail, &detail); err != nil {
+		slog.Error("invalid event format", "id", evt.ID, "category", evt.DetailType, "err", err)
+		return nil
+	}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(evt.Detail, &payload); err != nil {
+		slog.Error("invalid event format", "id", evt.ID, "category", evt.DetailType, "err", err)
+		return nil
+	}
+	delete(payload, "replyTo")
+
+	if detail.ReplyTo == "" {
+		return nil
+	}
+
+	bus, err := arn.Parse(detail.ReplyTo)
+	if err != nil || bus.Service != "events" {
+		slog.Error("invalid reply-to", "uid", detail.UID, "category", evt.DetailType, "replyTo", detail.ReplyTo)
+		return nil
+	}
+
+	relayed, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	val, err := s.bus.PutEvents(ctx,
+		&eventbridge.PutEventsInput{
+			Entries: []ebtypes.PutEventsRequestEntry{
+				{
+					EventBusName: aws.String(detail.ReplyTo),
+					Source:       aws.String(evt.Source),
+					DetailType:   aws.String(evt.DetailType),
+					Detail:       aws.String(string(relayed)),
+				},
+			},
+		},
+	)
+	if err != nil {
+		slog.Error("failed to relay event", "uid", detail.UID, "category", evt.DetailType, "account", bus.AccountID, "err", err)
+		return err
+	}
+
+	if val.FailedEntryCount > 0 {
+		return fmt.Errorf("failed to relay %s to %s: %s", evt.DetailType, detail.ReplyTo, aws.ToString(val.Entries[0].ErrorMessage))
+	}
+
+	slog.Info("event relayed", "uid", detail.UID, "category", evt.DetailType, "account", bus.AccountID)
+
+	return nil
+}
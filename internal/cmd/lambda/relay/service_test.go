@@ -0,0 +1,101 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/fogfish/it/v2"
+)
+
+const replyTo = "arn:aws:events:eu-west-1:111111111111:event-bus/app"
+
+func TestRelay(t *testing.T) {
+	bus := &mockBus{}
+	service := New(bus)
+
+	err := service.Handle(context.Background(),
+		lambdaevents.EventBridgeEvent{
+			Source:     "craft",
+			DetailType: "EventDeployment",
+			Detail:     json.RawMessage(`{"uid":"a","status":"succeeded","replyTo":"` + replyTo + `"}`),
+		},
+	)
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(len(bus.seq), 1),
+		it.Equal(aws.ToString(bus.seq[0].EventBusName), replyTo),
+		it.Equal(aws.ToString(bus.seq[0].Source), "craft"),
+		it.Equal(aws.ToString(bus.seq[0].DetailType), "EventDeployment"),
+		it.Equal(aws.ToString(bus.seq[0].Detail), `{"status":"succeeded","uid":"a"}`),
+	)
+}
+
+func TestRelaySkip(t *testing.T) {
+	bus := &mockBus{}
+	service := New(bus)
+
+	for _, detail := range []string{
+		`{"uid":"a","status":"succeeded"}`,
+		`{"uid":"a","status":"succeeded","replyTo":"app"}`,
+		`{"uid":"a","status":"succeeded","replyTo":"arn:aws:sqs:eu-west-1:111111111111:app"}`,
+		`not json`,
+	} {
+		err := service.Handle(context.Background(),
+			lambdaevents.EventBridgeEvent{
+				Source:     "craft",
+				DetailType: "EventDeployment",
+				Detail:     json.RawMessage(detail),
+			},
+		)
+		it.Then(t).Should(it.Nil(err))
+	}
+
+	it.Then(t).Should(it.Equal(len(bus.seq), 0))
+}
+
+func TestRelayFailed(t *testing.T) {
+	bus := &mockBus{failed: true}
+	service := New(bus)
+
+	err := service.Handle(context.Background(),
+		lambdaevents.EventBridgeEvent{
+			Source:     "craft",
+			DetailType: "EventCraftDiff",
+			Detail:     json.RawMessage(`{"uid":"a","replyTo":"` + replyTo + `"}`),
+		},
+	)
+	it.Then(t).ShouldNot(it.Nil(err))
+}
+
+//------------------------------------------------------------------------------
+
+// records relayed events, access to the bus is denied if failed
+type mockBus struct {
+	failed bool
+	seq    []ebtypes.PutEventsRequestEntry
+}
+
+func (m *mockBus) PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	if m.failed {
+		return &eventbridge.PutEventsOutput{
+			FailedEntryCount: 1,
+			Entries:          []ebtypes.PutEventsResultEntry{{ErrorCode: aws.String("AccessDeniedException"), ErrorMessage: aws.String("denied")}},
+		}, nil
+	}
+
+	m.seq = append(m.seq, params.Entries...)
+	return &eventbridge.PutEventsOutput{}, nil
+}
@@ -0,0 +1,76 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"github.com/fogfish/craft/internal/events"
+	_ "github.com/fogfish/logger/v3"
+	"github.com/fogfish/swarm"
+	swarmeventbridge "github.com/fogfish/swarm/broker/eventbridge"
+	"github.com/fogfish/swarm/dequeue"
+)
+
+func main() {
+	aws, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		slog.Error("fatal failure of aws client", "err", err)
+		panic(err)
+	}
+
+	opts := []Option{}
+
+	// Archived events are replayed to the gateway
+	if arn := os.Getenv("CONFIG_ARCHIVE"); arn != "" {
+		opts = append(opts,
+			WithArchive(
+				eventbridge.NewFromConfig(aws),
+				arn,
+				os.Getenv("CONFIG_EVENT_BUS_ARN"),
+				os.Getenv("CONFIG_GATEWAY_RULE"),
+			),
+		)
+	}
+
+	// EventCraft failed by the inbox are replayed to the craft's bus
+	if url := os.Getenv("CONFIG_INBOX_DEAD_LETTER"); url != "" {
+		opts = append(opts, WithInbox(url))
+	}
+
+	// Failed events are replayed from the dead-letter queue to the craft's bus
+	service := New(
+		sqs.NewFromConfig(aws),
+		os.Getenv("CONFIG_DEAD_LETTER"),
+		eventbridge.NewFromConfig(aws),
+		os.Getenv("CONFIG_EVENT_BUS"),
+		opts...,
+	)
+
+	q, err := swarmeventbridge.NewDequeuer("default",
+		swarmeventbridge.WithConfig(
+			swarm.WithLogStdErr(),
+		),
+	)
+	if err != nil {
+		slog.Error("fatal failure of eventbrige client", "err", err)
+		panic(err)
+	}
+
+	go service.Run(dequeue.Typed[events.EventReplay](q))
+	go service.RunArchive(dequeue.Typed[events.EventArchiveReplay](q))
+
+	q.Await()
+}
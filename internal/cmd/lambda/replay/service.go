@@ -0,0 +1,422 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/swarm"
+)
+
+type Queue interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+	GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)
+}
+
+type Bus interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+type Archive interface {
+	StartReplay(ctx context.Context, params *eventbridge.StartReplayInput, optFns ...func(*eventbridge.Options)) (*eventbridge.StartReplayOutput, error)
+}
+
+// Record of the dead-letter queue, as delivered by on-failure destination
+// of AWS Lambda asynchronous invocation.
+type Record struct {
+	Timestamp string `json:"timestamp"`
+
+	RequestPayload struct {
+		Source     string          `json:"source"`
+		DetailType string          `json:"detail-type"`
+		Detail     json.RawMessage `json:"detail"`
+	} `json:"requestPayload"`
+
+	ResponsePayload struct {
+		ErrorMessage string `json:"errorMessage"`
+	} `json:"responsePayload"`
+}
+
+// messages received by the replay are hidden from next receives until
+// the replay is completed, it covers timeout of the function.
+const visibilityTimeout = 300
+
+// the queue is long-polled, the replay is completed once the queue has no
+// visible messages or after repeated empty receives.
+const (
+	waitTimeSeconds  = 5
+	emptyReceivesMax = 3
+)
+
+type Service struct {
+	queue   Queue
+	url     string
+	inbox   string
+	bus     Bus
+	name    string
+	archive *archive
+}
+
+// archive of the bus, events are replayed to the rule
+type archive struct {
+	api  Archive
+	arn  string
+	bus  string
+	rule string
+}
+
+type Option func(*Service)
+
+// WithArchive enables replay of the archive (arn) to the rule of the bus (arn)
+func WithArchive(api Archive, arn, bus, rule string) Option {
+	return func(s *Service) {
+		s.archive = &archive{api: api, arn: arn, bus: bus, rule: rule}
+	}
+}
+
+// WithInbox enables replay of the inbox's dead-letter queue (url), messages
+// are EventCraft as sent by producers.
+func WithInbox(url string) Option {
+	return func(s *Service) {
+		s.inbox = url
+	}
+}
+
+func New(queue Queue, url string, bus Bus, name string, opts ...Option) *Service {
+	s := &Service{
+		queue: queue,
+		url:   url,
+		bus:   bus,
+		name:  name,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *Service) Run(rcv <-chan swarm.Msg[events.EventReplay], ack chan<- swarm.Msg[events.EventReplay]) {
+	for msg := range rcv {
+		if _, err := s.Replay(context.Background(), msg.Object); err != nil {
+			ack <- msg.Fail(err)
+			continue
+		}
+
+		ack <- msg
+	}
+}
+
+func (s *Service) RunArchive(rcv <-chan swarm.Msg[events.EventArchiveReplay], ack chan<- swarm.Msg[events.EventArchiveReplay]) {
+	for msg := range rcv {
+		if err := s.ReplayArchive(context.Background(), msg.Object); err != nil {
+			ack <- msg.Fail(err)
+			continue
+		}
+
+		ack <- msg
+	}
+}
+
+// ReplayArchive starts replay of archived events to the gateway, the replay
+// is named after the request so that redelivered request is not replayed twice.
+func (s *Service) ReplayArchive(ctx context.Context, evt events.EventArchiveReplay) error {
+	if s.archive == nil {
+		return fmt.Errorf("archive is not configured")
+	}
+
+	if evt.UID == "" || evt.After == "" || evt.Before == "" {
+		return fmt.Errorf("invalid event format")
+	}
+
+	after, err := time.Parse(time.RFC3339, evt.After)
+	if err != nil {
+		return fmt.Errorf("invalid after %s: %w", evt.After, err)
+	}
+
+	before, err := time.Parse(time.RFC3339, evt.Before)
+	if err != nil {
+		return fmt.Errorf("invalid before %s: %w", evt.Before, err)
+	}
+
+	val, err := s.archive.api.StartReplay(ctx,
+		&eventbridge.StartReplayInput{
+			ReplayName:     aws.String(evt.UID),
+			EventSourceArn: aws.String(s.archive.arn),
+			EventStartTime: aws.Time(after),
+			EventEndTime:   aws.Time(before),
+			Destination: &ebtypes.ReplayDestination{
+				Arn:        aws.String(s.archive.bus),
+				FilterArns: []string{s.archive.rule},
+			},
+		},
+	)
+
+	var exists *ebtypes.ResourceAlreadyExistsException
+	if errors.As(err, &exists) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.Info("archive replay started", "uid", evt.UID, "replay", aws.ToString(val.ReplayArn), "after", after, "before", before)
+
+	return nil
+}
+
+// Replay matching records of the dead-letter queue to the bus, it returns
+// number of replayed events. Replayed records are deleted, others are
+// released back to the queue.
+func (s *Service) Replay(ctx context.Context, evt events.EventReplay) (int, error) {
+	filter, err := newFilter(evt)
+	if err != nil {
+		return 0, err
+	}
+
+	url, decode := s.url, decodeRecord
+	switch evt.Queue {
+	case "", events.REPLAY_GATEWAY:
+	case events.REPLAY_INBOX:
+		if s.inbox == "" {
+			return 0, fmt.Errorf("inbox is not configured")
+		}
+		url, decode = s.inbox, decodeInbox
+	default:
+		return 0, fmt.Errorf("unknown queue %s", evt.Queue)
+	}
+
+	replayed := 0
+	skipped := make([]string, 0)
+	defer func() { s.release(ctx, url, skipped) }()
+
+	for empty := 0; empty < emptyReceivesMax; {
+		val, err := s.queue.ReceiveMessage(ctx,
+			&sqs.ReceiveMessageInput{
+				QueueUrl:                    aws.String(url),
+				MaxNumberOfMessages:         10,
+				VisibilityTimeout:           visibilityTimeout,
+				WaitTimeSeconds:             waitTimeSeconds,
+				MessageSystemAttributeNames: []types.MessageSystemAttributeName{types.MessageSystemAttributeNameSentTimestamp},
+			},
+		)
+		if err != nil {
+			return replayed, err
+		}
+
+		if len(val.Messages) == 0 {
+			if s.drained(ctx, url) {
+				break
+			}
+			empty++
+			continue
+		}
+		empty = 0
+
+		for _, msg := range val.Messages {
+			r, err := decode(msg)
+			if err != nil {
+				slog.Error("invalid dead-letter record", "id", aws.ToString(msg.MessageId), "err", err)
+				skipped = append(skipped, aws.ToString(msg.ReceiptHandle))
+				continue
+			}
+
+			if !filter.matches(r) {
+				skipped = append(skipped, aws.ToString(msg.ReceiptHandle))
+				continue
+			}
+
+			if err := s.emit(ctx, r); err != nil {
+				skipped = append(skipped, aws.ToString(msg.ReceiptHandle))
+				return replayed, err
+			}
+
+			_, err = s.queue.DeleteMessage(ctx,
+				&sqs.DeleteMessageInput{
+					QueueUrl:      aws.String(url),
+					ReceiptHandle: msg.ReceiptHandle,
+				},
+			)
+			if err != nil {
+				return replayed, err
+			}
+
+			replayed++
+			slog.Info("event replayed", "uid", evt.UID, "category", r.RequestPayload.DetailType, "failed", r.Timestamp, "reason", r.ResponsePayload.ErrorMessage)
+		}
+	}
+
+	slog.Info("replay completed", "uid", evt.UID, "replayed", replayed, "skipped", len(skipped))
+
+	return replayed, nil
+}
+
+func (s *Service) emit(ctx context.Context, r Record) error {
+	source := r.RequestPayload.Source
+	if source == "" {
+		source = s.name
+	}
+
+	val, err := s.bus.PutEvents(ctx,
+		&eventbridge.PutEventsInput{
+			Entries: []ebtypes.PutEventsRequestEntry{
+				{
+					EventBusName: aws.String(s.name),
+					Source:       aws.String(source),
+					DetailType:   aws.String(r.RequestPayload.DetailType),
+					Detail:       aws.String(string(r.RequestPayload.Detail)),
+				},
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if val.FailedEntryCount > 0 {
+		return fmt.Errorf("failed to replay %s: %s", r.RequestPayload.DetailType, aws.ToString(val.Entries[0].ErrorMessage))
+	}
+
+	return nil
+}
+
+// checks if the queue has no visible messages
+func (s *Service) drained(ctx context.Context, url string) bool {
+	val, err := s.queue.GetQueueAttributes(ctx,
+		&sqs.GetQueueAttributesInput{
+			QueueUrl:       aws.String(url),
+			AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameApproximateNumberOfMessages},
+		},
+	)
+	if err != nil {
+		slog.Warn("failed to read queue attributes", "err", err)
+		return false
+	}
+
+	return val.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessages)] == "0"
+}
+
+// releases skipped records back to the queue
+func (s *Service) release(ctx context.Context, url string, seq []string) {
+	for _, receipt := range seq {
+		_, err := s.queue.ChangeMessageVisibility(ctx,
+			&sqs.ChangeMessageVisibilityInput{
+				QueueUrl:          aws.String(url),
+				ReceiptHandle:     aws.String(receipt),
+				VisibilityTimeout: 0,
+			},
+		)
+		if err != nil {
+			slog.Warn("failed to release dead-letter record", "err", err)
+		}
+	}
+}
+
+// decodes the record of the gateway's dead-letter queue
+func decodeRecord(msg types.Message) (Record, error) {
+	var r Record
+	err := json.Unmarshal([]byte(aws.ToString(msg.Body)), &r)
+	return r, err
+}
+
+// decodes EventCraft of the inbox's dead-letter queue, the time of failure
+// is approximated by the time it was sent.
+func decodeInbox(msg types.Message) (Record, error) {
+	var r Record
+
+	body := []byte(aws.ToString(msg.Body))
+	if !json.Valid(body) {
+		return r, fmt.Errorf("invalid format of EventCraft")
+	}
+
+	if sent, err := strconv.ParseInt(msg.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)], 10, 64); err == nil {
+		r.Timestamp = time.UnixMilli(sent).UTC().Format(time.RFC3339Nano)
+	}
+	r.RequestPayload.DetailType = swarm.TypeOf[events.EventCraft]()
+	r.RequestPayload.Detail = body
+
+	return r, nil
+}
+
+type filter struct {
+	deployment, module string
+	after, before      time.Time
+}
+
+func newFilter(evt events.EventReplay) (*filter, error) {
+	f := &filter{deployment: evt.Deployment, module: evt.Module}
+
+	if evt.After != "" {
+		t, err := time.Parse(time.RFC3339, evt.After)
+		if err != nil {
+			return nil, fmt.Errorf("invalid after %s: %w", evt.After, err)
+		}
+		f.after = t
+	}
+
+	if evt.Before != "" {
+		t, err := time.Parse(time.RFC3339, evt.Before)
+		if err != nil {
+			return nil, fmt.Errorf("invalid before %s: %w", evt.Before, err)
+		}
+		f.before = t
+	}
+
+	return f, nil
+}
+
+func (f *filter) matches(r Record) bool {
+	var detail struct {
+		UID    string `json:"uid"`
+		Module string `json:"module"`
+	}
+	if err := json.Unmarshal(r.RequestPayload.Detail, &detail); err != nil {
+		return false
+	}
+
+	if f.deployment != "" && f.deployment != detail.UID {
+		return false
+	}
+
+	if f.module != "" && f.module != detail.Module {
+		return false
+	}
+
+	if !f.after.IsZero() || !f.before.IsZero() {
+		t, err := time.Parse(time.RFC3339Nano, r.Timestamp)
+		if err != nil {
+			return false
+		}
+
+		if !f.after.IsZero() && t.Before(f.after) {
+			return false
+		}
+
+		if !f.before.IsZero() && !t.Before(f.before) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,232 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/it/v2"
+)
+
+var deadLetter = []string{
+	`{"timestamp": "2024-10-01T10:00:00.000Z", "requestPayload": {"source": "craft", "detail-type": "EventCraft", "detail": {"uid": "a", "module": "m1"}}, "responsePayload": {"errorMessage": "throttled"}}`,
+	`{"timestamp": "2024-10-02T10:00:00.000Z", "requestPayload": {"source": "craft", "detail-type": "EventCraft", "detail": {"uid": "b", "module": "m2"}}, "responsePayload": {"errorMessage": "throttled"}}`,
+	`{"timestamp": "2024-10-03T10:00:00.000Z", "requestPayload": {"source": "craft", "detail-type": "EventRollout", "detail": {"uid": "c", "module": "m1"}}, "responsePayload": {"errorMessage": "throttled"}}`,
+	`not json`,
+}
+
+func TestReplay(t *testing.T) {
+	for name, tt := range map[string]struct {
+		evt    events.EventReplay
+		expect []string
+	}{
+		"All":        {events.EventReplay{UID: "r"}, []string{"a", "b", "c"}},
+		"Deployment": {events.EventReplay{UID: "r", Deployment: "b"}, []string{"b"}},
+		"Module":     {events.EventReplay{UID: "r", Module: "m1"}, []string{"a", "c"}},
+		"After":      {events.EventReplay{UID: "r", After: "2024-10-02T00:00:00Z"}, []string{"b", "c"}},
+		"Range":      {events.EventReplay{UID: "r", After: "2024-10-01T00:00:00Z", Before: "2024-10-02T10:00:00Z"}, []string{"a"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			queue := newMockQueue(deadLetter...)
+			bus := &mockBus{}
+
+			n, err := New(queue, "dlq", bus, "craft").Replay(context.Background(), tt.evt)
+			it.Then(t).Should(
+				it.Nil(err),
+				it.Equal(n, len(tt.expect)),
+				it.Seq(bus.seq).Equal(tt.expect...),
+				it.Equal(len(queue.deleted), len(tt.expect)),
+				it.Equal(len(queue.released), len(deadLetter)-len(tt.expect)),
+			)
+		})
+	}
+}
+
+func TestReplayLongPoll(t *testing.T) {
+	// the queue returns empty receives while messages remain visible
+	queue := newMockQueue(deadLetter...)
+	queue.gaps = emptyReceivesMax - 1
+	bus := &mockBus{}
+
+	n, err := New(queue, "dlq", bus, "craft").Replay(context.Background(), events.EventReplay{UID: "r"})
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(n, 3),
+		it.Seq(bus.seq).Equal("a", "b", "c"),
+	)
+}
+
+func TestReplayInbox(t *testing.T) {
+	queue := newMockQueue(
+		`{"uid": "a", "module": "m1", "context": {}}`,
+		`{"uid": "b", "module": "m2", "context": {}}`,
+		`not json`,
+	)
+	queue.visible[0].Attributes = map[string]string{"SentTimestamp": "1727776800000"}
+	queue.visible[1].Attributes = map[string]string{"SentTimestamp": "1727863200000"}
+	bus := &mockBus{}
+
+	n, err := New(queue, "dlq", bus, "craft", WithInbox("inbox-dlq")).Replay(context.Background(),
+		events.EventReplay{UID: "r", Queue: events.REPLAY_INBOX, After: "2024-10-02T00:00:00Z"},
+	)
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(n, 1),
+		it.Seq(bus.seq).Equal("b"),
+		it.Seq(bus.category).Equal("EventCraft"),
+		it.Seq(queue.urls).Equal("inbox-dlq"),
+		it.Equal(len(queue.released), 2),
+	)
+
+	_, err = New(queue, "dlq", bus, "craft").Replay(context.Background(),
+		events.EventReplay{UID: "r", Queue: events.REPLAY_INBOX},
+	)
+	it.Then(t).ShouldNot(it.Nil(err))
+}
+
+func TestReplayInvalidFilter(t *testing.T) {
+	_, err := New(newMockQueue(), "dlq", &mockBus{}, "craft").Replay(context.Background(),
+		events.EventReplay{UID: "r", After: "yesterday"},
+	)
+	it.Then(t).ShouldNot(it.Nil(err))
+}
+
+func TestReplayArchive(t *testing.T) {
+	api := &mockArchive{}
+	service := New(newMockQueue(), "dlq", &mockBus{}, "craft",
+		WithArchive(api, "arn:archive", "arn:bus", "arn:rule"),
+	)
+
+	err := service.ReplayArchive(context.Background(),
+		events.EventArchiveReplay{UID: "r", After: "2024-10-01T00:00:00Z", Before: "2024-10-02T00:00:00Z"},
+	)
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(len(api.seq), 1),
+		it.Equal(aws.ToString(api.seq[0].ReplayName), "r"),
+		it.Equal(aws.ToString(api.seq[0].EventSourceArn), "arn:archive"),
+		it.Equal(api.seq[0].EventStartTime.Format(time.RFC3339), "2024-10-01T00:00:00Z"),
+		it.Equal(api.seq[0].EventEndTime.Format(time.RFC3339), "2024-10-02T00:00:00Z"),
+		it.Seq(api.seq[0].Destination.FilterArns).Equal("arn:rule"),
+	)
+
+	// redelivered request
+	err = service.ReplayArchive(context.Background(),
+		events.EventArchiveReplay{UID: "r", After: "2024-10-01T00:00:00Z", Before: "2024-10-02T00:00:00Z"},
+	)
+	it.Then(t).Should(it.Nil(err))
+
+	err = service.ReplayArchive(context.Background(), events.EventArchiveReplay{UID: "r"})
+	it.Then(t).ShouldNot(it.Nil(err))
+}
+
+//------------------------------------------------------------------------------
+
+// in-memory queue, received messages are not visible until released.
+// The first receives are empty as many as gaps.
+type mockQueue struct {
+	visible  []types.Message
+	deleted  []string
+	released []string
+	urls     []string
+	gaps     int
+}
+
+func newMockQueue(bodies ...string) *mockQueue {
+	q := &mockQueue{}
+	for i, body := range bodies {
+		q.visible = append(q.visible,
+			types.Message{
+				MessageId:     aws.String(fmt.Sprintf("msg-%d", i)),
+				ReceiptHandle: aws.String(fmt.Sprintf("receipt-%d", i)),
+				Body:          aws.String(body),
+			},
+		)
+	}
+	return q
+}
+
+func (m *mockQueue) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	if !slices.Contains(m.urls, aws.ToString(params.QueueUrl)) {
+		m.urls = append(m.urls, aws.ToString(params.QueueUrl))
+	}
+
+	if m.gaps > 0 {
+		m.gaps--
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+
+	n := min(len(m.visible), 2)
+	seq := m.visible[:n]
+	m.visible = m.visible[n:]
+	return &sqs.ReceiveMessageOutput{Messages: seq}, nil
+}
+
+func (m *mockQueue) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	m.deleted = append(m.deleted, aws.ToString(params.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (m *mockQueue) ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	m.released = append(m.released, aws.ToString(params.ReceiptHandle))
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func (m *mockQueue) GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	return &sqs.GetQueueAttributesOutput{
+		Attributes: map[string]string{"ApproximateNumberOfMessages": strconv.Itoa(len(m.visible))},
+	}, nil
+}
+
+// records uid and category of replayed events
+type mockBus struct {
+	seq      []string
+	category []string
+}
+
+func (m *mockBus) PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	for _, e := range params.Entries {
+		var detail struct {
+			UID string `json:"uid"`
+		}
+		if err := json.Unmarshal([]byte(aws.ToString(e.Detail)), &detail); err != nil {
+			return nil, err
+		}
+		m.seq = append(m.seq, detail.UID)
+		m.category = append(m.category, aws.ToString(e.DetailType))
+	}
+	return &eventbridge.PutEventsOutput{}, nil
+}
+
+// records started replays, names are unique
+type mockArchive struct {
+	seq []*eventbridge.StartReplayInput
+}
+
+func (m *mockArchive) StartReplay(ctx context.Context, params *eventbridge.StartReplayInput, optFns ...func(*eventbridge.Options)) (*eventbridge.StartReplayOutput, error) {
+	for _, r := range m.seq {
+		if aws.ToString(r.ReplayName) == aws.ToString(params.ReplayName) {
+			return nil, &ebtypes.ResourceAlreadyExistsException{}
+		}
+	}
+	m.seq = append(m.seq, params)
+	return &eventbridge.StartReplayOutput{ReplayArn: aws.String("arn:replay")}, nil
+}
@@ -0,0 +1,160 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+	"github.com/fogfish/craft/internal/webhook"
+	"github.com/fogfish/swarm"
+)
+
+type Log interface {
+	Put(ctx context.Context, d *webhook.Delivery) error
+}
+
+type Client interface {
+	Deliver(ctx context.Context, callback, delivery, secret string, payload []byte) ([]webhook.Attempt, error)
+}
+
+type Secrets interface {
+	Secret(ctx context.Context, subscriber string) (string, error)
+	Reset()
+}
+
+type Service struct {
+	client  Client
+	secrets Secrets
+	log     Log
+}
+
+// New service delivers status of deployments to callbacks, secrets are
+// shared with subscribers, identified by host of the callback.
+func New(client Client, secrets Secrets, log Log) *Service {
+	return &Service{
+		client:  client,
+		secrets: secrets,
+		log:     log,
+	}
+}
+
+func (s *Service) Run(rcv <-chan swarm.Msg[events.EventDeployment], ack chan<- swarm.Msg[events.EventDeployment]) {
+	for msg := range rcv {
+		if err := s.Notify(context.Background(), msg.Object); err != nil {
+			ack <- msg.Fail(err)
+			continue
+		}
+
+		ack <- msg
+	}
+}
+
+// Notify the callback about terminal status of the deployment. Failed
+// delivery is logged, it is not retried beyond the client's back-off.
+func (s *Service) Notify(ctx context.Context, evt events.EventDeployment) error {
+	if evt.Callback == "" || !terminal(evt.Status) {
+		return nil
+	}
+
+	d := &webhook.Delivery{
+		UID:        evt.UID,
+		Callback:   evt.Callback,
+		Deployment: evt.Status,
+		Delivery:   webhook.DeliveryID(evt.UID, evt.Status, evt.Job),
+	}
+
+	subscriber, err := webhook.Subscriber(evt.Callback)
+	if err != nil {
+		return s.failed(ctx, d, err)
+	}
+	d.Subscriber = subscriber
+
+	secret, err := s.secrets.Secret(ctx, subscriber)
+	switch {
+	case errors.Is(err, webhook.ErrUnknownSubscriber):
+		return s.failed(ctx, d, err)
+	case err != nil:
+		return err
+	}
+
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	d.Attempts, err = s.client.Deliver(ctx, evt.Callback, d.Delivery, secret, payload)
+	if err != nil && rejected(d.Attempts) {
+		// the secret might be rotated since it was loaded
+		var rotated string
+		rotated, err = s.rotated(ctx, subscriber, secret)
+		if err == nil {
+			var attempts []webhook.Attempt
+			attempts, err = s.client.Deliver(ctx, evt.Callback, d.Delivery, rotated, payload)
+			d.Attempts = append(d.Attempts, attempts...)
+		}
+	}
+	if err != nil {
+		return s.failed(ctx, d, err)
+	}
+
+	d.Status = webhook.DELIVERY_DELIVERED
+	slog.Info("webhook delivered", "uid", evt.UID, "subscriber", subscriber, "attempts", len(d.Attempts))
+
+	return s.log.Put(ctx, d)
+}
+
+func (s *Service) failed(ctx context.Context, d *webhook.Delivery, err error) error {
+	d.Status = webhook.DELIVERY_FAILED
+	d.Reason = err.Error()
+
+	slog.Warn("webhook failed", "uid", d.UID, "subscriber", d.Subscriber, "attempts", len(d.Attempts), "reason", d.Reason)
+
+	return s.log.Put(ctx, d)
+}
+
+// rotated secret of the subscriber, it is reloaded
+func (s *Service) rotated(ctx context.Context, subscriber, secret string) (string, error) {
+	s.secrets.Reset()
+
+	rotated, err := s.secrets.Secret(ctx, subscriber)
+	if err != nil {
+		return "", err
+	}
+
+	if rotated == secret {
+		return "", errors.New("signature is rejected")
+	}
+
+	return rotated, nil
+}
+
+// the callback rejects signature of the delivery
+func rejected(attempts []webhook.Attempt) bool {
+	if len(attempts) == 0 {
+		return false
+	}
+
+	code := attempts[len(attempts)-1].Code
+	return code == http.StatusUnauthorized || code == http.StatusForbidden
+}
+
+func terminal(status string) bool {
+	switch status {
+	case registry.STATUS_SUCCEEDED, registry.STATUS_FAILED, registry.STATUS_DISCARDED:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,295 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/webhook"
+	"github.com/fogfish/it/v2"
+)
+
+func TestNotify(t *testing.T) {
+	srv := newSubscriber("secret", http.StatusServiceUnavailable, http.StatusOK)
+	defer srv.Close()
+
+	log := &mockLog{}
+	service := New(newClient(), newSecrets(`{"127.0.0.1": "secret"}`), log)
+
+	err := service.Notify(context.Background(),
+		events.EventDeployment{UID: "a", Module: "m", Status: "succeeded", Callback: srv.URL},
+	)
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(len(srv.seq), 1),
+		it.Equal(srv.seq[0].UID, "a"),
+		it.Equal(srv.seq[0].Status, "succeeded"),
+		it.Equal(len(log.seq), 1),
+		it.Equal(log.seq[0].Status, webhook.DELIVERY_DELIVERED),
+		it.Equal(log.seq[0].Subscriber, "127.0.0.1"),
+		it.Equal(len(log.seq[0].Attempts), 2),
+		it.Equal(log.seq[0].Attempts[0].Code, http.StatusServiceUnavailable),
+		it.Equal(log.seq[0].Attempts[1].Code, http.StatusOK),
+		it.Equal(srv.deliveries[0], webhook.DeliveryID("a", "succeeded", "")),
+		it.Equal(log.seq[0].Delivery, srv.deliveries[0]),
+	)
+}
+
+func TestNotifyDelivery(t *testing.T) {
+	srv := newSubscriber("secret")
+	defer srv.Close()
+
+	service := New(newClient(), newSecrets(`{"127.0.0.1": "secret"}`), &mockLog{})
+
+	// redelivered status has same identity, the status of retried job differs
+	for _, evt := range []events.EventDeployment{
+		{UID: "a", Module: "m", Status: "failed", Job: "job-1", Callback: srv.URL},
+		{UID: "a", Module: "m", Status: "failed", Job: "job-1", Callback: srv.URL},
+		{UID: "a", Module: "m", Status: "failed", Job: "job-2", Callback: srv.URL},
+		{UID: "a", Module: "m", Status: "succeeded", Job: "job-2", Callback: srv.URL},
+	} {
+		err := service.Notify(context.Background(), evt)
+		it.Then(t).Should(it.Nil(err))
+	}
+
+	it.Then(t).Should(
+		it.Equal(len(srv.deliveries), 4),
+		it.Equal(srv.deliveries[0], srv.deliveries[1]),
+	).ShouldNot(
+		it.Equal(srv.deliveries[0], "a"),
+		it.Equal(srv.deliveries[1], srv.deliveries[2]),
+		it.Equal(srv.deliveries[2], srv.deliveries[3]),
+	)
+}
+
+func TestNotifyRotatedSecret(t *testing.T) {
+	srv := newSubscriber("rotated")
+	defer srv.Close()
+
+	api := &mockSecretsManager{val: `{"127.0.0.1": "secret"}`}
+	secrets := webhook.NewSecrets(api, "test", time.Hour)
+
+	log := &mockLog{}
+	service := New(newClient(), secrets, log)
+
+	// the secret is loaded and cached
+	_, err := secrets.Secret(context.Background(), "127.0.0.1")
+	it.Then(t).Should(it.Nil(err))
+
+	// the secret is rotated, rejected signature reloads it
+	api.val = `{"127.0.0.1": "rotated"}`
+	err = service.Notify(context.Background(),
+		events.EventDeployment{UID: "a", Module: "m", Status: "succeeded", Callback: srv.URL},
+	)
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(api.loaded, 2),
+		it.Equal(len(srv.seq), 1),
+		it.Equal(log.seq[0].Status, webhook.DELIVERY_DELIVERED),
+		it.Equal(len(log.seq[0].Attempts), 2),
+		it.Equal(log.seq[0].Attempts[0].Code, http.StatusUnauthorized),
+	)
+}
+
+func TestSecretsTTL(t *testing.T) {
+	api := &mockSecretsManager{val: `{"127.0.0.1": "secret"}`}
+	secrets := webhook.NewSecrets(api, "test", 10*time.Millisecond)
+
+	_, err := secrets.Secret(context.Background(), "127.0.0.1")
+	it.Then(t).Should(it.Nil(err))
+
+	// new subscriber is picked up once cache is expired
+	api.val = `{"127.0.0.1": "secret", "example.com": "other"}`
+	_, err = secrets.Secret(context.Background(), "example.com")
+	it.Then(t).Should(
+		it.True(errors.Is(err, webhook.ErrUnknownSubscriber)),
+		it.Equal(api.loaded, 1),
+	)
+
+	time.Sleep(20 * time.Millisecond)
+	secret, err := secrets.Secret(context.Background(), "example.com")
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(secret, "other"),
+		it.Equal(api.loaded, 2),
+	)
+}
+
+func TestNotifyFailed(t *testing.T) {
+	for name, tt := range map[string]struct {
+		codes    []int
+		attempts int
+	}{
+		"Exhausted": {[]int{http.StatusInternalServerError}, 3},
+		"Throttled": {[]int{http.StatusTooManyRequests}, 3},
+		"Rejected":  {[]int{http.StatusBadRequest}, 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := newSubscriber("secret", tt.codes...)
+			defer srv.Close()
+
+			log := &mockLog{}
+			service := New(newClient(), newSecrets(`{"127.0.0.1": "secret"}`), log)
+
+			err := service.Notify(context.Background(),
+				events.EventDeployment{UID: "a", Module: "m", Status: "failed", Callback: srv.URL},
+			)
+			it.Then(t).Should(
+				it.Nil(err),
+				it.Equal(len(srv.seq), 0),
+				it.Equal(len(log.seq), 1),
+				it.Equal(log.seq[0].Status, webhook.DELIVERY_FAILED),
+				it.Equal(len(log.seq[0].Attempts), tt.attempts),
+			)
+		})
+	}
+}
+
+func TestNotifyInvalidSignature(t *testing.T) {
+	srv := newSubscriber("other")
+	defer srv.Close()
+
+	log := &mockLog{}
+	service := New(newClient(), newSecrets(`{"127.0.0.1": "secret"}`), log)
+
+	err := service.Notify(context.Background(),
+		events.EventDeployment{UID: "a", Module: "m", Status: "succeeded", Callback: srv.URL},
+	)
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(len(srv.seq), 0),
+		it.Equal(log.seq[0].Status, webhook.DELIVERY_FAILED),
+		it.Equal(log.seq[0].Attempts[0].Code, http.StatusUnauthorized),
+	)
+}
+
+func TestNotifyUnknownSubscriber(t *testing.T) {
+	srv := newSubscriber("secret")
+	defer srv.Close()
+
+	log := &mockLog{}
+	service := New(newClient(), newSecrets(`{"example.com": "secret"}`), log)
+
+	err := service.Notify(context.Background(),
+		events.EventDeployment{UID: "a", Module: "m", Status: "succeeded", Callback: srv.URL},
+	)
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(len(srv.seq), 0),
+		it.Equal(len(log.seq), 1),
+		it.Equal(log.seq[0].Status, webhook.DELIVERY_FAILED),
+		it.Equal(len(log.seq[0].Attempts), 0),
+	)
+}
+
+func TestNotifySkip(t *testing.T) {
+	srv := newSubscriber("secret")
+	defer srv.Close()
+
+	log := &mockLog{}
+	service := New(newClient(), newSecrets(`{"127.0.0.1": "secret"}`), log)
+
+	for _, evt := range []events.EventDeployment{
+		{UID: "a", Module: "m", Status: "succeeded"},
+		{UID: "a", Module: "m", Status: "pending", Callback: srv.URL},
+	} {
+		err := service.Notify(context.Background(), evt)
+		it.Then(t).Should(it.Nil(err))
+	}
+
+	it.Then(t).Should(
+		it.Equal(len(srv.seq), 0),
+		it.Equal(len(log.seq), 0),
+	)
+}
+
+//------------------------------------------------------------------------------
+
+func newClient() *webhook.Client {
+	return webhook.New(webhook.WithRetry(3, time.Millisecond))
+}
+
+// subscriber verifies signature of deliveries, it replies with given codes,
+// the last code is repeated. Accepted deliveries are recorded.
+type subscriber struct {
+	*httptest.Server
+	seq        []events.EventDeployment
+	deliveries []string
+}
+
+func newSubscriber(secret string, codes ...int) *subscriber {
+	s := &subscriber{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		sign := webhook.Sign(secret, r.Header.Get(webhook.HEADER_TIMESTAMP), body)
+		if r.Header.Get(webhook.HEADER_SIGNATURE) != sign {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		code := http.StatusOK
+		if len(codes) > 0 {
+			code = codes[0]
+			if len(codes) > 1 {
+				codes = codes[1:]
+			}
+		}
+
+		if code < 300 {
+			var evt events.EventDeployment
+			if err := json.Unmarshal(body, &evt); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			s.seq = append(s.seq, evt)
+			s.deliveries = append(s.deliveries, r.Header.Get(webhook.HEADER_DELIVERY))
+		}
+
+		w.WriteHeader(code)
+	}))
+	return s
+}
+
+func newSecrets(val string) *webhook.Secrets {
+	return webhook.NewSecrets(&mockSecretsManager{val: val}, "test", time.Hour)
+}
+
+type mockSecretsManager struct {
+	val    string
+	loaded int
+}
+
+func (m *mockSecretsManager) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	m.loaded++
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(m.val)}, nil
+}
+
+type mockLog struct {
+	seq []webhook.Delivery
+}
+
+func (m *mockLog) Put(ctx context.Context, d *webhook.Delivery) error {
+	m.seq = append(m.seq, *d)
+	return nil
+}
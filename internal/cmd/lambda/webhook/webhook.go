@@ -0,0 +1,63 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/webhook"
+	_ "github.com/fogfish/logger/v3"
+	"github.com/fogfish/swarm"
+	"github.com/fogfish/swarm/broker/eventbridge"
+	"github.com/fogfish/swarm/dequeue"
+)
+
+func main() {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		slog.Error("fatal failure of aws client", "err", err)
+		panic(err)
+	}
+
+	// Run event consumption loop
+	service := New(
+		webhook.New(),
+		webhook.NewSecrets(
+			secretsmanager.NewFromConfig(cfg),
+			os.Getenv("CONFIG_WEBHOOK_SECRETS"),
+			5*time.Minute,
+		),
+		webhook.NewStore(
+			dynamodb.NewFromConfig(cfg),
+			os.Getenv("CONFIG_WEBHOOKS"),
+		),
+	)
+
+	q, err := eventbridge.NewDequeuer("default",
+		eventbridge.WithConfig(
+			swarm.WithLogStdErr(),
+		),
+	)
+	if err != nil {
+		slog.Error("fatal failure of eventbrige client", "err", err)
+		panic(err)
+	}
+
+	go service.Run(dequeue.Typed[events.EventDeployment](q))
+
+	q.Await()
+}
@@ -0,0 +1,163 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+// Package debounce implements coalescing of successive deployments of
+// the tenant's module, deployments are buffered within the window and
+// merged into the one.
+package debounce
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/fogfish/craft/internal/events"
+)
+
+// Buffer of deployments of the tenant's module
+type Buffer struct {
+	Tenant string `dynamodbav:"tenant"`
+	Module string `dynamodbav:"module"`
+
+	// Identity of the deployment, the buffer is opened by
+	UID string `dynamodbav:"uid"`
+
+	// Identities of all buffered deployments
+	UIDs []string `dynamodbav:"uids"`
+
+	// Merged deployment, the most recent values win
+	Version string          `dynamodbav:"version,omitempty"`
+	Context json.RawMessage `dynamodbav:"context,omitempty"`
+	Account string          `dynamodbav:"account,omitempty"`
+	Region  string          `dynamodbav:"region,omitempty"`
+	Role    string          `dynamodbav:"role,omitempty"`
+
+	Callback string `dynamodbav:"callback,omitempty"`
+	ReplyTo  string `dynamodbav:"replyTo,omitempty"`
+
+	// Sequence number of the update, used for optimistic locking
+	Seq int `dynamodbav:"seq"`
+
+	Opened string `dynamodbav:"opened,omitempty"`
+}
+
+// New buffer of the deployment
+func New(evt events.EventCraft) *Buffer {
+	return &Buffer{
+		Tenant:  evt.Tenant,
+		Module:  evt.Module,
+		UID:     evt.UID,
+		UIDs:    []string{evt.UID},
+		Version: evt.Version,
+		Context: evt.Context,
+		Account: evt.Account,
+		Region:  evt.Region,
+		Role:    evt.Role,
+
+		Callback: evt.Callback,
+		ReplyTo:  evt.ReplyTo,
+		Opened:   time.Now().UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// Merge the buffer with more recent one. Contexts are merged using JSON
+// Merge Patch (RFC 7386): objects are merged recursively, other values are
+// replaced and null removes the key. Deployments are merged once.
+func (b *Buffer) Merge(other *Buffer) error {
+	fresh := false
+	for _, uid := range other.UIDs {
+		if !slices.Contains(b.UIDs, uid) {
+			b.UIDs = append(b.UIDs, uid)
+			fresh = true
+		}
+	}
+
+	if !fresh {
+		return nil
+	}
+
+	context, err := Merge(b.Context, other.Context)
+	if err != nil {
+		return err
+	}
+	b.Context = context
+
+	for _, kv := range [][2]*string{
+		{&b.Version, &other.Version},
+		{&b.Account, &other.Account},
+		{&b.Region, &other.Region},
+		{&b.Role, &other.Role},
+		{&b.Callback, &other.Callback},
+		{&b.ReplyTo, &other.ReplyTo},
+	} {
+		if *kv[1] != "" {
+			*kv[0] = *kv[1]
+		}
+	}
+
+	return nil
+}
+
+// Event of merged deployment
+func (b *Buffer) Event() events.EventCraft {
+	return events.EventCraft{
+		UID:     b.UID,
+		Tenant:  b.Tenant,
+		Module:  b.Module,
+		Version: b.Version,
+		Context: b.Context,
+		Account: b.Account,
+		Region:  b.Region,
+		Role:    b.Role,
+
+		Callback: b.Callback,
+		ReplyTo:  b.ReplyTo,
+	}
+}
+
+// Merge the patch into JSON document (RFC 7386)
+func Merge(doc, patch json.RawMessage) (json.RawMessage, error) {
+	if len(patch) == 0 {
+		return doc, nil
+	}
+
+	var a, b any
+	if len(doc) != 0 {
+		if err := json.Unmarshal(doc, &a); err != nil {
+			return nil, fmt.Errorf("invalid context: %w", err)
+		}
+	}
+	if err := json.Unmarshal(patch, &b); err != nil {
+		return nil, fmt.Errorf("invalid context: %w", err)
+	}
+
+	return json.Marshal(merge(a, b))
+}
+
+func merge(doc, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	d, ok := doc.(map[string]any)
+	if !ok {
+		d = map[string]any{}
+	}
+
+	for key, val := range p {
+		if val == nil {
+			delete(d, key)
+			continue
+		}
+		d[key] = merge(d[key], val)
+	}
+
+	return d
+}
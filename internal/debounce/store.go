@@ -0,0 +1,172 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package debounce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+)
+
+// number of attempts to append the deployment to concurrently updated buffer
+const attempts = 5
+
+// DynamoDB declares the subset of interface from AWS SDK used by the store.
+type DynamoDB interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+// Store of buffers, the buffer is identified by tenant and module
+type Store struct {
+	api   DynamoDB
+	table string
+}
+
+func NewStore(api DynamoDB, table string) *Store {
+	return &Store{
+		api:   api,
+		table: table,
+	}
+}
+
+// Append the buffer to the stored one, the buffer is created if it does
+// not exist. It returns the stored buffer.
+func (s *Store) Append(ctx context.Context, b *Buffer) (*Buffer, error) {
+	for i := 0; i < attempts; i++ {
+		stored, err := s.get(ctx, b.Tenant, b.Module)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			stored = b
+			stored.Seq = 0
+			err = s.put(ctx, stored, "attribute_not_exists(#tenant)",
+				map[string]string{"#tenant": "tenant"},
+				nil,
+			)
+		case err != nil:
+			return nil, err
+		default:
+			if err := stored.Merge(b); err != nil {
+				return nil, err
+			}
+			seq := stored.Seq
+			stored.Seq++
+			err = s.put(ctx, stored, "#seq = :seq",
+				map[string]string{"#seq": "seq"},
+				map[string]types.AttributeValue{
+					":seq": &types.AttributeValueMemberN{Value: strconv.Itoa(seq)},
+				},
+			)
+		}
+
+		if errors.Is(err, ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return stored, nil
+	}
+
+	return nil, fmt.Errorf("buffer %s of %s: %w", b.Module, b.Tenant, ErrConflict)
+}
+
+// Take the buffer out of the store, it returns ErrNotFound if the buffer
+// does not exist (e.g. it has been taken already).
+func (s *Store) Take(ctx context.Context, tenant, module string) (*Buffer, error) {
+	val, err := s.api.DeleteItem(ctx,
+		&dynamodb.DeleteItemInput{
+			TableName:    aws.String(s.table),
+			Key:          key(tenant, module),
+			ReturnValues: types.ReturnValueAllOld,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(val.Attributes) == 0 {
+		return nil, fmt.Errorf("buffer %s of %s: %w", module, tenant, ErrNotFound)
+	}
+
+	var b Buffer
+	if err := attributevalue.UnmarshalMap(val.Attributes, &b); err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+func (s *Store) get(ctx context.Context, tenant, module string) (*Buffer, error) {
+	val, err := s.api.GetItem(ctx,
+		&dynamodb.GetItemInput{
+			TableName:      aws.String(s.table),
+			Key:            key(tenant, module),
+			ConsistentRead: aws.Bool(true),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if val.Item == nil {
+		return nil, fmt.Errorf("buffer %s of %s: %w", module, tenant, ErrNotFound)
+	}
+
+	var b Buffer
+	if err := attributevalue.UnmarshalMap(val.Item, &b); err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+func (s *Store) put(ctx context.Context, b *Buffer, cond string, names map[string]string, values map[string]types.AttributeValue) error {
+	item, err := attributevalue.MarshalMap(b)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.api.PutItem(ctx,
+		&dynamodb.PutItemInput{
+			TableName:                 aws.String(s.table),
+			Item:                      item,
+			ConditionExpression:       aws.String(cond),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		},
+	)
+
+	var conflict *types.ConditionalCheckFailedException
+	if errors.As(err, &conflict) {
+		return fmt.Errorf("buffer %s of %s: %w", b.Module, b.Tenant, ErrConflict)
+	}
+
+	return err
+}
+
+func key(tenant, module string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"tenant": &types.AttributeValueMemberS{Value: tenant},
+		"module": &types.AttributeValueMemberS{Value: module},
+	}
+}
@@ -0,0 +1,88 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+// Package desired implements the desired state of tenants, the module,
+// version and context that the reconciler keeps deployed per tenant.
+package desired
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+// Back-off of reconciliation attempts
+const (
+	BACKOFF_MIN = 5 * time.Minute
+	BACKOFF_MAX = 6 * time.Hour
+)
+
+// State of the module, desired by tenant
+type State struct {
+	Tenant  string          `dynamodbav:"tenant"`
+	Module  string          `dynamodbav:"module"`
+	Version string          `dynamodbav:"version,omitempty"`
+	Context json.RawMessage `dynamodbav:"context,omitempty"`
+	Account string          `dynamodbav:"account,omitempty"`
+	Region  string          `dynamodbav:"region,omitempty"`
+	Role    string          `dynamodbav:"role,omitempty"`
+	Mode    string          `dynamodbav:"mode,omitempty"`
+
+	// Number of reconciliation attempts and time of the next one
+	Attempts int    `dynamodbav:"attempts"`
+	Retry    string `dynamodbav:"retry,omitempty"`
+
+	// Time when the state was declared, used for optimistic locking
+	Declared string `dynamodbav:"declared"`
+}
+
+// Same returns true if the deployment of version and context matches
+// the desired state.
+func (s *State) Same(version string, context json.RawMessage) bool {
+	if s.Version != version {
+		return false
+	}
+
+	var a, b any
+	if err := json.Unmarshal(s.Context, &a); err != nil {
+		return false
+	}
+	if err := json.Unmarshal(context, &b); err != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// Due returns true if the reconciliation attempt is allowed at the time
+func (s *State) Due(now time.Time) bool {
+	if s.Retry == "" {
+		return true
+	}
+
+	t, err := time.Parse(time.RFC3339, s.Retry)
+	return err != nil || !now.Before(t)
+}
+
+// Backoff records the reconciliation attempt, the next attempt is delayed
+// exponentially from BACKOFF_MIN to BACKOFF_MAX.
+func (s *State) Backoff(now time.Time) {
+	delay := BACKOFF_MAX
+	if s.Attempts < 16 {
+		delay = min(BACKOFF_MIN<<s.Attempts, BACKOFF_MAX)
+	}
+
+	s.Attempts++
+	s.Retry = now.Add(delay).UTC().Format(time.RFC3339)
+}
+
+// Reset the back-off once the tenant is reconciled
+func (s *State) Reset() {
+	s.Attempts = 0
+	s.Retry = ""
+}
@@ -0,0 +1,139 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package desired
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var ErrConflict = errors.New("conflict")
+
+// DynamoDB declares the subset of interface from AWS SDK used by the store.
+type DynamoDB interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+}
+
+// Store of desired states, the state is identified by tenant and module
+type Store struct {
+	api   DynamoDB
+	table string
+}
+
+func NewStore(api DynamoDB, table string) *Store {
+	return &Store{
+		api:   api,
+		table: table,
+	}
+}
+
+// Put declares the desired state, it overrides the previous one
+// and resets the back-off.
+func (s *Store) Put(ctx context.Context, state *State) error {
+	state.Reset()
+	state.Declared = time.Now().UTC().Format(time.RFC3339Nano)
+
+	item, err := attributevalue.MarshalMap(state)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.api.PutItem(ctx,
+		&dynamodb.PutItemInput{
+			TableName: aws.String(s.table),
+			Item:      item,
+		},
+	)
+
+	return err
+}
+
+// Update the back-off of desired state, it returns ErrConflict if
+// the state has been declared again since it was read.
+func (s *Store) Update(ctx context.Context, state *State) error {
+	item, err := attributevalue.MarshalMap(state)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.api.PutItem(ctx,
+		&dynamodb.PutItemInput{
+			TableName:           aws.String(s.table),
+			Item:                item,
+			ConditionExpression: aws.String("#declared = :declared"),
+			ExpressionAttributeNames: map[string]string{
+				"#declared": "declared",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":declared": &types.AttributeValueMemberS{Value: state.Declared},
+			},
+		},
+	)
+
+	var conflict *types.ConditionalCheckFailedException
+	if errors.As(err, &conflict) {
+		return fmt.Errorf("desired state %s of %s: %w", state.Module, state.Tenant, ErrConflict)
+	}
+
+	return err
+}
+
+// Delete the desired state of tenant's module, the module is not reconciled
+func (s *Store) Delete(ctx context.Context, tenant, module string) error {
+	_, err := s.api.DeleteItem(ctx,
+		&dynamodb.DeleteItemInput{
+			TableName: aws.String(s.table),
+			Key: map[string]types.AttributeValue{
+				"tenant": &types.AttributeValueMemberS{Value: tenant},
+				"module": &types.AttributeValueMemberS{Value: module},
+			},
+		},
+	)
+
+	return err
+}
+
+// List all desired states
+func (s *Store) List(ctx context.Context) ([]State, error) {
+	seq := make([]State, 0)
+
+	var cursor map[string]types.AttributeValue
+	for {
+		val, err := s.api.Scan(ctx,
+			&dynamodb.ScanInput{
+				TableName:         aws.String(s.table),
+				ConsistentRead:    aws.Bool(true),
+				ExclusiveStartKey: cursor,
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		page := make([]State, 0, len(val.Items))
+		if err := attributevalue.UnmarshalListOfMaps(val.Items, &page); err != nil {
+			return nil, err
+		}
+		seq = append(seq, page...)
+
+		if val.LastEvaluatedKey == nil {
+			return seq, nil
+		}
+		cursor = val.LastEvaluatedKey
+	}
+}
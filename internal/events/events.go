@@ -14,6 +14,66 @@ import (
 
 const EVENT_CRAFT = "craft.event.json"
 
+// Modes of crafting the module
+const (
+	// Deploy the module
+	MODE_DEPLOY = "deploy"
+
+	// Create change set without executing it and report changes
+	// as EventCraftDiff
+	MODE_DIFF = "diff"
+
+	// Create change set and request approval of changes using
+	// EventApprovalRequested. The change set is executed or deleted upon
+	// EventApproval with same UID.
+	MODE_APPROVAL = "approval"
+
+	// Detect drift of deployed stacks from their templates and report
+	// drift of each stack as EventDrift
+	MODE_DRIFT = "drift"
+
+	// Destroy stacks of the module, used by deprovisioning of tenant
+	MODE_DESTROY = "destroy"
+)
+
+// Decisions on requested approval
+const (
+	DECISION_APPROVE = "approve"
+	DECISION_REJECT  = "reject"
+	DECISION_EXPIRE  = "expire"
+)
+
+// Status of fleet rollout
+const (
+	ROLLOUT_RUNNING   = "running"
+	ROLLOUT_HALTED    = "halted"
+	ROLLOUT_ABORTED   = "aborted"
+	ROLLOUT_COMPLETED = "completed"
+)
+
+// Status of tenant lifecycle
+const (
+	TENANT_PROVISIONING   = "provisioning"
+	TENANT_ACTIVE         = "active"
+	TENANT_SUSPENDED      = "suspended"
+	TENANT_DEPROVISIONING = "deprovisioning"
+	TENANT_DELETED        = "deleted"
+)
+
+// Transitions of tenant lifecycle
+const (
+	TRANSITION_PROVISION   = "provision"
+	TRANSITION_SUSPEND     = "suspend"
+	TRANSITION_RESUME      = "resume"
+	TRANSITION_DEPROVISION = "deprovision"
+)
+
+// Controls of fleet rollout
+const (
+	ACTION_RESUME = "resume"
+	ACTION_ABORT  = "abort"
+)
+
 // Craft cloud resources using the module
 type EventCraft struct {
 	// Unique identity of event (job), use it follow up deployment status
@@ -26,6 +86,495 @@ type EventCraft struct {
 	// (e.g. github.com/fogfish/app)
 	Module string `json:"module,omitempty"`
 
+	// Version of deployable module, the template is served from
+	// {module}@{version} prefix of the bucket
+	// (e.g. v1.2.3)
+	Version string `json:"version,omitempty"`
+
+	// Identity of tenant, whose stack is crafted by the module. The craft
+	// keeps history of tenant's deployments.
+	Tenant string `json:"tenant,omitempty"`
+
 	// AWS CDK Context, the raw content of cdk.context.json file.
 	Context json.RawMessage `json:"context,omitempty"`
+
+	// Target AWS Account and Region to deploy the module into. The account
+	// must be trusted by craft. Default: the account and region of craft.
+	Account string `json:"account,omitempty"`
+	Region  string `json:"region,omitempty"`
+
+	// Name of IAM Role at target account, the job assumes to deploy the module
+	// (e.g. craft-deploy). The job uses AWS CDK bootstrap roles of
+	// the target account if the role is not defined.
+	Role string `json:"role,omitempty"`
+
+	// Mode of crafting (deploy, diff, approval). Default: deploy.
+	Mode string `json:"mode,omitempty"`
+
+	// Deploy the module not before the time (RFC3339, e.g. 2024-10-01T02:00:00Z)
+	// or at next occurrence of cron expression (e.g. 0 2 ? * SUN *). The cron
+	// expression is evaluated at timezone (e.g. Europe/Helsinki). Default: UTC.
+	NotBefore string `json:"notBefore,omitempty"`
+	Cron      string `json:"cron,omitempty"`
+	Timezone  string `json:"timezone,omitempty"`
+
+	// Name of schedule, which has emitted the delayed deployment. It is
+	// defined by craft, the schedule is deleted once the event is emitted.
+	Schedule string `json:"schedule,omitempty"`
+
+	// Sequence number of tenant's deployment, assigned by the producer. The
+	// craft applies deployments of tenant's module in the order of sequence,
+	// the deployment older than the last applied one is parked.
+	Seq int64 `json:"seq,omitempty"`
+
+	// URL of webhook, the craft POSTs EventDeployment signed by the secret
+	// of subscriber (the host of URL) once the job is completed.
+	Callback string `json:"callback,omitempty"`
+
+	// ARN of AWS EventBridge bus at the producer's account, the craft
+	// delivers status (EventDeployment) and outputs (EventCraftDiff,
+	// EventApprovalRequested) of the deployment to the bus.
+	ReplyTo string `json:"replyTo,omitempty"`
+}
+
+// Detect drift of deployed stacks, the most recent succeeded deployment of
+// each module per tenant is checked by the drift job.
+type EventDriftDetection struct {
+	// Unique identity of event, drift jobs are identified as {uid}-{index}
+	UID string `json:"uid,omitempty"`
+
+	// Only deployments of tenant are checked. Default: all tenants.
+	Tenant string `json:"tenant,omitempty"`
+
+	// Redeploy the recorded module and context of drifted stacks
+	Remediate bool `json:"remediate,omitempty"`
+}
+
+// Drift of the stack from its template. The event is emitted by the job
+// in drift mode for each stack of the module.
+type EventDrift struct {
+	// Unique identity of drift job
+	UID string `json:"uid,omitempty"`
+
+	// Identity of checked deployment
+	Deployment string `json:"deployment,omitempty"`
+
+	Tenant  string `json:"tenant,omitempty"`
+	Module  string `json:"module,omitempty"`
+	Version string `json:"version,omitempty"`
+	Stack   string `json:"stack,omitempty"`
+
+	// Drift status of the stack (DRIFTED, IN_SYNC, UNKNOWN)
+	Status string `json:"status,omitempty"`
+
+	// Modified and deleted resources of the stack
+	Resources []DriftedResource `json:"resources,omitempty"`
+
+	// S3 location of property differences of drifted resources
+	Drifts string `json:"drifts,omitempty"`
+}
+
+// Resource of the stack, which differs from its template
+type DriftedResource struct {
+	LogicalId  string `json:"logicalId,omitempty"`
+	PhysicalId string `json:"physicalId,omitempty"`
+	Type       string `json:"type,omitempty"`
+	Status     string `json:"status,omitempty"`
+}
+
+// Reconcile tenants to their desired state, the desired state is declared
+// by the most recent deployment of each module per tenant. Tenants, which
+// differ from the desired state or have failed, are redeployed with back-off.
+type EventReconcile struct {
+	// Unique identity of event, deployments are identified as {uid}-{index}
+	UID string `json:"uid,omitempty"`
+
+	// Only tenant is reconciled. Default: all tenants.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Cancel the delayed deployment, which is not started yet.
+type EventScheduleCancel struct {
+	// Unique identity of delayed deployment
+	UID string `json:"uid,omitempty"`
+}
+
+// Request list of delayed deployments, the craft replies with EventSchedules.
+type EventScheduleList struct {
+	// Unique identity of request, the reply uses same identity
+	UID string `json:"uid,omitempty"`
+
+	// Only deployments of tenant are listed. Default: all deployments.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// List of delayed deployments
+type EventSchedules struct {
+	UID       string       `json:"uid,omitempty"`
+	Schedules []EventCraft `json:"schedules"`
+}
+
+// Replay events failed by the gateway, the dead-letter queue keeps them
+// with the failure reason. Only events matching all defined filters are
+// replayed and removed from the queue, others are kept.
+type EventReplay struct {
+	// Unique identity of request
+	UID string `json:"uid,omitempty"`
+
+	// Only events of deployment or module are replayed. Default: all events.
+	Deployment string `json:"deployment,omitempty"`
+	Module     string `json:"module,omitempty"`
+
+	// Only events failed within the time range (RFC3339) are replayed.
+	// Default: all events.
+	After  string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+
+	// Dead-letter queue to replay: gateway or inbox. Default: gateway.
+	Queue string `json:"queue,omitempty"`
+}
+
+// Dead-letter queues of the replay
+const (
+	REPLAY_GATEWAY = "gateway"
+	REPLAY_INBOX   = "inbox"
+)
+
+// Replay events of the craft's archive within the time range (RFC3339),
+// e.g. to rebuild the registry. Events are replayed to the gateway only,
+// deployments already recorded by the registry are not submitted again.
+type EventArchiveReplay struct {
+	// Unique identity of request, it names the replay
+	UID string `json:"uid,omitempty"`
+
+	After  string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+}
+
+// Deploy the module to many targets using single AWS Batch array job.
+// Each child job of the array deploys the module to one target.
+type EventCraftArray struct {
+	// Unique identity of event (array job), child jobs are identified as
+	// {uid}-{index}, where index is the position of target.
+	UID string `json:"uid,omitempty"`
+
+	// Deployable module and its version
+	Module  string `json:"module,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// Targets of deployment, 2 to 10000 targets are supported
+	Targets []Target `json:"targets,omitempty"`
+}
+
+// Target of the module deployment within the array job
+type Target struct {
+	Tenant  string          `json:"tenant,omitempty"`
+	Context json.RawMessage `json:"context,omitempty"`
+	Account string          `json:"account,omitempty"`
+	Region  string          `json:"region,omitempty"`
+	Role    string          `json:"role,omitempty"`
+}
+
+// Aggregated results of the array job, the event is emitted by craft
+// once all child jobs are completed.
+type EventCraftArrayCompleted struct {
+	UID     string `json:"uid,omitempty"`
+	Module  string `json:"module,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// Number of child jobs per status
+	Size      int `json:"size"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+
+	// S3 location of status of each child job
+	Results string `json:"results,omitempty"`
+}
+
+// Status of the child job within the array job
+type TargetStatus struct {
+	Index  int    `json:"index"`
+	UID    string `json:"uid,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+	Status string `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Deploy modules of tenant's environment as a graph, downstream modules
+// are deployed after upstream ones. The context of downstream module refers
+// outputs of upstream ones using ${name.OutputKey} or ${name.Stack.OutputKey}
+// syntax. Failure of any module cancels the rest of the graph.
+type EventComposite struct {
+	// Unique identity of event, modules are deployed as {uid}-{name}
+	UID string `json:"uid,omitempty"`
+
+	// Tenant and target AWS Account, Region and Role shared by all modules
+	Tenant  string `json:"tenant,omitempty"`
+	Account string `json:"account,omitempty"`
+	Region  string `json:"region,omitempty"`
+	Role    string `json:"role,omitempty"`
+
+	Modules []Component `json:"modules,omitempty"`
+}
+
+// Module within composite deployment
+type Component struct {
+	// Unique name of module within the composite, it consists of letters,
+	// numbers, hyphens (-), and underscores (_).
+	Name string `json:"name,omitempty"`
+
+	Module  string          `json:"module,omitempty"`
+	Version string          `json:"version,omitempty"`
+	Context json.RawMessage `json:"context,omitempty"`
+
+	// Names of upstream modules
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// Rollback the tenant to the last known-good deployment of the module.
+// Either tenant or reverted deployment has to be defined.
+type EventRollback struct {
+	// Unique identity of event (job), the rollback is recorded as
+	// new deployment using this identity.
+	UID string `json:"uid,omitempty"`
+
+	// Identity of tenant, the most recent deployment of tenant is reverted.
+	Tenant string `json:"tenant,omitempty"`
+
+	// Identity of deployment (UID) to revert.
+	Reverts string `json:"reverts,omitempty"`
+}
+
+// Summary of changes, the deployment of module would apply.
+// The event is emitted by the job in diff mode.
+type EventCraftDiff struct {
+	UID    string `json:"uid,omitempty"`
+	Module string `json:"module,omitempty"`
+
+	// S3 location of structured changes
+	Changes string `json:"changes,omitempty"`
+
+	// Summary of changes per stack
+	Stacks []ChangeSummary `json:"stacks,omitempty"`
+
+	// ARN of AWS EventBridge bus, the event is delivered to.
+	ReplyTo string `json:"replyTo,omitempty"`
+}
+
+// Request of approval for changes, the deployment of module would apply.
+// The event is emitted by the job in approval mode.
+type EventApprovalRequested struct {
+	EventCraftDiff
+
+	// The approval expires after this time, the change set is deleted
+	ExpiresAt string `json:"expiresAt,omitempty"`
+}
+
+// Decision on requested approval
+type EventApproval struct {
+	// Unique identity of event (job), which has requested approval
+	UID string `json:"uid,omitempty"`
+
+	// Decision (approve, reject). The expire decision is emitted by craft
+	// when approval times out.
+	Decision string `json:"decision,omitempty"`
+
+	// Identity of reviewer and reason of decision, used for audit purposes
+	Reviewer string `json:"reviewer,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Status of approval, the change set is either executed, discarded or failed.
+type EventApprovalCompleted struct {
+	UID      string `json:"uid,omitempty"`
+	Decision string `json:"decision,omitempty"`
+	Status   string `json:"status,omitempty"`
+}
+
+// Number of changed resources at the stack
+type ChangeSummary struct {
+	Stack          string `json:"stack,omitempty"`
+	ChangeSet      string `json:"changeSet,omitempty"`
+	Added          int    `json:"added"`
+	Modified       int    `json:"modified"`
+	Replaced       int    `json:"replaced"`
+	Removed        int    `json:"removed"`
+	IAM            int    `json:"iam"`
+	SecurityGroups int    `json:"securityGroups"`
+}
+
+// Bootstrap target account for deployments of craft modules
+type EventBootstrap struct {
+	// Unique identity of event (job), use it follow up bootstrap status
+	UID string `json:"uid,omitempty"`
+
+	// Target AWS Account and Region to bootstrap.
+	// Default region: the region of craft.
+	Account string `json:"account,omitempty"`
+	Region  string `json:"region,omitempty"`
+
+	// AWS CDK bootstrap qualifier. Default: hnb659fds.
+	Qualifier string `json:"qualifier,omitempty"`
+
+	// AWS Accounts trusted to deploy into target account, the account of
+	// craft is always trusted.
+	Trust []string `json:"trust,omitempty"`
+}
+
+// Status of bootstrapped account, the event is emitted by bootstrap job
+type EventBootstrapped struct {
+	UID       string `json:"uid,omitempty"`
+	Account   string `json:"account,omitempty"`
+	Region    string `json:"region,omitempty"`
+	Qualifier string `json:"qualifier,omitempty"`
+
+	// Version of AWS CDK bootstrap stack
+	Version string `json:"version,omitempty"`
+}
+
+// Close the debounce window of tenant's module, the event is emitted by
+// craft's schedule. Buffered deployments are merged into the one.
+type EventDebounce struct {
+	// Identity of the deployment, which has opened the window
+	UID    string `json:"uid,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+	Module string `json:"module,omitempty"`
+}
+
+// Status of the deployment, the event is emitted by craft when the job
+// of recorded deployment is completed.
+type EventDeployment struct {
+	UID     string `json:"uid,omitempty"`
+	Tenant  string `json:"tenant,omitempty"`
+	Module  string `json:"module,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// Status of deployment (pending, succeeded, failed, discarded) and
+	// reason of failure.
+	Status string `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+
+	// Identity of AWS Batch job of the deployment.
+	Job string `json:"job,omitempty"`
+
+	// Identity of fleet rollout, the deployment belongs to.
+	Rollout string `json:"rollout,omitempty"`
+
+	// Transition of tenant lifecycle, the deployment belongs to.
+	Lifecycle string `json:"lifecycle,omitempty"`
+
+	// Identities of deployments coalesced into this one by debounce.
+	Coalesced []string `json:"coalesced,omitempty"`
+
+	// URL of webhook, the status is delivered to.
+	Callback string `json:"callback,omitempty"`
+
+	// ARN of AWS EventBridge bus, the status is delivered to.
+	ReplyTo string `json:"replyTo,omitempty"`
+}
+
+// Provision new tenant, the module is deployed into the tenant's environment.
+// The tenant is active once the deployment is succeeded.
+type EventTenantProvision struct {
+	// Unique identity of event (job), the deployment uses this identity
+	UID string `json:"uid,omitempty"`
+
+	Tenant  string          `json:"tenant,omitempty"`
+	Module  string          `json:"module,omitempty"`
+	Version string          `json:"version,omitempty"`
+	Context json.RawMessage `json:"context,omitempty"`
+	Account string          `json:"account,omitempty"`
+	Region  string          `json:"region,omitempty"`
+	Role    string          `json:"role,omitempty"`
+}
+
+// Suspend active tenant, modules of tenant are redeployed with
+// "suspended": true within context (e.g. templates scale to zero).
+type EventTenantSuspend struct {
+	// Unique identity of event, deployments are identified as {uid}-{index}
+	UID    string `json:"uid,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Resume suspended tenant, modules of tenant are redeployed with
+// "suspended": false within context.
+type EventTenantResume struct {
+	// Unique identity of event, deployments are identified as {uid}-{index}
+	UID    string `json:"uid,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Deprovision tenant, stacks of tenant's modules are destroyed.
+// The tenant is deleted once all stacks are destroyed.
+type EventTenantDeprovision struct {
+	// Unique identity of event, deployments are identified as {uid}-{index}
+	UID    string `json:"uid,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Transition of tenant lifecycle, the event is emitted by craft on each
+// recorded transition.
+type EventTenantTransition struct {
+	Tenant     string `json:"tenant,omitempty"`
+	Transition string `json:"transition,omitempty"`
+	From       string `json:"from,omitempty"`
+	To         string `json:"to,omitempty"`
+
+	// Identity of event (job), which has caused the transition
+	UID string `json:"uid,omitempty"`
+
+	// Reason of failed transition
+	Reason string `json:"reason,omitempty"`
+}
+
+// Rollout the version of module across tenants in waves
+type EventRollout struct {
+	// Unique identity of the rollout, use it to control rollout and
+	// follow up its progress
+	UID string `json:"uid,omitempty"`
+
+	// Module and its target version
+	Module  string `json:"module,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// Glob patterns of tenants (e.g. eu-*), the rollout targets tenants
+	// with previous deployments of the module. Default: all tenants.
+	Selector []string `json:"selector,omitempty"`
+
+	// Number of tenants at each wave, the last size is repeated until all
+	// tenants are covered. Default: single wave.
+	Waves []int `json:"waves,omitempty"`
+
+	// Maximum number of concurrent deployments. Default: the wave size.
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// Number of failed deployments tolerated before the rollout is halted.
+	// Default: 0, the rollout is halted on first failure.
+	FailureThreshold int `json:"failureThreshold,omitempty"`
+}
+
+// Resume halted rollout or abort it
+type EventRolloutControl struct {
+	UID    string `json:"uid,omitempty"`
+	Action string `json:"action,omitempty"`
+}
+
+// Progress of rollout, the event is emitted by craft on each change
+type EventRolloutProgress struct {
+	UID     string `json:"uid,omitempty"`
+	Module  string `json:"module,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// Status of the rollout (running, halted, aborted, completed)
+	Status string `json:"status,omitempty"`
+
+	// Current wave (starting from 1) and total number of waves
+	Wave  int `json:"wave"`
+	Waves int `json:"waves"`
+
+	// Number of tenants per status of deployment
+	Queued    int `json:"queued"`
+	Running   int `json:"running"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
 }
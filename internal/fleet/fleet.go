@@ -0,0 +1,184 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+// Package fleet implements the state of rollout, which drives deployments
+// of the module version across tenants in waves.
+package fleet
+
+import (
+	"fmt"
+
+	"github.com/fogfish/craft/internal/events"
+)
+
+// Status of target deployment
+const (
+	TARGET_QUEUED    = "queued"
+	TARGET_RUNNING   = "running"
+	TARGET_SUCCEEDED = "succeeded"
+	TARGET_FAILED    = "failed"
+)
+
+// Target tenant of the rollout
+type Target struct {
+	Tenant string `dynamodbav:"tenant"`
+
+	// Identity of previous deployment, which defines context of tenant
+	Source string `dynamodbav:"source"`
+
+	// Identity of the deployment, the rollout schedules for tenant
+	UID    string `dynamodbav:"uid"`
+	Wave   int    `dynamodbav:"wave"`
+	Status string `dynamodbav:"status"`
+	Reason string `dynamodbav:"reason,omitempty"`
+
+	// status of target known to the store
+	stored string
+}
+
+// Rollout of the module version
+type Rollout struct {
+	UID     string `dynamodbav:"uid"`
+	Module  string `dynamodbav:"module"`
+	Version string `dynamodbav:"version"`
+	Status  string `dynamodbav:"status"`
+
+	Concurrency int `dynamodbav:"concurrency"`
+	Threshold   int `dynamodbav:"threshold"`
+
+	// Number of failures acknowledged by resume of the rollout
+	Tolerated int `dynamodbav:"tolerated"`
+
+	// Current wave and total number of waves
+	Wave  int `dynamodbav:"wave"`
+	Waves int `dynamodbav:"waves"`
+
+	// Targets are stored as own items, the size of fleet is not bounded
+	// by the size of item.
+	Targets []Target `dynamodbav:"-"`
+	Size    int      `dynamodbav:"size"`
+
+	// Sequence number of the update, used for optimistic locking
+	Seq int `dynamodbav:"seq"`
+
+	Created string `dynamodbav:"created,omitempty"`
+	Updated string `dynamodbav:"updated,omitempty"`
+}
+
+// New plans the rollout of targets in waves of given sizes, the last size
+// is repeated until all targets are covered.
+func New(evt events.EventRollout, targets []Target) *Rollout {
+	r := &Rollout{
+		UID:         evt.UID,
+		Module:      evt.Module,
+		Version:     evt.Version,
+		Status:      events.ROLLOUT_RUNNING,
+		Concurrency: evt.Concurrency,
+		Threshold:   evt.FailureThreshold,
+		Targets:     targets,
+	}
+
+	wave, size := 0, 0
+	for i := range r.Targets {
+		if len(evt.Waves) > 0 {
+			if size == evt.Waves[min(wave, len(evt.Waves)-1)] {
+				wave, size = wave+1, 0
+			}
+			size++
+		}
+
+		r.Targets[i].UID = fmt.Sprintf("%s-%d", evt.UID, i)
+		r.Targets[i].Wave = wave
+		r.Targets[i].Status = TARGET_QUEUED
+	}
+	r.Waves = wave + 1
+	r.Size = len(r.Targets)
+
+	return r
+}
+
+// Lookup target by the identity of its deployment
+func (r *Rollout) Target(uid string) *Target {
+	for i := range r.Targets {
+		if r.Targets[i].UID == uid {
+			return &r.Targets[i]
+		}
+	}
+	return nil
+}
+
+// Count targets with the status
+func (r *Rollout) Count(status string) int {
+	n := 0
+	for _, t := range r.Targets {
+		if t.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Exceeded is true if failures exceeds the threshold
+func (r *Rollout) Exceeded() bool {
+	return r.Count(TARGET_FAILED)-r.Tolerated > r.Threshold
+}
+
+// Next targets to deploy, it advances the rollout to the next wave once
+// all deployments of current wave are completed.
+func (r *Rollout) Next() []*Target {
+	for r.Status == events.ROLLOUT_RUNNING {
+		if r.Wave >= r.Waves {
+			r.Status = events.ROLLOUT_COMPLETED
+			return nil
+		}
+
+		running, queued := 0, make([]*Target, 0)
+		for i := range r.Targets {
+			t := &r.Targets[i]
+			if t.Wave != r.Wave {
+				continue
+			}
+			switch t.Status {
+			case TARGET_RUNNING:
+				running++
+			case TARGET_QUEUED:
+				queued = append(queued, t)
+			}
+		}
+
+		if running == 0 && len(queued) == 0 {
+			r.Wave++
+			continue
+		}
+
+		n := len(queued)
+		if r.Concurrency > 0 {
+			n = max(0, min(n, r.Concurrency-running))
+		}
+
+		return queued[:n]
+	}
+
+	return nil
+}
+
+// Progress of the rollout
+func (r *Rollout) Progress() events.EventRolloutProgress {
+	return events.EventRolloutProgress{
+		UID:       r.UID,
+		Module:    r.Module,
+		Version:   r.Version,
+		Status:    r.Status,
+		Wave:      min(r.Wave+1, r.Waves),
+		Waves:     r.Waves,
+		Queued:    r.Count(TARGET_QUEUED),
+		Running:   r.Count(TARGET_RUNNING),
+		Succeeded: r.Count(TARGET_SUCCEEDED),
+		Failed:    r.Count(TARGET_FAILED),
+	}
+}
@@ -0,0 +1,273 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package fleet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+)
+
+// Limit of keys read by single batch
+const batchSize = 100
+
+// DynamoDB declares the subset of interface from AWS SDK used by the store.
+type DynamoDB interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+}
+
+// target of rollout is stored as own item, keyed by rollout and position
+type target struct {
+	Key     string `dynamodbav:"uid"`
+	Rollout string `dynamodbav:"rollout"`
+	Tenant  string `dynamodbav:"tenant"`
+	Source  string `dynamodbav:"source"`
+	UID     string `dynamodbav:"deployment"`
+	Wave    int    `dynamodbav:"wave"`
+	Status  string `dynamodbav:"status"`
+	Reason  string `dynamodbav:"reason,omitempty"`
+}
+
+func targetKey(rollout string, i int) string {
+	return fmt.Sprintf("%s#%d", rollout, i)
+}
+
+// Store of rollouts
+type Store struct {
+	api   DynamoDB
+	table string
+}
+
+func NewStore(api DynamoDB, table string) *Store {
+	return &Store{
+		api:   api,
+		table: table,
+	}
+}
+
+// Create new rollout, it returns ErrConflict if rollout exists. Targets
+// are stored before the rollout, existing targets are not overwritten.
+func (s *Store) Create(ctx context.Context, r *Rollout) error {
+	r.Created = time.Now().UTC().Format(time.RFC3339Nano)
+	r.Updated = r.Created
+	r.Seq = 0
+	r.Size = len(r.Targets)
+
+	for i := range r.Targets {
+		err := s.putTarget(ctx, r, i, "attribute_not_exists(#uid)", map[string]string{"#uid": "uid"})
+		if err != nil && !errors.Is(err, ErrConflict) {
+			return err
+		}
+	}
+
+	return s.put(ctx, r, "attribute_not_exists(#uid)",
+		map[string]string{"#uid": "uid"},
+		nil,
+	)
+}
+
+// Update the rollout, it returns ErrConflict if the rollout has been
+// concurrently updated since it was read. The conditional update of
+// the rollout claims the update of targets, changed targets are written
+// after it.
+func (s *Store) Update(ctx context.Context, r *Rollout) error {
+	seq := r.Seq
+	r.Seq++
+	r.Updated = time.Now().UTC().Format(time.RFC3339Nano)
+
+	err := s.put(ctx, r, "#seq = :seq",
+		map[string]string{"#seq": "seq"},
+		map[string]types.AttributeValue{
+			":seq": &types.AttributeValueMemberN{Value: strconv.Itoa(seq)},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	for i := range r.Targets {
+		if r.Targets[i].Status == r.Targets[i].stored {
+			continue
+		}
+
+		if err := s.putTarget(ctx, r, i, "", nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) putTarget(ctx context.Context, r *Rollout, i int, cond string, names map[string]string) error {
+	t := &r.Targets[i]
+	item, err := attributevalue.MarshalMap(
+		target{
+			Key:     targetKey(r.UID, i),
+			Rollout: r.UID,
+			Tenant:  t.Tenant,
+			Source:  t.Source,
+			UID:     t.UID,
+			Wave:    t.Wave,
+			Status:  t.Status,
+			Reason:  t.Reason,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	req := &dynamodb.PutItemInput{
+		TableName:                aws.String(s.table),
+		Item:                     item,
+		ExpressionAttributeNames: names,
+	}
+	if cond != "" {
+		req.ConditionExpression = aws.String(cond)
+	}
+
+	_, err = s.api.PutItem(ctx, req)
+
+	var conflict *types.ConditionalCheckFailedException
+	if errors.As(err, &conflict) {
+		return fmt.Errorf("target %s: %w", t.UID, ErrConflict)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	t.stored = t.Status
+	return nil
+}
+
+func (s *Store) put(ctx context.Context, r *Rollout, cond string, names map[string]string, values map[string]types.AttributeValue) error {
+	item, err := attributevalue.MarshalMap(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.api.PutItem(ctx,
+		&dynamodb.PutItemInput{
+			TableName:                 aws.String(s.table),
+			Item:                      item,
+			ConditionExpression:       aws.String(cond),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		},
+	)
+
+	var conflict *types.ConditionalCheckFailedException
+	if errors.As(err, &conflict) {
+		return fmt.Errorf("rollout %s: %w", r.UID, ErrConflict)
+	}
+
+	return err
+}
+
+// Get rollout by its unique identity
+func (s *Store) Get(ctx context.Context, uid string) (*Rollout, error) {
+	val, err := s.api.GetItem(ctx,
+		&dynamodb.GetItemInput{
+			TableName: aws.String(s.table),
+			Key: map[string]types.AttributeValue{
+				"uid": &types.AttributeValueMemberS{Value: uid},
+			},
+			ConsistentRead: aws.Bool(true),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if val.Item == nil {
+		return nil, fmt.Errorf("rollout %s: %w", uid, ErrNotFound)
+	}
+
+	var r Rollout
+	if err := attributevalue.UnmarshalMap(val.Item, &r); err != nil {
+		return nil, err
+	}
+
+	if err := s.getTargets(ctx, &r); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// reads targets of the rollout in batches
+func (s *Store) getTargets(ctx context.Context, r *Rollout) error {
+	r.Targets = make([]Target, r.Size)
+
+	for i := 0; i < r.Size; i += batchSize {
+		keys := make([]map[string]types.AttributeValue, 0, batchSize)
+		for k := i; k < min(i+batchSize, r.Size); k++ {
+			keys = append(keys, map[string]types.AttributeValue{
+				"uid": &types.AttributeValueMemberS{Value: targetKey(r.UID, k)},
+			})
+		}
+
+		req := map[string]types.KeysAndAttributes{
+			s.table: {Keys: keys, ConsistentRead: aws.Bool(true)},
+		}
+		for len(req) > 0 {
+			val, err := s.api.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: req})
+			if err != nil {
+				return err
+			}
+
+			seq := make([]target, 0, len(keys))
+			if err := attributevalue.UnmarshalListOfMaps(val.Responses[s.table], &seq); err != nil {
+				return err
+			}
+
+			for _, t := range seq {
+				var k int
+				if _, err := fmt.Sscanf(strings.TrimPrefix(t.Key, r.UID+"#"), "%d", &k); err != nil || k < 0 || k >= r.Size {
+					return fmt.Errorf("rollout %s has invalid target %s", r.UID, t.Key)
+				}
+
+				r.Targets[k] = Target{
+					Tenant: t.Tenant,
+					Source: t.Source,
+					UID:    t.UID,
+					Wave:   t.Wave,
+					Status: t.Status,
+					Reason: t.Reason,
+					stored: t.Status,
+				}
+			}
+
+			req = val.UnprocessedKeys
+		}
+	}
+
+	for i, t := range r.Targets {
+		if t.UID == "" {
+			return fmt.Errorf("target %s: %w", targetKey(r.UID, i), ErrNotFound)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+// Package ledger implements the ledger of events processed by the gateway.
+// The event is identified by its category and uid, the ledger makes
+// reprocessing of events (e.g. replay of the archive) idempotent.
+package ledger
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoDB declares the subset of interface from AWS SDK used by the store.
+type DynamoDB interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// Store of processed events, records are expired after ttl, it covers
+// retention of the archive and dead-letter queues.
+type Store struct {
+	api   DynamoDB
+	table string
+	ttl   time.Duration
+}
+
+func NewStore(api DynamoDB, table string, ttl time.Duration) *Store {
+	return &Store{
+		api:   api,
+		table: table,
+		ttl:   ttl,
+	}
+}
+
+// Has checks if the event is processed
+func (s *Store) Has(ctx context.Context, category, uid string) (bool, error) {
+	val, err := s.api.GetItem(ctx,
+		&dynamodb.GetItemInput{
+			TableName: aws.String(s.table),
+			Key: map[string]types.AttributeValue{
+				"category": &types.AttributeValueMemberS{Value: category},
+				"uid":      &types.AttributeValueMemberS{Value: uid},
+			},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return len(val.Item) != 0, nil
+}
+
+// Put the processed event to the ledger
+func (s *Store) Put(ctx context.Context, category, uid string) error {
+	now := time.Now().UTC()
+
+	_, err := s.api.PutItem(ctx,
+		&dynamodb.PutItemInput{
+			TableName: aws.String(s.table),
+			Item: map[string]types.AttributeValue{
+				"category":  &types.AttributeValueMemberS{Value: category},
+				"uid":       &types.AttributeValueMemberS{Value: uid},
+				"processed": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+				"expires":   &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(s.ttl).Unix(), 10)},
+			},
+		},
+	)
+
+	return err
+}
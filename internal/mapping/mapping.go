@@ -0,0 +1,291 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+// Package mapping implements declarative rules, which translate business
+// events (e.g. SubscriptionCreated) into EventCraft. Fields of EventCraft
+// are JMESPath expressions evaluated against the envelope of the event
+// (id, source, detail-type, account, region, time, detail).
+package mapping
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/jmespath/go-jmespath"
+
+	"github.com/fogfish/craft/internal/events"
+)
+
+// Category of business events, the craft delivers business events of any
+// type to the mapper as detail of BusinessEvent, keeping their envelope.
+const CATEGORY = "BusinessEvent"
+
+// Rule maps events of the source and detail type into EventCraft.
+type Rule struct {
+	// Name of the rule, the deployment is identified as {id}-{name} by default
+	Name string `json:"name"`
+
+	// Source and detail type of matching events, any if not defined
+	Source     string `json:"source,omitempty"`
+	DetailType string `json:"detailType,omitempty"`
+
+	// JMESPath expression, the rule is applied only if it is truthy
+	// (e.g. detail.plan != 'free')
+	When string `json:"when,omitempty"`
+
+	// Module and mode of the deployment
+	Module string `json:"module"`
+	Mode   string `json:"mode,omitempty"`
+
+	// JMESPath expressions of the deployment (e.g. detail.customerId),
+	// literals are quoted (e.g. 'v1.2.3')
+	UID     string `json:"uid,omitempty"`
+	Tenant  string `json:"tenant,omitempty"`
+	Version string `json:"version,omitempty"`
+	Account string `json:"account,omitempty"`
+	Region  string `json:"region,omitempty"`
+	Role    string `json:"role,omitempty"`
+
+	// JMESPath expression of the context, it should produce the object
+	// (e.g. {plan: detail.plan, seats: detail.seats})
+	Context string `json:"context,omitempty"`
+
+	expr map[string]*jmespath.JMESPath
+}
+
+// Rules of mapping
+type Rules struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Parse rules and compile their expressions
+func Parse(b []byte) (*Rules, error) {
+	var rules Rules
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("invalid rules: %w", err)
+	}
+
+	for i := range rules.Rules {
+		if err := rules.Rules[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &rules, nil
+}
+
+// S3 declares the subset of interface from AWS SDK used to load rules.
+type S3 interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// Load rules from S3 bucket
+func Load(ctx context.Context, api S3, bucket, key string) (*Rules, error) {
+	val, err := api.GetObject(ctx,
+		&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer val.Body.Close()
+
+	b, err := io.ReadAll(val.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(b)
+}
+
+// Apply rules to the event, the event is JSON envelope as delivered by
+// AWS EventBridge. Failure of the rule does not prevent others.
+func (rules *Rules) Apply(evt []byte) ([]events.EventCraft, error) {
+	var doc struct {
+		ID         string `json:"id"`
+		Source     string `json:"source"`
+		DetailType string `json:"detail-type"`
+	}
+	if err := json.Unmarshal(evt, &doc); err != nil {
+		return nil, fmt.Errorf("invalid event: %w", err)
+	}
+
+	var data any
+	if err := json.Unmarshal(evt, &data); err != nil {
+		return nil, fmt.Errorf("invalid event: %w", err)
+	}
+
+	seq := make([]events.EventCraft, 0)
+	errs := make([]error, 0)
+	for i := range rules.Rules {
+		rule := &rules.Rules[i]
+		if !rule.matches(doc.Source, doc.DetailType) {
+			continue
+		}
+
+		craft, err := rule.apply(doc.ID, data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", rule.Name, err))
+			continue
+		}
+		if craft != nil {
+			seq = append(seq, *craft)
+		}
+	}
+
+	return seq, errors.Join(errs...)
+}
+
+func (rule *Rule) compile() error {
+	if rule.Name == "" || rule.Module == "" {
+		return fmt.Errorf("invalid rule %q: name and module are required", rule.Name)
+	}
+
+	rule.expr = map[string]*jmespath.JMESPath{}
+	for key, expr := range map[string]string{
+		"when":    rule.When,
+		"uid":     rule.UID,
+		"tenant":  rule.Tenant,
+		"version": rule.Version,
+		"account": rule.Account,
+		"region":  rule.Region,
+		"role":    rule.Role,
+		"context": rule.Context,
+	} {
+		if expr == "" {
+			continue
+		}
+
+		compiled, err := jmespath.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid rule %s: %s: %w", rule.Name, key, err)
+		}
+		rule.expr[key] = compiled
+	}
+
+	return nil
+}
+
+func (rule *Rule) matches(source, detailType string) bool {
+	return (rule.Source == "" || rule.Source == source) &&
+		(rule.DetailType == "" || rule.DetailType == detailType)
+}
+
+// evaluates the rule, it returns nil if the rule is not applicable
+func (rule *Rule) apply(id string, data any) (*events.EventCraft, error) {
+	if when, has := rule.expr["when"]; has {
+		val, err := when.Search(data)
+		if err != nil {
+			return nil, err
+		}
+		if !truthy(val) {
+			return nil, nil
+		}
+	}
+
+	craft := events.EventCraft{
+		UID:    id + "-" + rule.Name,
+		Module: rule.Module,
+		Mode:   rule.Mode,
+	}
+
+	for key, field := range map[string]*string{
+		"uid":     &craft.UID,
+		"tenant":  &craft.Tenant,
+		"version": &craft.Version,
+		"account": &craft.Account,
+		"region":  &craft.Region,
+		"role":    &craft.Role,
+	} {
+		val, err := rule.string(key, data)
+		if err != nil {
+			return nil, err
+		}
+		if val != "" {
+			*field = val
+		}
+	}
+
+	context, err := rule.context(data)
+	if err != nil {
+		return nil, err
+	}
+	craft.Context = context
+
+	return &craft, nil
+}
+
+func (rule *Rule) string(key string, data any) (string, error) {
+	expr, has := rule.expr[key]
+	if !has {
+		return "", nil
+	}
+
+	val, err := expr.Search(data)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+
+	switch v := val.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("%s: string is expected, got %T", key, val)
+	}
+}
+
+func (rule *Rule) context(data any) (json.RawMessage, error) {
+	expr, has := rule.expr["context"]
+	if !has {
+		return json.RawMessage(`{}`), nil
+	}
+
+	val, err := expr.Search(data)
+	if err != nil {
+		return nil, fmt.Errorf("context: %w", err)
+	}
+
+	switch val.(type) {
+	case nil:
+		return json.RawMessage(`{}`), nil
+	case map[string]any:
+		return json.Marshal(val)
+	default:
+		return nil, fmt.Errorf("context: object is expected, got %T", val)
+	}
+}
+
+// truthy as defined by JMESPath: false, null, empty string, list and object are false
+func truthy(val any) bool {
+	switch v := val.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case []any:
+		return len(v) != 0
+	case map[string]any:
+		return len(v) != 0
+	default:
+		return true
+	}
+}
@@ -0,0 +1,154 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package mapping_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/fogfish/craft/internal/mapping"
+	"github.com/fogfish/it/v2"
+)
+
+func TestApply(t *testing.T) {
+	rules, err := mapping.Parse([]byte(`{
+		"rules": [
+			{
+				"name": "order",
+				"source": "shop",
+				"module": "github.com/acme/app",
+				"mode": "diff",
+				"tenant": "detail.customer",
+				"version": "detail.build",
+				"account": "detail.account",
+				"region": "region",
+				"context": "{size: detail.size}"
+			}
+		]
+	}`))
+	it.Then(t).Should(it.Nil(err))
+
+	seq, err := rules.Apply([]byte(`{
+		"id": "1",
+		"source": "shop",
+		"detail-type": "OrderPlaced",
+		"region": "eu-west-1",
+		"detail": {"customer": "acme", "build": 1234567, "account": 111111111111, "size": 0.5}
+	}`))
+
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(len(seq), 1),
+		it.Equal(seq[0].UID, "1-order"),
+		it.Equal(seq[0].Mode, "diff"),
+		it.Equal(seq[0].Tenant, "acme"),
+		it.Equal(seq[0].Version, "1234567"),
+		it.Equal(seq[0].Account, "111111111111"),
+		it.Equal(seq[0].Region, "eu-west-1"),
+		it.Equal(string(seq[0].Context), `{"size":0.5}`),
+	)
+}
+
+func TestApplyMatch(t *testing.T) {
+	rules, err := mapping.Parse([]byte(`{
+		"rules": [
+			{"name": "any", "module": "m"},
+			{"name": "source", "source": "shop", "module": "m"},
+			{"name": "type", "detailType": "OrderPlaced", "module": "m"},
+			{"name": "when", "when": "detail.size > ` + "`1`" + `", "module": "m"}
+		]
+	}`))
+	it.Then(t).Should(it.Nil(err))
+
+	for evt, expect := range map[string][]string{
+		`{"id": "1", "source": "shop", "detail-type": "OrderPlaced", "detail": {"size": 2}}`:    {"1-any", "1-source", "1-type", "1-when"},
+		`{"id": "2", "source": "shop", "detail-type": "OrderCancelled", "detail": {"size": 1}}`: {"2-any", "2-source"},
+		`{"id": "3", "source": "crm", "detail-type": "OrderPlaced", "detail": {}}`:              {"3-any", "3-type"},
+	} {
+		seq, err := rules.Apply([]byte(evt))
+		uids := make([]string, len(seq))
+		for i, craft := range seq {
+			uids[i] = craft.UID
+		}
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Seq(uids).Equal(expect...),
+		)
+	}
+}
+
+func TestApplyFailed(t *testing.T) {
+	rules, err := mapping.Parse([]byte(`{
+		"rules": [
+			{"name": "tenant", "module": "m", "tenant": "detail"},
+			{"name": "context", "module": "m", "context": "detail.plan"},
+			{"name": "valid", "module": "m", "tenant": "detail.customer"}
+		]
+	}`))
+	it.Then(t).Should(it.Nil(err))
+
+	// failed rules do not prevent others
+	seq, err := rules.Apply([]byte(`{"id": "1", "detail": {"customer": "acme", "plan": "pro"}}`))
+	it.Then(t).ShouldNot(
+		it.Nil(err),
+	).Should(
+		it.Equal(len(seq), 1),
+		it.Equal(seq[0].UID, "1-valid"),
+	)
+
+	_, err = rules.Apply([]byte(`not json`))
+	it.Then(t).ShouldNot(it.Nil(err))
+}
+
+func TestParseFailed(t *testing.T) {
+	for _, rules := range []string{
+		`{"rules": [{"name": "x"}]}`,
+		`{"rules": [{"module": "m"}]}`,
+		`{"rules": [{"name": "x", "module": "m", "when": "detail.["}]}`,
+		`{"rules": {}}`,
+		`not json`,
+	} {
+		_, err := mapping.Parse([]byte(rules))
+		it.Then(t).ShouldNot(it.Nil(err))
+	}
+}
+
+func TestLoad(t *testing.T) {
+	api := &mockS3{key: "craft/rules.json", body: `{"rules": [{"name": "x", "module": "m"}]}`}
+
+	rules, err := mapping.Load(context.Background(), api, "test", "craft/rules.json")
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(len(rules.Rules), 1),
+		it.Equal(rules.Rules[0].Name, "x"),
+	)
+
+	_, err = mapping.Load(context.Background(), api, "test", "craft/other.json")
+	it.Then(t).ShouldNot(it.Nil(err))
+}
+
+//------------------------------------------------------------------------------
+
+type mockS3 struct {
+	key  string
+	body string
+}
+
+func (m *mockS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if aws.ToString(params.Key) != m.key {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader([]byte(m.body)))}, nil
+}
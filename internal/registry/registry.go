@@ -0,0 +1,412 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Status of deployment
+const (
+	STATUS_SCHEDULED = "scheduled"
+	STATUS_PENDING   = "pending"
+	STATUS_SUCCEEDED = "succeeded"
+	STATUS_FAILED    = "failed"
+	STATUS_DISCARDED = "discarded"
+	STATUS_PARKED    = "parked"
+)
+
+// Global secondary indexes, which order deployments of tenant, module and
+// status. Indexes are named after their partition key.
+const (
+	INDEX_TENANT = "tenant"
+	INDEX_MODULE = "module"
+	INDEX_STATUS = "status"
+)
+
+// Lease of claimed deployment, the claim without job is taken over once
+// the lease expires (e.g. the job submission has failed).
+const CLAIM_LEASE = 30 * time.Second
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+)
+
+// Deployment of the module, the registry keeps history of deployments
+type Deployment struct {
+	UID     string          `json:"uid"                dynamodbav:"uid"`
+	Tenant  string          `json:"tenant,omitempty"   dynamodbav:"tenant,omitempty"`
+	Module  string          `json:"module,omitempty"   dynamodbav:"module,omitempty"`
+	Version string          `json:"version,omitempty"  dynamodbav:"version,omitempty"`
+	Context json.RawMessage `json:"context,omitempty"  dynamodbav:"context,omitempty"`
+	Account string          `json:"account,omitempty"  dynamodbav:"account,omitempty"`
+	Region  string          `json:"region,omitempty"   dynamodbav:"region,omitempty"`
+	Role    string          `json:"role,omitempty"     dynamodbav:"role,omitempty"`
+	Mode    string          `json:"mode,omitempty"     dynamodbav:"mode,omitempty"`
+
+	// Identity of AWS Batch job
+	Job string `json:"job,omitempty" dynamodbav:"job,omitempty"`
+
+	// Status of deployment and reason of failure
+	Status string `json:"status,omitempty" dynamodbav:"status,omitempty"`
+	Reason string `json:"reason,omitempty" dynamodbav:"reason,omitempty"`
+
+	// Identity of deployment, this one reverts
+	Reverts string `json:"reverts,omitempty" dynamodbav:"reverts,omitempty"`
+
+	// Identity of fleet rollout, the deployment belongs to
+	Rollout string `json:"rollout,omitempty" dynamodbav:"rollout,omitempty"`
+
+	// Transition of tenant lifecycle, the deployment belongs to
+	Lifecycle string `json:"lifecycle,omitempty" dynamodbav:"lifecycle,omitempty"`
+
+	// Sequence number of tenant's deployment, if assigned by the producer
+	Seq int64 `json:"seq,omitempty" dynamodbav:"seq,omitempty"`
+
+	// URL of webhook, the status of deployment is delivered to
+	Callback string `json:"callback,omitempty" dynamodbav:"callback,omitempty"`
+
+	// ARN of AWS EventBridge bus, the status of deployment is delivered to
+	ReplyTo string `json:"replyTo,omitempty" dynamodbav:"replyTo,omitempty"`
+
+	// Identities of deployments coalesced into this one by debounce
+	Coalesced []string `json:"coalesced,omitempty" dynamodbav:"coalesced,omitempty"`
+
+	Created string `json:"created,omitempty" dynamodbav:"created,omitempty"`
+	Updated string `json:"updated,omitempty" dynamodbav:"updated,omitempty"`
+}
+
+// DynamoDB declares the subset of interface from AWS SDK used by the registry.
+type DynamoDB interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+}
+
+// Registry of deployments
+type Registry struct {
+	api   DynamoDB
+	table string
+}
+
+func New(api DynamoDB, table string) *Registry {
+	return &Registry{
+		api:   api,
+		table: table,
+	}
+}
+
+// Put deployment to the registry
+func (r *Registry) Put(ctx context.Context, d Deployment) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	if d.Created == "" {
+		d.Created = now
+	}
+	d.Updated = now
+
+	item, err := attributevalue.MarshalMap(d)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.api.PutItem(ctx,
+		&dynamodb.PutItemInput{
+			TableName: aws.String(r.table),
+			Item:      item,
+		},
+	)
+
+	return err
+}
+
+// Claim the deployment before its job is submitted, it returns ErrConflict
+// if the deployment is already recorded. Failed and discarded deployments
+// are claimed again, as well as claims without job after the lease.
+func (r *Registry) Claim(ctx context.Context, d Deployment) error {
+	now := time.Now().UTC()
+	d.Job = ""
+	d.Created = now.Format(time.RFC3339Nano)
+	d.Updated = d.Created
+
+	item, err := attributevalue.MarshalMap(d)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.api.PutItem(ctx,
+		&dynamodb.PutItemInput{
+			TableName: aws.String(r.table),
+			Item:      item,
+			ConditionExpression: aws.String(
+				"attribute_not_exists(#uid) OR #status IN (:failed, :discarded) OR " +
+					"(#status = :scheduled AND attribute_not_exists(#job) AND #updated < :lease)",
+			),
+			ExpressionAttributeNames: map[string]string{
+				"#uid":     "uid",
+				"#status":  "status",
+				"#job":     "job",
+				"#updated": "updated",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":failed":    &types.AttributeValueMemberS{Value: STATUS_FAILED},
+				":discarded": &types.AttributeValueMemberS{Value: STATUS_DISCARDED},
+				":scheduled": &types.AttributeValueMemberS{Value: STATUS_SCHEDULED},
+				":lease":     &types.AttributeValueMemberS{Value: now.Add(-CLAIM_LEASE).Format(time.RFC3339Nano)},
+			},
+		},
+	)
+
+	var conflict *types.ConditionalCheckFailedException
+	if errors.As(err, &conflict) {
+		return fmt.Errorf("deployment %s: %w", d.UID, ErrConflict)
+	}
+
+	return err
+}
+
+// Attach the job to the claimed deployment
+func (r *Registry) Attach(ctx context.Context, uid, job string) error {
+	_, err := r.api.UpdateItem(ctx,
+		&dynamodb.UpdateItemInput{
+			TableName: aws.String(r.table),
+			Key: map[string]types.AttributeValue{
+				"uid": &types.AttributeValueMemberS{Value: uid},
+			},
+			ConditionExpression: aws.String("attribute_exists(#uid)"),
+			UpdateExpression:    aws.String("SET #job = :job, #updated = :updated"),
+			ExpressionAttributeNames: map[string]string{
+				"#uid":     "uid",
+				"#job":     "job",
+				"#updated": "updated",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":job":     &types.AttributeValueMemberS{Value: job},
+				":updated": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339Nano)},
+			},
+		},
+	)
+
+	var notFound *types.ConditionalCheckFailedException
+	if errors.As(err, &notFound) {
+		return fmt.Errorf("deployment %s: %w", uid, ErrNotFound)
+	}
+
+	return err
+}
+
+// Transit the deployment from the status to another one, it returns
+// ErrConflict if the deployment is not at the status (e.g. it is decided
+// by concurrent or redelivered event).
+func (r *Registry) Transit(ctx context.Context, uid, from, to string) error {
+	_, err := r.api.UpdateItem(ctx,
+		&dynamodb.UpdateItemInput{
+			TableName: aws.String(r.table),
+			Key: map[string]types.AttributeValue{
+				"uid": &types.AttributeValueMemberS{Value: uid},
+			},
+			ConditionExpression: aws.String("#status = :from"),
+			UpdateExpression:    aws.String("SET #status = :to, #updated = :updated"),
+			ExpressionAttributeNames: map[string]string{
+				"#status":  "status",
+				"#updated": "updated",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":from":    &types.AttributeValueMemberS{Value: from},
+				":to":      &types.AttributeValueMemberS{Value: to},
+				":updated": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339Nano)},
+			},
+		},
+	)
+
+	var conflict *types.ConditionalCheckFailedException
+	if errors.As(err, &conflict) {
+		return fmt.Errorf("deployment %s is not %s: %w", uid, from, ErrConflict)
+	}
+
+	return err
+}
+
+// Get deployment by its unique identity
+func (r *Registry) Get(ctx context.Context, uid string) (*Deployment, error) {
+	val, err := r.api.GetItem(ctx,
+		&dynamodb.GetItemInput{
+			TableName: aws.String(r.table),
+			Key: map[string]types.AttributeValue{
+				"uid": &types.AttributeValueMemberS{Value: uid},
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if val.Item == nil {
+		return nil, fmt.Errorf("deployment %s: %w", uid, ErrNotFound)
+	}
+
+	var d Deployment
+	if err := attributevalue.UnmarshalMap(val.Item, &d); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// Update status of existing deployment, it returns ErrNotFound if
+// the deployment is not known to the registry.
+func (r *Registry) UpdateStatus(ctx context.Context, uid, status, reason string) (*Deployment, error) {
+	val, err := r.api.UpdateItem(ctx,
+		&dynamodb.UpdateItemInput{
+			TableName: aws.String(r.table),
+			Key: map[string]types.AttributeValue{
+				"uid": &types.AttributeValueMemberS{Value: uid},
+			},
+			ConditionExpression: aws.String("attribute_exists(#uid)"),
+			UpdateExpression:    aws.String("SET #status = :status, #reason = :reason, #updated = :updated"),
+			ExpressionAttributeNames: map[string]string{
+				"#uid":     "uid",
+				"#status":  "status",
+				"#reason":  "reason",
+				"#updated": "updated",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":status":  &types.AttributeValueMemberS{Value: status},
+				":reason":  &types.AttributeValueMemberS{Value: reason},
+				":updated": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339Nano)},
+			},
+			ReturnValues: types.ReturnValueAllNew,
+		},
+	)
+
+	var notFound *types.ConditionalCheckFailedException
+	if errors.As(err, &notFound) {
+		return nil, fmt.Errorf("deployment %s: %w", uid, ErrNotFound)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var d Deployment
+	if err := attributevalue.UnmarshalMap(val.Attributes, &d); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// History of tenant's deployments, the most recent deployment is first.
+func (r *Registry) History(ctx context.Context, tenant string) ([]Deployment, error) {
+	return r.query(ctx, INDEX_TENANT, tenant)
+}
+
+// Tenants of the module, it returns the most recent deployment of
+// the module per tenant. Parked deployments are skipped.
+func (r *Registry) Tenants(ctx context.Context, module string) ([]Deployment, error) {
+	history, err := r.query(ctx, INDEX_MODULE, module)
+	if err != nil {
+		return nil, err
+	}
+
+	seq := make([]Deployment, 0)
+	has := map[string]struct{}{}
+	for _, d := range history {
+		if _, exists := has[d.Tenant]; exists || d.Tenant == "" || d.Status == STATUS_PARKED {
+			continue
+		}
+		has[d.Tenant] = struct{}{}
+		seq = append(seq, d)
+	}
+
+	return seq, nil
+}
+
+// Deployed returns the most recent succeeded deployment of each module
+// per tenant (optionally, of the tenant only), ordered by tenant and module.
+func (r *Registry) Deployed(ctx context.Context, tenant string) ([]Deployment, error) {
+	index, key := INDEX_STATUS, STATUS_SUCCEEDED
+	if tenant != "" {
+		index, key = INDEX_TENANT, tenant
+	}
+
+	history, err := r.query(ctx, index, key)
+	if err != nil {
+		return nil, err
+	}
+
+	seq := make([]Deployment, 0)
+	has := map[[2]string]struct{}{}
+	for _, d := range history {
+		key := [2]string{d.Tenant, d.Module}
+		if _, exists := has[key]; exists || d.Tenant == "" || d.Status != STATUS_SUCCEEDED {
+			continue
+		}
+		has[key] = struct{}{}
+		seq = append(seq, d)
+	}
+
+	sort.Slice(seq, func(i, j int) bool {
+		if seq[i].Tenant != seq[j].Tenant {
+			return seq[i].Tenant < seq[j].Tenant
+		}
+		return seq[i].Module < seq[j].Module
+	})
+
+	return seq, nil
+}
+
+// query deployments using the index, the most recent deployment is first.
+func (r *Registry) query(ctx context.Context, index, key string) ([]Deployment, error) {
+	seq := make([]Deployment, 0)
+
+	var cursor map[string]types.AttributeValue
+	for {
+		val, err := r.api.Query(ctx,
+			&dynamodb.QueryInput{
+				TableName:              aws.String(r.table),
+				IndexName:              aws.String(index),
+				KeyConditionExpression: aws.String("#key = :key"),
+				ExpressionAttributeNames: map[string]string{
+					"#key": index,
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":key": &types.AttributeValueMemberS{Value: key},
+				},
+				ScanIndexForward:  aws.Bool(false),
+				ExclusiveStartKey: cursor,
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		page := make([]Deployment, 0, len(val.Items))
+		if err := attributevalue.UnmarshalListOfMaps(val.Items, &page); err != nil {
+			return nil, err
+		}
+		seq = append(seq, page...)
+
+		if val.LastEvaluatedKey == nil {
+			return seq, nil
+		}
+		cursor = val.LastEvaluatedKey
+	}
+}
@@ -0,0 +1,131 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package registry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/fogfish/craft/internal/registry"
+	"github.com/fogfish/it/v2"
+)
+
+func TestClaim(t *testing.T) {
+	t.Run("Claimed", func(t *testing.T) {
+		db := &mockDynamoDB{}
+		err := registry.New(db, "test").Claim(context.Background(),
+			registry.Deployment{UID: "a", Status: registry.STATUS_SCHEDULED, Job: "stale"},
+		)
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(len(db.put), 1),
+			it.String(aws.ToString(db.put[0].ConditionExpression)).Contain("attribute_not_exists(#uid)"),
+			it.String(aws.ToString(db.put[0].ConditionExpression)).Contain("#status IN (:failed, :discarded)"),
+			it.String(aws.ToString(db.put[0].ConditionExpression)).Contain("attribute_not_exists(#job) AND #updated < :lease"),
+		)
+
+		// the job is attached after submit
+		_, has := db.put[0].Item["job"]
+		it.Then(t).ShouldNot(it.True(has))
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		db := &mockDynamoDB{conflict: true}
+		err := registry.New(db, "test").Claim(context.Background(),
+			registry.Deployment{UID: "a", Status: registry.STATUS_SCHEDULED},
+		)
+
+		it.Then(t).Should(
+			it.True(errors.Is(err, registry.ErrConflict)),
+		)
+	})
+}
+
+func TestAttach(t *testing.T) {
+	t.Run("Attached", func(t *testing.T) {
+		db := &mockDynamoDB{}
+		err := registry.New(db, "test").Attach(context.Background(), "a", "job:1")
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(aws.ToString(db.update.ConditionExpression), "attribute_exists(#uid)"),
+			it.Equal(db.update.ExpressionAttributeValues[":job"].(*types.AttributeValueMemberS).Value, "job:1"),
+		)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		db := &mockDynamoDB{conflict: true}
+		err := registry.New(db, "test").Attach(context.Background(), "a", "job:1")
+
+		it.Then(t).Should(
+			it.True(errors.Is(err, registry.ErrNotFound)),
+		)
+	})
+}
+
+func TestTransit(t *testing.T) {
+	t.Run("Pending", func(t *testing.T) {
+		db := &mockDynamoDB{}
+		err := registry.New(db, "test").Transit(context.Background(), "a", registry.STATUS_PENDING, registry.STATUS_SCHEDULED)
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(aws.ToString(db.update.ConditionExpression), "#status = :from"),
+			it.Equal(db.update.ExpressionAttributeValues[":from"].(*types.AttributeValueMemberS).Value, registry.STATUS_PENDING),
+			it.Equal(db.update.ExpressionAttributeValues[":to"].(*types.AttributeValueMemberS).Value, registry.STATUS_SCHEDULED),
+		)
+	})
+
+	t.Run("Decided", func(t *testing.T) {
+		db := &mockDynamoDB{conflict: true}
+		err := registry.New(db, "test").Transit(context.Background(), "a", registry.STATUS_PENDING, registry.STATUS_SCHEDULED)
+
+		it.Then(t).Should(
+			it.True(errors.Is(err, registry.ErrConflict)),
+		)
+	})
+}
+
+//------------------------------------------------------------------------------
+
+// records conditional writes, the condition fails if conflict
+type mockDynamoDB struct {
+	conflict bool
+	put      []*dynamodb.PutItemInput
+	update   *dynamodb.UpdateItemInput
+}
+
+func (m *mockDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if m.conflict && params.ConditionExpression != nil {
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("conflict")}
+	}
+	m.put = append(m.put, params)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *mockDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+func (m *mockDynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	if m.conflict && params.ConditionExpression != nil {
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("conflict")}
+	}
+	m.update = params
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (m *mockDynamoDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	return &dynamodb.QueryOutput{}, nil
+}
@@ -0,0 +1,380 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+	"github.com/aws/aws-sdk-go-v2/service/batch/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+)
+
+// Limits of AWS Batch array job size
+const (
+	ARRAY_SIZE_MIN = 2
+	ARRAY_SIZE_MAX = 10000
+)
+
+// ScheduleArray deploys the module to many targets using AWS Batch array job.
+// Targets are stored as JSON lines file at the bucket, the child job picks
+// the target using its array index.
+func (s *Service) ScheduleArray(evt events.EventCraftArray) error {
+	if s.storage == nil {
+		return fmt.Errorf("storage is not configured")
+	}
+
+	if len(evt.Targets) < ARRAY_SIZE_MIN || len(evt.Targets) > ARRAY_SIZE_MAX {
+		return fmt.Errorf("array of %d targets is not supported", len(evt.Targets))
+	}
+
+	buf := &bytes.Buffer{}
+	codec := json.NewEncoder(buf)
+	for i, target := range evt.Targets {
+		if target.Context == nil {
+			return fmt.Errorf("target %d has no context", i)
+		}
+
+		if err := s.validateTarget(target.Account, target.Role); err != nil {
+			return fmt.Errorf("target %d: %w", i, err)
+		}
+
+		if err := codec.Encode(target); err != nil {
+			return err
+		}
+	}
+
+	ctx := context.Background()
+	key := fmt.Sprintf("craft/contexts/%s.jsonl", evt.UID)
+
+	recorded, children, err := s.claimArray(ctx, evt)
+	if recorded || err != nil {
+		return err
+	}
+
+	// the array job and its children are claimed again by the retry
+	claimed := children
+	if s.registry != nil {
+		claimed = append(claimed, registry.Deployment{UID: evt.UID, Module: evt.Module, Version: evt.Version})
+	}
+
+	_, err = s.storage.PutObject(ctx,
+		&s3.PutObjectInput{
+			Bucket:      aws.String(s.bucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(buf.Bytes()),
+			ContentType: aws.String("application/x-ndjson"),
+		},
+	)
+	if err != nil {
+		s.releaseAll(ctx, claimed, err)
+		return err
+	}
+
+	env := []types.KeyValuePair{
+		{Name: aws.String("CRAFT_UID"), Value: aws.String(evt.UID)},
+		{Name: aws.String("CRAFT_BUCKET"), Value: aws.String(s.bucket)},
+		{Name: aws.String("CRAFT_MODULE"), Value: aws.String(evt.Module)},
+		{Name: aws.String("CRAFT_ARRAY"), Value: aws.String(key)},
+	}
+
+	if evt.Version != "" {
+		env = append(env,
+			types.KeyValuePair{Name: aws.String("CRAFT_MODULE_VERSION"), Value: aws.String(evt.Version)},
+		)
+	}
+
+	val, err := s.api.SubmitJob(ctx,
+		&batch.SubmitJobInput{
+			JobName:            aws.String(evt.UID),
+			JobDefinition:      aws.String(s.definition),
+			JobQueue:           aws.String(s.queue),
+			ArrayProperties:    &types.ArrayProperties{Size: aws.Int32(int32(len(evt.Targets)))},
+			ContainerOverrides: &types.ContainerOverrides{Environment: env},
+		},
+	)
+	if err != nil {
+		s.releaseAll(ctx, claimed, err)
+		return err
+	}
+
+	slog.Info("array job scheduled", "uid", evt.UID, "job", val.JobId, "size", len(evt.Targets))
+
+	if s.registry == nil {
+		return nil
+	}
+
+	job := aws.ToString(val.JobId)
+	if err := s.attachChildren(ctx, job, children); err != nil {
+		return err
+	}
+
+	if err := s.registry.Attach(ctx, evt.UID, job); err != nil {
+		slog.Error("failed to record job", "uid", evt.UID, "job", job, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+// records the array job and its targets before the job is submitted, it
+// returns true if the array job is already recorded, otherwise claimed
+// children. Targets are declared as desired state of tenants.
+func (s *Service) claimArray(ctx context.Context, evt events.EventCraftArray) (bool, []registry.Deployment, error) {
+	if s.registry != nil {
+		d, err := s.registry.Get(ctx, evt.UID)
+		switch {
+		case errors.Is(err, registry.ErrNotFound):
+		case err != nil:
+			return false, nil, err
+		case d.Job != "" && d.Status != registry.STATUS_FAILED && d.Status != registry.STATUS_DISCARDED:
+			slog.Info("job duplicate", "uid", evt.UID, "status", d.Status)
+			return true, nil, nil
+		}
+
+		array := registry.Deployment{
+			UID:     evt.UID,
+			Module:  evt.Module,
+			Version: evt.Version,
+			Status:  registry.STATUS_SCHEDULED,
+		}
+		if err := s.registry.Claim(ctx, array); err != nil {
+			slog.Error("failed to record deployment", "uid", evt.UID, "err", err)
+			return false, nil, err
+		}
+	}
+
+	children := make([]registry.Deployment, 0, len(evt.Targets))
+	for i, target := range evt.Targets {
+		craft := events.EventCraft{
+			UID:     fmt.Sprintf("%s-%d", evt.UID, i),
+			Tenant:  target.Tenant,
+			Module:  evt.Module,
+			Version: evt.Version,
+			Context: target.Context,
+			Account: target.Account,
+			Region:  target.Region,
+			Role:    target.Role,
+		}
+		if err := s.declare(ctx, craft, lineage{}); err != nil {
+			slog.Error("failed to declare desired state", "uid", craft.UID, "err", err)
+			if s.registry != nil {
+				s.release(ctx, registry.Deployment{UID: evt.UID, Module: evt.Module, Version: evt.Version}, err)
+			}
+			return false, nil, err
+		}
+
+		children = append(children,
+			registry.Deployment{
+				UID:     craft.UID,
+				Tenant:  craft.Tenant,
+				Module:  craft.Module,
+				Version: craft.Version,
+				Context: craft.Context,
+				Account: craft.Account,
+				Region:  craft.Region,
+				Role:    craft.Role,
+				Status:  registry.STATUS_SCHEDULED,
+			},
+		)
+	}
+
+	if s.registry == nil {
+		return false, nil, nil
+	}
+
+	if err := s.claimChildren(ctx, children); err != nil {
+		s.release(ctx, registry.Deployment{UID: evt.UID, Module: evt.Module, Version: evt.Version}, err)
+		return false, nil, err
+	}
+
+	return false, children, nil
+}
+
+// claims child deployments of array job, the child running by concurrent
+// or redelivered array job conflicts. Claimed children are released then.
+func (s *Service) claimChildren(ctx context.Context, children []registry.Deployment) error {
+	for i, d := range children {
+		if err := s.registry.Claim(ctx, d); err != nil {
+			slog.Error("failed to record deployment", "uid", d.UID, "err", err)
+			s.releaseAll(ctx, children[:i], err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// attaches jobs to child deployments of array job, AWS Batch identifies
+// the child job by its index (e.g. job:1).
+func (s *Service) attachChildren(ctx context.Context, job string, children []registry.Deployment) error {
+	for i, d := range children {
+		child := fmt.Sprintf("%s:%d", job, i)
+		if err := s.registry.Attach(ctx, d.UID, child); err != nil {
+			slog.Error("failed to record job", "uid", d.UID, "job", child, "err", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// releases claims of deployments, whose job is not submitted
+func (s *Service) releaseAll(ctx context.Context, seq []registry.Deployment, cause error) {
+	for _, d := range seq {
+		s.release(ctx, d, cause)
+	}
+}
+
+// Target of array job, which deploys many modules (e.g. reconciliation or
+// drift detection), the child job uses module and version of its target.
+type moduleTarget struct {
+	events.Target
+	Module    string `json:"module"`
+	Version   string `json:"version,omitempty"`
+	Drift     string `json:"drift,omitempty"`
+	Remediate string `json:"remediate,omitempty"`
+}
+
+// schedules array job of modules using the mode, large fleet is split into
+// many array jobs. AWS Batch array job requires at least two child jobs,
+// single target is submitted as standalone job.
+func (s *Service) scheduleModules(ctx context.Context, uid, mode string, seq []moduleTarget, link lineage) error {
+	for i := 0; i < len(seq); i += ARRAY_SIZE_MAX {
+		array := uid
+		if len(seq) > ARRAY_SIZE_MAX {
+			array = fmt.Sprintf("%s-%d", uid, i/ARRAY_SIZE_MAX)
+		}
+
+		chunk := seq[i:min(i+ARRAY_SIZE_MAX, len(seq))]
+		if len(chunk) >= ARRAY_SIZE_MIN {
+			if err := s.submitModules(ctx, array, mode, chunk); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for k, target := range chunk {
+			job := events.EventCraft{
+				UID:     fmt.Sprintf("%s-%d", array, k),
+				Module:  target.Module,
+				Version: target.Version,
+				Tenant:  target.Tenant,
+				Context: target.Context,
+				Account: target.Account,
+				Region:  target.Region,
+				Role:    target.Role,
+				Mode:    mode,
+			}
+
+			link.drift, link.remediate = target.Drift, target.Remediate
+			if _, err := s.schedule(ctx, job, link); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// submits array job of modules, child deployments are recorded before
+// the job is submitted unless the mode has no effect on stacks.
+func (s *Service) submitModules(ctx context.Context, uid, mode string, seq []moduleTarget) error {
+	if s.storage == nil {
+		return fmt.Errorf("storage is not configured")
+	}
+
+	buf := &bytes.Buffer{}
+	codec := json.NewEncoder(buf)
+	for _, target := range seq {
+		if err := codec.Encode(target); err != nil {
+			return err
+		}
+	}
+
+	key := fmt.Sprintf("craft/contexts/%s.jsonl", uid)
+	_, err := s.storage.PutObject(ctx,
+		&s3.PutObjectInput{
+			Bucket:      aws.String(s.bucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(buf.Bytes()),
+			ContentType: aws.String("application/x-ndjson"),
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	recorded := s.registry != nil && mode != events.MODE_DIFF && mode != events.MODE_DRIFT
+	children := make([]registry.Deployment, 0, len(seq))
+	if recorded {
+		for i, target := range seq {
+			children = append(children,
+				registry.Deployment{
+					UID:     fmt.Sprintf("%s-%d", uid, i),
+					Tenant:  target.Tenant,
+					Module:  target.Module,
+					Version: target.Version,
+					Context: target.Context,
+					Account: target.Account,
+					Region:  target.Region,
+					Role:    target.Role,
+					Mode:    mode,
+					Status:  registry.STATUS_SCHEDULED,
+				},
+			)
+		}
+
+		if err := s.claimChildren(ctx, children); err != nil {
+			return err
+		}
+	}
+
+	env := []types.KeyValuePair{
+		{Name: aws.String("CRAFT_UID"), Value: aws.String(uid)},
+		{Name: aws.String("CRAFT_BUCKET"), Value: aws.String(s.bucket)},
+		{Name: aws.String("CRAFT_ARRAY"), Value: aws.String(key)},
+	}
+
+	if mode != "" {
+		env = append(env,
+			types.KeyValuePair{Name: aws.String("CRAFT_MODE"), Value: aws.String(mode)},
+		)
+	}
+
+	val, err := s.api.SubmitJob(ctx,
+		&batch.SubmitJobInput{
+			JobName:            aws.String(uid),
+			JobDefinition:      aws.String(s.definition),
+			JobQueue:           aws.String(s.queue),
+			ArrayProperties:    &types.ArrayProperties{Size: aws.Int32(int32(len(seq)))},
+			ContainerOverrides: &types.ContainerOverrides{Environment: env},
+		},
+	)
+	if err != nil {
+		s.releaseAll(ctx, children, err)
+		return err
+	}
+
+	slog.Info("array job scheduled", "uid", uid, "job", val.JobId, "mode", mode, "size", len(seq))
+
+	if recorded {
+		return s.attachChildren(ctx, aws.ToString(val.JobId), children)
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+	"github.com/fogfish/craft/internal/scheduler"
+	"github.com/fogfish/it/v2"
+)
+
+var eventArray = events.EventCraftArray{
+	UID:    "arr",
+	Module: "github.com/acme/api",
+	Targets: []events.Target{
+		{Tenant: "a", Context: []byte(`{}`)},
+		{Tenant: "b", Context: []byte(`{}`)},
+	},
+}
+
+func TestScheduleArrayAttach(t *testing.T) {
+	jobs := &mockJobs{}
+	db := &mockRegistry{}
+	s := scheduler.New(jobs, "test-queue", "test-job", "test-s3",
+		scheduler.WithRegistry(db),
+		scheduler.WithStorage(mockStorage{}),
+	)
+
+	err := s.ScheduleArray(eventArray)
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(len(jobs.seq), 1),
+		it.Seq(db.seq).Equal(
+			registry.Deployment{UID: "arr", Module: "github.com/acme/api", Status: registry.STATUS_SCHEDULED, Job: "job-1"},
+			registry.Deployment{UID: "arr-0", Tenant: "a", Module: "github.com/acme/api", Context: []byte(`{}`), Status: registry.STATUS_SCHEDULED, Job: "job-1:0"},
+			registry.Deployment{UID: "arr-1", Tenant: "b", Module: "github.com/acme/api", Context: []byte(`{}`), Status: registry.STATUS_SCHEDULED, Job: "job-1:1"},
+		),
+	)
+}
+
+func TestScheduleArrayClaim(t *testing.T) {
+	// the child is running by concurrent array job
+	jobs := &mockJobs{}
+	db := &mockRegistry{
+		seq: []registry.Deployment{
+			{UID: "arr-1", Tenant: "b", Module: "github.com/acme/api", Status: registry.STATUS_SCHEDULED, Job: "other:1"},
+		},
+	}
+	s := scheduler.New(jobs, "test-queue", "test-job", "test-s3",
+		scheduler.WithRegistry(db),
+		scheduler.WithStorage(mockStorage{}),
+	)
+
+	err := s.ScheduleArray(eventArray)
+	arr, _ := db.Get(context.Background(), "arr")
+	arr0, _ := db.Get(context.Background(), "arr-0")
+	arr1, _ := db.Get(context.Background(), "arr-1")
+	it.Then(t).Should(
+		it.True(errors.Is(err, registry.ErrConflict)),
+		it.Equal(len(jobs.seq), 0),
+		it.Equal(arr.Status, registry.STATUS_FAILED),
+		it.Equal(arr0.Status, registry.STATUS_FAILED),
+		it.Equal(arr1.Status, registry.STATUS_SCHEDULED),
+		it.Equal(arr1.Job, "other:1"),
+	)
+}
+
+func TestScheduleArrayFailed(t *testing.T) {
+	db := &mockRegistry{}
+	s := scheduler.New(mockJobsFailed{}, "test-queue", "test-job", "test-s3",
+		scheduler.WithRegistry(db),
+		scheduler.WithStorage(mockStorage{}),
+	)
+
+	err := s.ScheduleArray(eventArray)
+	it.Then(t).ShouldNot(it.Nil(err))
+
+	// the array job and children are released, the retry claims them again
+	for _, d := range db.seq {
+		it.Then(t).Should(it.Equal(d.Status, registry.STATUS_FAILED))
+	}
+	retry := scheduler.New(&mockJobs{}, "test-queue", "test-job", "test-s3",
+		scheduler.WithRegistry(db),
+		scheduler.WithStorage(mockStorage{}),
+	)
+	it.Then(t).Should(
+		it.Equal(len(db.seq), 3),
+		it.Nil(retry.ScheduleArray(eventArray)),
+	)
+}
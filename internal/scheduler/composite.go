@@ -0,0 +1,151 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+
+	"github.com/fogfish/craft/internal/events"
+)
+
+// AWS Batch limits number of job dependencies
+const COMPOSITE_DEPENDS_MAX = 20
+
+var componentName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ScheduleComposite deploys modules in the order of their dependencies.
+// Jobs of downstream modules depend on jobs of upstream ones. Modules
+// recorded by the registry are not scheduled again, the composite retried
+// after partial failure continues from the failed module.
+func (s *Service) ScheduleComposite(evt events.EventComposite) error {
+	if err := s.validateTarget(evt.Account, evt.Role); err != nil {
+		return err
+	}
+
+	seq, err := topology(evt.Modules)
+	if err != nil {
+		return err
+	}
+
+	members := make([]string, len(seq))
+	for i, c := range seq {
+		members[i] = evt.UID + "-" + c.Name
+	}
+
+	ctx := context.Background()
+	jobs := map[string]upstream{}
+	for _, c := range seq {
+		link := lineage{composite: evt.UID, members: members}
+		for _, dep := range c.DependsOn {
+			link.upstream = append(link.upstream, jobs[dep])
+		}
+
+		craft := events.EventCraft{
+			UID:     evt.UID + "-" + c.Name,
+			Module:  c.Module,
+			Version: c.Version,
+			Tenant:  evt.Tenant,
+			Context: c.Context,
+			Account: evt.Account,
+			Region:  evt.Region,
+			Role:    evt.Role,
+		}
+
+		job, err := s.scheduleComponent(ctx, craft, link)
+		if err != nil {
+			slog.Error("failed to schedule composite", "uid", evt.UID, "module", c.Name, "err", err)
+			return err
+		}
+
+		jobs[c.Name] = upstream{name: c.Name, uid: craft.UID, job: job}
+	}
+
+	return nil
+}
+
+// schedules the module of composite unless it is already recorded
+func (s *Service) scheduleComponent(ctx context.Context, evt events.EventCraft, link lineage) (string, error) {
+	d, err := s.duplicate(ctx, evt)
+	if err != nil {
+		return "", err
+	}
+
+	if d != nil {
+		return d.Job, nil
+	}
+
+	return s.schedule(ctx, evt, link)
+}
+
+// sorts components so that upstream components precede downstream ones
+func topology(components []events.Component) ([]events.Component, error) {
+	if len(components) == 0 {
+		return nil, fmt.Errorf("composite has no modules")
+	}
+
+	index := map[string]events.Component{}
+	for _, c := range components {
+		if !componentName.MatchString(c.Name) {
+			return nil, fmt.Errorf("invalid module name %q", c.Name)
+		}
+		if c.Module == "" || c.Context == nil {
+			return nil, fmt.Errorf("module %s is not defined", c.Name)
+		}
+		if len(c.DependsOn) > COMPOSITE_DEPENDS_MAX {
+			return nil, fmt.Errorf("module %s has too many dependencies", c.Name)
+		}
+		if _, has := index[c.Name]; has {
+			return nil, fmt.Errorf("module %s is duplicated", c.Name)
+		}
+		index[c.Name] = c
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	seq := make([]events.Component, 0, len(components))
+	state := map[string]int{}
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("module %s has cyclic dependency", name)
+		}
+
+		state[name] = visiting
+		for _, dep := range index[name].DependsOn {
+			if _, has := index[dep]; !has {
+				return fmt.Errorf("module %s depends on unknown module %s", name, dep)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+
+		seq = append(seq, index[name])
+		return nil
+	}
+
+	for _, c := range components {
+		if err := visit(c.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	return seq, nil
+}
@@ -0,0 +1,100 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/scheduler/types"
+	"github.com/fogfish/craft/internal/debounce"
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+)
+
+// buffers the deployment of tenant's module. The first deployment opens
+// the window, the schedule emits EventDebounce once the window is closed.
+func (s *Service) coalesce(ctx context.Context, evt events.EventCraft) error {
+	if s.timer == nil {
+		return fmt.Errorf("debounce requires delayed deployments")
+	}
+
+	if err := s.validateTarget(evt.Account, evt.Role); err != nil {
+		return err
+	}
+
+	b, err := s.debounce.Append(ctx, debounce.New(evt))
+	if err != nil {
+		return err
+	}
+
+	if b.UID != evt.UID {
+		slog.Info("job coalesced", "uid", evt.UID, "into", b.UID, "tenant", b.Tenant, "module", b.Module)
+		return nil
+	}
+
+	// the deployment has opened the window, the redelivered one opens it again
+	closes := time.Now().Add(s.window)
+	input, err := json.Marshal(events.EventDebounce{UID: b.UID, Tenant: b.Tenant, Module: b.Module})
+	if err != nil {
+		return err
+	}
+
+	err = s.timer.create(ctx, scheduleName(SCHEDULE_DEBOUNCE, b.UID),
+		"at("+closes.UTC().Format("2006-01-02T15:04:05")+")", "UTC",
+		types.ActionAfterCompletionDelete, b.Module, "EventDebounce", input,
+	)
+
+	var conflict *types.ConflictException
+	if err != nil && !errors.As(err, &conflict) {
+		return err
+	}
+
+	slog.Info("job debounced", "uid", evt.UID, "tenant", b.Tenant, "module", b.Module, "closes", closes)
+
+	return nil
+}
+
+// Debounce closes the window, buffered deployments are merged and scheduled
+// as one job identified by the deployment, which has opened the window.
+func (s *Service) Debounce(evt events.EventDebounce) error {
+	if s.debounce == nil {
+		return fmt.Errorf("debounce is not configured")
+	}
+
+	ctx := context.Background()
+
+	b, err := s.debounce.Take(ctx, evt.Tenant, evt.Module)
+	switch {
+	case errors.Is(err, debounce.ErrNotFound):
+		// the event is delivered at least once
+		return nil
+	case err != nil:
+		return err
+	}
+
+	if job, err := s.schedule(ctx, b.Event(), lineage{coalesced: b.UIDs}); err != nil {
+		// the buffer is restored if the job is not submitted, the window is
+		// closed again on retry
+		if job == "" && !errors.Is(err, registry.ErrConflict) {
+			if _, e := s.debounce.Append(ctx, b); e != nil {
+				slog.Error("failed to restore buffer", "uid", b.UID, "uids", b.UIDs, "err", e)
+			}
+		}
+		return err
+	}
+
+	slog.Info("debounce window closed", "uid", b.UID, "coalesced", b.UIDs)
+
+	return nil
+}
@@ -0,0 +1,92 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package scheduler_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+	"github.com/fogfish/craft/internal/scheduler"
+	"github.com/fogfish/it/v2"
+)
+
+func TestDebounceCoalesce(t *testing.T) {
+	jobs := &mockJobs{}
+	timer := &mockTimer{}
+	buffers := &mockDebounce{}
+	s := scheduler.New(jobs, "test-queue", "test-job", "test-s3",
+		scheduler.WithRegistry(&mockRegistry{}),
+		scheduler.WithTimer(timer, "test-group", bus, "test-role"),
+		scheduler.WithDebounce(buffers, time.Minute),
+	)
+
+	for _, uid := range []string{"a", "b", "a", "c"} {
+		_, err := s.Submit(events.EventCraft{UID: uid, Tenant: "acme", Module: "m", Context: []byte(`{}`)})
+		it.Then(t).Should(it.Nil(err))
+	}
+
+	_, has := timer.schedules[scheduler.SCHEDULE_DEBOUNCE+"a"]
+	it.Then(t).Should(
+		it.Equal(len(jobs.seq), 0),
+		it.Equal(len(timer.schedules), 1),
+		it.True(has),
+	)
+
+	// the window is closed once, the redelivered event is ignored
+	for i := 0; i < 2; i++ {
+		err := s.Debounce(events.EventDebounce{UID: "a", Tenant: "acme", Module: "m"})
+		it.Then(t).Should(it.Nil(err))
+	}
+
+	it.Then(t).Should(
+		it.Equal(len(jobs.seq), 1),
+		it.Equal(env(jobs.seq[0], "CRAFT_UID"), "a"),
+		it.Equal(env(jobs.seq[0], "CRAFT_COALESCED"), "a b c"),
+	)
+}
+
+func TestDebounceRestore(t *testing.T) {
+	for name, tt := range map[string]struct {
+		jobs     scheduler.JobQueue
+		recorded []registry.Deployment
+		restored bool
+	}{
+		// the job is not submitted, the window is closed again by retry
+		"Failed": {mockJobsFailed{}, nil, true},
+		// the job is submitted by concurrent close of the window
+		"Conflict": {&mockJobs{}, []registry.Deployment{
+			{UID: "a", Tenant: "acme", Module: "m", Status: registry.STATUS_SCHEDULED, Job: "job-a"},
+		}, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := &mockRegistry{}
+			buffers := &mockDebounce{}
+			s := scheduler.New(tt.jobs, "test-queue", "test-job", "test-s3",
+				scheduler.WithRegistry(db),
+				scheduler.WithTimer(&mockTimer{}, "test-group", bus, "test-role"),
+				scheduler.WithDebounce(buffers, time.Minute),
+			)
+
+			_, err := s.Submit(events.EventCraft{UID: "a", Tenant: "acme", Module: "m", Context: []byte(`{}`)})
+			it.Then(t).Should(it.Nil(err))
+
+			db.seq = tt.recorded
+			err = s.Debounce(events.EventDebounce{UID: "a", Tenant: "acme", Module: "m"})
+			_, has := buffers.seq["acme m"]
+			it.Then(t).Should(
+				it.Fail(func() error { return err }),
+				it.Equal(errors.Is(err, registry.ErrConflict), !tt.restored),
+				it.Equal(has, tt.restored),
+			)
+		})
+	}
+}
@@ -0,0 +1,240 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package scheduler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/scheduler"
+	"github.com/aws/aws-sdk-go-v2/service/scheduler/types"
+	"github.com/fogfish/craft/internal/events"
+)
+
+// AWS EventBridge Scheduler limits length of schedule name
+const SCHEDULE_NAME_MAX = 64
+
+// Kinds of schedules, the schedule is named after the kind and identity of
+// the deployment.
+const (
+	SCHEDULE_DELAY    = "delay-"
+	SCHEDULE_DEBOUNCE = "debounce-"
+)
+
+// timer of delayed deployments, each deployment is one-time schedule
+// named after the deployment.
+type timer struct {
+	api   Timer
+	group string
+	bus   string
+	role  string
+}
+
+// delays the deployment till notBefore time or next occurrence of cron.
+// The schedule emits the deployment back to the bus. It returns false if
+// the time has already passed, the deployment is submitted as usual.
+func (s *Service) delay(ctx context.Context, evt events.EventCraft) (bool, error) {
+	if s.timer == nil {
+		return false, fmt.Errorf("delayed deployments are not configured")
+	}
+
+	if err := s.validateTarget(evt.Account, evt.Role); err != nil {
+		return false, err
+	}
+
+	if err := validateMode(evt.Mode); err != nil {
+		return false, err
+	}
+
+	if evt.NotBefore != "" && evt.Cron != "" {
+		return false, fmt.Errorf("either notBefore or cron is allowed")
+	}
+
+	// schedule of cron expression is deleted once it emits the deployment
+	expr := "cron(" + evt.Cron + ")"
+	tz := evt.Timezone
+	after := types.ActionAfterCompletionNone
+
+	if evt.NotBefore != "" {
+		t, err := time.Parse(time.RFC3339, evt.NotBefore)
+		if err != nil {
+			return false, fmt.Errorf("invalid notBefore %s: %w", evt.NotBefore, err)
+		}
+
+		// the time has already passed, the module is deployed immediately
+		if !t.After(time.Now()) {
+			return false, nil
+		}
+
+		expr = "at(" + t.UTC().Format("2006-01-02T15:04:05") + ")"
+		tz = "UTC"
+		after = types.ActionAfterCompletionDelete
+	}
+
+	if tz == "" {
+		tz = "UTC"
+	}
+
+	evt.Schedule = scheduleName(SCHEDULE_DELAY, evt.UID)
+	input, err := json.Marshal(evt)
+	if err != nil {
+		return false, err
+	}
+
+	// the redelivered deployment has already created the schedule
+	err = s.timer.create(ctx, evt.Schedule, expr, tz, after, evt.Module, "EventCraft", input)
+
+	var conflict *types.ConflictException
+	if err != nil && !errors.As(err, &conflict) {
+		return false, err
+	}
+
+	slog.Info("job delayed", "uid", evt.UID, "schedule", expr, "timezone", tz)
+
+	return true, nil
+}
+
+// name of the schedule is derived from its kind and identity of
+// the deployment, long name is truncated and suffixed by its hash.
+func scheduleName(kind, uid string) string {
+	name := kind + uid
+	if len(name) <= SCHEDULE_NAME_MAX {
+		return name
+	}
+
+	hash := sha256.Sum256([]byte(name))
+	return name[:SCHEDULE_NAME_MAX-17] + "-" + hex.EncodeToString(hash[:])[:16]
+}
+
+// creates the schedule, which emits the event of category to the bus
+func (t *timer) create(ctx context.Context, name, expr, tz string, after types.ActionAfterCompletion, description, category string, input []byte) error {
+	_, err := t.api.CreateSchedule(ctx,
+		&scheduler.CreateScheduleInput{
+			Name:                       aws.String(name),
+			GroupName:                  aws.String(t.group),
+			ScheduleExpression:         aws.String(expr),
+			ScheduleExpressionTimezone: aws.String(tz),
+			ActionAfterCompletion:      after,
+			FlexibleTimeWindow:         &types.FlexibleTimeWindow{Mode: types.FlexibleTimeWindowModeOff},
+			Description:                aws.String(description),
+			Target: &types.Target{
+				Arn:     aws.String(t.bus),
+				RoleArn: aws.String(t.role),
+				Input:   aws.String(string(input)),
+				EventBridgeParameters: &types.EventBridgeParameters{
+					DetailType: aws.String(category),
+					Source:     aws.String(t.bus[strings.LastIndex(t.bus, "/")+1:]),
+				},
+			},
+		},
+	)
+
+	return err
+}
+
+// expire the schedule of delayed deployment, the schedule of cron expression
+// is not deleted automatically after it has emitted the deployment.
+func (s *Service) expire(ctx context.Context, uid string) error {
+	if s.timer == nil {
+		return nil
+	}
+
+	_, err := s.timer.api.DeleteSchedule(ctx,
+		&scheduler.DeleteScheduleInput{
+			Name:      aws.String(scheduleName(SCHEDULE_DELAY, uid)),
+			GroupName: aws.String(s.timer.group),
+		},
+	)
+
+	var notFound *types.ResourceNotFoundException
+	if err != nil && !errors.As(err, &notFound) {
+		return err
+	}
+
+	return nil
+}
+
+// ScheduleCancel cancels delayed deployment, which is not started yet.
+func (s *Service) ScheduleCancel(evt events.EventScheduleCancel) error {
+	if s.timer == nil {
+		return fmt.Errorf("delayed deployments are not configured")
+	}
+
+	if err := s.expire(context.Background(), evt.UID); err != nil {
+		return err
+	}
+
+	slog.Info("job cancelled", "uid", evt.UID)
+
+	return nil
+}
+
+// ScheduleList lists delayed deployments, optionally of the tenant.
+func (s *Service) ScheduleList(evt events.EventScheduleList) (*events.EventSchedules, error) {
+	if s.timer == nil {
+		return nil, fmt.Errorf("delayed deployments are not configured")
+	}
+
+	ctx := context.Background()
+	seq := make([]events.EventCraft, 0)
+
+	var token *string
+	for {
+		val, err := s.timer.api.ListSchedules(ctx,
+			&scheduler.ListSchedulesInput{
+				GroupName: aws.String(s.timer.group),
+				State:     types.ScheduleStateEnabled,
+				NextToken: token,
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, summary := range val.Schedules {
+			schedule, err := s.timer.api.GetSchedule(ctx,
+				&scheduler.GetScheduleInput{
+					Name:      summary.Name,
+					GroupName: aws.String(s.timer.group),
+				},
+			)
+			if err != nil {
+				return nil, err
+			}
+
+			// schedules of other events (e.g. debounce) are not delayed deployments
+			if schedule.Target == nil || schedule.Target.EventBridgeParameters == nil ||
+				aws.ToString(schedule.Target.EventBridgeParameters.DetailType) != "EventCraft" {
+				continue
+			}
+
+			var delayed events.EventCraft
+			if err := json.Unmarshal([]byte(aws.ToString(schedule.Target.Input)), &delayed); err != nil {
+				return nil, fmt.Errorf("invalid schedule %s: %w", aws.ToString(summary.Name), err)
+			}
+
+			if evt.Tenant == "" || evt.Tenant == delayed.Tenant {
+				seq = append(seq, delayed)
+			}
+		}
+
+		if val.NextToken == nil {
+			return &events.EventSchedules{UID: evt.UID, Schedules: seq}, nil
+		}
+		token = val.NextToken
+	}
+}
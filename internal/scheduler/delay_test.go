@@ -0,0 +1,82 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package scheduler_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+	"github.com/fogfish/craft/internal/scheduler"
+	"github.com/fogfish/it/v2"
+)
+
+const bus = "arn:aws:events:eu-west-1:000000000000:event-bus/test-bus"
+
+func TestDelayPassed(t *testing.T) {
+	// the deployment is already submitted, the passed delay does not bypass
+	// the check of duplicates
+	jobs := &mockJobs{}
+	db := &mockRegistry{
+		seq: []registry.Deployment{
+			{UID: "a", Module: "m", Status: registry.STATUS_SCHEDULED, Job: "job-a"},
+		},
+	}
+	s := scheduler.New(jobs, "test-queue", "test-job", "test-s3",
+		scheduler.WithRegistry(db),
+		scheduler.WithTimer(&mockTimer{}, "test-group", bus, "test-role"),
+	)
+
+	job, err := s.Submit(events.EventCraft{UID: "a", Module: "m", Context: []byte(`{}`), NotBefore: "2000-01-01T00:00:00Z"})
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(job, "job-a"),
+		it.Equal(len(jobs.seq), 0),
+	)
+}
+
+func TestDelayRedelivered(t *testing.T) {
+	timer := &mockTimer{}
+	s := scheduler.New(&mockJobs{}, "test-queue", "test-job", "test-s3",
+		scheduler.WithTimer(timer, "test-group", bus, "test-role"),
+	)
+
+	evt := events.EventCraft{UID: "a", Module: "m", Context: []byte(`{}`), NotBefore: time.Now().Add(time.Hour).Format(time.RFC3339)}
+	for i := 0; i < 2; i++ {
+		_, err := s.Submit(evt)
+		it.Then(t).Should(it.Nil(err))
+	}
+
+	it.Then(t).Should(
+		it.Equal(len(timer.schedules), 1),
+	)
+}
+
+func TestScheduleCancelDebounce(t *testing.T) {
+	// the debounce window of the deployment is not cancelled with delay
+	timer := &mockTimer{}
+	s := scheduler.New(&mockJobs{}, "test-queue", "test-job", "test-s3",
+		scheduler.WithTimer(timer, "test-group", bus, "test-role"),
+		scheduler.WithDebounce(&mockDebounce{}, time.Minute),
+	)
+
+	_, err := s.Submit(events.EventCraft{UID: "a", Tenant: "acme", Module: "m", Context: []byte(`{}`)})
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(len(timer.schedules), 1),
+	)
+
+	err = s.ScheduleCancel(events.EventScheduleCancel{UID: "a"})
+	_, has := timer.schedules[scheduler.SCHEDULE_DEBOUNCE+"a"]
+	it.Then(t).Should(
+		it.Nil(err),
+		it.True(has),
+	)
+}
@@ -0,0 +1,71 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/fogfish/craft/internal/events"
+)
+
+// DetectDrift schedules drift job for the most recent succeeded deployment
+// of each module per tenant. Drift jobs are submitted as AWS Batch array
+// job, drifted stacks are redeployed using mode of the recorded deployment
+// if remediation is requested.
+func (s *Service) DetectDrift(evt events.EventDriftDetection) error {
+	if s.registry == nil {
+		return fmt.Errorf("registry is not configured")
+	}
+
+	ctx := context.Background()
+
+	deployed, err := s.registry.Deployed(ctx, evt.Tenant)
+	if err != nil {
+		return err
+	}
+
+	seq := make([]moduleTarget, 0, len(deployed))
+	for _, d := range deployed {
+		// destroyed stacks have no drift
+		if d.Mode == events.MODE_DESTROY {
+			continue
+		}
+
+		target := moduleTarget{
+			Target: events.Target{
+				Tenant:  d.Tenant,
+				Context: d.Context,
+				Account: d.Account,
+				Region:  d.Region,
+				Role:    d.Role,
+			},
+			Module:  d.Module,
+			Version: d.Version,
+			Drift:   d.UID,
+		}
+		if evt.Remediate {
+			target.Remediate = events.MODE_DEPLOY
+			if d.Mode == events.MODE_APPROVAL {
+				target.Remediate = events.MODE_APPROVAL
+			}
+		}
+
+		seq = append(seq, target)
+	}
+
+	if err := s.scheduleModules(ctx, evt.UID, events.MODE_DRIFT, seq, lineage{}); err != nil {
+		return err
+	}
+
+	slog.Info("drift detection scheduled", "uid", evt.UID, "jobs", len(seq))
+
+	return nil
+}
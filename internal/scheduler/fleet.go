@@ -0,0 +1,218 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/fleet"
+	"github.com/fogfish/craft/internal/registry"
+)
+
+// Rollout the module version across tenants, selected from the registry.
+// The rollout deploys the first wave, following waves are deployed as
+// deployments are completed (see RolloutDeployment).
+func (s *Service) Rollout(evt events.EventRollout) (*events.EventRolloutProgress, error) {
+	if s.registry == nil || s.fleet == nil {
+		return nil, fmt.Errorf("fleet is not configured")
+	}
+
+	for _, size := range evt.Waves {
+		if size <= 0 {
+			return nil, fmt.Errorf("invalid wave size %d", size)
+		}
+	}
+
+	ctx := context.Background()
+
+	tenants, err := s.registry.Tenants(ctx, evt.Module)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]fleet.Target, 0)
+	for _, d := range tenants {
+		if selected(evt.Selector, d.Tenant) {
+			targets = append(targets, fleet.Target{Tenant: d.Tenant, Source: d.UID})
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no tenants of module %s", evt.Module)
+	}
+
+	r := fleet.New(evt, targets)
+	err = s.fleet.Create(ctx, r)
+	switch {
+	case errors.Is(err, fleet.ErrConflict):
+		// the event is delivered at least once, the rollout is continued
+		r, err = s.fleet.Get(ctx, evt.UID)
+		if err != nil {
+			return nil, err
+		}
+
+		if r.Module != evt.Module || r.Version != evt.Version {
+			return nil, fmt.Errorf("rollout %s of %s@%s: %w", evt.UID, r.Module, r.Version, fleet.ErrConflict)
+		}
+	case err != nil:
+		return nil, err
+	}
+
+	return s.rollout(ctx, r)
+}
+
+// RolloutControl resumes halted rollout or aborts it. Resume acknowledges
+// failures happened so far.
+func (s *Service) RolloutControl(evt events.EventRolloutControl) (*events.EventRolloutProgress, error) {
+	if s.fleet == nil {
+		return nil, fmt.Errorf("fleet is not configured")
+	}
+
+	ctx := context.Background()
+
+	r, err := s.fleet.Get(ctx, evt.UID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case evt.Action == events.ACTION_RESUME && r.Status == events.ROLLOUT_HALTED:
+		r.Status = events.ROLLOUT_RUNNING
+		r.Tolerated = r.Count(fleet.TARGET_FAILED)
+	case evt.Action == events.ACTION_ABORT && (r.Status == events.ROLLOUT_RUNNING || r.Status == events.ROLLOUT_HALTED):
+		r.Status = events.ROLLOUT_ABORTED
+	default:
+		return nil, fmt.Errorf("action %s is not allowed for %s rollout", evt.Action, r.Status)
+	}
+
+	return s.rollout(ctx, r)
+}
+
+// RolloutDeployment tracks completed deployment of the rollout and
+// deploys next targets.
+func (s *Service) RolloutDeployment(evt events.EventDeployment) (*events.EventRolloutProgress, error) {
+	if s.fleet == nil {
+		return nil, fmt.Errorf("fleet is not configured")
+	}
+
+	ctx := context.Background()
+
+	r, err := s.fleet.Get(ctx, evt.Rollout)
+	if err != nil {
+		return nil, err
+	}
+
+	t := r.Target(evt.UID)
+	if t == nil {
+		return nil, fmt.Errorf("deployment %s does not belong to rollout %s", evt.UID, r.UID)
+	}
+
+	// the event is delivered at least once
+	if t.Status != fleet.TARGET_RUNNING {
+		return nil, nil
+	}
+
+	switch evt.Status {
+	case registry.STATUS_SUCCEEDED:
+		t.Status = fleet.TARGET_SUCCEEDED
+	case registry.STATUS_FAILED, registry.STATUS_DISCARDED:
+		t.Status = fleet.TARGET_FAILED
+		t.Reason = evt.Reason
+	default:
+		return nil, nil
+	}
+
+	return s.rollout(ctx, r)
+}
+
+// deploys next targets of the rollout until the failure threshold is exceeded.
+// Targets are claimed by the update of rollout before they are deployed,
+// the concurrent update fails with conflict.
+func (s *Service) rollout(ctx context.Context, r *fleet.Rollout) (*events.EventRolloutProgress, error) {
+	if r.Status == events.ROLLOUT_RUNNING && r.Exceeded() {
+		r.Status = events.ROLLOUT_HALTED
+	}
+
+	claimed := make([]*fleet.Target, 0)
+	for seq := r.Next(); len(seq) > 0; seq = r.Next() {
+		for _, t := range seq {
+			t.Status = fleet.TARGET_RUNNING
+			claimed = append(claimed, t)
+		}
+	}
+
+	if err := s.fleet.Update(ctx, r); err != nil {
+		return nil, err
+	}
+
+	failed := false
+	for _, t := range claimed {
+		if err := s.deploy(ctx, r, t); err != nil {
+			slog.Error("failed to deploy tenant", "rollout", r.UID, "tenant", t.Tenant, "err", err)
+			t.Status = fleet.TARGET_FAILED
+			t.Reason = err.Error()
+			failed = true
+		}
+	}
+
+	// failed targets either halt the rollout or release next ones
+	if failed {
+		return s.rollout(ctx, r)
+	}
+
+	progress := r.Progress()
+	slog.Info("rollout", "uid", r.UID, "status", progress.Status, "wave", progress.Wave,
+		"succeeded", progress.Succeeded, "failed", progress.Failed,
+	)
+
+	return &progress, nil
+}
+
+// deploys target version of the module using tenant's context
+func (s *Service) deploy(ctx context.Context, r *fleet.Rollout, t *fleet.Target) error {
+	source, err := s.registry.Get(ctx, t.Source)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.schedule(ctx,
+		events.EventCraft{
+			UID:     t.UID,
+			Module:  r.Module,
+			Version: r.Version,
+			Tenant:  t.Tenant,
+			Context: source.Context,
+			Account: source.Account,
+			Region:  source.Region,
+			Role:    source.Role,
+		},
+		lineage{rollout: r.UID},
+	)
+
+	return err
+}
+
+func selected(selector []string, tenant string) bool {
+	if len(selector) == 0 {
+		return true
+	}
+
+	for _, pattern := range selector {
+		if ok, _ := path.Match(pattern, tenant); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,254 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+	"github.com/fogfish/craft/internal/tenant"
+)
+
+// Provision new tenant, the module is deployed into the tenant's environment.
+func (s *Service) Provision(evt events.EventTenantProvision) ([]events.EventTenantTransition, error) {
+	return s.transit(events.TRANSITION_PROVISION, evt.UID, evt.Tenant,
+		func(ctx context.Context) ([]events.EventCraft, error) {
+			craft := events.EventCraft{
+				UID:     evt.UID,
+				Tenant:  evt.Tenant,
+				Module:  evt.Module,
+				Version: evt.Version,
+				Context: evt.Context,
+				Account: evt.Account,
+				Region:  evt.Region,
+				Role:    evt.Role,
+			}
+			return []events.EventCraft{craft}, nil
+		},
+	)
+}
+
+// Suspend active tenant, modules are redeployed with "suspended": true.
+func (s *Service) Suspend(evt events.EventTenantSuspend) ([]events.EventTenantTransition, error) {
+	return s.transit(events.TRANSITION_SUSPEND, evt.UID, evt.Tenant,
+		func(ctx context.Context) ([]events.EventCraft, error) {
+			return s.redeploy(ctx, evt.UID, evt.Tenant, "", true)
+		},
+	)
+}
+
+// Resume suspended tenant, modules are redeployed with "suspended": false.
+func (s *Service) Resume(evt events.EventTenantResume) ([]events.EventTenantTransition, error) {
+	return s.transit(events.TRANSITION_RESUME, evt.UID, evt.Tenant,
+		func(ctx context.Context) ([]events.EventCraft, error) {
+			return s.redeploy(ctx, evt.UID, evt.Tenant, "", false)
+		},
+	)
+}
+
+// Deprovision tenant, stacks of modules are destroyed.
+func (s *Service) Deprovision(evt events.EventTenantDeprovision) ([]events.EventTenantTransition, error) {
+	return s.transit(events.TRANSITION_DEPROVISION, evt.UID, evt.Tenant,
+		func(ctx context.Context) ([]events.EventCraft, error) {
+			return s.redeploy(ctx, evt.UID, evt.Tenant, events.MODE_DESTROY, nil)
+		},
+	)
+}
+
+// TenantDeployment tracks completed deployment of the tenant's transition,
+// the tenant transits to the target status once all deployments are succeeded.
+func (s *Service) TenantDeployment(evt events.EventDeployment) ([]events.EventTenantTransition, error) {
+	if s.tenants == nil {
+		return nil, fmt.Errorf("tenants are not configured")
+	}
+
+	switch evt.Status {
+	case registry.STATUS_SUCCEEDED, registry.STATUS_FAILED, registry.STATUS_DISCARDED:
+	default:
+		return nil, nil
+	}
+
+	ctx := context.Background()
+
+	t, err := s.tenants.Get(ctx, evt.Tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	// the event is delivered at least once
+	if !slices.Contains(t.Pending, evt.UID) {
+		return nil, nil
+	}
+
+	seq := t.Complete(evt.UID, evt.Status, evt.Reason)
+	if err := s.tenants.Update(ctx, t); err != nil {
+		return nil, err
+	}
+
+	return transitionsOf(t, seq), nil
+}
+
+// transits the tenant and schedules deployments of the transition. The
+// transition is recorded before deployments are scheduled so that their
+// completion is not lost.
+func (s *Service) transit(
+	transition, uid, id string,
+	jobs func(context.Context) ([]events.EventCraft, error),
+) ([]events.EventTenantTransition, error) {
+	if s.registry == nil || s.tenants == nil {
+		return nil, fmt.Errorf("tenants are not configured")
+	}
+
+	if id == "" {
+		return nil, fmt.Errorf("tenant is not defined")
+	}
+
+	ctx := context.Background()
+
+	t, err := s.tenants.Get(ctx, id)
+	switch {
+	case errors.Is(err, tenant.ErrNotFound):
+		t = tenant.New(id)
+	case err != nil:
+		return nil, err
+	}
+
+	if err := t.Allowed(transition); err != nil {
+		return nil, err
+	}
+
+	seq, err := jobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]string, len(seq))
+	for i, craft := range seq {
+		pending[i] = craft.UID
+	}
+
+	created := t.Created == ""
+	trs, err := t.Transit(transition, uid, pending)
+	if err != nil {
+		return nil, err
+	}
+
+	if created {
+		err = s.tenants.Create(ctx, t)
+	} else {
+		err = s.tenants.Update(ctx, t)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	failed := make([]tenant.Transition, 0)
+	for _, craft := range seq {
+		if _, err := s.schedule(ctx, craft, lineage{lifecycle: transition}); err != nil {
+			slog.Error("failed to schedule transition", "tenant", id, "transition", transition, "module", craft.Module, "err", err)
+			failed = append(failed, t.Complete(craft.UID, registry.STATUS_FAILED, err.Error())...)
+		}
+	}
+
+	if len(failed) != 0 {
+		if err := s.tenants.Update(ctx, t); err != nil {
+			return nil, err
+		}
+		trs = append(trs, failed...)
+	}
+
+	slog.Info("tenant transition", "tenant", id, "transition", transition, "status", t.Status, "deployments", len(seq))
+
+	return transitionsOf(t, trs), nil
+}
+
+// redeploys modules of the tenant using the most recent succeeded
+// deployment, the context is extended with suspended flag if defined.
+// Destroyed modules are skipped.
+func (s *Service) redeploy(ctx context.Context, uid, id, mode string, suspended any) ([]events.EventCraft, error) {
+	history, err := s.registry.History(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := make([]string, 0)
+	has := map[string]struct{}{}
+	for _, d := range history {
+		if _, exists := has[d.Module]; !exists {
+			has[d.Module] = struct{}{}
+			modules = append(modules, d.Module)
+		}
+	}
+	sort.Strings(modules)
+
+	seq := make([]events.EventCraft, 0)
+	for _, module := range modules {
+		_, succeeded := lastOf(history, module)
+		if succeeded == nil || succeeded.Mode == events.MODE_DESTROY {
+			continue
+		}
+
+		context := succeeded.Context
+		if suspended != nil {
+			context, err = withContext(context, "suspended", suspended)
+			if err != nil {
+				return nil, fmt.Errorf("module %s: %w", module, err)
+			}
+		}
+
+		seq = append(seq,
+			events.EventCraft{
+				UID:     fmt.Sprintf("%s-%d", uid, len(seq)),
+				Tenant:  id,
+				Module:  module,
+				Version: succeeded.Version,
+				Context: context,
+				Account: succeeded.Account,
+				Region:  succeeded.Region,
+				Role:    succeeded.Role,
+				Mode:    mode,
+			},
+		)
+	}
+
+	return seq, nil
+}
+
+// sets the key of deployment's context
+func withContext(context json.RawMessage, key string, val any) (json.RawMessage, error) {
+	kv := map[string]json.RawMessage{}
+	if len(context) != 0 {
+		if err := json.Unmarshal(context, &kv); err != nil {
+			return nil, fmt.Errorf("invalid context: %w", err)
+		}
+	}
+
+	raw, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	kv[key] = raw
+
+	return json.Marshal(kv)
+}
+
+func transitionsOf(t *tenant.Tenant, seq []tenant.Transition) []events.EventTenantTransition {
+	evts := make([]events.EventTenantTransition, len(seq))
+	for i, tr := range seq {
+		evts[i] = tr.Event(t.ID)
+	}
+	return evts
+}
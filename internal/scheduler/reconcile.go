@@ -0,0 +1,142 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/fogfish/craft/internal/desired"
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+)
+
+// Reconcile compares desired state of tenants with their last succeeded
+// deployment and redeploys tenants, which differ or have failed. Tenants
+// with deployment in progress or discarded by approval are skipped.
+// Redeployments are submitted as AWS Batch array job per mode.
+func (s *Service) Reconcile(evt events.EventReconcile) error {
+	if s.registry == nil || s.desired == nil {
+		return fmt.Errorf("desired state is not configured")
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+
+	states, err := s.desired.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	histories := map[string][]registry.Deployment{}
+	deploy := make([]moduleTarget, 0)
+	approval := make([]moduleTarget, 0)
+	for _, state := range states {
+		if evt.Tenant != "" && state.Tenant != evt.Tenant {
+			continue
+		}
+
+		history, has := histories[state.Tenant]
+		if !has {
+			history, err = s.registry.History(ctx, state.Tenant)
+			if err != nil {
+				return err
+			}
+			histories[state.Tenant] = history
+		}
+
+		latest, succeeded := lastOf(history, state.Module)
+
+		if succeeded != nil && state.Same(succeeded.Version, succeeded.Context) {
+			if state.Attempts != 0 {
+				state.Reset()
+				if err := s.desired.Update(ctx, &state); err != nil && !errors.Is(err, desired.ErrConflict) {
+					return err
+				}
+			}
+			continue
+		}
+
+		if latest != nil {
+			switch latest.Status {
+			case registry.STATUS_SCHEDULED, registry.STATUS_PENDING, registry.STATUS_DISCARDED:
+				continue
+			}
+		}
+
+		if !state.Due(now) {
+			continue
+		}
+
+		// back-off is recorded before the deployment, concurrently declared
+		// state is not reconciled
+		state.Backoff(now)
+		if err := s.desired.Update(ctx, &state); err != nil {
+			if errors.Is(err, desired.ErrConflict) {
+				continue
+			}
+			return err
+		}
+
+		target := moduleTarget{
+			Target: events.Target{
+				Tenant:  state.Tenant,
+				Context: state.Context,
+				Account: state.Account,
+				Region:  state.Region,
+				Role:    state.Role,
+			},
+			Module:  state.Module,
+			Version: state.Version,
+		}
+		if state.Mode == events.MODE_APPROVAL {
+			approval = append(approval, target)
+		} else {
+			deploy = append(deploy, target)
+		}
+
+		slog.Info("tenant reconciled", "tenant", state.Tenant, "module", state.Module, "attempts", state.Attempts)
+	}
+
+	// failure of either mode fails the reconciliation, it is retried
+	var errs []error
+	if err := s.scheduleModules(ctx, evt.UID, "", deploy, lineage{reconcile: true}); err != nil {
+		slog.Error("failed to reconcile tenants", "uid", evt.UID, "size", len(deploy), "err", err)
+		errs = append(errs, err)
+	}
+
+	uid := fmt.Sprintf("%s-%s", evt.UID, events.MODE_APPROVAL)
+	if err := s.scheduleModules(ctx, uid, events.MODE_APPROVAL, approval, lineage{reconcile: true}); err != nil {
+		slog.Error("failed to reconcile tenants", "uid", uid, "size", len(approval), "err", err)
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// returns the most recent and the most recent succeeded deployment of module
+func lastOf(history []registry.Deployment, module string) (*registry.Deployment, *registry.Deployment) {
+	var latest, succeeded *registry.Deployment
+	for i := range history {
+		if history[i].Module != module {
+			continue
+		}
+		if latest == nil {
+			latest = &history[i]
+		}
+		if history[i].Status == registry.STATUS_SUCCEEDED {
+			succeeded = &history[i]
+			break
+		}
+	}
+	return latest, succeeded
+}
@@ -0,0 +1,67 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package scheduler_test
+
+import (
+	"testing"
+
+	"github.com/fogfish/craft/internal/desired"
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+	"github.com/fogfish/craft/internal/scheduler"
+	"github.com/fogfish/it/v2"
+)
+
+// desired state of two tenants, which are not deployed yet
+func drifted() *mockDesired {
+	return &mockDesired{
+		seq: map[string]desired.State{
+			"a m": {Tenant: "a", Module: "m", Version: "v1", Context: []byte(`{}`)},
+			"b m": {Tenant: "b", Module: "m", Version: "v1", Context: []byte(`{}`)},
+		},
+	}
+}
+
+func TestReconcileArray(t *testing.T) {
+	jobs := &mockJobs{}
+	db := &mockRegistry{}
+	s := scheduler.New(jobs, "test-queue", "test-job", "test-s3",
+		scheduler.WithRegistry(db),
+		scheduler.WithDesired(drifted()),
+		scheduler.WithStorage(mockStorage{}),
+	)
+
+	err := s.Reconcile(events.EventReconcile{UID: "r"})
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(len(jobs.seq), 1),
+		it.Equal(len(db.seq), 2),
+		it.Equal(db.seq[0].UID, "r-0"),
+		it.Equal(db.seq[0].Job, "job-1:0"),
+		it.Equal(db.seq[1].UID, "r-1"),
+		it.Equal(db.seq[1].Job, "job-1:1"),
+	)
+}
+
+func TestReconcileFailed(t *testing.T) {
+	db := &mockRegistry{}
+	s := scheduler.New(mockJobsFailed{}, "test-queue", "test-job", "test-s3",
+		scheduler.WithRegistry(db),
+		scheduler.WithDesired(drifted()),
+		scheduler.WithStorage(mockStorage{}),
+	)
+
+	err := s.Reconcile(events.EventReconcile{UID: "r"})
+	it.Then(t).Should(
+		it.Fail(func() error { return err }),
+		it.Equal(len(db.seq), 2),
+		it.Equal(db.seq[0].Status, registry.STATUS_FAILED),
+		it.Equal(db.seq[1].Status, registry.STATUS_FAILED),
+	)
+}
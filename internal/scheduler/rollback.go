@@ -0,0 +1,96 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+)
+
+// Rollback redeploys the last known-good deployment of the tenant, which
+// precedes the reverted one. The rollback is recorded as new deployment
+// linked to the reverted one.
+func (s *Service) Rollback(evt events.EventRollback) error {
+	if s.registry == nil {
+		return fmt.Errorf("registry is not configured")
+	}
+
+	ctx := context.Background()
+
+	tenant := evt.Tenant
+	if evt.Reverts != "" {
+		reverted, err := s.registry.Get(ctx, evt.Reverts)
+		if err != nil {
+			return err
+		}
+		tenant = reverted.Tenant
+	}
+
+	if tenant == "" {
+		return fmt.Errorf("tenant is not defined")
+	}
+
+	history, err := s.registry.History(ctx, tenant)
+	if err != nil {
+		return err
+	}
+
+	reverts, good := knownGood(history, evt.Reverts)
+	if good == nil {
+		return fmt.Errorf("no known-good deployment of tenant %s", tenant)
+	}
+
+	slog.Info("rollback", "uid", evt.UID, "tenant", tenant, "reverts", reverts, "to", good.UID)
+
+	_, err = s.schedule(ctx,
+		events.EventCraft{
+			UID:     evt.UID,
+			Module:  good.Module,
+			Version: good.Version,
+			Tenant:  good.Tenant,
+			Context: good.Context,
+			Account: good.Account,
+			Region:  good.Region,
+			Role:    good.Role,
+		},
+		lineage{reverts: reverts},
+	)
+
+	return err
+}
+
+// finds the reverted deployment (the most recent one if uid is not defined)
+// and the succeeded deployment of same module and tenant preceding it.
+func knownGood(history []registry.Deployment, uid string) (string, *registry.Deployment) {
+	at := -1
+	for i, d := range history {
+		if uid == "" || d.UID == uid {
+			at = i
+			break
+		}
+	}
+
+	if at == -1 {
+		return uid, nil
+	}
+
+	reverted := history[at]
+	for i := at + 1; i < len(history); i++ {
+		d := history[i]
+		if d.Module == reverted.Module && d.Tenant == reverted.Tenant && d.Status == registry.STATUS_SUCCEEDED {
+			return reverted.UID, &history[i]
+		}
+	}
+
+	return reverted.UID, nil
+}
@@ -0,0 +1,59 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package scheduler_test
+
+import (
+	"testing"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+	"github.com/fogfish/craft/internal/scheduler"
+	"github.com/fogfish/it/v2"
+)
+
+func TestRollbackModule(t *testing.T) {
+	// history of tenant mixes modules, the most recent deployment is last
+	history := func() *mockRegistry {
+		return &mockRegistry{
+			seq: []registry.Deployment{
+				{UID: "a", Tenant: "acme", Module: "github.com/acme/api", Version: "v1.0.0", Status: registry.STATUS_SUCCEEDED},
+				{UID: "b", Tenant: "acme", Module: "github.com/acme/web", Version: "v2.0.0", Status: registry.STATUS_SUCCEEDED},
+				{UID: "c", Tenant: "acme", Module: "github.com/acme/api", Version: "v1.1.0", Status: registry.STATUS_SUCCEEDED},
+				{UID: "d", Tenant: "acme", Module: "github.com/acme/web", Version: "v2.1.0", Status: registry.STATUS_SUCCEEDED},
+			},
+		}
+	}
+
+	// reverted deployment -> version of module redeployed by the rollback
+	for reverts, expect := range map[string]string{
+		"d": "v2.0.0",
+		"c": "v1.0.0",
+		"b": "",
+		"a": "",
+		"":  "v2.0.0",
+	} {
+		t.Run("Reverts"+reverts, func(t *testing.T) {
+			jobs := &mockJobs{}
+			db := history()
+			s := scheduler.New(jobs, "test-queue", "test-job", "test-s3", scheduler.WithRegistry(db))
+
+			err := s.Rollback(events.EventRollback{UID: "rollback", Tenant: "acme", Reverts: reverts})
+			if expect == "" {
+				it.Then(t).ShouldNot(it.Nil(err))
+				return
+			}
+
+			it.Then(t).Should(
+				it.Nil(err),
+				it.Equal(len(jobs.seq), 1),
+				it.Equal(env(jobs.seq[0], "CRAFT_MODULE_VERSION"), expect),
+			)
+		})
+	}
+}
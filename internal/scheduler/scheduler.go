@@ -9,55 +9,779 @@
 package scheduler
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/aws/aws-sdk-go-v2/service/batch"
 	"github.com/aws/aws-sdk-go-v2/service/batch/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/scheduler"
+	"github.com/fogfish/craft/internal/debounce"
+	"github.com/fogfish/craft/internal/desired"
 	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/fleet"
+	"github.com/fogfish/craft/internal/registry"
+	"github.com/fogfish/craft/internal/tenant"
 )
 
+var ErrInvalid = errors.New("invalid deployment")
+
 type JobQueue interface {
 	SubmitJob(ctx context.Context, params *batch.SubmitJobInput, optFns ...func(*batch.Options)) (*batch.SubmitJobOutput, error)
 }
 
+type Registry interface {
+	Put(ctx context.Context, d registry.Deployment) error
+	Claim(ctx context.Context, d registry.Deployment) error
+	Attach(ctx context.Context, uid, job string) error
+	Transit(ctx context.Context, uid, from, to string) error
+	Get(ctx context.Context, uid string) (*registry.Deployment, error)
+	History(ctx context.Context, tenant string) ([]registry.Deployment, error)
+	Tenants(ctx context.Context, module string) ([]registry.Deployment, error)
+	Deployed(ctx context.Context, tenant string) ([]registry.Deployment, error)
+}
+
+type Storage interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+type Fleet interface {
+	Create(ctx context.Context, r *fleet.Rollout) error
+	Update(ctx context.Context, r *fleet.Rollout) error
+	Get(ctx context.Context, uid string) (*fleet.Rollout, error)
+}
+
+type Desired interface {
+	Put(ctx context.Context, state *desired.State) error
+	Update(ctx context.Context, state *desired.State) error
+	Delete(ctx context.Context, tenant, module string) error
+	List(ctx context.Context) ([]desired.State, error)
+}
+
+type Tenants interface {
+	Create(ctx context.Context, t *tenant.Tenant) error
+	Update(ctx context.Context, t *tenant.Tenant) error
+	Get(ctx context.Context, id string) (*tenant.Tenant, error)
+}
+
+type Debounce interface {
+	Append(ctx context.Context, b *debounce.Buffer) (*debounce.Buffer, error)
+	Take(ctx context.Context, tenant, module string) (*debounce.Buffer, error)
+}
+
+type Sequence interface {
+	Advance(ctx context.Context, tenant, module string, seq int64) error
+}
+
+type Timer interface {
+	CreateSchedule(ctx context.Context, params *scheduler.CreateScheduleInput, optFns ...func(*scheduler.Options)) (*scheduler.CreateScheduleOutput, error)
+	DeleteSchedule(ctx context.Context, params *scheduler.DeleteScheduleInput, optFns ...func(*scheduler.Options)) (*scheduler.DeleteScheduleOutput, error)
+	GetSchedule(ctx context.Context, params *scheduler.GetScheduleInput, optFns ...func(*scheduler.Options)) (*scheduler.GetScheduleOutput, error)
+	ListSchedules(ctx context.Context, params *scheduler.ListSchedulesInput, optFns ...func(*scheduler.Options)) (*scheduler.ListSchedulesOutput, error)
+}
+
 type Service struct {
-	api        JobQueue
-	queue      string
-	definition string
-	bucket     string
+	api          JobQueue
+	registry     Registry
+	fleet        Fleet
+	desired      Desired
+	tenants      Tenants
+	storage      Storage
+	timer        *timer
+	debounce     Debounce
+	sequence     Sequence
+	window       time.Duration
+	queue        string
+	definition   string
+	bucket       string
+	accounts     map[string]struct{}
+	bootstrap    string
+	organization string
+	bus          string
+}
+
+type Option func(*Service)
+
+// WithJobBootstrap defines job definition of bootstrap jobs
+func WithJobBootstrap(definition string) Option {
+	return func(s *Service) {
+		s.bootstrap = definition
+	}
+}
+
+// WithOrganization allows bootstrap of any account at the organization,
+// the organization membership is enforced by IAM.
+func WithOrganization(id string) Option {
+	return func(s *Service) {
+		s.organization = id
+	}
 }
 
-func New(api JobQueue, queue string, definition string, bucket string) *Service {
-	return &Service{
+// WithRegistry enables history of deployments
+func WithRegistry(registry Registry) Option {
+	return func(s *Service) {
+		s.registry = registry
+	}
+}
+
+// WithFleet enables rollouts of module across tenants
+func WithFleet(fleet Fleet) Option {
+	return func(s *Service) {
+		s.fleet = fleet
+	}
+}
+
+// WithDesired enables reconciliation of tenants to desired state, each
+// deployment of tenant declares its desired state.
+func WithDesired(desired Desired) Option {
+	return func(s *Service) {
+		s.desired = desired
+	}
+}
+
+// WithTenants enables lifecycle of tenants
+func WithTenants(tenants Tenants) Option {
+	return func(s *Service) {
+		s.tenants = tenants
+	}
+}
+
+// WithStorage enables array jobs, targets of array job are stored
+// at the bucket.
+func WithStorage(storage Storage) Option {
+	return func(s *Service) {
+		s.storage = storage
+	}
+}
+
+// WithTimer enables delayed deployments, the schedule of group emits
+// the deployment to the bus (arn) using the role.
+func WithTimer(api Timer, group, bus, role string) Option {
+	return func(s *Service) {
+		s.timer = &timer{api: api, group: group, bus: bus, role: role}
+	}
+}
+
+// WithDebounce enables coalescing of successive deployments of tenant's
+// module within the window, it requires the timer.
+func WithDebounce(store Debounce, window time.Duration) Option {
+	return func(s *Service) {
+		s.debounce = store
+		s.window = window
+	}
+}
+
+// WithSequence enables ordering of tenant's deployments by sequence
+// number, deployments older than the last applied one are parked.
+func WithSequence(sequence Sequence) Option {
+	return func(s *Service) {
+		s.sequence = sequence
+	}
+}
+
+// WithAccounts defines allow-list of target accounts
+func WithAccounts(accounts ...string) Option {
+	return func(s *Service) {
+		for _, acc := range accounts {
+			if acc != "" {
+				s.accounts[acc] = struct{}{}
+			}
+		}
+	}
+}
+
+// WithBus defines the craft's event bus (arn), it is not accepted as reply-to,
+// its account is the target of deployments without account.
+func WithBus(arn string) Option {
+	return func(s *Service) {
+		s.bus = arn
+	}
+}
+
+func New(api JobQueue, queue string, definition string, bucket string, opts ...Option) *Service {
+	s := &Service{
 		api:        api,
 		queue:      queue,
 		definition: definition,
 		bucket:     bucket,
+		accounts:   map[string]struct{}{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
 	}
+
+	return s
 }
 
 func (s *Service) Schedule(evt events.EventCraft) error {
+	_, err := s.Submit(evt)
+	return err
+}
+
+// Submit the deployment, it returns identity of AWS Batch job. Delayed,
+// debounced and parked deployments have no job yet, the duplicate one
+// returns the job of recorded deployment.
+func (s *Service) Submit(evt events.EventCraft) (string, error) {
+	ctx := context.Background()
+
+	switch {
+	case evt.Schedule != "":
+		// the schedule is only defined by craft for the delayed deployment
+		if evt.Schedule != scheduleName(SCHEDULE_DELAY, evt.UID) {
+			return "", fmt.Errorf("schedule %s does not belong to %s: %w", evt.Schedule, evt.UID, ErrInvalid)
+		}
+
+		if err := s.expire(ctx, evt.UID); err != nil {
+			return "", err
+		}
+	case evt.NotBefore != "" || evt.Cron != "":
+		if delayed, err := s.delay(ctx, evt); delayed || err != nil {
+			return "", err
+		}
+	}
+
+	if d, err := s.duplicate(ctx, evt); d != nil || err != nil {
+		if err != nil {
+			return "", err
+		}
+		return d.Job, nil
+	}
+
+	if parked, err := s.order(ctx, evt); parked || err != nil {
+		return "", err
+	}
+
+	if s.debounce != nil && evt.Schedule == "" && evt.Tenant != "" && (evt.Mode == "" || evt.Mode == events.MODE_DEPLOY) {
+		return "", s.coalesce(ctx, evt)
+	}
+
+	return s.schedule(ctx, evt, lineage{})
+}
+
+// Validate the deployment before it is submitted
+func (s *Service) Validate(evt events.EventCraft) error {
+	if evt.UID == "" || evt.Module == "" || evt.Context == nil {
+		return fmt.Errorf("uid, module and context are required: %w", ErrInvalid)
+	}
+
+	if err := s.validateTarget(evt.Account, evt.Role); err != nil {
+		return fmt.Errorf("%s: %w", err, ErrInvalid)
+	}
+
+	// the schedule is defined by craft when the delayed deployment fires
+	if evt.Schedule != "" && evt.Schedule != scheduleName(SCHEDULE_DELAY, evt.UID) {
+		return fmt.Errorf("schedule %s is not defined by craft: %w", evt.Schedule, ErrInvalid)
+	}
+
+	if err := validateMode(evt.Mode); err != nil {
+		return fmt.Errorf("%s: %w", err, ErrInvalid)
+	}
+
+	if err := validateCallback(evt.Callback); err != nil {
+		return fmt.Errorf("%s: %w", err, ErrInvalid)
+	}
+
+	if err := s.validateReplyTo(evt); err != nil {
+		return fmt.Errorf("%s: %w", err, ErrInvalid)
+	}
+
+	return nil
+}
+
+// Deployment recorded by the registry
+func (s *Service) Deployment(uid string) (*registry.Deployment, error) {
+	if s.registry == nil {
+		return nil, fmt.Errorf("registry is not configured")
+	}
+
+	return s.registry.Get(context.Background(), uid)
+}
+
+// checks if the deployment is already recorded, the event is delivered
+// at least once or replayed from the archive. Failed deployments and
+// deployments, whose job has not been submitted, are scheduled again.
+func (s *Service) duplicate(ctx context.Context, evt events.EventCraft) (*registry.Deployment, error) {
+	if s.registry == nil {
+		return nil, nil
+	}
+
+	d, err := s.registry.Get(ctx, evt.UID)
+	switch {
+	case errors.Is(err, registry.ErrNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	switch {
+	case d.Status == registry.STATUS_FAILED || d.Status == registry.STATUS_DISCARDED:
+		return nil, nil
+	case d.Status == registry.STATUS_SCHEDULED && d.Job == "":
+		// the job is not submitted yet, the claim decides if it is duplicate
+		return nil, nil
+	}
+
+	// the uid of recorded deployment is reused by other one
+	if d.Module != evt.Module || d.Tenant != evt.Tenant {
+		slog.Error("job duplicate, uid is reused", "uid", evt.UID, "module", evt.Module, "tenant", evt.Tenant, "recorded", d.Module)
+		return nil, fmt.Errorf("uid %s is recorded for %s of %s: %w", evt.UID, d.Module, d.Tenant, ErrInvalid)
+	}
+
+	// the event is either redelivered or replayed
+	slog.Warn("job duplicate", "uid", evt.UID, "status", d.Status, "job", d.Job)
+	return d, nil
+}
+
+// lineage of the deployment, links it with reverted one, the rollout,
+// the composite deployment or deployment checked for drift.
+type lineage struct {
+	reverts string
+	rollout string
+
+	// identity of composite deployment and all its deployments
+	composite string
+	members   []string
+
+	// upstream deployments of composite, the job depends on
+	upstream []upstream
+
+	// deployment checked by drift job and mode of its remediation
+	drift     string
+	remediate string
+
+	// deployment is scheduled by reconciler to achieve desired state
+	reconcile bool
+
+	// transition of tenant lifecycle
+	lifecycle string
+
+	// deployments coalesced by debounce
+	coalesced []string
+}
+
+type upstream struct {
+	name string
+	uid  string
+	job  string
+}
+
+// schedules the job, it returns identity of the job. The identity is
+// returned along with the error if the job is submitted but not recorded.
+func (s *Service) schedule(ctx context.Context, evt events.EventCraft, link lineage) (string, error) {
+	if err := s.validateTarget(evt.Account, evt.Role); err != nil {
+		return "", err
+	}
+
+	if err := validateCallback(evt.Callback); err != nil {
+		return "", err
+	}
+
+	if err := s.validateReplyTo(evt); err != nil {
+		return "", err
+	}
+
+	// stacks are only destroyed by deprovisioning of tenant, drift is only
+	// detected for the recorded deployment
+	destroy := evt.Mode == events.MODE_DESTROY && link.lifecycle == events.TRANSITION_DEPROVISION
+	drift := evt.Mode == events.MODE_DRIFT && link.drift != ""
+	if !destroy && !drift {
+		if err := validateMode(evt.Mode); err != nil {
+			return "", err
+		}
+	}
+
+	env := []types.KeyValuePair{
+		{Name: aws.String("CRAFT_UID"), Value: aws.String(evt.UID)},
+		{Name: aws.String("CRAFT_BUCKET"), Value: aws.String(s.bucket)},
+		{Name: aws.String("CRAFT_MODULE"), Value: aws.String(evt.Module)},
+		{Name: aws.String("CRAFT_CDK_CONTEXT"), Value: aws.String(string(evt.Context))},
+	}
+
+	if evt.Version != "" {
+		env = append(env,
+			types.KeyValuePair{Name: aws.String("CRAFT_MODULE_VERSION"), Value: aws.String(evt.Version)},
+		)
+	}
+
+	if evt.Tenant != "" {
+		env = append(env,
+			types.KeyValuePair{Name: aws.String("CRAFT_TENANT"), Value: aws.String(evt.Tenant)},
+		)
+	}
+
+	if evt.Account != "" {
+		env = append(env,
+			types.KeyValuePair{Name: aws.String("CRAFT_TARGET_ACCOUNT"), Value: aws.String(evt.Account)},
+		)
+	}
+
+	if evt.Region != "" {
+		env = append(env,
+			types.KeyValuePair{Name: aws.String("CRAFT_TARGET_REGION"), Value: aws.String(evt.Region)},
+		)
+	}
+
+	if evt.Role != "" {
+		env = append(env,
+			types.KeyValuePair{Name: aws.String("CRAFT_TARGET_ROLE"), Value: aws.String(evt.Role)},
+		)
+	}
+
+	if evt.Mode != "" {
+		env = append(env,
+			types.KeyValuePair{Name: aws.String("CRAFT_MODE"), Value: aws.String(evt.Mode)},
+		)
+	}
+
+	if link.composite != "" {
+		env = append(env,
+			types.KeyValuePair{Name: aws.String("CRAFT_COMPOSITE"), Value: aws.String(link.composite)},
+			types.KeyValuePair{Name: aws.String("CRAFT_COMPOSITE_JOBS"), Value: aws.String(strings.Join(link.members, " "))},
+		)
+	}
+
+	if link.drift != "" {
+		env = append(env,
+			types.KeyValuePair{Name: aws.String("CRAFT_DRIFT_DEPLOYMENT"), Value: aws.String(link.drift)},
+		)
+	}
+
+	if link.remediate != "" {
+		env = append(env,
+			types.KeyValuePair{Name: aws.String("CRAFT_DRIFT_REMEDIATE"), Value: aws.String(link.remediate)},
+		)
+	}
+
+	if len(link.coalesced) != 0 {
+		env = append(env,
+			types.KeyValuePair{Name: aws.String("CRAFT_COALESCED"), Value: aws.String(strings.Join(link.coalesced, " "))},
+		)
+	}
+
+	deps := make([]types.JobDependency, 0, len(link.upstream))
+	refs := make([]string, 0, len(link.upstream))
+	for _, up := range link.upstream {
+		deps = append(deps, types.JobDependency{JobId: aws.String(up.job)})
+		refs = append(refs, up.name+"="+up.uid)
+	}
+
+	if len(refs) != 0 {
+		env = append(env,
+			types.KeyValuePair{Name: aws.String("CRAFT_DEPENDS"), Value: aws.String(strings.Join(refs, " "))},
+		)
+	}
+
+	if evt.ReplyTo != "" {
+		env = append(env,
+			types.KeyValuePair{Name: aws.String("CRAFT_REPLY_TO"), Value: aws.String(evt.ReplyTo)},
+		)
+	}
+
+	// the desired state is declared before the job is submitted, the retry
+	// of failed declaration is not treated as duplicate.
+	recorded := s.registry != nil && evt.Mode != events.MODE_DIFF && evt.Mode != events.MODE_DRIFT
+	if evt.Mode != events.MODE_DIFF && evt.Mode != events.MODE_DRIFT {
+		if err := s.declare(ctx, evt, link); err != nil {
+			slog.Error("failed to declare desired state", "uid", evt.UID, "err", err)
+			return "", err
+		}
+	}
+
+	// the deployment is recorded before the job is submitted, the claim
+	// rejects concurrent duplicates of the event.
+	deployment := registry.Deployment{}
+	if recorded {
+		deployment = registry.Deployment{
+			UID:     evt.UID,
+			Tenant:  evt.Tenant,
+			Module:  evt.Module,
+			Version: evt.Version,
+			Context: evt.Context,
+			Account: evt.Account,
+			Region:  evt.Region,
+			Role:    evt.Role,
+			Mode:    evt.Mode,
+			Status:  registry.STATUS_SCHEDULED,
+			Reverts: link.reverts,
+			Rollout: link.rollout,
+
+			Lifecycle: link.lifecycle,
+			Coalesced: link.coalesced,
+			Seq:       evt.Seq,
+			Callback:  evt.Callback,
+			ReplyTo:   evt.ReplyTo,
+		}
+		if err := s.registry.Claim(ctx, deployment); err != nil {
+			slog.Error("failed to record deployment", "uid", evt.UID, "err", err)
+			return "", err
+		}
+	}
+
+	val, err := s.api.SubmitJob(ctx,
+		&batch.SubmitJobInput{
+			JobName:            aws.String(evt.UID),
+			JobDefinition:      aws.String(s.definition),
+			JobQueue:           aws.String(s.queue),
+			DependsOn:          deps,
+			ContainerOverrides: &types.ContainerOverrides{Environment: env},
+		},
+	)
+	if err != nil {
+		if recorded {
+			s.release(ctx, deployment, err)
+		}
+		return "", err
+	}
+
+	slog.Info("job scheduled", "uid", evt.UID, "job", val.JobId)
+
+	job := aws.ToString(val.JobId)
+	if recorded {
+		if err := s.registry.Attach(ctx, evt.UID, job); err != nil {
+			slog.Error("failed to record job", "uid", evt.UID, "job", job, "err", err)
+			return job, err
+		}
+	}
+
+	return job, nil
+}
+
+// releases the claim of deployment, whose job is not submitted, the failed
+// deployment is claimed again by the retry.
+func (s *Service) release(ctx context.Context, d registry.Deployment, cause error) {
+	d.Status = registry.STATUS_FAILED
+	d.Reason = cause.Error()
+
+	if err := s.registry.Put(ctx, d); err != nil {
+		slog.Error("failed to release deployment", "uid", d.UID, "err", err)
+	}
+}
+
+// declares the deployment of tenant as its desired state
+func (s *Service) declare(ctx context.Context, evt events.EventCraft, link lineage) error {
+	if s.desired == nil || evt.Tenant == "" || link.reconcile {
+		return nil
+	}
+
+	if evt.Mode == events.MODE_DESTROY {
+		return s.desired.Delete(ctx, evt.Tenant, evt.Module)
+	}
+
+	// context referring outputs of upstream modules is resolved by the job,
+	// the module of composite deployment is not reconciled.
+	if len(link.upstream) != 0 && bytes.Contains(evt.Context, []byte("${")) {
+		slog.Info("desired state is not declared for unresolved context", "uid", evt.UID, "tenant", evt.Tenant, "module", evt.Module)
+		return s.desired.Delete(ctx, evt.Tenant, evt.Module)
+	}
+
+	return s.desired.Put(ctx,
+		&desired.State{
+			Tenant:  evt.Tenant,
+			Module:  evt.Module,
+			Version: evt.Version,
+			Context: evt.Context,
+			Account: evt.Account,
+			Region:  evt.Region,
+			Role:    evt.Role,
+			Mode:    evt.Mode,
+		},
+	)
+}
+
+func (s *Service) ScheduleBootstrap(evt events.EventBootstrap) error {
+	if s.bootstrap == "" {
+		return fmt.Errorf("bootstrap job is not configured")
+	}
+
+	if s.organization == "" && !s.isTrusted(evt.Account) {
+		return fmt.Errorf("account %s is not allowed", evt.Account)
+	}
+
+	env := []types.KeyValuePair{
+		{Name: aws.String("CRAFT_UID"), Value: aws.String(evt.UID)},
+		{Name: aws.String("CRAFT_BUCKET"), Value: aws.String(s.bucket)},
+		{Name: aws.String("CRAFT_TARGET_ACCOUNT"), Value: aws.String(evt.Account)},
+	}
+
+	if evt.Region != "" {
+		env = append(env,
+			types.KeyValuePair{Name: aws.String("CRAFT_TARGET_REGION"), Value: aws.String(evt.Region)},
+		)
+	}
+
+	if evt.Qualifier != "" {
+		env = append(env,
+			types.KeyValuePair{Name: aws.String("CRAFT_QUALIFIER"), Value: aws.String(evt.Qualifier)},
+		)
+	}
+
+	if len(evt.Trust) != 0 {
+		env = append(env,
+			types.KeyValuePair{Name: aws.String("CRAFT_TRUST"), Value: aws.String(strings.Join(evt.Trust, ","))},
+		)
+	}
+
 	val, err := s.api.SubmitJob(context.Background(),
+		&batch.SubmitJobInput{
+			JobName:            aws.String(evt.UID),
+			JobDefinition:      aws.String(s.bootstrap),
+			JobQueue:           aws.String(s.queue),
+			ContainerOverrides: &types.ContainerOverrides{Environment: env},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("job scheduled", "uid", evt.UID, "job", val.JobId, "account", evt.Account)
+
+	return nil
+}
+
+func (s *Service) ScheduleApproval(evt events.EventApproval) error {
+	switch evt.Decision {
+	case events.DECISION_APPROVE, events.DECISION_REJECT, events.DECISION_EXPIRE:
+	default:
+		return fmt.Errorf("decision %s is not supported", evt.Decision)
+	}
+
+	ctx := context.Background()
+	if pending, err := s.isPendingApproval(ctx, evt); !pending || err != nil {
+		return err
+	}
+
+	// the decision is taken once, the concurrent or redelivered one is ignored
+	err := s.registry.Transit(ctx, evt.UID, registry.STATUS_PENDING, registry.STATUS_SCHEDULED)
+	switch {
+	case errors.Is(err, registry.ErrConflict):
+		slog.Info("approval is decided", "uid", evt.UID, "decision", evt.Decision)
+		return nil
+	case err != nil:
+		return err
+	}
+
+	val, err := s.api.SubmitJob(ctx,
 		&batch.SubmitJobInput{
 			JobName:       aws.String(evt.UID),
 			JobDefinition: aws.String(s.definition),
 			JobQueue:      aws.String(s.queue),
 			ContainerOverrides: &types.ContainerOverrides{
+				Command: []string{"sh", "/bin/approval.sh"},
 				Environment: []types.KeyValuePair{
+					{Name: aws.String("CRAFT_UID"), Value: aws.String(evt.UID)},
 					{Name: aws.String("CRAFT_BUCKET"), Value: aws.String(s.bucket)},
-					{Name: aws.String("CRAFT_MODULE"), Value: aws.String(evt.Module)},
-					{Name: aws.String("CRAFT_CDK_CONTEXT"), Value: aws.String(string(evt.Context))},
+					{Name: aws.String("CRAFT_DECISION"), Value: aws.String(evt.Decision)},
 				},
 			},
 		},
 	)
 	if err != nil {
+		// the decision is taken again by the retry
+		if err := s.registry.Transit(ctx, evt.UID, registry.STATUS_SCHEDULED, registry.STATUS_PENDING); err != nil {
+			slog.Error("failed to release approval", "uid", evt.UID, "err", err)
+		}
 		return err
 	}
 
-	slog.Info("job scheduled", "uid", evt.UID, "job", val.JobId)
+	slog.Info("job scheduled", "uid", evt.UID, "job", val.JobId, "decision", evt.Decision, "reviewer", evt.Reviewer)
+
+	return nil
+}
+
+// checks that the deployment was crafted in approval mode and still awaits
+// the decision. The expiry of decided approval is ignored.
+func (s *Service) isPendingApproval(ctx context.Context, evt events.EventApproval) (bool, error) {
+	if s.registry == nil {
+		return false, fmt.Errorf("registry is not configured")
+	}
+
+	d, err := s.registry.Get(ctx, evt.UID)
+	if err != nil {
+		return false, err
+	}
+
+	if d.Mode == events.MODE_APPROVAL && d.Status == registry.STATUS_PENDING {
+		return true, nil
+	}
+
+	if d.Mode == events.MODE_APPROVAL && evt.Decision == events.DECISION_EXPIRE {
+		slog.Info("approval is decided", "uid", evt.UID, "status", d.Status)
+		return false, nil
+	}
+
+	return false, fmt.Errorf("deployment %s is not pending approval: %w", evt.UID, ErrInvalid)
+}
+
+func (s *Service) validateTarget(account, role string) error {
+	if account != "" && !s.isTrusted(account) {
+		return fmt.Errorf("account %s is not allowed", account)
+	}
+
+	if role != "" && account == "" {
+		return fmt.Errorf("role %s requires target account", role)
+	}
 
 	return nil
 }
+
+func validateMode(mode string) error {
+	switch mode {
+	case "", events.MODE_DEPLOY, events.MODE_DIFF, events.MODE_APPROVAL:
+		return nil
+	default:
+		return fmt.Errorf("mode %s is not supported", mode)
+	}
+}
+
+func validateCallback(callback string) error {
+	if callback == "" {
+		return nil
+	}
+
+	uri, err := url.Parse(callback)
+	if err != nil || (uri.Scheme != "https" && uri.Scheme != "http") || uri.Host == "" {
+		return fmt.Errorf("callback %s is not valid url", callback)
+	}
+
+	return nil
+}
+
+// reply-to bus is reachable by the relay, it puts events into buses of
+// the organization or trusted accounts. The craft's bus would relay events
+// to itself.
+func (s *Service) validateReplyTo(evt events.EventCraft) error {
+	if evt.ReplyTo == "" {
+		return nil
+	}
+
+	bus, err := arn.Parse(evt.ReplyTo)
+	if err != nil || bus.Service != "events" || !strings.HasPrefix(bus.Resource, "event-bus/") || bus.AccountID == "" {
+		return fmt.Errorf("reply-to %s is not valid event bus arn", evt.ReplyTo)
+	}
+
+	craft, _ := arn.Parse(s.bus)
+	if s.bus != "" && bus.AccountID == craft.AccountID && bus.Region == craft.Region && bus.Resource == craft.Resource {
+		return fmt.Errorf("reply-to %s is the craft's bus", evt.ReplyTo)
+	}
+
+	// the organization membership is enforced by IAM policy of the relay
+	if s.organization == "" && !s.isTrusted(bus.AccountID) && bus.AccountID != craft.AccountID {
+		return fmt.Errorf("reply-to %s is not reachable by the relay", evt.ReplyTo)
+	}
+
+	return nil
+}
+
+func (s *Service) isTrusted(account string) bool {
+	_, has := s.accounts[account]
+	return has
+}
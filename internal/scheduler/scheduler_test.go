@@ -0,0 +1,265 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package scheduler_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	awsscheduler "github.com/aws/aws-sdk-go-v2/service/scheduler"
+	schedtypes "github.com/aws/aws-sdk-go-v2/service/scheduler/types"
+	"github.com/fogfish/craft/internal/debounce"
+	"github.com/fogfish/craft/internal/desired"
+	"github.com/fogfish/craft/internal/registry"
+)
+
+// records submitted jobs, the job is named after its index
+type mockJobs struct {
+	seq []*batch.SubmitJobInput
+}
+
+func (m *mockJobs) SubmitJob(ctx context.Context, params *batch.SubmitJobInput, optFns ...func(*batch.Options)) (*batch.SubmitJobOutput, error) {
+	m.seq = append(m.seq, params)
+	return &batch.SubmitJobOutput{JobId: aws.String(fmt.Sprintf("job-%d", len(m.seq)))}, nil
+}
+
+// returns value of environment variable of the job
+func env(job *batch.SubmitJobInput, key string) string {
+	for _, e := range job.ContainerOverrides.Environment {
+		if aws.ToString(e.Name) == key {
+			return aws.ToString(e.Value)
+		}
+	}
+	return ""
+}
+
+type mockJobsFailed struct{}
+
+func (mockJobsFailed) SubmitJob(ctx context.Context, params *batch.SubmitJobInput, optFns ...func(*batch.Options)) (*batch.SubmitJobOutput, error) {
+	return nil, fmt.Errorf("failed to submit %s", aws.ToString(params.JobName))
+}
+
+type mockStorage struct{}
+
+func (mockStorage) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	return &s3.PutObjectOutput{}, nil
+}
+
+// in-memory schedules, the existing schedule conflicts
+type mockTimer struct {
+	schedules map[string]*awsscheduler.CreateScheduleInput
+}
+
+func (m *mockTimer) CreateSchedule(ctx context.Context, params *awsscheduler.CreateScheduleInput, optFns ...func(*awsscheduler.Options)) (*awsscheduler.CreateScheduleOutput, error) {
+	if m.schedules == nil {
+		m.schedules = map[string]*awsscheduler.CreateScheduleInput{}
+	}
+	if _, has := m.schedules[aws.ToString(params.Name)]; has {
+		return nil, &schedtypes.ConflictException{Message: aws.String("exists")}
+	}
+	m.schedules[aws.ToString(params.Name)] = params
+	return &awsscheduler.CreateScheduleOutput{}, nil
+}
+
+func (m *mockTimer) DeleteSchedule(ctx context.Context, params *awsscheduler.DeleteScheduleInput, optFns ...func(*awsscheduler.Options)) (*awsscheduler.DeleteScheduleOutput, error) {
+	if _, has := m.schedules[aws.ToString(params.Name)]; !has {
+		return nil, &schedtypes.ResourceNotFoundException{Message: aws.String("not found")}
+	}
+	delete(m.schedules, aws.ToString(params.Name))
+	return &awsscheduler.DeleteScheduleOutput{}, nil
+}
+
+func (m *mockTimer) GetSchedule(ctx context.Context, params *awsscheduler.GetScheduleInput, optFns ...func(*awsscheduler.Options)) (*awsscheduler.GetScheduleOutput, error) {
+	schedule, has := m.schedules[aws.ToString(params.Name)]
+	if !has {
+		return nil, &schedtypes.ResourceNotFoundException{Message: aws.String("not found")}
+	}
+	return &awsscheduler.GetScheduleOutput{Name: schedule.Name, Target: schedule.Target}, nil
+}
+
+func (m *mockTimer) ListSchedules(ctx context.Context, params *awsscheduler.ListSchedulesInput, optFns ...func(*awsscheduler.Options)) (*awsscheduler.ListSchedulesOutput, error) {
+	seq := make([]schedtypes.ScheduleSummary, 0)
+	for name := range m.schedules {
+		seq = append(seq, schedtypes.ScheduleSummary{Name: aws.String(name)})
+	}
+	return &awsscheduler.ListSchedulesOutput{Schedules: seq}, nil
+}
+
+// in-memory store of debounce buffers, it keeps copies as the real storage does
+type mockDebounce struct {
+	seq map[string]debounce.Buffer
+}
+
+func (m *mockDebounce) Append(ctx context.Context, b *debounce.Buffer) (*debounce.Buffer, error) {
+	if m.seq == nil {
+		m.seq = map[string]debounce.Buffer{}
+	}
+
+	stored, has := m.seq[b.Tenant+" "+b.Module]
+	if !has {
+		stored = *b
+	} else if err := stored.Merge(b); err != nil {
+		return nil, err
+	}
+
+	stored.UIDs = append([]string{}, stored.UIDs...)
+	m.seq[b.Tenant+" "+b.Module] = stored
+	return &stored, nil
+}
+
+func (m *mockDebounce) Take(ctx context.Context, tenant, module string) (*debounce.Buffer, error) {
+	b, has := m.seq[tenant+" "+module]
+	if !has {
+		return nil, debounce.ErrNotFound
+	}
+	delete(m.seq, tenant+" "+module)
+	return &b, nil
+}
+
+// in-memory desired state of tenants, listed in order of tenant and module
+type mockDesired struct {
+	seq map[string]desired.State
+}
+
+func (m *mockDesired) Put(ctx context.Context, state *desired.State) error {
+	if m.seq == nil {
+		m.seq = map[string]desired.State{}
+	}
+	state.Reset()
+	m.seq[state.Tenant+" "+state.Module] = *state
+	return nil
+}
+
+func (m *mockDesired) Update(ctx context.Context, state *desired.State) error {
+	m.seq[state.Tenant+" "+state.Module] = *state
+	return nil
+}
+
+func (m *mockDesired) Delete(ctx context.Context, tenant, module string) error {
+	delete(m.seq, tenant+" "+module)
+	return nil
+}
+
+func (m *mockDesired) List(ctx context.Context) ([]desired.State, error) {
+	seq := make([]desired.State, 0)
+	for _, state := range m.seq {
+		seq = append(seq, state)
+	}
+	sort.Slice(seq, func(i, j int) bool {
+		return seq[i].Tenant < seq[j].Tenant || (seq[i].Tenant == seq[j].Tenant && seq[i].Module < seq[j].Module)
+	})
+	return seq, nil
+}
+
+// in-memory registry, deployments are kept in chronological order, the claim
+// follows conditions of the registry.
+type mockRegistry struct {
+	seq []registry.Deployment
+}
+
+func (m *mockRegistry) at(uid string) int {
+	for i := len(m.seq) - 1; i >= 0; i-- {
+		if m.seq[i].UID == uid {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *mockRegistry) Put(ctx context.Context, d registry.Deployment) error {
+	if i := m.at(d.UID); i != -1 {
+		m.seq[i] = d
+		return nil
+	}
+	m.seq = append(m.seq, d)
+	return nil
+}
+
+func (m *mockRegistry) Claim(ctx context.Context, d registry.Deployment) error {
+	if i := m.at(d.UID); i != -1 {
+		x := m.seq[i]
+		switch {
+		case x.Status == registry.STATUS_FAILED || x.Status == registry.STATUS_DISCARDED:
+		case x.Status == registry.STATUS_SCHEDULED && x.Job == "" &&
+			x.Updated < time.Now().UTC().Add(-registry.CLAIM_LEASE).Format(time.RFC3339Nano):
+		default:
+			return registry.ErrConflict
+		}
+		m.seq[i] = d
+		return nil
+	}
+	m.seq = append(m.seq, d)
+	return nil
+}
+
+func (m *mockRegistry) Attach(ctx context.Context, uid, job string) error {
+	if i := m.at(uid); i != -1 {
+		m.seq[i].Job = job
+		return nil
+	}
+	return registry.ErrNotFound
+}
+
+func (m *mockRegistry) Transit(ctx context.Context, uid, from, to string) error {
+	if i := m.at(uid); i != -1 && m.seq[i].Status == from {
+		m.seq[i].Status = to
+		return nil
+	}
+	return registry.ErrConflict
+}
+
+func (m *mockRegistry) Get(ctx context.Context, uid string) (*registry.Deployment, error) {
+	if i := m.at(uid); i != -1 {
+		d := m.seq[i]
+		return &d, nil
+	}
+	return nil, registry.ErrNotFound
+}
+
+func (m *mockRegistry) History(ctx context.Context, tenant string) ([]registry.Deployment, error) {
+	seq := make([]registry.Deployment, 0)
+	for i := len(m.seq) - 1; i >= 0; i-- {
+		if m.seq[i].Tenant == tenant {
+			seq = append(seq, m.seq[i])
+		}
+	}
+	return seq, nil
+}
+
+func (m *mockRegistry) Tenants(ctx context.Context, module string) ([]registry.Deployment, error) {
+	seq := make([]registry.Deployment, 0)
+	has := map[string]struct{}{}
+	for i := len(m.seq) - 1; i >= 0; i-- {
+		if _, exists := has[m.seq[i].Tenant]; !exists && m.seq[i].Module == module && m.seq[i].Status != registry.STATUS_PARKED {
+			has[m.seq[i].Tenant] = struct{}{}
+			seq = append(seq, m.seq[i])
+		}
+	}
+	return seq, nil
+}
+
+func (m *mockRegistry) Deployed(ctx context.Context, tenant string) ([]registry.Deployment, error) {
+	seq := make([]registry.Deployment, 0)
+	has := map[[2]string]struct{}{}
+	for i := len(m.seq) - 1; i >= 0; i-- {
+		d := m.seq[i]
+		key := [2]string{d.Tenant, d.Module}
+		if _, exists := has[key]; exists || d.Tenant == "" || d.Status != registry.STATUS_SUCCEEDED || (tenant != "" && d.Tenant != tenant) {
+			continue
+		}
+		has[key] = struct{}{}
+		seq = append(seq, d)
+	}
+	return seq, nil
+}
@@ -0,0 +1,75 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+	"github.com/fogfish/craft/internal/sequence"
+)
+
+// orders the deployment of tenant's module by its sequence number, it
+// returns true if the deployment is stale and parked at the registry.
+// The retry of deployment, claimed before, is not ordered again.
+// The deployment without sequence number is not ordered.
+func (s *Service) order(ctx context.Context, evt events.EventCraft) (bool, error) {
+	if s.sequence == nil || evt.Seq == 0 || evt.Tenant == "" {
+		return false, nil
+	}
+
+	err := s.sequence.Advance(ctx, evt.Tenant, evt.Module, evt.Seq)
+	switch {
+	case err == nil:
+		return false, nil
+	case !errors.Is(err, sequence.ErrStale):
+		return false, err
+	}
+
+	if s.registry == nil {
+		slog.Warn("job parked", "uid", evt.UID, "tenant", evt.Tenant, "module", evt.Module, "seq", evt.Seq, "err", err)
+		return true, nil
+	}
+
+	// the deployment claimed by earlier attempt has passed the ordering,
+	// the retry is not stale even if the sequence is advanced since then
+	d, e := s.registry.Get(ctx, evt.UID)
+	switch {
+	case e == nil && d.Status == registry.STATUS_SCHEDULED:
+		return false, nil
+	case e != nil && !errors.Is(e, registry.ErrNotFound):
+		return false, e
+	}
+
+	slog.Warn("job parked", "uid", evt.UID, "tenant", evt.Tenant, "module", evt.Module, "seq", evt.Seq, "err", err)
+
+	deployment := registry.Deployment{
+		UID:     evt.UID,
+		Tenant:  evt.Tenant,
+		Module:  evt.Module,
+		Version: evt.Version,
+		Context: evt.Context,
+		Account: evt.Account,
+		Region:  evt.Region,
+		Role:    evt.Role,
+		Mode:    evt.Mode,
+		Status:  registry.STATUS_PARKED,
+		Reason:  err.Error(),
+		Seq:     evt.Seq,
+	}
+	// the parked record never overrides the recorded deployment
+	if err := s.registry.Claim(ctx, deployment); err != nil && !errors.Is(err, registry.ErrConflict) {
+		return true, err
+	}
+
+	return true, nil
+}
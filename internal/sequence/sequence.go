@@ -0,0 +1,81 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+// Package sequence implements ordering of deployments of tenant's module
+// by the sequence number, carried by events. The store keeps the last
+// applied sequence number, older deployments are stale.
+package sequence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var ErrStale = errors.New("stale")
+
+// DynamoDB declares the subset of interface from AWS SDK used by the store.
+type DynamoDB interface {
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
+// Store of sequence numbers, the sequence is identified by tenant and module
+type Store struct {
+	api   DynamoDB
+	table string
+}
+
+func NewStore(api DynamoDB, table string) *Store {
+	return &Store{
+		api:   api,
+		table: table,
+	}
+}
+
+// Advance the sequence of tenant's module, it returns ErrStale if the
+// sequence number is older than the last applied one. Same sequence
+// number is accepted again, events are delivered at least once.
+func (s *Store) Advance(ctx context.Context, tenant, module string, seq int64) error {
+	_, err := s.api.UpdateItem(ctx,
+		&dynamodb.UpdateItemInput{
+			TableName: aws.String(s.table),
+			Key: map[string]types.AttributeValue{
+				"tenant": &types.AttributeValueMemberS{Value: tenant},
+				"module": &types.AttributeValueMemberS{Value: module},
+			},
+			ConditionExpression: aws.String("attribute_not_exists(#seq) OR #seq <= :seq"),
+			UpdateExpression:    aws.String("SET #seq = :seq, #updated = :updated"),
+			ExpressionAttributeNames: map[string]string{
+				"#seq":     "seq",
+				"#updated": "updated",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":seq":     &types.AttributeValueMemberN{Value: strconv.FormatInt(seq, 10)},
+				":updated": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339Nano)},
+			},
+			ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+		},
+	)
+
+	var stale *types.ConditionalCheckFailedException
+	if errors.As(err, &stale) {
+		last := "unknown"
+		if v, ok := stale.Item["seq"].(*types.AttributeValueMemberN); ok {
+			last = v.Value
+		}
+		return fmt.Errorf("sequence %d of %s %s is older than %s: %w", seq, tenant, module, last, ErrStale)
+	}
+
+	return err
+}
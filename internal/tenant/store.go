@@ -0,0 +1,124 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+)
+
+// DynamoDB declares the subset of interface from AWS SDK used by the store.
+type DynamoDB interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+}
+
+// Store of tenants
+type Store struct {
+	api   DynamoDB
+	table string
+}
+
+func NewStore(api DynamoDB, table string) *Store {
+	return &Store{
+		api:   api,
+		table: table,
+	}
+}
+
+// Create new tenant, it returns ErrConflict if tenant exists
+func (s *Store) Create(ctx context.Context, t *Tenant) error {
+	t.Created = time.Now().UTC().Format(time.RFC3339Nano)
+	t.Updated = t.Created
+	t.Seq = 0
+
+	return s.put(ctx, t, "attribute_not_exists(#tenant)",
+		map[string]string{"#tenant": "tenant"},
+		nil,
+	)
+}
+
+// Update the tenant, it returns ErrConflict if the tenant has been
+// concurrently updated since it was read.
+func (s *Store) Update(ctx context.Context, t *Tenant) error {
+	seq := t.Seq
+	t.Seq++
+	t.Updated = time.Now().UTC().Format(time.RFC3339Nano)
+
+	return s.put(ctx, t, "#seq = :seq",
+		map[string]string{"#seq": "seq"},
+		map[string]types.AttributeValue{
+			":seq": &types.AttributeValueMemberN{Value: strconv.Itoa(seq)},
+		},
+	)
+}
+
+func (s *Store) put(ctx context.Context, t *Tenant, cond string, names map[string]string, values map[string]types.AttributeValue) error {
+	item, err := attributevalue.MarshalMap(t)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.api.PutItem(ctx,
+		&dynamodb.PutItemInput{
+			TableName:                 aws.String(s.table),
+			Item:                      item,
+			ConditionExpression:       aws.String(cond),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		},
+	)
+
+	var conflict *types.ConditionalCheckFailedException
+	if errors.As(err, &conflict) {
+		return fmt.Errorf("tenant %s: %w", t.ID, ErrConflict)
+	}
+
+	return err
+}
+
+// Get tenant by its identity
+func (s *Store) Get(ctx context.Context, id string) (*Tenant, error) {
+	val, err := s.api.GetItem(ctx,
+		&dynamodb.GetItemInput{
+			TableName: aws.String(s.table),
+			Key: map[string]types.AttributeValue{
+				"tenant": &types.AttributeValueMemberS{Value: id},
+			},
+			ConsistentRead: aws.Bool(true),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if val.Item == nil {
+		return nil, fmt.Errorf("tenant %s: %w", id, ErrNotFound)
+	}
+
+	var t Tenant
+	if err := attributevalue.UnmarshalMap(val.Item, &t); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
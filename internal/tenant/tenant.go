@@ -0,0 +1,187 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+// Package tenant implements the lifecycle of tenant, the state machine of
+// provisioning, suspension and deprovisioning driven by deployments.
+package tenant
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/fogfish/craft/internal/events"
+	"github.com/fogfish/craft/internal/registry"
+)
+
+// Allowed transitions of the tenant, the transition moves the tenant
+// from one of statuses via intermediate status to the target status once
+// all deployments of the transition are succeeded. The tenant stays at
+// current status during the transition if intermediate status is not defined.
+var transitions = map[string]struct {
+	from []string
+	via  string
+	to   string
+}{
+	events.TRANSITION_PROVISION: {
+		from: []string{"", events.TENANT_PROVISIONING, events.TENANT_DELETED},
+		via:  events.TENANT_PROVISIONING,
+		to:   events.TENANT_ACTIVE,
+	},
+	events.TRANSITION_SUSPEND: {
+		from: []string{events.TENANT_ACTIVE},
+		to:   events.TENANT_SUSPENDED,
+	},
+	events.TRANSITION_RESUME: {
+		from: []string{events.TENANT_SUSPENDED},
+		to:   events.TENANT_ACTIVE,
+	},
+	events.TRANSITION_DEPROVISION: {
+		from: []string{events.TENANT_PROVISIONING, events.TENANT_ACTIVE, events.TENANT_SUSPENDED, events.TENANT_DEPROVISIONING},
+		via:  events.TENANT_DEPROVISIONING,
+		to:   events.TENANT_DELETED,
+	},
+}
+
+// Length of transitions history, the oldest transitions are dropped
+const TRANSITIONS_MAX = 100
+
+// Transition of the tenant, recorded to its history
+type Transition struct {
+	Transition string `dynamodbav:"transition"`
+	From       string `dynamodbav:"from,omitempty"`
+	To         string `dynamodbav:"to"`
+	UID        string `dynamodbav:"uid,omitempty"`
+	Reason     string `dynamodbav:"reason,omitempty"`
+	At         string `dynamodbav:"at"`
+}
+
+// Tenant of craft
+type Tenant struct {
+	ID     string `dynamodbav:"tenant"`
+	Status string `dynamodbav:"status"`
+
+	// Ongoing transition and its deployments, the transition is completed
+	// once all deployments are succeeded.
+	Ongoing string   `dynamodbav:"ongoing,omitempty"`
+	Pending []string `dynamodbav:"pending,omitempty"`
+
+	// History of transitions
+	Transitions []Transition `dynamodbav:"transitions"`
+
+	// Sequence number of the update, used for optimistic locking
+	Seq int `dynamodbav:"seq"`
+
+	Created string `dynamodbav:"created,omitempty"`
+	Updated string `dynamodbav:"updated,omitempty"`
+}
+
+func New(id string) *Tenant {
+	return &Tenant{ID: id, Transitions: []Transition{}}
+}
+
+// Allowed checks the transition is allowed from the current status
+func (t *Tenant) Allowed(transition string) error {
+	spec, has := transitions[transition]
+	if !has {
+		return fmt.Errorf("transition %s is not supported", transition)
+	}
+
+	if len(t.Pending) != 0 {
+		return fmt.Errorf("tenant %s: transition %s is in progress", t.ID, t.Ongoing)
+	}
+
+	if !slices.Contains(spec.from, t.Status) {
+		return fmt.Errorf("tenant %s: transition %s is not allowed from %s", t.ID, transition, t.Status)
+	}
+
+	return nil
+}
+
+// Transit the tenant, deployments of the transition are pending.
+// The transition is completed at once if there are no deployments.
+func (t *Tenant) Transit(transition, uid string, pending []string) ([]Transition, error) {
+	if err := t.Allowed(transition); err != nil {
+		return nil, err
+	}
+
+	via := transitions[transition].via
+	if via == "" {
+		via = t.Status
+	}
+
+	seq := []Transition{t.record(transition, via, uid, "")}
+
+	t.Ongoing = transition
+	t.Pending = pending
+
+	if len(pending) == 0 {
+		seq = append(seq, t.complete(uid)...)
+	}
+
+	return seq, nil
+}
+
+// Complete the deployment of ongoing transition. The failure of any
+// deployment fails the transition, the tenant stays at current status.
+func (t *Tenant) Complete(uid, status, reason string) []Transition {
+	if !slices.Contains(t.Pending, uid) {
+		return nil
+	}
+
+	if status != registry.STATUS_SUCCEEDED {
+		tr := t.record(t.Ongoing, t.Status, uid, reason)
+		t.Pending = nil
+		return []Transition{tr}
+	}
+
+	t.Pending = slices.DeleteFunc(t.Pending, func(x string) bool { return x == uid })
+	if len(t.Pending) != 0 {
+		return nil
+	}
+
+	return t.complete(uid)
+}
+
+func (t *Tenant) complete(uid string) []Transition {
+	to := transitions[t.Ongoing].to
+	if to == "" || to == t.Status {
+		return nil
+	}
+
+	return []Transition{t.record(t.Ongoing, to, uid, "")}
+}
+
+func (t *Tenant) record(transition, to, uid, reason string) Transition {
+	tr := Transition{
+		Transition: transition,
+		From:       t.Status,
+		To:         to,
+		UID:        uid,
+		Reason:     reason,
+		At:         time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	t.Status = to
+	t.Transitions = append(t.Transitions, tr)
+	if len(t.Transitions) > TRANSITIONS_MAX {
+		t.Transitions = t.Transitions[len(t.Transitions)-TRANSITIONS_MAX:]
+	}
+	return tr
+}
+
+// Event of the transition
+func (tr Transition) Event(tenant string) events.EventTenantTransition {
+	return events.EventTenantTransition{
+		Tenant:     tenant,
+		Transition: tr.Transition,
+		From:       tr.From,
+		To:         tr.To,
+		UID:        tr.UID,
+		Reason:     tr.Reason,
+	}
+}
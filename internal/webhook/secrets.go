@@ -0,0 +1,96 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// ErrUnknownSubscriber is returned if the subscriber has no secret
+var ErrUnknownSubscriber = errors.New("unknown subscriber")
+
+// SecretsManager declares the subset of interface from AWS SDK used by secrets.
+type SecretsManager interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// Secrets of subscribers, JSON object of host to secret. Secrets are cached
+// and reloaded after ttl, rotated secrets and new subscribers are picked up
+// by the warm instance.
+type Secrets struct {
+	sync.Mutex
+	api     SecretsManager
+	id      string
+	ttl     time.Duration
+	loaded  time.Time
+	secrets map[string]string
+}
+
+func NewSecrets(api SecretsManager, id string, ttl time.Duration) *Secrets {
+	return &Secrets{
+		api: api,
+		id:  id,
+		ttl: ttl,
+	}
+}
+
+// Secret of the subscriber
+func (s *Secrets) Secret(ctx context.Context, subscriber string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.secrets == nil || time.Since(s.loaded) > s.ttl {
+		if err := s.load(ctx); err != nil {
+			return "", err
+		}
+	}
+
+	secret, has := s.secrets[subscriber]
+	if !has {
+		return "", fmt.Errorf("%w %s", ErrUnknownSubscriber, subscriber)
+	}
+
+	return secret, nil
+}
+
+// Reset the cache, secrets are reloaded on next use
+func (s *Secrets) Reset() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.secrets = nil
+}
+
+func (s *Secrets) load(ctx context.Context) error {
+	val, err := s.api.GetSecretValue(ctx,
+		&secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(s.id),
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to load secrets %s: %w", s.id, err)
+	}
+
+	secrets := map[string]string{}
+	if err := json.Unmarshal([]byte(aws.ToString(val.SecretString)), &secrets); err != nil {
+		return fmt.Errorf("invalid format of secrets %s: %w", s.id, err)
+	}
+
+	s.secrets = secrets
+	s.loaded = time.Now()
+	return nil
+}
@@ -0,0 +1,75 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// Status of delivery
+const (
+	DELIVERY_DELIVERED = "delivered"
+	DELIVERY_FAILED    = "failed"
+)
+
+// Delivery log of deployment's status to the callback
+type Delivery struct {
+	UID        string    `json:"uid"                  dynamodbav:"uid"`
+	Created    string    `json:"created"              dynamodbav:"created"`
+	Callback   string    `json:"callback"             dynamodbav:"callback"`
+	Subscriber string    `json:"subscriber,omitempty" dynamodbav:"subscriber,omitempty"`
+	Deployment string    `json:"deployment"           dynamodbav:"deployment"`
+	Delivery   string    `json:"delivery,omitempty"   dynamodbav:"delivery,omitempty"`
+	Status     string    `json:"status"               dynamodbav:"status"`
+	Reason     string    `json:"reason,omitempty"     dynamodbav:"reason,omitempty"`
+	Attempts   []Attempt `json:"attempts,omitempty"   dynamodbav:"attempts,omitempty"`
+}
+
+// DynamoDB declares the subset of interface from AWS SDK used by the store.
+type DynamoDB interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// Store of delivery logs, the log is identified by deployment's uid and
+// the time of delivery, redelivered status is logged again.
+type Store struct {
+	api   DynamoDB
+	table string
+}
+
+func NewStore(api DynamoDB, table string) *Store {
+	return &Store{
+		api:   api,
+		table: table,
+	}
+}
+
+// Put the delivery to the log
+func (s *Store) Put(ctx context.Context, d *Delivery) error {
+	d.Created = time.Now().UTC().Format(time.RFC3339Nano)
+
+	item, err := attributevalue.MarshalMap(d)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.api.PutItem(ctx,
+		&dynamodb.PutItemInput{
+			TableName: aws.String(s.table),
+			Item:      item,
+		},
+	)
+
+	return err
+}
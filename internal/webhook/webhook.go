@@ -0,0 +1,163 @@
+//
+// Copyright (C) 2024 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/craft
+//
+
+// Package webhook implements delivery of deployment status to HTTP
+// callbacks. The payload is signed using HMAC-SHA256 with the secret of
+// subscriber, the subscriber is identified by the host of callback.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Headers of delivery
+const (
+	HEADER_SIGNATURE = "X-Craft-Signature"
+	HEADER_TIMESTAMP = "X-Craft-Timestamp"
+	HEADER_DELIVERY  = "X-Craft-Delivery"
+)
+
+// Sign the payload, the signature is sha256={hex}, where hex is
+// HMAC-SHA256 of {timestamp}.{payload} using the secret.
+func Sign(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeliveryID identifies the status of deployment, the identity is same for
+// redelivered status so that subscribers deduplicate it. The job distinguishes
+// statuses of retried deployment.
+func DeliveryID(uid, status, job string) string {
+	hash := sha256.Sum256([]byte(uid + "\n" + status + "\n" + job))
+	return hex.EncodeToString(hash[:16])
+}
+
+// Subscriber of the callback
+func Subscriber(callback string) (string, error) {
+	uri, err := url.Parse(callback)
+	if err != nil || uri.Host == "" {
+		return "", fmt.Errorf("invalid callback %s", callback)
+	}
+
+	return uri.Hostname(), nil
+}
+
+// Attempt of delivery
+type Attempt struct {
+	At    string `json:"at"              dynamodbav:"at"`
+	Code  int    `json:"code,omitempty"  dynamodbav:"code,omitempty"`
+	Error string `json:"error,omitempty" dynamodbav:"error,omitempty"`
+}
+
+// Client delivers payloads to callbacks
+type Client struct {
+	http     *http.Client
+	attempts int
+	backoff  time.Duration
+}
+
+type Option func(*Client)
+
+// WithHTTP defines HTTP client
+func WithHTTP(http *http.Client) Option {
+	return func(c *Client) {
+		c.http = http
+	}
+}
+
+// WithRetry defines number of attempts and the initial back-off, it is
+// doubled after each failed attempt.
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.attempts = attempts
+		c.backoff = backoff
+	}
+}
+
+func New(opts ...Option) *Client {
+	c := &Client{
+		http:     &http.Client{Timeout: 10 * time.Second},
+		attempts: 5,
+		backoff:  time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Deliver the payload to the callback, it retries with exponential back-off
+// on network failures, 429 and 5xx. Attempts are returned for delivery logs.
+func (c *Client) Deliver(ctx context.Context, callback, delivery, secret string, payload []byte) ([]Attempt, error) {
+	attempts := make([]Attempt, 0, c.attempts)
+	backoff := c.backoff
+
+	for i := 0; i < c.attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return attempts, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		code, err := c.post(ctx, callback, delivery, secret, payload)
+		attempt := Attempt{At: time.Now().UTC().Format(time.RFC3339Nano), Code: code}
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+		attempts = append(attempts, attempt)
+
+		switch {
+		case err == nil && code < 300:
+			return attempts, nil
+		case err == nil && code != http.StatusTooManyRequests && code < 500:
+			return attempts, fmt.Errorf("callback %s rejected delivery: %d", callback, code)
+		}
+	}
+
+	return attempts, fmt.Errorf("callback %s failed after %d attempts", callback, len(attempts))
+}
+
+func (c *Client) post(ctx context.Context, callback, delivery, secret string, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callback, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HEADER_DELIVERY, delivery)
+	req.Header.Set(HEADER_TIMESTAMP, ts)
+	req.Header.Set(HEADER_SIGNATURE, Sign(secret, ts, payload))
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode, nil
+}